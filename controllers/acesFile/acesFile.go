@@ -1,12 +1,14 @@
 package acesFile
 
 import (
-	"bytes"
-	"time"
+	"encoding/xml"
+	"log"
+	"strconv"
 
 	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/encoding"
 	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/helpers/stream"
 	"github.com/curt-labs/API/models/acesFile"
 	"github.com/curt-labs/API/models/brand"
 	"github.com/go-martini/martini"
@@ -14,6 +16,9 @@ import (
 	"net/http"
 )
 
+// GetAcesFile streams the brand's ACES file in the version asked for,
+// generated from its parts' fitment. source=ftp serves the file uploaded to
+// FTP instead.
 func GetAcesFile(rw http.ResponseWriter, req *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
 	var err error
 	version := params["version"]
@@ -26,15 +31,85 @@ func GetAcesFile(rw http.ResponseWriter, req *http.Request, enc encoding.Encoder
 		return ""
 	}
 
-	file, err := acesFile.GetAcesFile(brandObj, version)
-	if err != nil {
-		apierror.GenerateError("Could not fetch Aces File", err, rw, req, http.StatusBadRequest)
-		return ""
+	if req.URL.Query().Get("source") == "ftp" {
+		var ftpFile string
+		ftpFile, err = acesFile.GetAcesFile(brandObj, version)
+		if err != nil {
+			apierror.GenerateError("Could not fetch Aces File", err, rw, req, http.StatusBadRequest)
+			return ""
+		}
+		rw.Header().Set("Content-Type", "application/xml")
+		return ftpFile
 	}
 
 	rw.Header().Set("Content-Type", "application/xml")
+	w := stream.NewWriter(rw)
+	err = acesFile.GetCachedAcesFile(dtx.Context(), w, brandObj, version)
+	acesFileError(err, w, rw, req, brandObj)
+	return ""
+}
 
-	http.ServeContent(rw, req, "aces.xml", time.Now(), bytes.NewReader([]byte(file)))
+// RefreshAcesFile generates the brand's ACES file in the version asked for
+// again, rather than serving it from the cache, and streams it.
+func RefreshAcesFile(rw http.ResponseWriter, req *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var brandObj brand.Brand
+	brandObj.ID = dtx.BrandID
+	if err := brandObj.Get(); err != nil {
+		apierror.GenerateError("Invalid brand ID", err, rw, req, http.StatusBadRequest)
+		return ""
+	}
 
+	rw.Header().Set("Content-Type", "application/xml")
+	w := stream.NewWriter(rw)
+	err := acesFile.RefreshAcesFile(dtx.Context(), w, brandObj, params["version"])
+	acesFileError(err, w, rw, req, brandObj)
 	return ""
 }
+
+func acesFileError(err error, w *stream.Writer, rw http.ResponseWriter, req *http.Request, b brand.Brand) {
+	switch {
+	case err == nil:
+	case w.Written():
+		// too late for an error response, the client sees a truncated
+		// file
+		log.Printf("Error generating brand %d's ACES file: %s\n", b.ID, err)
+	case err == acesFile.ErrUnknownAcesVersion:
+		apierror.GenerateError(err.Error(), err, rw, req, http.StatusBadRequest)
+	default:
+		apierror.GenerateError("Could not generate Aces File", err, rw, req)
+	}
+}
+
+// ImportAcesFile checks an uploaded ACES file against the VCDB and the
+// brand's catalog, and reports the fitment it adds to and removes from
+// each part. With apply=true the differences are saved.
+func ImportAcesFile(rw http.ResponseWriter, req *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	var err error
+	brandID := dtx.BrandID
+	if v := req.FormValue("brandID"); v != "" {
+		if brandID, err = strconv.Atoi(v); err != nil {
+			apierror.GenerateError("Trouble parsing brandID", err, rw, req, http.StatusBadRequest)
+			return ""
+		}
+	}
+
+	file, _, err := req.FormFile("file")
+	if err != nil {
+		apierror.GenerateError("Error getting file from form", err, rw, req, http.StatusBadRequest)
+		return ""
+	}
+	defer file.Close()
+
+	apply, _ := strconv.ParseBool(req.FormValue("apply"))
+	imp, err := acesFile.ImportAces(dtx.Context(), file, brandID, apply)
+	if _, ok := err.(*xml.SyntaxError); ok || err == acesFile.ErrNotAcesFile {
+		apierror.GenerateError("Could not read Aces File", err, rw, req, http.StatusBadRequest)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Could not import Aces File", err, rw, req)
+		return ""
+	}
+
+	return encoding.Must(enc.Encode(imp))
+}
@@ -8,46 +8,17 @@ import (
 	"strconv"
 	"time"
 
-	// "github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/encoding"
 	"github.com/curt-labs/API/helpers/error"
 	"github.com/curt-labs/API/models/cartIntegration"
-	"github.com/curt-labs/API/models/customer"
+	"github.com/curt-labs/API/models/currency"
 	"github.com/go-martini/martini"
 )
 
-func setDB(r *http.Request) error {
-	brandID, err := strconv.Atoi(r.URL.Query().Get("brandID"))
-	if err != nil {
-		return err
-	}
-	cartIntegration.Brand_ID = brandID
-	return nil
-}
-
-func setCustomerId(r *http.Request) error {
-	c := customer.Customer{}
-	err := c.GetCustomerIdFromKey(r.URL.Query().Get("key"))
-	if err != nil {
-		return err
-	}
-	cartIntegration.Customer_ID = c.Id
-	return nil
-}
-
 // Requires APIKEY and brandID in header
-func GetPricing(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
+func GetPricing(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
 	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
 
 	var page int
 	var count int
@@ -65,11 +36,19 @@ func GetPricing(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) s
 		}
 	}
 
-	prices, err := cartIntegration.GetCustomerPrices(page, count)
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
+		return ""
+	}
+	prices, err := cartIntegration.GetCustomerPrices(dtx, page, count)
 	if err != nil {
 		apierror.GenerateError("Trouble getting prices by customer ID", err, rw, r)
 		return ""
 	}
+	if err = cartIntegration.CustomerPricesInCurrency(dtx, cur, prices.Items); err != nil {
+		apierror.GenerateError("Trouble converting prices", err, rw, r)
+		return ""
+	}
 
 	if r.URL.Query().Get("format") == "json-obj" {
 		return encoding.Must(enc.Encode(prices))
@@ -80,19 +59,7 @@ func GetPricing(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) s
 
 // Requires APIKEY and brandID in header
 // Requires count and page in params
-func GetPricingPaged(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
-
+func GetPricingPaged(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
 	page, err := strconv.Atoi(params["page"])
 	if page < 1 || err != nil {
 		apierror.GenerateError("Trouble getting page number for paged customer pricing", err, rw, r)
@@ -105,28 +72,25 @@ func GetPricingPaged(rw http.ResponseWriter, r *http.Request, enc encoding.Encod
 		return ""
 	}
 
-	prices, err := cartIntegration.GetPricingPaged(page, count)
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
+		return ""
+	}
+	prices, err := cartIntegration.GetPricingPaged(dtx, page, count)
 	if err != nil {
 		apierror.GenerateError("Trouble getting prices for paged customer pricing", err, rw, r)
 		return ""
 	}
+	if err = cartIntegration.CustomerPricesInCurrency(dtx, cur, prices); err != nil {
+		apierror.GenerateError("Trouble converting prices", err, rw, r)
+		return ""
+	}
 	return encoding.Must(enc.Encode(prices))
 }
 
 //Returns int
-func GetPricingCount(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
-	count, err := cartIntegration.GetPricingCount()
+func GetPricingCount(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	count, err := cartIntegration.GetPricingCount(dtx)
 	if err != nil {
 		apierror.GenerateError("Trouble getting pricing count", err, rw, r)
 		return ""
@@ -135,49 +99,40 @@ func GetPricingCount(rw http.ResponseWriter, r *http.Request, enc encoding.Encod
 }
 
 //Returns Mfr Prices for a part
-func GetPartPricesByPartID(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
+func GetPartPricesByPartID(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
 	partNumber := params["part"]
 	if partNumber == "" {
+		err := errors.New("part number is required")
 		apierror.GenerateError("Trouble getting part number for part pricing", err, rw, r)
 		return ""
 	}
-	prices, err := cartIntegration.GetPartPricesByPartID(partNumber)
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
+		return ""
+	}
+	prices, err := cartIntegration.GetPartPricesByPartID(dtx, partNumber)
 	if err != nil {
 		apierror.GenerateError("Trouble getting pricing", err, rw, r)
 		return ""
 	}
-	return encoding.Must(enc.Encode(prices))
+	return encoding.Must(enc.Encode(cartIntegration.PricesInCurrency(prices, cur)))
 }
 
 //Returns Mfr Prices
-func GetAllPartPrices(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
+func GetAllPartPrices(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
 		return ""
 	}
-	prices, err := cartIntegration.GetPartPrices()
+	prices, err := cartIntegration.GetPartPrices(dtx)
 	if err != nil {
 		apierror.GenerateError("Trouble getting pricing", err, rw, r)
 		return ""
 	}
-	return encoding.Must(enc.Encode(prices))
+	return encoding.Must(enc.Encode(cartIntegration.PricesInCurrency(prices, cur)))
 }
 
-func CreatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
+func CreatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		apierror.GenerateError("Trouble creating pricing", err, rw, r)
@@ -189,23 +144,18 @@ func CreatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder)
 		apierror.GenerateError("Trouble creating pricing", err, rw, r)
 		return ""
 	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
-	price.CustID = cartIntegration.Customer_ID
+	price.CustID = dtx.CustomerID
 	err = validatePrice(price)
 	if err != nil {
 		apierror.GenerateError(err.Error(), err, rw, r)
 		return ""
 	}
-	err = price.Create()
+	err = price.Create(dtx)
 	if err != nil {
-		apierror.GenerateError("Trouble creating pricing", err, rw, r)
+		priceError("Trouble creating pricing", err, rw, r)
 		return ""
 	}
-	err = price.InsertCartIntegration()
+	err = price.InsertCartIntegration(dtx)
 	if err != nil {
 		apierror.GenerateError("Trouble creating CartIntegration", err, rw, r)
 		return ""
@@ -213,13 +163,7 @@ func CreatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder)
 	return encoding.Must(enc.Encode(price))
 }
 
-func UpdatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
+func UpdatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
 		apierror.GenerateError("Trouble creating pricing", err, rw, r)
@@ -231,26 +175,21 @@ func UpdatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder)
 		apierror.GenerateError("Trouble creating pricing", err, rw, r)
 		return ""
 	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
-	price.CustID = cartIntegration.Customer_ID
+	price.CustID = dtx.CustomerID
 	err = validatePrice(price)
 	if err != nil {
 		apierror.GenerateError(err.Error(), err, rw, r)
 		return ""
 	}
-	err = price.Update()
+	err = price.Update(dtx)
 	if err != nil {
-		apierror.GenerateError("Trouble updating price", err, rw, r)
+		priceError("Trouble updating price", err, rw, r)
 		return ""
 	}
 	if price.ReferenceID > 0 {
-		err = price.UpdateCartIntegration()
+		err = price.UpdateCartIntegration(dtx)
 	} else {
-		err = price.InsertCartIntegration()
+		err = price.InsertCartIntegration(dtx)
 	}
 	if err != nil {
 		apierror.GenerateError("Trouble updating CartIntegration", err, rw, r)
@@ -260,126 +199,61 @@ func UpdatePrice(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder)
 	return encoding.Must(enc.Encode(price))
 }
 
-//set all of a customer's prices to MAP
-func ResetAllToMap(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
+//Returns the customer's active and upcoming sales
+func GetSales(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
 		return ""
 	}
-	err = setCustomerId(r)
+	sales, err := cartIntegration.GetSales(dtx, time.Now())
 	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
+		apierror.GenerateError("Trouble getting sales", err, rw, r)
 		return ""
 	}
-	custPricesJson, err := cartIntegration.GetCustomerPrices(0, 0)
-	if err != nil {
-		apierror.GenerateError("Trouble getting prices by customer ID", err, rw, r)
+	if err = cartIntegration.CustomerPricesInCurrency(dtx, cur, sales.Active, sales.Upcoming); err != nil {
+		apierror.GenerateError("Trouble converting prices", err, rw, r)
 		return ""
 	}
-	custPrices := custPricesJson.Items
+	return encoding.Must(enc.Encode(sales))
+}
 
-	//create map of MAP prices
-	prices, err := cartIntegration.GetMAPPartPrices()
+//set all of a customer's prices to MAP
+func ResetAllToMap(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	report, err := cartIntegration.ResetToMap(dtx)
+	if err == cartIntegration.ErrInvalidUpload {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		return encoding.Must(enc.Encode(report))
+	}
 	if err != nil {
-		apierror.GenerateError("Trouble getting part prices", err, rw, r)
+		apierror.GenerateError("Trouble updating prices", err, rw, r)
 		return ""
 	}
-	priceMap := make(map[int]cartIntegration.Price)
-	for _, p := range prices {
-		priceMap[p.PartID] = p
-	}
-
-	//set to MAP
-	for i, _ := range custPrices {
-		custPrices[i].Price = priceMap[custPrices[i].PartID].Price
-		if custPrices[i].CustID == 0 {
-			custPrices[i].CustID = cartIntegration.Customer_ID
-		}
-		if custPrices[i].ID == 0 {
-			err = custPrices[i].Create()
-		} else {
-			err = custPrices[i].Update()
-		}
-		if err != nil {
-			apierror.GenerateError("Trouble updating price", err, rw, r)
-			return ""
-		}
-	}
-	return encoding.Must(enc.Encode(custPrices))
+	return encoding.Must(enc.Encode(report.Prices()))
 }
 
 //sets all of a customer's prices to a percentage of the price type specified in params
-func Global(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
-	err = setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
-	}
-	priceType := params["type"]
+func Global(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
 	percent, err := strconv.ParseFloat(params["percentage"], 64)
 	if err != nil {
 		apierror.GenerateError("Trouble parsing percentage", err, rw, r)
 		return ""
 	}
-	percent = percent / 100
 
-	//create partPriceMap
-	prices, err := cartIntegration.GetPartPrices()
-	if err != nil {
-		apierror.GenerateError("Trouble getting part prices", err, rw, r)
-		return ""
-	}
-	priceMap := make(map[string]float64)
-	for _, p := range prices {
-		key := strconv.Itoa(p.PartID) + p.Type
-		priceMap[key] = p.Price
+	report, err := cartIntegration.Global(dtx, params["type"], percent)
+	if err == cartIntegration.ErrInvalidUpload {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		return encoding.Must(enc.Encode(report))
 	}
-
-	//get CustPrices
-	custPricesJson, err := cartIntegration.GetCustomerPrices(0, 0)
 	if err != nil {
-		apierror.GenerateError("Trouble getting prices by customer ID", err, rw, r)
+		apierror.GenerateError("Trouble updating prices", err, rw, r)
 		return ""
 	}
-	custPrices := custPricesJson.Items
-
-	//set to percentage
-	for i, _ := range custPrices {
-		if custPrices[i].CustID == 0 {
-			custPrices[i].CustID = cartIntegration.Customer_ID
-		}
-		custPrices[i].Price = priceMap[strconv.Itoa(custPrices[i].PartID)+priceType] * percent
-		if custPrices[i].ID == 0 {
-			err = custPrices[i].Create()
-		} else {
-			err = custPrices[i].Update()
-
-		}
-		if err != nil {
-			apierror.GenerateError("Trouble updating price", err, rw, r)
-			return ""
-		}
-	}
-	return encoding.Must(enc.Encode(custPrices))
+	return encoding.Must(enc.Encode(report.Prices()))
 }
 
 //Get those price types
-func GetAllPriceTypes(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	var err error
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
-	}
-	types, err := cartIntegration.GetAllPriceTypes()
+func GetAllPriceTypes(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	types, err := cartIntegration.GetAllPriceTypes(dtx.Context())
 	if err != nil {
 		apierror.GenerateError("Trouble getting price types", err, rw, r)
 		return ""
@@ -388,7 +262,44 @@ func GetAllPriceTypes(rw http.ResponseWriter, r *http.Request, enc encoding.Enco
 }
 
 //Utility
+// priceError refuses prices below an enforced MAP with a 422, so that the
+// dealer site can tell them apart from failures, and updates to a price the
+// customer doesn't have with a 404.
+func priceError(msg string, err error, rw http.ResponseWriter, r *http.Request) {
+	if v, ok := err.(*cartIntegration.MAPViolation); ok {
+		apierror.GenerateError(v.Error()+"; MAP is enforced for this part", err, rw, r, http.StatusUnprocessableEntity)
+		return
+	}
+	if err == cartIntegration.ErrSaleMismatch || err == cartIntegration.ErrPriceExists {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return
+	}
+	if err == cartIntegration.ErrPriceNotFound {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusNotFound)
+		return
+	}
+	apierror.GenerateError(msg, err, rw, r)
+}
+
+// converter resolves the currency that prices are returned in, from the
+// currency parameter or else the customer's default.
+func converter(rw http.ResponseWriter, r *http.Request, dtx *apicontext.DataContext) (*currency.Converter, bool) {
+	cur, err := currency.Resolve(dtx, r.URL.Query().Get("currency"))
+	if err == currency.ErrUnknownCurrency {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting exchange rate", err, rw, r)
+		return nil, false
+	}
+	return cur, true
+}
+
 func validatePrice(p cartIntegration.CustomerPrice) error {
+	if p.Currency != "" && p.Currency != currency.Base {
+		return errors.New("Prices are set in " + currency.Base)
+	}
 	if p.CustID < 1 {
 		return errors.New("Customer ID cannot be less than 1")
 	}
@@ -0,0 +1,54 @@
+package cartIntegration
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/helpers/stream"
+	"github.com/curt-labs/API/models/products"
+	"github.com/go-martini/martini"
+)
+
+// Export streams the customer's catalog as a cart platform's product import
+// CSV, priced at the customer's prices. brandID and categoryID narrow it.
+func Export(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	platform := strings.ToLower(params["platform"])
+	if _, ok := products.ExportProfiles[platform]; !ok {
+		apierror.GenerateError("Error exporting catalog", products.ErrUnknownProfile, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	var f products.ExportFilter
+	var err error
+	if v := r.URL.Query().Get("brandID"); v != "" {
+		if f.BrandID, err = strconv.Atoi(v); err != nil {
+			apierror.GenerateError("Trouble parsing brandID", err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+	if v := r.URL.Query().Get("categoryID"); v != "" {
+		if f.CategoryID, err = strconv.Atoi(v); err != nil {
+			apierror.GenerateError("Trouble parsing categoryID", err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+
+	rw.Header().Set("Content-Type", "text/csv")
+	rw.Header().Set("Content-Disposition", "attachment;filename="+platform+"-products.csv")
+	w := stream.NewWriter(rw)
+	if err = products.ExportCatalog(dtx, w, platform, f); err != nil {
+		if w.Written() {
+			// too late for an error response, the client sees a
+			// truncated file
+			log.Printf("Error exporting %s catalog: %s\n", platform, err)
+			return ""
+		}
+		rw.Header().Del("Content-Disposition")
+		apierror.GenerateError("Error exporting catalog", err, rw, r)
+	}
+	return ""
+}
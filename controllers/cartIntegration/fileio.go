@@ -4,128 +4,114 @@ import (
 	"bytes"
 	"encoding/csv"
 	"errors"
+	"mime/multipart"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/encoding"
 	"github.com/curt-labs/API/helpers/error"
 	"github.com/curt-labs/API/models/cartIntegration"
+	"github.com/go-martini/martini"
 )
 
-//TODO - extremely untested
-
-func Upload(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	err := setCustomerId(r)
+// Upload validates a CSV or XLSX price sheet and applies it in a single
+// transaction. Nothing is written if any row has an error. With
+// dry_run=true nothing is written either way, and the batchId of a valid
+// report can be confirmed with ConfirmUpload.
+func Upload(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	file, fileHeader, err := r.FormFile("file")
 	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
+		apierror.GenerateError("Error getting file from form", err, rw, r)
 		return ""
 	}
-	err = setDB(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
-		return ""
+	defer file.Close()
+
+	var lines [][]string
+	switch {
+	case isXLSX(fileHeader):
+		lines, err = cartIntegration.ReadXLSX(file, fileHeader.Size)
+	case isCSV(fileHeader):
+		lines, err = cartIntegration.ReadCSV(file)
+	default:
+		err = errors.New("The file you tried uploading was not a valid CSV or XLSX file. Please try again using a valid CSV or XLSX file.")
 	}
-	key := r.URL.Query().Get("key")
-	file, fileHeader, err := r.FormFile("file")
 	if err != nil {
-		apierror.GenerateError("Error getting file from form", err, rw, r)
+		apierror.GenerateError("Error uploading file", err, rw, r, http.StatusBadRequest)
 		return ""
 	}
 
-	if fileHeader != nil {
-		contentType := fileHeader.Header.Get("Content-Type")
-
-		if contentType != "text/comma-separated-values" && contentType != "text/csv" &&
-			contentType != "application/csv" && contentType != "application/excel" &&
-			contentType != "application/vnd.ms-excel" && contentType != "application/vnd.msexcel" {
-			err = errors.New("The file you tried uploading was not a valid CSV file. Please try again using a valid CSV file.")
-			apierror.GenerateError("Error uploading file", err, rw, r)
-			return ""
-		}
+	var report *cartIntegration.UploadReport
+	if dryRun, _ := strconv.ParseBool(r.FormValue("dry_run")); dryRun {
+		report, err = cartIntegration.DryRun(dtx, lines)
+	} else {
+		report, err = cartIntegration.UploadFile(dtx, lines)
 	}
+	return uploadResponse(rw, r, enc, report, err)
+}
 
-	go cartIntegration.UploadFile(file, key)
-	if err != nil {
-		apierror.GenerateError("Error uploading file", err, rw, r)
+// ConfirmUpload applies an upload that was validated with dry_run=true.
+func ConfirmUpload(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	report, err := cartIntegration.ConfirmUpload(dtx, params["batch"])
+	if err == cartIntegration.ErrUploadNotFound {
+		apierror.GenerateError("Error confirming upload", err, rw, r, http.StatusNotFound)
 		return ""
 	}
-	return ""
+	return uploadResponse(rw, r, enc, report, err)
 }
 
-func Download(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
-	err := setCustomerId(r)
-	if err != nil {
-		apierror.GenerateError("Trouble getting customer from api key", err, rw, r)
-		return ""
+func uploadResponse(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, report *cartIntegration.UploadReport, err error) string {
+	if err == cartIntegration.ErrInvalidUpload {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		return encoding.Must(enc.Encode(report))
 	}
-	err = setDB(r)
 	if err != nil {
-		apierror.GenerateError("Trouble getting brandID from query string", err, rw, r)
+		apierror.GenerateError("Error uploading file", err, rw, r)
 		return ""
 	}
+	return encoding.Must(enc.Encode(report))
+}
 
-	b := &bytes.Buffer{}
-	wr := csv.NewWriter(b)
-
-	customerPricesJson, err := cartIntegration.GetCustomerPrices(0, 0)
+// Download returns the customer's price sheet as CSV, or as XLSX with
+// format=xlsx. Either can be edited and uploaded again.
+func Download(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	lines, err := cartIntegration.ExportRows(dtx)
 	if err != nil {
-		apierror.GenerateError("Error getting customer prices ", err, rw, r)
+		apierror.GenerateError("Error getting customer prices", err, rw, r)
 		return ""
 	}
-	customerPrices := customerPricesJson.Items
 
-	//Price map
-	prices, err := cartIntegration.GetPartPrices()
+	b := &bytes.Buffer{}
+	contentType, filename := "text/csv", "data.csv"
+	if r.FormValue("format") == "xlsx" {
+		contentType, filename = cartIntegration.XLSX_CONTENT_TYPE, "data.xlsx"
+		err = cartIntegration.WriteXLSX(b, lines)
+	} else {
+		err = csv.NewWriter(b).WriteAll(lines)
+	}
 	if err != nil {
-		apierror.GenerateError("Error getting part prices ", err, rw, r)
+		apierror.GenerateError("Error writing customer prices", err, rw, r)
 		return ""
 	}
-	priceMap := make(map[string]float64)
-	for _, p := range prices {
-		priceMap[strconv.Itoa(p.PartID)+":"+p.Type] = p.Price
-	}
-
-	//Write
-	wr.Write([]string{
-		"CURT Part Number",
-		"Customer Part ID",
-		"Sale Price",
-		"Sale Start Date",
-		"Sale End Date",
-		"Map Price",
-		"List Price"})
-
-	for _, price := range customerPrices {
-		mapPrice := ""
-		listPrice := ""
 
-		mapPrice = strconv.FormatFloat(priceMap[strconv.Itoa(price.PartID)+":Map"], 'f', 2, 64)
-		listPrice = strconv.FormatFloat(priceMap[strconv.Itoa(price.PartID)+":List"], 'f', 2, 64)
+	rw.Header().Set("Content-Type", contentType)
+	rw.Header().Set("Content-Disposition", "attachment;filename="+filename)
+	rw.Write(b.Bytes())
 
-		//stringify dates
-		var start, end string
-		if price.SaleStart != nil && !price.SaleStart.IsZero() {
-			start = price.SaleStart.Format(cartIntegration.DATE_FORMAT)
-		}
-		if price.SaleEnd != nil && !price.SaleStart.IsZero() {
-			end = price.SaleEnd.Format(cartIntegration.DATE_FORMAT)
-		}
-		wr.Write([]string{
-			price.PartNumber,
-			strconv.Itoa(price.CustomerPartID), //TODO - get CartIntegration at the same time
-			strconv.FormatFloat(price.Price, 'f', 2, 64),
-			start,
-			end,
-			mapPrice,
-			listPrice,
-		})
+	return ""
+}
 
+func isCSV(fh *multipart.FileHeader) bool {
+	switch fh.Header.Get("Content-Type") {
+	case "text/comma-separated-values", "text/csv", "application/csv", "application/excel",
+		"application/vnd.ms-excel", "application/vnd.msexcel":
+		return true
 	}
+	return strings.HasSuffix(strings.ToLower(fh.Filename), ".csv")
+}
 
-	wr.Flush()
-	rw.Header().Set("Content-Type", "text/csv")
-	rw.Header().Set("Content-Disposition", "attachment;filename=data.csv")
-	rw.Write(b.Bytes())
-
-	return ""
+func isXLSX(fh *multipart.FileHeader) bool {
+	return fh.Header.Get("Content-Type") == cartIntegration.XLSX_CONTENT_TYPE ||
+		strings.HasSuffix(strings.ToLower(fh.Filename), ".xlsx")
 }
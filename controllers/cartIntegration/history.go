@@ -0,0 +1,77 @@
+package cartIntegration
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/cartIntegration"
+	"github.com/go-martini/martini"
+)
+
+// GetHistory returns the customer's price changes, most recent first,
+// filtered by part, batch, source and date range.
+func GetHistory(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	qs := r.URL.Query()
+	q := cartIntegration.HistoryQuery{
+		PartNumber: qs.Get("part"),
+		BatchID:    qs.Get("batch"),
+		Source:     qs.Get("source"),
+	}
+	var err error
+	for name, t := range map[string]*time.Time{"since": &q.Since, "until": &q.Until} {
+		if qs.Get(name) == "" {
+			continue
+		}
+		if *t, err = parseHistoryTime(qs.Get(name)); err != nil {
+			apierror.GenerateError("Trouble parsing "+name, err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+	if qs.Get("page") != "" {
+		if q.Page, err = strconv.Atoi(qs.Get("page")); err != nil {
+			apierror.GenerateError("Trouble parsing page", err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+	if qs.Get("count") != "" {
+		if q.Count, err = strconv.Atoi(qs.Get("count")); err != nil {
+			apierror.GenerateError("Trouble parsing count", err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+
+	history, err := cartIntegration.GetPriceHistory(dtx, q)
+	if err != nil {
+		apierror.GenerateError("Trouble getting price history", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(history))
+}
+
+// RevertBatch undoes the price changes of a batch. Prices changed again
+// since are reported as conflicts and left alone unless force=true.
+func RevertBatch(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	force, _ := strconv.ParseBool(r.URL.Query().Get("force"))
+	report, err := cartIntegration.RevertBatch(dtx, params["batch"], force)
+	if err == cartIntegration.ErrBatchNotFound {
+		apierror.GenerateError("Trouble reverting price changes", err, rw, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble reverting price changes", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+// parseHistoryTime accepts a date or an RFC 3339 time.
+func parseHistoryTime(s string) (time.Time, error) {
+	if t, err := time.Parse(cartIntegration.DATE_FORMAT, s); err == nil {
+		return t, nil
+	}
+	return time.Parse(time.RFC3339, s)
+}
@@ -0,0 +1,83 @@
+package cartIntegration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/cartIntegration"
+	"github.com/go-martini/martini"
+)
+
+// GetMAPViolations lists the customer prices below MAP, optionally for a
+// single brandID and/or custID. Only enforced MAP is checked unless
+// all=true.
+func GetMAPViolations(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	qs := r.URL.Query()
+	brandID, _ := strconv.Atoi(qs.Get("brandID"))
+	custID, _ := strconv.Atoi(qs.Get("custID"))
+	all, _ := strconv.ParseBool(qs.Get("all"))
+
+	report, err := cartIntegration.GetMAPViolations(dtx.Context(), brandID, custID, all)
+	if err != nil {
+		apierror.GenerateError("Trouble getting MAP violations", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+// GetMAPOverrides lists the MAP overrides of custID, or of every customer.
+func GetMAPOverrides(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	custID, _ := strconv.Atoi(r.URL.Query().Get("custID"))
+	overrides, err := cartIntegration.GetMAPOverrides(dtx.Context(), custID)
+	if err != nil {
+		apierror.GenerateError("Trouble getting MAP overrides", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(overrides))
+}
+
+func CreateMAPOverride(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		apierror.GenerateError("Trouble creating MAP override", err, rw, r)
+		return ""
+	}
+	var override cartIntegration.MAPOverride
+	if err = json.Unmarshal(body, &override); err != nil {
+		apierror.GenerateError("Trouble creating MAP override", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = override.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = override.Create(dtx); err != nil {
+		apierror.GenerateError("Trouble creating MAP override", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(override))
+}
+
+func DeleteMAPOverride(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var override cartIntegration.MAPOverride
+	var err error
+	if override.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting MAP override ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	err = override.Delete(dtx.Context())
+	if err == cartIntegration.ErrOverrideNotFound {
+		apierror.GenerateError("Trouble deleting MAP override", err, rw, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble deleting MAP override", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(override))
+}
@@ -0,0 +1,134 @@
+package cartIntegration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/cartIntegration"
+	"github.com/go-martini/martini"
+)
+
+// GetRules returns the customer's pricing rules in the order they're tried.
+func GetRules(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	rules, err := cartIntegration.GetPricingRules(dtx)
+	if err != nil {
+		apierror.GenerateError("Trouble getting pricing rules", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rules))
+}
+
+func GetRule(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var rule cartIntegration.PricingRule
+	var err error
+	if rule.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting pricing rule ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Get(dtx); err != nil {
+		ruleError("Trouble getting pricing rule", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rule))
+}
+
+func CreateRule(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	rule, err := readRule(r)
+	if err != nil {
+		apierror.GenerateError("Trouble creating pricing rule", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Create(dtx); err != nil {
+		ruleError("Trouble creating pricing rule", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rule))
+}
+
+func UpdateRule(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	rule, err := readRule(r)
+	if err != nil {
+		apierror.GenerateError("Trouble updating pricing rule", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if rule.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting pricing rule ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Update(dtx); err != nil {
+		ruleError("Trouble updating pricing rule", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rule))
+}
+
+func DeleteRule(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var rule cartIntegration.PricingRule
+	var err error
+	if rule.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting pricing rule ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = rule.Delete(dtx); err != nil {
+		ruleError("Trouble deleting pricing rule", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rule))
+}
+
+// PreviewRules reports the prices the customer's rules would set, without
+// writing anything.
+func PreviewRules(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	report, err := cartIntegration.PreviewRules(dtx)
+	if err != nil {
+		apierror.GenerateError("Trouble previewing pricing rules", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+// ApplyRules writes the prices the customer's rules set, or nothing if any
+// part can't be priced.
+func ApplyRules(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	report, err := cartIntegration.ApplyRules(dtx)
+	if err == cartIntegration.ErrInvalidUpload {
+		rw.WriteHeader(http.StatusUnprocessableEntity)
+		return encoding.Must(enc.Encode(report))
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble applying pricing rules", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+func readRule(r *http.Request) (cartIntegration.PricingRule, error) {
+	var rule cartIntegration.PricingRule
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return rule, err
+	}
+	err = json.Unmarshal(body, &rule)
+	return rule, err
+}
+
+func ruleError(msg string, err error, rw http.ResponseWriter, r *http.Request) {
+	if err == cartIntegration.ErrRuleNotFound {
+		apierror.GenerateError(msg, err, rw, r, http.StatusNotFound)
+		return
+	}
+	apierror.GenerateError(msg, err, rw, r)
+}
@@ -0,0 +1,155 @@
+package cartIntegration
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/cartIntegration"
+	"github.com/go-martini/martini"
+)
+
+// GetWebhooks returns the customer's webhooks, without their secrets.
+func GetWebhooks(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	hooks, err := cartIntegration.GetWebhooks(dtx)
+	if err != nil {
+		apierror.GenerateError("Trouble getting webhooks", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(hooks))
+}
+
+func GetWebhook(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var hook cartIntegration.Webhook
+	var err error
+	if hook.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting webhook ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Get(dtx); err != nil {
+		webhookError("Trouble getting webhook", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(hook))
+}
+
+// CreateWebhook registers a webhook and returns it with its secret, which
+// isn't returned again.
+func CreateWebhook(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	hook, err := readWebhook(r)
+	if err != nil {
+		apierror.GenerateError("Trouble creating webhook", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Create(dtx); err != nil {
+		apierror.GenerateError("Trouble creating webhook", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(hook))
+}
+
+func UpdateWebhook(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	hook, err := readWebhook(r)
+	if err != nil {
+		apierror.GenerateError("Trouble updating webhook", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if hook.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting webhook ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Update(dtx); err != nil {
+		webhookError("Trouble updating webhook", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(hook))
+}
+
+func DeleteWebhook(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var hook cartIntegration.Webhook
+	var err error
+	if hook.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting webhook ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = hook.Delete(dtx); err != nil {
+		webhookError("Trouble deleting webhook", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(hook))
+}
+
+// GetDeliveries returns the delivery log of a webhook, or of all of the
+// customer's webhooks, optionally by status.
+func GetDeliveries(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var webhookID int
+	var err error
+	if params["id"] != "" {
+		if webhookID, err = strconv.Atoi(params["id"]); err != nil {
+			apierror.GenerateError("Trouble getting webhook ID", err, rw, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+	return deliveries(rw, r, enc, dtx, webhookID, r.URL.Query().Get("status"))
+}
+
+// GetDeadDeliveries returns the dead-letter list: deliveries that ran out
+// of attempts, across all of the customer's webhooks.
+func GetDeadDeliveries(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	return deliveries(rw, r, enc, dtx, 0, cartIntegration.DeliveryDead)
+}
+
+// Redeliver queues a dead delivery to be sent again.
+func Redeliver(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	webhookID, err := strconv.Atoi(params["id"])
+	if err != nil {
+		apierror.GenerateError("Trouble getting webhook ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = cartIntegration.Redeliver(dtx, webhookID, params["delivery"]); err != nil {
+		webhookError("Trouble redelivering webhook", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(params["delivery"]))
+}
+
+func deliveries(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext, webhookID int, status string) string {
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	log, err := cartIntegration.GetDeliveries(dtx, webhookID, status, page, count)
+	if err != nil {
+		apierror.GenerateError("Trouble getting webhook deliveries", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(log))
+}
+
+func readWebhook(r *http.Request) (cartIntegration.Webhook, error) {
+	var hook cartIntegration.Webhook
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return hook, err
+	}
+	err = json.Unmarshal(body, &hook)
+	return hook, err
+}
+
+func webhookError(msg string, err error, rw http.ResponseWriter, r *http.Request) {
+	if err == cartIntegration.ErrWebhookNotFound || err == cartIntegration.ErrDeliveryNotFound {
+		apierror.GenerateError(msg, err, rw, r, http.StatusNotFound)
+		return
+	}
+	apierror.GenerateError(msg, err, rw, r)
+}
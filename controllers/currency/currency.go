@@ -0,0 +1,113 @@
+package currency_ctlr
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/currency"
+	"github.com/go-martini/martini"
+)
+
+// CustomerCurrency is a customer's default currency.
+type CustomerCurrency struct {
+	CustID   int    `json:"custId" xml:"custId,attr"`
+	Currency string `json:"currency" xml:"currency,attr"`
+}
+
+// GetRates returns the exchange rates, most recent first, optionally of a
+// single currency.
+func GetRates(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	rates, err := currency.GetRates(dtx.Context(), r.URL.Query().Get("currency"))
+	if err != nil {
+		apierror.GenerateError("Trouble getting exchange rates", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(rates))
+}
+
+func CreateRate(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	var x currency.ExchangeRate
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &x)
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble reading exchange rate", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = x.Validate(); err != nil {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err = x.Create(dtx.Context()); err != nil {
+		apierror.GenerateError("Trouble creating exchange rate", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(x))
+}
+
+func DeleteRate(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var x currency.ExchangeRate
+	var err error
+	if x.ID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting exchange rate ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	err = x.Delete(dtx.Context())
+	if err == currency.ErrRateNotFound {
+		apierror.GenerateError("Trouble deleting exchange rate", err, rw, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble deleting exchange rate", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(x))
+}
+
+func GetCustomerCurrency(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var cc CustomerCurrency
+	var err error
+	if cc.CustID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting customer ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if cc.Currency, err = currency.GetCustomerCurrency(dtx.Context(), cc.CustID); err != nil {
+		apierror.GenerateError("Trouble getting customer currency", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(cc))
+}
+
+// SetCustomerCurrency changes the currency that a customer's prices are
+// returned in when a request doesn't ask for one.
+func SetCustomerCurrency(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var cc CustomerCurrency
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil {
+		err = json.Unmarshal(body, &cc)
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble reading customer currency", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if cc.CustID, err = strconv.Atoi(params["id"]); err != nil {
+		apierror.GenerateError("Trouble getting customer ID", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	cc.Currency, err = currency.SetCustomerCurrency(dtx.Context(), cc.CustID, cc.Currency)
+	if err == currency.ErrUnknownCurrency {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble setting customer currency", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(cc))
+}
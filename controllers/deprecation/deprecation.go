@@ -0,0 +1,20 @@
+package deprecation_ctlr
+
+import (
+	"net/http"
+
+	"github.com/curt-labs/API/helpers/deprecation"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+)
+
+// Report lists how often each API key has called a deprecated route, so
+// integrators can be contacted before the route is turned off.
+func Report(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder) string {
+	usage, err := deprecation.Report()
+	if err != nil {
+		apierror.GenerateError("Trouble getting deprecated route usage", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(usage))
+}
@@ -0,0 +1,120 @@
+package exports
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/blobstore"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/products"
+	"github.com/go-martini/martini"
+)
+
+// Request asks for catalog exports. No brands means every brand, and no
+// formats means both.
+type Request struct {
+	Brands  []int    `json:"brands"`
+	Formats []string `json:"formats"`
+}
+
+// List returns the catalog exports of the customer's brands, newest first.
+func List(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	snapshots, err := products.GetSnapshots(dtx, count)
+	if err != nil {
+		apierror.GenerateError("Trouble getting catalog exports", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(snapshots))
+}
+
+// Get returns a catalog export's status.
+func Get(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	s, err := products.GetSnapshot(dtx, params["id"])
+	if err == products.ErrSnapshotNotFound {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting catalog export", err, rw, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(s))
+}
+
+// Download streams a complete catalog export's file, with its checksum in
+// the X-Checksum-SHA256 header.
+func Download(rw http.ResponseWriter, r *http.Request, params martini.Params, dtx *apicontext.DataContext) string {
+	s, err := products.GetSnapshot(dtx, params["id"])
+	if err == products.ErrSnapshotNotFound {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting catalog export", err, rw, r)
+		return ""
+	}
+	f, err := s.Open()
+	if err == products.ErrSnapshotIncomplete {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusConflict)
+		return ""
+	}
+	if err == blobstore.ErrNotFound {
+		apierror.GenerateError("That catalog export's file is gone", err, rw, r, http.StatusGone)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble opening catalog export", err, rw, r)
+		return ""
+	}
+	defer f.Close()
+
+	rw.Header().Set("Content-Type", "application/gzip")
+	rw.Header().Set("Content-Disposition", "attachment;filename="+s.FileName())
+	rw.Header().Set("Content-Length", strconv.FormatInt(s.Size, 10))
+	rw.Header().Set("X-Checksum-SHA256", s.Checksum)
+	if _, err = io.Copy(rw, f); err != nil {
+		log.Printf("Error sending catalog export %s: %s\n", s.ID.Hex(), err)
+	}
+	return ""
+}
+
+// Create queues catalog exports, which are made in the background.
+func Create(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	var req Request
+	body, err := ioutil.ReadAll(r.Body)
+	if err == nil && len(body) > 0 {
+		err = json.Unmarshal(body, &req)
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble reading catalog export request", err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if len(req.Formats) == 0 {
+		req.Formats = products.SnapshotFormats
+	}
+	if len(req.Brands) == 0 {
+		if req.Brands, err = products.SnapshotBrands(dtx.Context()); err != nil {
+			apierror.GenerateError("Trouble getting brands", err, rw, r)
+			return ""
+		}
+	}
+
+	queued, err := products.QueueSnapshots(dtx.Context(), req.Brands, req.Formats, "api")
+	if err == products.ErrUnknownSnapshotType {
+		apierror.GenerateError(err.Error(), err, rw, r, http.StatusBadRequest)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble queueing catalog exports", err, rw, r)
+		return ""
+	}
+	rw.WriteHeader(http.StatusAccepted)
+	return encoding.Must(enc.Encode(queued))
+}
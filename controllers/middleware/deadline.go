@@ -0,0 +1,170 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curt-labs/API/helpers/error"
+)
+
+// Deadlines holds the amount of time a request is allowed to run before
+// its context is cancelled and the client receives a 504. Routes are
+// matched on the longest registered path prefix, falling back to Default.
+type Deadlines struct {
+	Default time.Duration
+
+	mu     sync.RWMutex
+	routes map[string]time.Duration
+}
+
+func NewDeadlines(def time.Duration) *Deadlines {
+	return &Deadlines{
+		Default: def,
+		routes:  make(map[string]time.Duration),
+	}
+}
+
+// Set registers a deadline for every route beginning with prefix. A timeout
+// of zero disables the deadline, and the server's read and write
+// timeouts, which is what streaming responses and uploads want.
+func (d *Deadlines) Set(prefix string, timeout time.Duration) {
+	d.mu.Lock()
+	d.routes[prefix] = timeout
+	d.mu.Unlock()
+}
+
+// For returns the deadline that applies to path.
+func (d *Deadlines) For(path string) time.Duration {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	timeout := d.Default
+	matched := -1
+	for prefix, t := range d.routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > matched {
+			timeout = t
+			matched = len(prefix)
+		}
+	}
+	return timeout
+}
+
+// Handler wraps h so that every request runs under a context carrying the
+// route's deadline. The response is buffered until the handler finishes; if
+// the deadline passes first the client gets a 504 and the context is
+// cancelled, which aborts any database work still running for the request.
+func (d *Deadlines) Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timeout := d.For(r.URL.Path)
+		if timeout <= 0 {
+			// the connection's deadlines were set from the server's
+			// timeouts when the request was read
+			rc := http.NewResponseController(w)
+			rc.SetReadDeadline(time.Time{})
+			rc.SetWriteDeadline(time.Time{})
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+
+		dw := &deadlineWriter{header: make(http.Header)}
+		done := make(chan struct{})
+		panicChan := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					panicChan <- p
+				}
+			}()
+			h.ServeHTTP(dw, r)
+			close(done)
+		}()
+
+		select {
+		case p := <-panicChan:
+			panic(p)
+		case <-done:
+			dw.mu.Lock()
+			defer dw.mu.Unlock()
+			dst := w.Header()
+			for k, v := range dw.header {
+				dst[k] = v
+			}
+			if dw.code == 0 {
+				dw.code = http.StatusOK
+			}
+			w.WriteHeader(dw.code)
+			w.Write(dw.buf.Bytes())
+		case <-ctx.Done():
+			dw.mu.Lock()
+			defer dw.mu.Unlock()
+			dw.timedOut = true
+			if ctx.Err() != context.DeadlineExceeded {
+				// the client went away, there's nobody to respond to
+				return
+			}
+			writeDeadlineExceeded(w, r, timeout)
+		}
+	})
+}
+
+func writeDeadlineExceeded(w http.ResponseWriter, r *http.Request, timeout time.Duration) {
+	e := apierror.ApiErr{
+		Message:        "The request took too long to process",
+		MessageDetails: "request exceeded its deadline of " + timeout.String(),
+		QueryString:    r.URL.Query(),
+	}
+	data, err := json.Marshal(e)
+	if err != nil {
+		http.Error(w, e.Message, http.StatusGatewayTimeout)
+		return
+	}
+
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusGatewayTimeout)
+	w.Write(data)
+}
+
+// deadlineWriter buffers a handler's response so that nothing reaches the
+// client until we know whether the handler beat its deadline.
+type deadlineWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	code     int
+	timedOut bool
+}
+
+func (dw *deadlineWriter) Header() http.Header {
+	return dw.header
+}
+
+func (dw *deadlineWriter) Write(p []byte) (int, error) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	if dw.code == 0 {
+		dw.code = http.StatusOK
+	}
+	return dw.buf.Write(p)
+}
+
+func (dw *deadlineWriter) WriteHeader(code int) {
+	dw.mu.Lock()
+	defer dw.mu.Unlock()
+	if dw.timedOut || dw.code != 0 {
+		return
+	}
+	dw.code = code
+}
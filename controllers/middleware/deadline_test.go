@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/helpers/error"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeadlines(t *testing.T) {
+	Convey("Testing Deadlines", t, func() {
+		d := NewDeadlines(time.Second)
+		d.Set("/vehicle", 50*time.Millisecond)
+		d.Set("/vehicle/mongo", 2*time.Second)
+		d.Set("/aces", 0)
+
+		Convey("longest prefix wins", func() {
+			So(d.For("/part/11000"), ShouldEqual, time.Second)
+			So(d.For("/vehicle/curt"), ShouldEqual, 50*time.Millisecond)
+			So(d.For("/vehicle/mongo/apps"), ShouldEqual, 2*time.Second)
+			So(d.For("/aces/3.2"), ShouldEqual, 0)
+		})
+
+		Convey("fast handlers respond normally", func() {
+			hasDeadline := make(chan bool, 1)
+			h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, ok := r.Context().Deadline()
+				hasDeadline <- ok
+				w.Header().Set("X-Test", "yes")
+				w.WriteHeader(http.StatusCreated)
+				w.Write([]byte("done"))
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/part/11000", nil))
+			So(rec.Code, ShouldEqual, http.StatusCreated)
+			So(rec.Header().Get("X-Test"), ShouldEqual, "yes")
+			So(rec.Body.String(), ShouldEqual, "done")
+			So(<-hasDeadline, ShouldBeTrue)
+		})
+
+		Convey("slow handlers get a 504 and a cancelled context", func() {
+			cancelled := make(chan error, 1)
+			h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-r.Context().Done()
+				cancelled <- r.Context().Err()
+				w.Write([]byte("too late"))
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/vehicle/curt?key=abc", nil))
+			So(rec.Code, ShouldEqual, http.StatusGatewayTimeout)
+
+			var e apierror.ApiErr
+			So(json.Unmarshal(rec.Body.Bytes(), &e), ShouldBeNil)
+			So(e.Message, ShouldNotBeEmpty)
+			So(e.QueryString.Get("key"), ShouldEqual, "abc")
+
+			So(<-cancelled, ShouldEqual, context.DeadlineExceeded)
+		})
+
+		Convey("routes without a deadline are passed straight through", func() {
+			hasDeadline := make(chan bool, 1)
+			h := d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_, ok := r.Context().Deadline()
+				hasDeadline <- ok
+				w.Write([]byte("streamed"))
+			}))
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest("GET", "/aces/3.2", nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Body.String(), ShouldEqual, "streamed")
+			So(<-hasDeadline, ShouldBeFalse)
+		})
+
+		Convey("routes without a deadline outlast the server's write timeout", func() {
+			srv := httptest.NewUnstartedServer(d.Handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				time.Sleep(100 * time.Millisecond)
+				w.Write([]byte("streamed"))
+			})))
+			srv.Config.WriteTimeout = 20 * time.Millisecond
+			srv.Start()
+			defer srv.Close()
+
+			resp, err := http.Get(srv.URL + "/aces/3.2")
+			So(err, ShouldBeNil)
+			defer resp.Body.Close()
+			body, err := ioutil.ReadAll(resp.Body)
+			So(err, ShouldBeNil)
+			So(string(body), ShouldEqual, "streamed")
+		})
+	})
+}
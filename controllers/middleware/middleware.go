@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -9,6 +10,7 @@ import (
 	"time"
 
 	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/background"
 	"github.com/curt-labs/API/helpers/database"
 	"github.com/curt-labs/API/helpers/error"
 	"github.com/curt-labs/API/models/cart"
@@ -18,7 +20,7 @@ import (
 )
 
 var (
-	ExcusedRoutes = []string{"/status", "/customer/auth", "/customer/user", "/new/customer/auth", "/customer/user/register", "/customer/user/resetPassword", "/cartIntegration/priceTypes", "/cartIntegration", "/cache"}
+	ExcusedRoutes = []string{"/status", "/customer/auth", "/customer/user", "/new/customer/auth", "/customer/user/register", "/customer/user/resetPassword", "/cartIntegration/priceTypes", "/cache", "/openapi.json"}
 
 	GetKeyType = `SELECT akt.type FROM ApiKey as ak, ApiKeyType as akt WHERE akt.id = ak.type_id AND ak.api_key=?`
 )
@@ -77,7 +79,10 @@ func Meddler() martini.Handler {
 
 		c.Next()
 
-		go logRequest(res, r, time.Now())
+		start := time.Now()
+		background.Go(func() {
+			logRequest(res, r, start)
+		})
 	}
 }
 
@@ -165,7 +170,7 @@ func processDataContext(r *http.Request, c martini.Context) (*apicontext.DataCon
 	}
 
 	//gets customer user from api key
-	user, err := getCustomerID(apiKey)
+	user, err := getCustomerID(r.Context(), apiKey)
 	if err != nil || user.Id == "" {
 		return nil, errors.New("No User for this API Key.")
 	}
@@ -205,6 +210,7 @@ func processDataContext(r *http.Request, c martini.Context) (*apicontext.DataCon
 		UserID:     user.Id, //current authenticated user
 		CustomerID: user.CustomerID,
 		Globals:    nil,
+		Ctx:        r.Context(),
 	}
 	err = dtx.GetBrandsArrayAndString(apiKey, brandID)
 	if err != nil {
@@ -214,12 +220,12 @@ func processDataContext(r *http.Request, c martini.Context) (*apicontext.DataCon
 	return dtx, nil
 }
 
-func getCustomerID(apiKey string) (*customer.CustomerUser, error) {
+func getCustomerID(ctx context.Context, apiKey string) (*customer.CustomerUser, error) {
 	err := database.Init()
 	if err != nil {
 		return nil, err
 	}
-	session := database.ProductMongoSession.Copy()
+	session := database.CopySession(ctx, database.ProductMongoSession)
 	defer session.Close()
 
 	query := bson.M{"users.keys.key": apiKey}
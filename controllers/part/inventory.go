@@ -0,0 +1,156 @@
+package part_ctlr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/products"
+	"github.com/go-martini/martini"
+)
+
+// Inventory returns a part's availability, total and by warehouse. Given
+// lat and lng, or a postal_code, warehouses are ranked by distance; the
+// customer's default warehouse always comes first.
+func Inventory(w http.ResponseWriter, r *http.Request, params martini.Params, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	id, err := strconv.Atoi(params["part"])
+	if err != nil {
+		apierror.GenerateError("Trouble getting part ID", err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	origin, ok := inventoryOrigin(w, r)
+	if !ok {
+		return ""
+	}
+
+	p := products.Part{ID: id}
+	inv, err := p.GetAvailability(dtx, origin)
+	if err == products.ErrPartNotFound {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting part inventory", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(inv))
+}
+
+// InventoryMulti returns the availability of several parts, given a JSON
+// array of part numbers.
+func InventoryMulti(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	var partNumbers []string
+	if err := json.NewDecoder(r.Body).Decode(&partNumbers); err != nil {
+		apierror.GenerateError("Trouble reading part numbers", err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	if len(partNumbers) > 500 {
+		err := errors.New("maximum request size is 500 parts")
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	origin, ok := inventoryOrigin(w, r)
+	if !ok {
+		return ""
+	}
+
+	avail, err := products.GetAvailabilityByPartNumbers(dtx, partNumbers, origin)
+	if err != nil {
+		apierror.GenerateError("Trouble getting part inventory", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(avail))
+}
+
+// IngestFeed applies a warehouse inventory feed, CSV or JSON as its
+// Content-Type or format says, and returns the feed's report.
+func IngestFeed(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = products.FeedFormat(r.Header.Get("Content-Type"))
+	}
+	if format != products.FeedCSV && format != products.FeedJSON {
+		err := products.ErrUnknownFeedFormat
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	}
+
+	body := http.MaxBytesReader(w, r.Body, products.MaxFeedSize)
+	report, err := products.IngestFeed(dtx.Context(), body, format, "api")
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		apierror.GenerateError(fmt.Sprintf("inventory feeds may be at most %d bytes", tooLarge.Limit), err, w, r, http.StatusRequestEntityTooLarge)
+		return ""
+	}
+	if _, ok := err.(products.InvalidFeedError); ok {
+		apierror.GenerateError("Trouble reading inventory feed", err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble ingesting inventory feed", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+func FeedReports(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	count, _ := strconv.Atoi(r.URL.Query().Get("count"))
+	reports, err := products.GetFeedReports(dtx.Context(), count)
+	if err != nil {
+		apierror.GenerateError("Trouble getting inventory feeds", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(reports))
+}
+
+func FeedReport(w http.ResponseWriter, r *http.Request, params martini.Params, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	report, err := products.GetFeedReport(dtx.Context(), params["id"])
+	if err == products.ErrFeedNotFound {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusNotFound)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting inventory feed", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(report))
+}
+
+// inventoryOrigin reads the point to rank warehouses from, if any, from lat
+// and lng or else postal_code.
+func inventoryOrigin(w http.ResponseWriter, r *http.Request) (*products.Origin, bool) {
+	qs := r.URL.Query()
+	if qs.Get("lat") != "" || qs.Get("lng") != "" {
+		var o products.Origin
+		var err error
+		if o.Latitude, err = strconv.ParseFloat(qs.Get("lat"), 64); err == nil {
+			o.Longitude, err = strconv.ParseFloat(qs.Get("lng"), 64)
+		}
+		if err == nil && (o.Latitude < -90 || o.Latitude > 90 || o.Longitude < -180 || o.Longitude > 180) {
+			err = errors.New("lat or lng is out of range")
+		}
+		if err != nil {
+			apierror.GenerateError("Trouble getting lat and lng", err, w, r, http.StatusBadRequest)
+			return nil, false
+		}
+		return &o, true
+	}
+	if postal := qs.Get("postal_code"); postal != "" {
+		o, err := products.LocatePostalCode(postal)
+		if err == products.ErrUnknownPostalCode {
+			apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+			return nil, false
+		}
+		if err != nil {
+			apierror.GenerateError("Trouble locating postal code", err, w, r)
+			return nil, false
+		}
+		return o, true
+	}
+	return nil, true
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/curt-labs/API/helpers/encoding"
 	"github.com/curt-labs/API/helpers/error"
 	"github.com/curt-labs/API/helpers/rest"
+	"github.com/curt-labs/API/models/currency"
 	"github.com/curt-labs/API/models/customer"
 	"github.com/curt-labs/API/models/products"
 	"github.com/curt-labs/API/models/vehicle"
@@ -103,11 +104,16 @@ func All(w http.ResponseWriter, r *http.Request, params martini.Params, enc enco
 		toTime = to
 	}
 
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	parts, total, err := products.All(page, count, dtx, fromTime, toTime)
 	if err != nil {
 		apierror.GenerateError("Trouble getting all parts", err, w, r)
 		return ""
 	}
+	products.PartsInCurrency(parts, cur)
 
 	//Format the response in JSON format if so desired, includes the
 	//total number of elements that results from the query
@@ -154,11 +160,16 @@ func Featured(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx
 		}
 	}
 
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	parts, err := products.Featured(count, dtx, brand)
 	if err != nil {
 		apierror.GenerateError("Trouble getting featured parts", err, w, r)
 		return ""
 	}
+	products.PartsInCurrency(parts, cur)
 
 	return encoding.Must(enc.Encode(parts))
 }
@@ -187,11 +198,16 @@ func Latest(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *a
 		}
 	}
 
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	parts, err := products.Latest(count, dtx, brand)
 	if err != nil {
 		apierror.GenerateError("Trouble getting latest parts", err, w, r)
 		return ""
 	}
+	products.PartsInCurrency(parts, cur)
 
 	return encoding.Must(enc.Encode(parts))
 }
@@ -202,6 +218,10 @@ func Get(w http.ResponseWriter, r *http.Request, params martini.Params, enc enco
 		apierror.GenerateError("Trouble getting part", err, w, r)
 		return ""
 	}
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	p := products.Part{
 		ID: id,
 	}
@@ -211,6 +231,13 @@ func Get(w http.ResponseWriter, r *http.Request, params martini.Params, enc enco
 		apierror.GenerateError("Trouble getting part", err, w, r)
 		return ""
 	}
+	if expandKits(r) {
+		if err = p.ExpandKit(dtx); err != nil {
+			apierror.GenerateError("Trouble getting kit components", err, w, r)
+			return ""
+		}
+	}
+	p.InCurrency(cur)
 
 	return encoding.Must(enc.Encode(p))
 }
@@ -223,12 +250,27 @@ func GetMulti(w http.ResponseWriter, r *http.Request, params martini.Params, enc
 		return ""
 	}
 
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	var parts []products.Part
 	parts, err = products.GetMulti(dtx, ids)
 	if err != nil {
 		apierror.GenerateError("Trouble getting part", err, w, r)
 		return ""
 	}
+	if err = products.ReportSubstitutions(dtx, parts); err != nil {
+		apierror.GenerateError("Trouble getting part substitutions", err, w, r)
+		return ""
+	}
+	if expandKits(r) {
+		if err = products.ExpandKits(dtx, parts); err != nil {
+			apierror.GenerateError("Trouble getting kit components", err, w, r)
+			return ""
+		}
+	}
+	products.PartsInCurrency(parts, cur)
 
 	return encoding.Must(enc.Encode(parts))
 }
@@ -239,11 +281,16 @@ func GetRelated(w http.ResponseWriter, r *http.Request, params martini.Params, e
 		ID: id,
 	}
 
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	parts, err := p.GetRelated(dtx)
 	if err != nil {
 		apierror.GenerateError("Trouble getting related parts", err, w, r)
 		return ""
 	}
+	products.PartsInCurrency(parts, cur)
 	return encoding.Must(enc.Encode(parts))
 }
 
@@ -492,6 +539,10 @@ func Prices(w http.ResponseWriter, r *http.Request, params martini.Params, enc e
 		apierror.GenerateError("Trouble getting part ID", err, w, r)
 		return ""
 	}
+	cur, ok := converter(w, r, dtx)
+	if !ok {
+		return ""
+	}
 	p := products.Part{
 		ID: id,
 	}
@@ -509,7 +560,7 @@ func Prices(w http.ResponseWriter, r *http.Request, params martini.Params, enc e
 		if custErr != nil {
 			err = custErr
 		}
-		p.Pricing = append(p.Pricing, products.Price{0, 0, "Customer", price, false, time.Now()})
+		p.Pricing = append(p.Pricing, products.Price{Type: "Customer", Price: price, DateModified: time.Now()})
 		custChan <- 1
 	}()
 
@@ -520,6 +571,7 @@ func Prices(w http.ResponseWriter, r *http.Request, params martini.Params, enc e
 		apierror.GenerateError("Trouble getting part prices", err, w, r)
 		return ""
 	}
+	p.InCurrency(cur)
 
 	return encoding.Must(enc.Encode(p.Pricing))
 }
@@ -535,10 +587,97 @@ func PartNumber(rw http.ResponseWriter, r *http.Request, params martini.Params,
 		return ""
 	}
 
+	cur, ok := converter(rw, r, dtx)
+	if !ok {
+		return ""
+	}
 	if err = p.GetPartByPartNumber(dtx); err != nil {
 		apierror.GenerateError("Trouble getting part by old part number", err, rw, r)
 		return ""
 	}
+	if r.URL.Query().Get("follow") == "true" {
+		err = p.FollowSupersession(dtx)
+		if err == products.ErrSupersessionCycle {
+			apierror.GenerateError(err.Error(), err, rw, r, http.StatusConflict)
+			return ""
+		}
+		if err != nil {
+			apierror.GenerateError("Trouble following part supersessions", err, rw, r)
+			return ""
+		}
+	}
+	if expandKits(r) {
+		if err = p.ExpandKit(dtx); err != nil {
+			apierror.GenerateError("Trouble getting kit components", err, rw, r)
+			return ""
+		}
+	}
+	p.InCurrency(cur)
 
 	return encoding.Must(enc.Encode(p))
 }
+
+// CurrentPartNumbers maps a JSON array of part numbers, up to 500, to the
+// parts that are current, following any supersessions.
+func CurrentPartNumbers(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	var partNumbers []string
+	if err := json.NewDecoder(r.Body).Decode(&partNumbers); err != nil {
+		apierror.GenerateError("Trouble reading part numbers", err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	if len(partNumbers) > 500 {
+		err := errors.New("maximum request size is 500 parts")
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	}
+
+	current, err := products.CurrentPartNumbers(dtx, partNumbers)
+	if err != nil {
+		apierror.GenerateError("Trouble getting current part numbers", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(current))
+}
+
+// Changes returns a page of the catalog's change feed, after the since
+// cursor.
+func Changes(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	qs := r.URL.Query()
+	count, _ := strconv.Atoi(qs.Get("count"))
+	feed, err := products.GetChanges(dtx, qs.Get("since"), count)
+	if err == products.ErrInvalidCursor {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting part changes", err, w, r)
+		return ""
+	}
+	return encoding.Must(enc.Encode(feed))
+}
+
+// expandKits reports whether the request asked for kits' components to be
+// embedded, with expand=components.
+func expandKits(r *http.Request) bool {
+	for _, e := range strings.Split(r.URL.Query().Get("expand"), ",") {
+		if strings.TrimSpace(e) == "components" {
+			return true
+		}
+	}
+	return false
+}
+
+// converter resolves the currency that prices are returned in, from the
+// currency parameter or else the customer's default.
+func converter(w http.ResponseWriter, r *http.Request, dtx *apicontext.DataContext) (*currency.Converter, bool) {
+	cur, err := currency.Resolve(dtx, r.URL.Query().Get("currency"))
+	if err == currency.ErrUnknownCurrency {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return nil, false
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble getting exchange rate", err, w, r)
+		return nil, false
+	}
+	return cur, true
+}
@@ -0,0 +1,58 @@
+package pies
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/helpers/stream"
+	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/products"
+	"github.com/go-martini/martini"
+)
+
+// GetPIESFile streams a PIES file of a brand's parts in the version asked
+// for. The brand is the key's, unless brandID picks one of them.
+func GetPIESFile(rw http.ResponseWriter, req *http.Request, enc encoding.Encoder, params martini.Params, dtx *apicontext.DataContext) string {
+	var err error
+	version := params["version"]
+	if _, ok := products.PIESVersions[version]; !ok {
+		apierror.GenerateError("Could not generate PIES file", products.ErrUnknownPIESVersion, rw, req, http.StatusBadRequest)
+		return ""
+	}
+
+	var brandObj brand.Brand
+	brandObj.ID = dtx.BrandID
+	if v := req.URL.Query().Get("brandID"); v != "" {
+		if brandObj.ID, err = strconv.Atoi(v); err != nil {
+			apierror.GenerateError("Trouble parsing brandID", err, rw, req, http.StatusBadRequest)
+			return ""
+		}
+	}
+	if err = brandObj.Get(); err != nil {
+		apierror.GenerateError("Invalid brand ID", err, rw, req, http.StatusBadRequest)
+		return ""
+	}
+
+	rw.Header().Set("Content-Type", "application/xml")
+	rw.Header().Set("Content-Disposition", "attachment;filename="+brandObj.Code+"_PIES"+version+".xml")
+	w := stream.NewWriter(rw)
+	err = products.ExportPIES(dtx, w, brandObj, version)
+	switch {
+	case err == nil:
+	case w.Written():
+		// too late for an error response, the client sees a truncated
+		// file
+		log.Printf("Error generating brand %d's PIES file: %s\n", brandObj.ID, err)
+	case err == products.ErrBrandNotAvailable:
+		rw.Header().Del("Content-Disposition")
+		apierror.GenerateError(err.Error(), err, rw, req, http.StatusForbidden)
+	default:
+		rw.Header().Del("Content-Disposition")
+		apierror.GenerateError("Could not generate PIES file", err, rw, req)
+	}
+	return ""
+}
@@ -128,6 +128,9 @@ func CurtLookupWorker(cl products.CurtLookup, heavyduty bool, dtx *apicontext.Da
 		if getCustomerPricing {
 			cl.Parts, err = products.BindCustomerToSeveralParts(cl.Parts, dtx)
 		}
+		if err == nil {
+			err = products.ReportSubstitutions(dtx, cl.Parts)
+		}
 	}
 
 	return cl, err
@@ -0,0 +1,69 @@
+package vehicle
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/error"
+	"github.com/curt-labs/API/models/products"
+)
+
+// ImportApplications stages an uploaded CSV of ARIES applications and
+// swaps it in for the collection, unless too many of its rows have errors
+// or dry_run=true. The report has each row's errors and the differences
+// from the current collection.
+func ImportApplications(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	file, _, err := r.FormFile("file")
+	if err != nil {
+		apierror.GenerateError("Error getting file from form", err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	defer file.Close()
+
+	maxErrorRate := -1.0
+	if v := r.FormValue("max_error_rate"); v != "" {
+		if maxErrorRate, err = strconv.ParseFloat(v, 64); err != nil || maxErrorRate < 0 || maxErrorRate > 1 {
+			apierror.GenerateError("max_error_rate must be between 0 and 1", err, w, r, http.StatusBadRequest)
+			return ""
+		}
+	}
+	dryRun, _ := strconv.ParseBool(r.FormValue("dry_run"))
+
+	report, err := products.Import(dtx.Context(), file, r.FormValue("collection"), maxErrorRate, dryRun)
+	if err == products.ErrInvalidCollection {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	}
+	if err == products.ErrImportInProgress {
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusConflict)
+		return ""
+	}
+	if err != nil {
+		apierror.GenerateError("Trouble importing applications", err, w, r)
+		return ""
+	}
+
+	return encoding.Must(enc.Encode(report))
+}
+
+// RollbackApplications puts back the collection replaced by its last
+// import.
+func RollbackApplications(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *apicontext.DataContext) string {
+	err := products.RollbackImport(dtx.Context(), r.FormValue("collection"))
+	switch err {
+	case nil:
+	case products.ErrInvalidCollection, products.ErrNoPreviousImport:
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusBadRequest)
+		return ""
+	case products.ErrImportInProgress:
+		apierror.GenerateError(err.Error(), err, w, r, http.StatusConflict)
+		return ""
+	default:
+		apierror.GenerateError("Trouble rolling back applications", err, w, r)
+		return ""
+	}
+
+	return encoding.Must(enc.Encode("success"))
+}
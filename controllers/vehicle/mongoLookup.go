@@ -112,7 +112,7 @@ func AllCollectionsLookup(w http.ResponseWriter, r *http.Request, enc encoding.E
 		return ""
 	}
 
-	sess := database.ProductMongoSession.Copy()
+	sess := database.CopySession(dtx.Context(), database.ProductMongoSession)
 	defer sess.Close()
 
 	//Get all collections
@@ -225,7 +225,7 @@ func AllCollectionsLookupCategory(w http.ResponseWriter, r *http.Request, enc en
 		return ""
 	}
 
-	sess := database.ProductMongoSession.Copy()
+	sess := database.CopySession(dtx.Context(), database.ProductMongoSession)
 	defer sess.Close()
 
 	// Get vehicle year
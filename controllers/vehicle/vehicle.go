@@ -62,7 +62,7 @@ func Query(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *ap
 			return ""
 		}
 	} else if l.Vehicle.Base.Model == "" { // Get Models
-		if err := l.GetModels(); err != nil {
+		if err := l.GetModels(dtx); err != nil {
 			apierror.GenerateError("Trouble getting models for vehicle lookup", err, w, r)
 			return ""
 		}
@@ -73,12 +73,12 @@ func Query(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *ap
 		go l.LoadParts(partChan, page, count, dtx)
 
 		if l.Vehicle.Submodel == "" { // Get Submodels
-			if err := l.GetSubmodels(); err != nil {
+			if err := l.GetSubmodels(dtx); err != nil {
 				apierror.GenerateError("Trouble getting submodels for vehicle lookup", err, w, r)
 				return ""
 			}
 		} else { // Get configurations
-			if err := l.GetConfigurations(); err != nil {
+			if err := l.GetConfigurations(dtx); err != nil {
 				apierror.GenerateError("Trouble getting configurations for vehicle lookup", err, w, r)
 				return ""
 			}
@@ -90,8 +90,15 @@ func Query(w http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx *ap
 				l.Parts = parts
 				l.Filter, _ = apifilter.PartFilter(l.Parts, nil)
 			}
+			if err := products.ReportSubstitutions(dtx, l.Parts); err != nil {
+				apierror.GenerateError("Trouble getting part substitutions", err, w, r)
+				return ""
+			}
 		case <-time.After(5 * time.Second):
 
+		case <-dtx.Context().Done():
+			apierror.GenerateError("Trouble getting parts for vehicle lookup", dtx.Context().Err(), w, r, http.StatusGatewayTimeout)
+			return ""
 		}
 	}
 
@@ -207,7 +214,7 @@ func Inquire(rw http.ResponseWriter, r *http.Request, enc encoding.Encoder, dtx
 		return ""
 	}
 
-	err = i.Push()
+	err = i.Push(dtx.Context())
 	if err != nil {
 		apierror.GenerateError("failed submission", err, rw, r, http.StatusInternalServerError)
 		return ""
@@ -3,6 +3,7 @@ package analytics
 import . "github.com/visionmedia/go-debug"
 import "github.com/jehiah/go-strftime"
 import "github.com/twinj/uuid"
+import "github.com/curt-labs/API/helpers/background"
 import . "encoding/json"
 import "io/ioutil"
 import "net/http"
@@ -206,7 +207,9 @@ func (c *Client) queue(msg Message) {
 	debug("buffer (%d/%d) %v", len(c.buffer), c.FlushAt, msg)
 
 	if len(c.buffer) >= c.FlushAt {
-		go c.flush()
+		background.Go(func() {
+			c.flush()
+		})
 	}
 }
 
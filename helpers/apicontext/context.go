@@ -1,6 +1,7 @@
 package apicontext
 
 import (
+	"context"
 	"errors"
 	"strconv"
 	"strings"
@@ -17,6 +18,12 @@ type DataContext struct {
 	Globals     map[string]interface{}
 	BrandArray  []int
 	BrandString string
+
+	// Ctx is the context of the request that created this DataContext.
+	// It carries the request deadline and is cancelled when the client
+	// goes away, so anything doing I/O on behalf of the request should
+	// use Context() rather than running unbounded.
+	Ctx context.Context
 }
 
 var (
@@ -25,6 +32,15 @@ var (
 		where ak.api_key = ?`
 )
 
+// Context returns the request context, falling back to
+// context.Background() for DataContexts built outside of a request.
+func (dtx *DataContext) Context() context.Context {
+	if dtx == nil || dtx.Ctx == nil {
+		return context.Background()
+	}
+	return dtx.Ctx
+}
+
 func (dtx *DataContext) GetBrandsFromKey() ([]int, error) {
 	var err error
 	var b int
@@ -34,12 +50,12 @@ func (dtx *DataContext) GetBrandsFromKey() ([]int, error) {
 		return brands, err
 	}
 
-	stmt, err := database.DB.Prepare(apiToBrandStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), apiToBrandStmt)
 	if err != nil {
 		return brands, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(dtx.APIKey)
+	res, err := stmt.QueryContext(dtx.Context(), dtx.APIKey)
 	if err != nil {
 		return brands, err
 	}
@@ -65,12 +81,12 @@ func (dtx *DataContext) GetBrandsArrayAndString(apiKey string, brandId int) erro
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(apiToBrandStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), apiToBrandStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(apiKey)
+	res, err := stmt.QueryContext(dtx.Context(), apiKey)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,38 @@
+package background
+
+import (
+	"context"
+	"sync"
+)
+
+var (
+	wg sync.WaitGroup
+)
+
+// Go runs fn in its own goroutine and tracks it, so that Wait can hold a
+// shutdown open until work started on behalf of a request (analytics,
+// cache writes, uploads) has finished.
+func Go(fn func()) {
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		fn()
+	}()
+}
+
+// Wait blocks until every goroutine started with Go has returned, or until
+// ctx is done, in which case ctx's error is returned.
+func Wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,37 @@
+package background
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestBackground(t *testing.T) {
+	Convey("Testing Wait", t, func() {
+		var finished int32
+		for i := 0; i < 10; i++ {
+			Go(func() {
+				time.Sleep(10 * time.Millisecond)
+				atomic.AddInt32(&finished, 1)
+			})
+		}
+		err := Wait(context.Background())
+		So(err, ShouldBeNil)
+		So(atomic.LoadInt32(&finished), ShouldEqual, 10)
+
+		release := make(chan struct{})
+		Go(func() {
+			<-release
+		})
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+		err = Wait(ctx)
+		So(err, ShouldEqual, context.DeadlineExceeded)
+
+		close(release)
+		So(Wait(context.Background()), ShouldBeNil)
+	})
+}
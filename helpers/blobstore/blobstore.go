@@ -0,0 +1,93 @@
+// Package blobstore keeps named files, such as catalog snapshots, somewhere
+// they can be read back from later.
+package blobstore
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Store keeps blobs by name. Names are slash separated paths.
+type Store interface {
+	// Put writes the blob, replacing any blob by that name. A blob
+	// isn't visible until it's been written in full.
+	Put(name string, r io.Reader) error
+	Open(name string) (io.ReadCloser, error)
+	Remove(name string) error
+}
+
+var (
+	ErrNotFound    = errors.New("blob not found")
+	ErrInvalidName = errors.New("blob names must be relative paths")
+)
+
+// Local keeps blobs as files under a directory.
+type Local struct {
+	Dir string
+}
+
+func NewLocal(dir string) *Local {
+	return &Local{Dir: dir}
+}
+
+func (l *Local) path(name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(name))
+	if name == "" || filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", ErrInvalidName
+	}
+	return filepath.Join(l.Dir, clean), nil
+}
+
+// Put writes the blob to a temporary file beside it, and renames it into
+// place once it's complete.
+func (l *Local) Put(name string, r io.Reader) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".blob-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err = io.Copy(tmp, r); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err = tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (l *Local) Open(name string) (io.ReadCloser, error) {
+	path, err := l.path(name)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (l *Local) Remove(name string) error {
+	path, err := l.path(name)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(path)
+	if os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return err
+}
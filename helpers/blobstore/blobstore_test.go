@@ -0,0 +1,36 @@
+package blobstore
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestLocal(t *testing.T) {
+	Convey("Testing the local blob store", t, func() {
+		dir, err := ioutil.TempDir("", "blobstore")
+		So(err, ShouldBeNil)
+		defer os.RemoveAll(dir)
+		store := NewLocal(dir)
+
+		So(store.Put("catalog/1/a.csv.gz", strings.NewReader("first")), ShouldBeNil)
+		So(store.Put("catalog/1/a.csv.gz", strings.NewReader("second")), ShouldBeNil)
+		rc, err := store.Open("catalog/1/a.csv.gz")
+		So(err, ShouldBeNil)
+		data, _ := ioutil.ReadAll(rc)
+		rc.Close()
+		So(string(data), ShouldEqual, "second")
+
+		So(store.Remove("catalog/1/a.csv.gz"), ShouldBeNil)
+		_, err = store.Open("catalog/1/a.csv.gz")
+		So(err, ShouldEqual, ErrNotFound)
+		So(store.Remove("catalog/1/a.csv.gz"), ShouldEqual, ErrNotFound)
+
+		So(store.Put("../escape", strings.NewReader("x")), ShouldEqual, ErrInvalidName)
+		_, err = store.Open("/etc/passwd")
+		So(err, ShouldEqual, ErrInvalidName)
+	})
+}
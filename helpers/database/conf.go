@@ -47,8 +47,20 @@ var (
 	DB     *sql.DB
 	VcdbDB *sql.DB
 	Driver = "mysql"
+
+	// Location is the time zone MySQL dates are read in, as set by loc in
+	// the connection strings.
+	Location = loadLocation("America/Chicago")
 )
 
+func loadLocation(name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return time.Local
+	}
+	return loc
+}
+
 func Init() error {
 	var err error
 	if DB == nil {
@@ -246,6 +258,45 @@ func InitMongo() error {
 	return err
 }
 
+// Close closes the MySQL pools and the Mongo sessions opened by Init. It's
+// meant for process shutdown; a later Init will reopen everything.
+func Close() error {
+	var err error
+	for _, db := range []**sql.DB{&DB, &VcdbDB} {
+		if *db == nil {
+			continue
+		}
+		if cerr := (*db).Close(); cerr != nil {
+			err = cerr
+		}
+		*db = nil
+	}
+
+	for _, sess := range []**mgo.Session{&MongoSession, &ProductMongoSession, &CategoryMongoSession, &AriesMongoSession} {
+		if *sess == nil {
+			continue
+		}
+		(*sess).Close()
+		*sess = nil
+	}
+	return err
+}
+
+// CopySession copies the given mgo session and bounds its socket timeout by
+// the deadline on ctx, so queries issued on behalf of a request can't outlive
+// it. Callers are responsible for closing the returned session.
+func CopySession(ctx context.Context, s *mgo.Session) *mgo.Session {
+	sess := s.Copy()
+	if deadline, ok := ctx.Deadline(); ok {
+		remaining := time.Until(deadline)
+		if remaining < time.Millisecond {
+			remaining = time.Millisecond
+		}
+		sess.SetSocketTimeout(remaining)
+	}
+	return sess
+}
+
 func clientFromCredentials() (*http.Client, error) {
 
 	const SQLScope = "https://www.googleapis.com/auth/sqlservice.admin"
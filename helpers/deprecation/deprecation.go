@@ -0,0 +1,173 @@
+package deprecation
+
+import (
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/curt-labs/API/helpers/background"
+)
+
+// GoneMessage is the body returned by a route once it has been turned off.
+const GoneMessage = "This API Endpoint has been deprecated. Please contact websupport@curtgroup.com if you have any questions or comments."
+
+// Notice describes a route that is on its way out. Until Sunset the route
+// keeps working, with Deprecation, Sunset and Link headers added to every
+// response; after Sunset it returns 410 Gone.
+type Notice struct {
+	Since  time.Time // when the route was deprecated
+	Sunset time.Time // when the route stops working
+	Link   string    // the route that replaces it, if any
+}
+
+// Usage is one line of the deprecation report: how many times a single API
+// key has called a deprecated route.
+type Usage struct {
+	Method     string     `json:"method" xml:"method,attr"`
+	Route      string     `json:"route" xml:"route,attr"`
+	Key        string     `json:"key" xml:"key,attr"`
+	Calls      int64      `json:"calls" xml:"calls,attr"`
+	LastCalled time.Time  `json:"last_called" xml:"last_called,attr"`
+	Sunset     *time.Time `json:"sunset,omitempty" xml:"sunset,attr,omitempty"`
+	Link       string     `json:"link,omitempty" xml:"link,attr,omitempty"`
+}
+
+// Store persists call counts. Record is called off the request path.
+type Store interface {
+	Record(method, route, key string, at time.Time) error
+	Usage() ([]Usage, error)
+}
+
+var (
+	mu      sync.RWMutex
+	store   Store = NewMemoryStore()
+	notices       = make(map[string]Notice)
+
+	// now is swapped out in tests
+	now = time.Now
+)
+
+// SetStore replaces where call counts are kept. The default keeps them in
+// memory, which is lost on restart and not shared between instances.
+func SetStore(s Store) {
+	mu.Lock()
+	store = s
+	mu.Unlock()
+}
+
+// Gone reports whether the sunset has passed.
+func (n Notice) Gone() bool {
+	return !n.Sunset.IsZero() && !now().Before(n.Sunset)
+}
+
+// Handler registers n for the route and returns a handler that must run
+// ahead of the route's own handlers. It records the call and adds the
+// deprecation headers, or responds with 410 Gone once the sunset has passed,
+// which stops martini from running the remaining handlers.
+func Handler(method, route string, n Notice) func(http.ResponseWriter, *http.Request) {
+	mu.Lock()
+	notices[routeID(method, route)] = n
+	mu.Unlock()
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		Record(r, method, route)
+
+		if n.Gone() {
+			Gone(w)
+			return
+		}
+
+		if n.Since.IsZero() {
+			w.Header().Set("Deprecation", "true")
+		} else {
+			w.Header().Set("Deprecation", "@"+strconv.FormatInt(n.Since.Unix(), 10))
+		}
+		if !n.Sunset.IsZero() {
+			w.Header().Set("Sunset", n.Sunset.UTC().Format(http.TimeFormat))
+		}
+		if n.Link != "" {
+			w.Header().Add("Link", "<"+n.Link+">; rel=\"successor-version\"")
+		}
+	}
+}
+
+// Gone responds with 410 Gone.
+func Gone(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "text/plain")
+	w.WriteHeader(http.StatusGone)
+	w.Write([]byte(GoneMessage))
+}
+
+// Record counts a call to a deprecated route against the request's API key.
+func Record(r *http.Request, method, route string) {
+	key := apiKey(r)
+	at := now()
+
+	mu.RLock()
+	s := store
+	mu.RUnlock()
+
+	background.Go(func() {
+		if err := s.Record(method, route, key, at); err != nil {
+			log.Printf("failed to record call to deprecated route %s %s: %s\n", method, route, err)
+		}
+	})
+}
+
+// Report returns every recorded call to a deprecated route, with the
+// route's sunset and successor where it has them, sorted by route and then
+// by the number of calls.
+func Report() ([]Usage, error) {
+	mu.RLock()
+	s := store
+	mu.RUnlock()
+
+	usage, err := s.Usage()
+	if err != nil {
+		return nil, err
+	}
+
+	mu.RLock()
+	for i, u := range usage {
+		n, ok := notices[routeID(u.Method, u.Route)]
+		if !ok {
+			continue
+		}
+		if !n.Sunset.IsZero() {
+			sunset := n.Sunset
+			usage[i].Sunset = &sunset
+		}
+		usage[i].Link = n.Link
+	}
+	mu.RUnlock()
+
+	sort.Slice(usage, func(i, j int) bool {
+		if usage[i].Route != usage[j].Route {
+			return usage[i].Route < usage[j].Route
+		}
+		if usage[i].Method != usage[j].Method {
+			return usage[i].Method < usage[j].Method
+		}
+		return usage[i].Calls > usage[j].Calls
+	})
+	return usage, nil
+}
+
+func routeID(method, route string) string {
+	return method + " " + route
+}
+
+// apiKey finds the API key the same way the request middleware does.
+func apiKey(r *http.Request) string {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		key = r.FormValue("key")
+	}
+	if key == "" {
+		key = r.Header.Get("key")
+	}
+	return key
+}
@@ -0,0 +1,96 @@
+package deprecation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/helpers/background"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestDeprecation(t *testing.T) {
+	Convey("Testing deprecation", t, func() {
+		var clock time.Time
+		now = func() time.Time { return clock }
+		defer func() { now = time.Now }()
+
+		reset := func() {
+			SetStore(NewMemoryStore())
+			clock = time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+		}
+
+		n := Notice{
+			Since:  time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+			Sunset: time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+			Link:   "/v4/part",
+		}
+		h := Handler("GET", "/part/:part/old", n)
+
+		call := func(key string) *httptest.ResponseRecorder {
+			rec := httptest.NewRecorder()
+			h(rec, httptest.NewRequest("GET", "/part/11000/old?key="+key, nil))
+			return rec
+		}
+
+		Convey("before the sunset the route keeps working with headers", func() {
+			reset()
+			rec := call("abc")
+			So(rec.Code, ShouldEqual, http.StatusOK)
+			So(rec.Header().Get("Deprecation"), ShouldEqual, "@1790812800")
+			So(rec.Header().Get("Sunset"), ShouldEqual, "Thu, 01 Apr 2027 00:00:00 GMT")
+			So(rec.Header().Get("Link"), ShouldEqual, `</v4/part>; rel="successor-version"`)
+			So(n.Gone(), ShouldBeFalse)
+		})
+
+		Convey("after the sunset the route is gone", func() {
+			reset()
+			clock = n.Sunset
+			rec := call("abc")
+			So(rec.Code, ShouldEqual, http.StatusGone)
+			So(rec.Body.String(), ShouldEqual, GoneMessage)
+			So(rec.Header().Get("Sunset"), ShouldEqual, "")
+			So(n.Gone(), ShouldBeTrue)
+		})
+
+		Convey("calls are counted per API key", func() {
+			reset()
+			call("abc")
+			call("abc")
+			call("def")
+			So(background.Wait(context.Background()), ShouldBeNil)
+
+			report, err := Report()
+			So(err, ShouldBeNil)
+			So(report, ShouldHaveLength, 2)
+			So(report[0].Key, ShouldEqual, "abc")
+			So(report[0].Calls, ShouldEqual, 2)
+			So(report[0].Method, ShouldEqual, "GET")
+			So(report[0].Route, ShouldEqual, "/part/:part/old")
+			So(report[0].LastCalled, ShouldEqual, clock)
+			So(report[0].Link, ShouldEqual, "/v4/part")
+			So(*report[0].Sunset, ShouldEqual, n.Sunset)
+			So(report[1].Key, ShouldEqual, "def")
+			So(report[1].Calls, ShouldEqual, 1)
+		})
+
+		Convey("routes that are already gone are counted too", func() {
+			reset()
+			rec := httptest.NewRecorder()
+			req := httptest.NewRequest("DELETE", "/blogs/1", nil)
+			req.Header.Set("key", "xyz")
+			Record(req, "DELETE", "/blogs/:id")
+			Gone(rec)
+			So(rec.Code, ShouldEqual, http.StatusGone)
+			So(background.Wait(context.Background()), ShouldBeNil)
+
+			report, err := Report()
+			So(err, ShouldBeNil)
+			So(report, ShouldHaveLength, 1)
+			So(report[0].Key, ShouldEqual, "xyz")
+			So(report[0].Sunset, ShouldBeNil)
+		})
+	})
+}
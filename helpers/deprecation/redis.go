@@ -0,0 +1,50 @@
+package deprecation
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/curt-labs/API/helpers/redis"
+)
+
+const (
+	redisCallsKey = "deprecation:calls"
+	redisLastKey  = "deprecation:last"
+)
+
+// RedisStore keeps call counts in redis so they're shared between instances
+// and survive restarts.
+type RedisStore struct{}
+
+func (RedisStore) Record(method, route, key string, at time.Time) error {
+	field := usageField(method, route, key)
+	if err := redis.Hincrby(redisCallsKey, field, 1); err != nil {
+		return err
+	}
+	return redis.Hset(redisLastKey, field, at.Unix())
+}
+
+func (RedisStore) Usage() ([]Usage, error) {
+	calls, err := redis.Hgetall(redisCallsKey)
+	if err != nil {
+		return nil, err
+	}
+	last, err := redis.Hgetall(redisLastKey)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := make([]Usage, 0, len(calls))
+	for field, count := range calls {
+		u, ok := parseUsageField(field)
+		if !ok {
+			continue
+		}
+		u.Calls, _ = strconv.ParseInt(count, 10, 64)
+		if ts, err := strconv.ParseInt(last[field], 10, 64); err == nil {
+			u.LastCalled = time.Unix(ts, 0).UTC()
+		}
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
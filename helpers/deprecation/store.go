@@ -0,0 +1,70 @@
+package deprecation
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+type counter struct {
+	calls int64
+	last  time.Time
+}
+
+// MemoryStore keeps call counts for the life of the process.
+type MemoryStore struct {
+	mu     sync.Mutex
+	counts map[string]*counter
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{counts: make(map[string]*counter)}
+}
+
+func (s *MemoryStore) Record(method, route, key string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	field := usageField(method, route, key)
+	c, ok := s.counts[field]
+	if !ok {
+		c = &counter{}
+		s.counts[field] = c
+	}
+	c.calls++
+	if at.After(c.last) {
+		c.last = at
+	}
+	return nil
+}
+
+func (s *MemoryStore) Usage() ([]Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	usage := make([]Usage, 0, len(s.counts))
+	for field, c := range s.counts {
+		u, ok := parseUsageField(field)
+		if !ok {
+			continue
+		}
+		u.Calls = c.calls
+		u.LastCalled = c.last
+		usage = append(usage, u)
+	}
+	return usage, nil
+}
+
+// usageField joins a route and API key with tabs, which can't appear in
+// either.
+func usageField(method, route, key string) string {
+	return method + "\t" + route + "\t" + key
+}
+
+func parseUsageField(field string) (Usage, bool) {
+	parts := strings.SplitN(field, "\t", 3)
+	if len(parts) != 3 {
+		return Usage{}, false
+	}
+	return Usage{Method: parts[0], Route: parts[1], Key: parts[2]}, true
+}
@@ -0,0 +1,309 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/go-martini/martini"
+)
+
+// Transform reshapes the JSON object produced for a single value. value is
+// the Go value that was encoded, obj is its encoded form, which may be
+// modified in place.
+type Transform func(value interface{}, obj map[string]interface{})
+
+// Version is a set of response transforms applied on top of the models'
+// own encoding, so that a newer API version can fix field names and shapes
+// without forking the model packages. Transforms only apply to JSON
+// responses; XML is encoded straight from the models' xml tags.
+type Version struct {
+	Name string
+
+	mu         sync.RWMutex
+	transforms map[reflect.Type][]Transform
+}
+
+func NewVersion(name string) *Version {
+	return &Version{
+		Name:       name,
+		transforms: make(map[reflect.Type][]Transform),
+	}
+}
+
+// Transform registers fn for every value with the same type as sample,
+// wherever it appears in a response.
+func (v *Version) Transform(sample interface{}, fn Transform) {
+	t := indirectType(reflect.TypeOf(sample))
+	v.mu.Lock()
+	v.transforms[t] = append(v.transforms[t], fn)
+	v.mu.Unlock()
+}
+
+// Rename moves the key from to the key to on every value with the same type
+// as sample.
+func (v *Version) Rename(sample interface{}, from, to string) {
+	v.Transform(sample, func(_ interface{}, obj map[string]interface{}) {
+		if val, ok := obj[from]; ok {
+			delete(obj, from)
+			obj[to] = val
+		}
+	})
+}
+
+// MapEncoder is a martini handler that wraps the Encoder mapped by
+// encoding.MapEncoder, so it must run after it.
+func (v *Version) MapEncoder(c martini.Context, w http.ResponseWriter, enc Encoder) {
+	w.Header().Set("X-API-Version", v.Name)
+	c.MapTo(v.Encoder(enc), (*Encoder)(nil))
+}
+
+// Encoder returns enc with the version's transforms applied to JSON.
+func (v *Version) Encoder(enc Encoder) Encoder {
+	if _, ok := enc.(JsonEncoder); !ok {
+		return enc
+	}
+	return versionEncoder{Encoder: enc, version: v}
+}
+
+type versionEncoder struct {
+	Encoder
+	version *Version
+}
+
+func (ve versionEncoder) Encode(v ...interface{}) (string, error) {
+	var data interface{} = v
+	if v == nil {
+		return ve.Encoder.Encode()
+	} else if len(v) == 1 {
+		data = v[0]
+	}
+
+	tree, err := ve.version.Apply(data)
+	if err != nil {
+		return "", err
+	}
+	return ve.Encoder.Encode(tree)
+}
+
+// Apply encodes data as JSON and returns the decoded result with the
+// version's transforms applied.
+func (v *Version) Apply(data interface{}) (interface{}, error) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	var tree interface{}
+	if err := dec.Decode(&tree); err != nil {
+		return nil, err
+	}
+
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	if len(v.transforms) == 0 {
+		return tree, nil
+	}
+	return v.walk(tree, reflect.ValueOf(data)), nil
+}
+
+var marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+
+// walk follows the decoded JSON alongside the value it was encoded from,
+// so that transforms can be matched by Go type.
+func (v *Version) walk(tree interface{}, val reflect.Value) interface{} {
+	for val.IsValid() && (val.Kind() == reflect.Ptr || val.Kind() == reflect.Interface) {
+		if val.IsNil() {
+			return tree
+		}
+		val = val.Elem()
+	}
+	if !val.IsValid() || val.Type().Implements(marshalerType) || reflect.PtrTo(val.Type()).Implements(marshalerType) {
+		return tree
+	}
+
+	switch val.Kind() {
+	case reflect.Struct:
+		obj, ok := tree.(map[string]interface{})
+		if !ok {
+			return tree
+		}
+		for name, index := range JSONFields(val.Type()) {
+			child, ok := obj[name]
+			if !ok {
+				continue
+			}
+			if fv, ok := fieldByIndex(val, index); ok {
+				obj[name] = v.walk(child, fv)
+			}
+		}
+		if fns := v.transforms[val.Type()]; len(fns) > 0 && val.CanInterface() {
+			value := val.Interface()
+			for _, fn := range fns {
+				fn(value, obj)
+			}
+		}
+		return obj
+	case reflect.Slice, reflect.Array:
+		arr, ok := tree.([]interface{})
+		if !ok || len(arr) != val.Len() {
+			return tree
+		}
+		for i := range arr {
+			arr[i] = v.walk(arr[i], val.Index(i))
+		}
+		return arr
+	case reflect.Map:
+		obj, ok := tree.(map[string]interface{})
+		if !ok {
+			return tree
+		}
+		for _, key := range val.MapKeys() {
+			var name string
+			switch key.Kind() {
+			case reflect.String:
+				name = key.String()
+			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+				name = strconv.FormatInt(key.Int(), 10)
+			case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+				name = strconv.FormatUint(key.Uint(), 10)
+			default:
+				continue
+			}
+			if child, ok := obj[name]; ok {
+				obj[name] = v.walk(child, val.MapIndex(key))
+			}
+		}
+		return obj
+	}
+	return tree
+}
+
+func fieldByIndex(val reflect.Value, index []int) (reflect.Value, bool) {
+	for i, idx := range index {
+		if i > 0 {
+			if val.Kind() == reflect.Ptr {
+				if val.IsNil() {
+					return reflect.Value{}, false
+				}
+				val = val.Elem()
+			}
+		}
+		val = val.Field(idx)
+	}
+	return val, true
+}
+
+func indirectType(t reflect.Type) reflect.Type {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	return t
+}
+
+var fieldCache sync.Map // map[reflect.Type]map[string][]int
+
+type jsonField struct {
+	index  []int
+	tagged bool
+}
+
+// JSONFields returns the encoded name of each field of t and the index path
+// to reach it, resolving embedded structs and name conflicts the same way
+// encoding/json does.
+func JSONFields(t reflect.Type) map[string][]int {
+	if f, ok := fieldCache.Load(t); ok {
+		return f.(map[string][]int)
+	}
+
+	type queued struct {
+		typ   reflect.Type
+		index []int
+	}
+	var current []queued
+	next := []queued{{typ: t}}
+	visited := map[reflect.Type]bool{}
+	fields := map[string][]int{}
+
+	for len(next) > 0 {
+		current, next = next, nil
+		level := map[string][]jsonField{}
+
+		for _, q := range current {
+			if visited[q.typ] {
+				continue
+			}
+			visited[q.typ] = true
+
+			for i := 0; i < q.typ.NumField(); i++ {
+				sf := q.typ.Field(i)
+				ft := indirectType(sf.Type)
+				if sf.Anonymous {
+					if sf.PkgPath != "" && ft.Kind() != reflect.Struct {
+						continue
+					}
+				} else if sf.PkgPath != "" {
+					continue
+				}
+
+				tag := sf.Tag.Get("json")
+				if tag == "-" {
+					continue
+				}
+				name := tag
+				if idx := strings.Index(tag, ","); idx >= 0 {
+					name = tag[:idx]
+				}
+				index := append(append([]int{}, q.index...), i)
+
+				if name == "" && sf.Anonymous && ft.Kind() == reflect.Struct {
+					next = append(next, queued{typ: ft, index: index})
+					continue
+				}
+				tagged := name != ""
+				if name == "" {
+					name = sf.Name
+				}
+				level[name] = append(level[name], jsonField{index: index, tagged: tagged})
+			}
+		}
+
+		for name, candidates := range level {
+			if _, ok := fields[name]; ok {
+				// a shallower field already has this name
+				continue
+			}
+			if len(candidates) == 1 {
+				fields[name] = candidates[0].index
+				continue
+			}
+			var tagged []jsonField
+			for _, c := range candidates {
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+			if len(tagged) == 1 {
+				fields[name] = tagged[0].index
+			} else {
+				// ambiguous: encoding/json drops the name, but it still
+				// hides any deeper field with the same name
+				fields[name] = nil
+			}
+		}
+	}
+
+	for name, index := range fields {
+		if index == nil {
+			delete(fields, name)
+		}
+	}
+	fieldCache.Store(t, fields)
+	return fields
+}
@@ -0,0 +1,90 @@
+package encoding
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testPart struct {
+	ID         int         `json:"id" xml:"id"`
+	Attributes []string    `json:"vehicle_atttributes" xml:"vehicle_attributes"`
+	Created    time.Time   `json:"created"`
+	Related    []*testPart `json:"related,omitempty"`
+	Big        int64       `json:"big"`
+}
+
+type testDealer struct {
+	testBase
+	Name      string  `json:"name"`
+	Latitude  float64 `json:"-"`
+	Longitude float64 `json:"-"`
+}
+
+type testBase struct {
+	Parts map[string]testPart `json:"parts"`
+}
+
+func TestVersion(t *testing.T) {
+	Convey("Testing Version", t, func() {
+		v := NewVersion("4")
+		v.Rename(testPart{}, "vehicle_atttributes", "vehicle_attributes")
+		v.Transform(&testDealer{}, func(value interface{}, obj map[string]interface{}) {
+			d := value.(testDealer)
+			obj["coords"] = map[string]interface{}{"latitude": d.Latitude, "longitude": d.Longitude}
+		})
+
+		part := testPart{
+			ID:         1,
+			Attributes: []string{"Hitch"},
+			Created:    time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC),
+			Related:    []*testPart{{ID: 2, Attributes: []string{"Ball"}}},
+			Big:        1<<62 + 1,
+		}
+
+		Convey("fields are renamed wherever the type appears", func() {
+			out, err := v.Encoder(JsonEncoder{}).Encode([]testPart{part})
+			So(err, ShouldBeNil)
+			So(out, ShouldNotContainSubstring, "atttributes")
+
+			var parts []map[string]interface{}
+			So(json.Unmarshal([]byte(out), &parts), ShouldBeNil)
+			So(parts[0]["vehicle_attributes"], ShouldResemble, []interface{}{"Hitch"})
+			So(parts[0]["created"], ShouldEqual, "2026-10-19T00:00:00Z")
+			related := parts[0]["related"].([]interface{})[0].(map[string]interface{})
+			So(related["vehicle_attributes"], ShouldResemble, []interface{}{"Ball"})
+			So(out, ShouldContainSubstring, `"big":4611686018427387905`)
+		})
+
+		Convey("transforms can add fields the models never encoded", func() {
+			d := &testDealer{
+				testBase:  testBase{Parts: map[string]testPart{"11000": part}},
+				Name:      "Bob's Hitches",
+				Latitude:  44.8,
+				Longitude: -91.5,
+			}
+			plain, err := JsonEncoder{}.Encode(d)
+			So(err, ShouldBeNil)
+			So(plain, ShouldNotContainSubstring, "coords")
+
+			out, err := v.Encoder(JsonEncoder{}).Encode(d)
+			So(err, ShouldBeNil)
+
+			var obj map[string]interface{}
+			So(json.Unmarshal([]byte(out), &obj), ShouldBeNil)
+			So(obj["coords"], ShouldResemble, map[string]interface{}{"latitude": 44.8, "longitude": -91.5})
+			p := obj["parts"].(map[string]interface{})["11000"].(map[string]interface{})
+			So(p, ShouldContainKey, "vehicle_attributes")
+		})
+
+		Convey("other encoders are left alone", func() {
+			So(v.Encoder(XmlEncoder{}), ShouldResemble, XmlEncoder{})
+
+			out, err := v.Encoder(JsonEncoder{}).Encode()
+			So(err, ShouldBeNil)
+			So(out, ShouldEqual, "[]")
+		})
+	})
+}
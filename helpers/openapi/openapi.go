@@ -0,0 +1,544 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curt-labs/API/helpers/deprecation"
+)
+
+// Auth policies a route can be documented with.
+const (
+	AuthNone     = "none"     // no API key required
+	AuthKey      = "key"      // public or private customer API key
+	AuthInternal = "internal" // internal API key only
+)
+
+// Operation is the documentation attached to a route. It's passed to the
+// router alongside the route's handlers and stripped out before the route is
+// handed to martini, e.g.
+//
+//	r.Get("/:part", openapi.Operation{Summary: "...", Response: products.Part{}}, part_ctlr.PartNumber)
+type Operation struct {
+	Summary     string
+	Description string
+	Tags        []string
+	Params      []Param
+	// Request and Response are zero values of the types sent and returned,
+	// e.g. products.Part{} or []products.Part{}. Either may be nil.
+	Request  interface{}
+	Response interface{}
+	// Auth overrides the policy otherwise derived from the route's handlers.
+	Auth string
+}
+
+// Param documents a query string or form parameter. Path parameters are
+// picked up from the route pattern and don't need to be listed.
+type Param struct {
+	Name        string
+	In          string // "query" (default), "header" or "formData"
+	Description string
+	Required    bool
+	Type        string // "string" (default), "integer", "number", "boolean"
+}
+
+// Route is a single registered method and path, with its documentation.
+type Route struct {
+	Method     string
+	Pattern    string // the full martini pattern, e.g. /v4/part/:part
+	Mount      string // the prefix the route is mounted under, e.g. /v4
+	Path       string // the OpenAPI path relative to its server, e.g. /part/{part}
+	Operation  Operation
+	Deprecated bool
+	Notice     *deprecation.Notice
+	Auth       string
+	Handler    string
+}
+
+// Registry collects the routes registered through a wrapped router and
+// builds an OpenAPI document from them.
+type Registry struct {
+	Title   string
+	Version string
+
+	mu         sync.RWMutex
+	routes     []Route
+	servers    []string
+	deprecated map[uintptr]bool
+	policies   map[uintptr]string
+	excused    []string
+	patches    map[reflect.Type][]func(props map[string]interface{})
+}
+
+func NewRegistry(title, version string) *Registry {
+	return &Registry{
+		Title:      title,
+		Version:    version,
+		deprecated: make(map[uintptr]bool),
+		policies:   make(map[uintptr]string),
+		patches:    make(map[reflect.Type][]func(props map[string]interface{})),
+	}
+}
+
+// PatchSchema lets fn modify the properties documented for the type of
+// sample, for responses that are reshaped on their way out (see
+// encoding.Version).
+func (reg *Registry) PatchSchema(sample interface{}, fn func(props map[string]interface{})) {
+	t := reflect.TypeOf(sample)
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	reg.mu.Lock()
+	reg.patches[t] = append(reg.patches[t], fn)
+	reg.mu.Unlock()
+}
+
+func (reg *Registry) addServer(prefix string) {
+	prefix = serverURL(prefix)
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+	for _, s := range reg.servers {
+		if s == prefix {
+			return
+		}
+	}
+	reg.servers = append(reg.servers, prefix)
+}
+
+// MarkDeprecated flags a handler as the "this route is gone" handler. Routes
+// using it are left out of the generated document.
+func (reg *Registry) MarkDeprecated(handler interface{}) {
+	reg.mu.Lock()
+	reg.deprecated[funcPointer(handler)] = true
+	reg.mu.Unlock()
+}
+
+// Policy documents every route using handler (typically an authentication
+// middleware) with the given auth policy.
+func (reg *Registry) Policy(handler interface{}, policy string) {
+	reg.mu.Lock()
+	reg.policies[funcPointer(handler)] = policy
+	reg.mu.Unlock()
+}
+
+// Unauthenticated documents routes whose path contains any of the given
+// fragments as not requiring an API key. It mirrors the way the request
+// middleware matches excused routes.
+func (reg *Registry) Unauthenticated(fragments ...string) {
+	reg.mu.Lock()
+	reg.excused = append(reg.excused, fragments...)
+	reg.mu.Unlock()
+}
+
+// Routes returns a copy of everything registered so far, in registration
+// order.
+func (reg *Registry) Routes() []Route {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+	routes := make([]Route, len(reg.routes))
+	copy(routes, reg.routes)
+	return routes
+}
+
+// add records a route and returns its handlers with any Operation removed.
+// Handlers inherited from enclosing groups count towards the route's auth
+// policy but are otherwise left to the router.
+func (reg *Registry) add(method, mount, pattern string, inherited, handlers []interface{}) []interface{} {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	rt := Route{
+		Method:  strings.ToLower(method),
+		Pattern: mount + pattern,
+		Mount:   mount,
+		Path:    ConvertPath(pattern),
+		Auth:    AuthKey,
+	}
+
+	for _, h := range inherited {
+		if policy, ok := reg.policies[funcPointer(h)]; ok {
+			rt.Auth = policy
+		}
+	}
+
+	var remaining []interface{}
+	for _, h := range handlers {
+		switch op := h.(type) {
+		case Operation:
+			rt.Operation = op
+			continue
+		case *Operation:
+			rt.Operation = *op
+			continue
+		case deprecation.Notice:
+			rt.Notice = &op
+			remaining = append(remaining, deprecation.Handler(method, rt.Pattern, op))
+			continue
+		}
+
+		ptr := funcPointer(h)
+		if reg.deprecated[ptr] {
+			rt.Deprecated = true
+		}
+		if policy, ok := reg.policies[ptr]; ok {
+			rt.Auth = policy
+		}
+		rt.Handler = funcName(h)
+		remaining = append(remaining, h)
+	}
+
+	for _, ex := range reg.excused {
+		if strings.Contains(rt.Pattern, ex) {
+			rt.Auth = AuthNone
+		}
+	}
+	if rt.Operation.Auth != "" {
+		rt.Auth = rt.Operation.Auth
+	}
+
+	reg.routes = append(reg.routes, rt)
+	return remaining
+}
+
+// Handler serves the generated document as JSON.
+func (reg *Registry) Handler(w http.ResponseWriter, r *http.Request) {
+	data, err := json.MarshalIndent(reg.Document(), "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(data)
+}
+
+// Document builds the OpenAPI 3 document for every registered route that
+// hasn't been turned off. Routes that are deprecated but still working are
+// marked as such. Paths that aren't mounted under every server list the
+// servers they are mounted under.
+func (reg *Registry) Document() map[string]interface{} {
+	schemas := newSchemaSet()
+	paths := make(map[string]map[string]interface{})
+	mounts := make(map[string]map[string]bool)
+
+	routes := reg.Routes()
+	// A parameter constrained by a regular expression only matches some of
+	// its path, e.g. /part/:part((.*?)\.(PDF|pdf)$), so it's only
+	// documented if nothing unconstrained has the same method and path.
+	sort.SliceStable(routes, func(i, j int) bool {
+		return !constrained(routes[i].Pattern) && constrained(routes[j].Pattern)
+	})
+	for _, rt := range routes {
+		if rt.Deprecated || rt.Notice != nil && rt.Notice.Gone() {
+			continue
+		}
+		if paths[rt.Path] == nil {
+			paths[rt.Path] = make(map[string]interface{})
+			mounts[rt.Path] = make(map[string]bool)
+		}
+		mounts[rt.Path][serverURL(rt.Mount)] = true
+		// the first registration of a method and path wins, same as the router
+		if _, ok := paths[rt.Path][rt.Method]; ok {
+			continue
+		}
+		paths[rt.Path][rt.Method] = rt.operation(schemas)
+	}
+
+	reg.mu.RLock()
+	for t, fns := range reg.patches {
+		def, ok := schemas.defs[schemaName(t)].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		props, _ := def["properties"].(map[string]interface{})
+		for _, fn := range fns {
+			fn(props)
+		}
+	}
+	servers := make([]interface{}, 0, len(reg.servers))
+	for _, s := range reg.servers {
+		servers = append(servers, map[string]interface{}{"url": s})
+	}
+	for path, mounted := range mounts {
+		if len(mounted) == len(reg.servers) {
+			continue
+		}
+		var own []interface{}
+		for _, s := range reg.servers {
+			if mounted[s] {
+				own = append(own, map[string]interface{}{"url": s})
+			}
+		}
+		paths[path]["servers"] = own
+	}
+	reg.mu.RUnlock()
+
+	return map[string]interface{}{
+		"openapi": "3.0.0",
+		"info": map[string]interface{}{
+			"title":   reg.Title,
+			"version": reg.Version,
+		},
+		"servers": servers,
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas":    schemas.defs,
+			"parameters": contextParameters,
+			"securitySchemes": map[string]interface{}{
+				"apiKey": map[string]interface{}{
+					"type":        "apiKey",
+					"in":          "query",
+					"name":        "key",
+					"description": "Customer API key from the dealer portal. May also be sent as a form value or `key` header.",
+				},
+			},
+		},
+	}
+}
+
+// contextParameters are the parameters every keyed request is processed
+// with into an apicontext.DataContext.
+var contextParameters = map[string]interface{}{
+	"key": map[string]interface{}{
+		"name":        "key",
+		"in":          "query",
+		"required":    true,
+		"description": "API key. May also be sent as a form value or header.",
+		"schema":      map[string]interface{}{"type": "string"},
+	},
+	"brandID": map[string]interface{}{
+		"name":        "brandID",
+		"in":          "query",
+		"description": "Restrict results to a single brand associated with the API key. May also be sent as a form value or header.",
+		"schema":      map[string]interface{}{"type": "integer"},
+	},
+	"websiteID": map[string]interface{}{
+		"name":        "websiteID",
+		"in":          "query",
+		"description": "Website the request is made on behalf of. May also be sent as a form value or header.",
+		"schema":      map[string]interface{}{"type": "integer"},
+	},
+}
+
+func (rt Route) operation(schemas *schemaSet) map[string]interface{} {
+	op := map[string]interface{}{
+		"operationId":   operationID(rt.Method, rt.Path),
+		"x-auth-policy": rt.Auth,
+	}
+	summary := rt.Operation.Summary
+	if summary == "" {
+		summary = rt.Handler
+	}
+	if summary != "" {
+		op["summary"] = summary
+	}
+	if rt.Operation.Description != "" {
+		op["description"] = rt.Operation.Description
+	}
+	if rt.Notice != nil {
+		op["deprecated"] = true
+		if !rt.Notice.Sunset.IsZero() {
+			op["x-sunset"] = rt.Notice.Sunset.UTC().Format(time.RFC3339)
+		}
+		if rt.Notice.Link != "" {
+			op["x-successor"] = rt.Notice.Link
+		}
+	}
+
+	tags := rt.Operation.Tags
+	if len(tags) == 0 {
+		tags = []string{defaultTag(rt.Path)}
+	}
+	op["tags"] = tags
+
+	var params []interface{}
+	if rt.Auth != AuthNone {
+		op["security"] = []interface{}{map[string]interface{}{"apiKey": []string{}}}
+		for _, name := range []string{"key", "brandID", "websiteID"} {
+			params = append(params, map[string]interface{}{"$ref": "#/components/parameters/" + name})
+		}
+	}
+	for _, name := range pathParams(rt.Path) {
+		params = append(params, map[string]interface{}{
+			"name":     name,
+			"in":       "path",
+			"required": true,
+			"schema":   map[string]interface{}{"type": "string"},
+		})
+	}
+	for _, p := range rt.Operation.Params {
+		in := p.In
+		if in == "" {
+			in = "query"
+		}
+		typ := p.Type
+		if typ == "" {
+			typ = "string"
+		}
+		params = append(params, map[string]interface{}{
+			"name":        p.Name,
+			"in":          in,
+			"required":    p.Required,
+			"description": p.Description,
+			"schema":      map[string]interface{}{"type": typ},
+		})
+	}
+	if len(params) > 0 {
+		op["parameters"] = params
+	}
+
+	if rt.Operation.Request != nil {
+		op["requestBody"] = map[string]interface{}{
+			"content": content(schemas.of(reflect.TypeOf(rt.Operation.Request))),
+		}
+	}
+
+	ok := map[string]interface{}{"description": "OK"}
+	if rt.Operation.Response != nil {
+		ok["content"] = content(schemas.of(reflect.TypeOf(rt.Operation.Response)))
+	}
+	op["responses"] = map[string]interface{}{
+		"200": ok,
+		"default": map[string]interface{}{
+			"description": "Error",
+			"content":     content(schemas.of(reflect.TypeOf(apiError{}))),
+		},
+	}
+	return op
+}
+
+// apiError mirrors apierror.ApiErr, which can't be imported here without
+// pulling in the database.
+type apiError struct {
+	Message        string              `json:"message"`
+	MessageDetails string              `json:"messageDetails"`
+	RequestBody    string              `json:"request_body"`
+	QueryString    map[string][]string `json:"query_string"`
+}
+
+func content(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"application/json": map[string]interface{}{"schema": schema},
+		"application/xml":  map[string]interface{}{"schema": schema},
+	}
+}
+
+// ConvertPath turns a martini route pattern into an OpenAPI path:
+// named parameters become {name} and any regular expressions or optional
+// groups around them are dropped, so /part/:part((.*?)\.(PDF|pdf)$) becomes
+// /part/{part} and /faqs/(:id) becomes /faqs/{id}.
+func ConvertPath(pattern string) string {
+	var out []byte
+	unmatched := 0
+	for i := 0; i < len(pattern); i++ {
+		c := pattern[i]
+		switch {
+		case c == '(' && i+1 < len(pattern) && pattern[i+1] == ':':
+			// optional group wrapping a parameter, drop its parens
+			unmatched++
+		case c == ')' && unmatched > 0:
+			unmatched--
+		case c == ':':
+			j := i + 1
+			for j < len(pattern) && isIdent(pattern[j]) {
+				j++
+			}
+			out = append(out, '{')
+			out = append(out, pattern[i+1:j]...)
+			out = append(out, '}')
+			// skip a regular expression constraint on the parameter
+			if j < len(pattern) && pattern[j] == '(' {
+				depth := 0
+				for ; j < len(pattern); j++ {
+					if pattern[j] == '(' {
+						depth++
+					} else if pattern[j] == ')' {
+						depth--
+						if depth == 0 {
+							j++
+							break
+						}
+					}
+				}
+			}
+			i = j - 1
+		default:
+			out = append(out, c)
+		}
+	}
+	if len(out) == 0 {
+		return "/"
+	}
+	return string(out)
+}
+
+// serverURL returns the server a route mounted under prefix is served from.
+func serverURL(prefix string) string {
+	if prefix == "" {
+		return "/"
+	}
+	return prefix
+}
+
+// constrained reports whether a martini pattern has a parameter restricted
+// by a regular expression.
+func constrained(pattern string) bool {
+	for i := strings.Index(pattern, ":"); i >= 0 && i < len(pattern); {
+		j := i + 1
+		for j < len(pattern) && isIdent(pattern[j]) {
+			j++
+		}
+		if j < len(pattern) && pattern[j] == '(' {
+			return true
+		}
+		next := strings.Index(pattern[j:], ":")
+		if next < 0 {
+			break
+		}
+		i = j + next
+	}
+	return false
+}
+
+func isIdent(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
+
+func pathParams(path string) []string {
+	var names []string
+	for {
+		start := strings.Index(path, "{")
+		if start < 0 {
+			return names
+		}
+		end := strings.Index(path[start:], "}")
+		if end < 0 {
+			return names
+		}
+		names = append(names, path[start+1:start+end])
+		path = path[start+end+1:]
+	}
+}
+
+func operationID(method, path string) string {
+	parts := []string{method}
+	for _, seg := range strings.Split(path, "/") {
+		seg = strings.Trim(seg, "{}")
+		if seg != "" {
+			parts = append(parts, seg)
+		}
+	}
+	return strings.Join(parts, "_")
+}
+
+func defaultTag(path string) string {
+	segs := strings.Split(strings.TrimPrefix(path, "/"), "/")
+	if len(segs) == 0 || segs[0] == "" {
+		return "root"
+	}
+	return segs[0]
+}
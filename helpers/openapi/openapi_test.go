@@ -0,0 +1,229 @@
+package openapi
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/helpers/deprecation"
+	"github.com/go-martini/martini"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+type testImage struct {
+	ID     int       `json:"id"`
+	Width  int       `json:"width,omitempty"`
+	Hidden string    `json:"-"`
+	Date   time.Time `json:"date"`
+}
+
+type testPart struct {
+	testBase
+	SKU      string            `json:"sku"`
+	Images   []testImage       `json:"images"`
+	Related  []*testPart       `json:"related"`
+	Attrs    map[string]string `json:"attributes"`
+	Price    float64
+	internal int
+}
+
+type testBase struct {
+	Brand string `json:"brand"`
+}
+
+func deprecated(w http.ResponseWriter, r *http.Request)   {}
+func internalOnly(w http.ResponseWriter, r *http.Request) {}
+func getPart(w http.ResponseWriter, r *http.Request)      {}
+func savePart(w http.ResponseWriter, r *http.Request)     {}
+
+func TestConvertPath(t *testing.T) {
+	Convey("Testing ConvertPath", t, func() {
+		So(ConvertPath(""), ShouldEqual, "/")
+		So(ConvertPath("/part/:part"), ShouldEqual, "/part/{part}")
+		So(ConvertPath("/part/:part/vehicles"), ShouldEqual, "/part/{part}/vehicles")
+		So(ConvertPath("/part/:part((.*?)\\.(PDF|pdf)$)"), ShouldEqual, "/part/{part}")
+		So(ConvertPath("/part/:part/:config(.+)"), ShouldEqual, "/part/{part}/{config}")
+		So(ConvertPath("/faqs/(:id)"), ShouldEqual, "/faqs/{id}")
+		So(ConvertPath("/cartIntegration/:page/:count"), ShouldEqual, "/cartIntegration/{page}/{count}")
+	})
+}
+
+func TestSchemas(t *testing.T) {
+	Convey("Testing schema generation", t, func() {
+		s := newSchemaSet()
+		ref := s.of(reflect.TypeOf(testPart{}))
+		So(ref["$ref"], ShouldEqual, "#/components/schemas/openapi.testPart")
+
+		part := s.defs["openapi.testPart"].(map[string]interface{})
+		props := part["properties"].(map[string]interface{})
+		So(props, ShouldContainKey, "brand")
+		So(props, ShouldContainKey, "sku")
+		So(props, ShouldContainKey, "Price")
+		So(props, ShouldNotContainKey, "internal")
+		So(props["related"], ShouldResemble, map[string]interface{}{
+			"type":  "array",
+			"items": map[string]interface{}{"$ref": "#/components/schemas/openapi.testPart"},
+		})
+		So(props["attributes"], ShouldResemble, map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": map[string]interface{}{"type": "string"},
+		})
+
+		img := s.defs["openapi.testImage"].(map[string]interface{})
+		imgProps := img["properties"].(map[string]interface{})
+		So(imgProps, ShouldNotContainKey, "-")
+		So(imgProps, ShouldNotContainKey, "Hidden")
+		So(imgProps["width"], ShouldResemble, map[string]interface{}{"type": "integer"})
+		So(imgProps["date"], ShouldResemble, map[string]interface{}{"type": "string", "format": "date-time"})
+	})
+}
+
+func TestRegistry(t *testing.T) {
+	Convey("Testing Registry", t, func() {
+		reg := NewRegistry("Test API", "1")
+		reg.MarkDeprecated(deprecated)
+		reg.Policy(internalOnly, AuthInternal)
+		reg.Unauthenticated("/status")
+
+		r := Wrap(martini.NewRouter(), reg)
+		r.Group("/part", func(r Router) {
+			r.Get("/:part", Operation{
+				Summary:  "Get a part",
+				Params:   []Param{{Name: "brand", Type: "integer"}},
+				Response: testPart{},
+			}, getPart)
+			r.Post("", internalOnly, Operation{Request: testPart{}, Response: testPart{}}, savePart)
+			r.Get("/:part/:year", deprecated)
+		})
+		r.Group("/admin", func(r Router) {
+			r.Delete("/:id", savePart)
+		}, internalOnly)
+		r.Get("/status", getPart)
+
+		routes := reg.Routes()
+		So(routes, ShouldHaveLength, 5)
+		So(routes[0].Pattern, ShouldEqual, "/part/:part")
+		So(routes[0].Operation.Summary, ShouldEqual, "Get a part")
+		So(routes[1].Auth, ShouldEqual, AuthInternal)
+		So(routes[2].Deprecated, ShouldBeTrue)
+		So(routes[3].Auth, ShouldEqual, AuthInternal)
+		So(routes[4].Auth, ShouldEqual, AuthNone)
+
+		Convey("the document leaves out deprecated routes", func() {
+			doc := reg.Document()
+			paths := doc["paths"].(map[string]map[string]interface{})
+			So(paths, ShouldContainKey, "/part/{part}")
+			So(paths, ShouldContainKey, "/part")
+			So(paths, ShouldNotContainKey, "/part/{part}/{year}")
+
+			get := paths["/part/{part}"]["get"].(map[string]interface{})
+			So(get["summary"], ShouldEqual, "Get a part")
+			params := get["parameters"].([]interface{})
+			// key, brandID, websiteID, the path param and the query param
+			So(params, ShouldHaveLength, 5)
+
+			status := paths["/status"]["get"].(map[string]interface{})
+			So(status["summary"], ShouldEqual, "getPart")
+			So(status, ShouldNotContainKey, "security")
+
+			schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+			So(schemas, ShouldContainKey, "openapi.testPart")
+		})
+
+		Convey("the handler serves valid JSON", func() {
+			rec := httptest.NewRecorder()
+			reg.Handler(rec, httptest.NewRequest("GET", "/openapi.json", nil))
+			So(rec.Code, ShouldEqual, http.StatusOK)
+
+			var doc map[string]interface{}
+			So(json.Unmarshal(rec.Body.Bytes(), &doc), ShouldBeNil)
+			So(doc["openapi"], ShouldEqual, "3.0.0")
+		})
+	})
+}
+
+func TestDeprecationNotices(t *testing.T) {
+	Convey("Testing routes with a deprecation notice", t, func() {
+		reg := NewRegistry("Test API", "1")
+		r := Wrap(martini.NewRouter(), reg)
+		r.Get("/old", deprecation.Notice{
+			Sunset: time.Now().Add(24 * time.Hour),
+			Link:   "/new",
+		}, getPart)
+		r.Get("/older", deprecation.Notice{Sunset: time.Now().Add(-time.Hour)}, getPart)
+
+		paths := reg.Document()["paths"].(map[string]map[string]interface{})
+		So(paths, ShouldNotContainKey, "/older")
+
+		op := paths["/old"]["get"].(map[string]interface{})
+		So(op["deprecated"], ShouldEqual, true)
+		So(op["x-successor"], ShouldEqual, "/new")
+		So(op, ShouldContainKey, "x-sunset")
+	})
+}
+
+func TestMount(t *testing.T) {
+	Convey("Testing Mount", t, func() {
+		reg := NewRegistry("Test API", "3")
+		reg.Unauthenticated("/status")
+		reg.PatchSchema(testPart{}, func(props map[string]interface{}) {
+			props["part_number"] = props["sku"]
+			delete(props, "sku")
+		})
+
+		routes := func(r Router) {
+			r.Group("/part", func(r Router) {
+				r.Get("/:part", Operation{Response: testPart{}}, getPart)
+			})
+			r.Get("/status", getPart)
+		}
+		Mount(martini.NewRouter(), reg, "/v3", routes, internalOnly)
+		Mount(martini.NewRouter(), reg, "", routes)
+		Wrap(martini.NewRouter(), reg).Get("/openapi.json", getPart)
+
+		rts := reg.Routes()
+		So(rts, ShouldHaveLength, 5)
+		So(rts[0].Pattern, ShouldEqual, "/v3/part/:part")
+		So(rts[0].Path, ShouldEqual, "/part/{part}")
+		So(rts[1].Auth, ShouldEqual, AuthNone)
+		So(rts[2].Pattern, ShouldEqual, "/part/:part")
+
+		doc := reg.Document()
+		So(doc["servers"], ShouldResemble, []interface{}{
+			map[string]interface{}{"url": "/v3"},
+			map[string]interface{}{"url": "/"},
+		})
+		paths := doc["paths"].(map[string]map[string]interface{})
+		So(paths, ShouldHaveLength, 3)
+		So(paths["/part/{part}"], ShouldNotContainKey, "servers")
+		So(paths["/openapi.json"]["servers"], ShouldResemble, []interface{}{
+			map[string]interface{}{"url": "/"},
+		})
+
+		schemas := doc["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+		props := schemas["openapi.testPart"].(map[string]interface{})["properties"].(map[string]interface{})
+		So(props, ShouldContainKey, "part_number")
+		So(props, ShouldNotContainKey, "sku")
+	})
+}
+
+func TestConstrainedRoutes(t *testing.T) {
+	Convey("Testing routes with constrained parameters", t, func() {
+		reg := NewRegistry("Test API", "1")
+		r := Wrap(martini.NewRouter(), reg)
+		r.Get("/part/:part((.*?)\\.(PDF|pdf)$)", Operation{Summary: "Download an install sheet"}, savePart)
+		r.Get("/part/:part", Operation{Summary: "Get a part"}, getPart)
+		r.Get("/sheet/:part((.*?)\\.(PDF|pdf)$)", Operation{Summary: "Download a sheet"}, savePart)
+
+		So(constrained("/part/:part((.*?)\\.(PDF|pdf)$)"), ShouldBeTrue)
+		So(constrained("/part/:part/:year"), ShouldBeFalse)
+		So(constrained("/faqs/(:id)"), ShouldBeFalse)
+
+		paths := reg.Document()["paths"].(map[string]map[string]interface{})
+		So(paths["/part/{part}"]["get"].(map[string]interface{})["summary"], ShouldEqual, "Get a part")
+		So(paths["/sheet/{part}"]["get"].(map[string]interface{})["summary"], ShouldEqual, "Download a sheet")
+	})
+}
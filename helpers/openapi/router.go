@@ -0,0 +1,109 @@
+package openapi
+
+import (
+	"github.com/go-martini/martini"
+)
+
+// Router is the subset of martini.Router used to declare routes. Routes
+// registered through it are recorded in a Registry, and any Operation in a
+// route's handler list documents that route instead of being passed on to
+// martini. A deprecation.Notice in the handler list is replaced with the
+// handler that enforces it.
+type Router interface {
+	Group(prefix string, fn func(Router), handlers ...martini.Handler)
+	Get(pattern string, handlers ...martini.Handler) martini.Route
+	Post(pattern string, handlers ...martini.Handler) martini.Route
+	Put(pattern string, handlers ...martini.Handler) martini.Route
+	Patch(pattern string, handlers ...martini.Handler) martini.Route
+	Delete(pattern string, handlers ...martini.Handler) martini.Route
+	Options(pattern string, handlers ...martini.Handler) martini.Route
+	Head(pattern string, handlers ...martini.Handler) martini.Route
+}
+
+type router struct {
+	r         martini.Router
+	reg       *Registry
+	mount     string
+	prefix    string
+	inherited []interface{}
+}
+
+// Wrap returns a Router that registers routes on r and records them in reg.
+func Wrap(r martini.Router, reg *Registry) Router {
+	reg.addServer("")
+	return &router{r: r, reg: reg}
+}
+
+// Mount registers the routes declared by fn under prefix, with handlers
+// running ahead of each of them. Routes are recorded in reg relative to the
+// prefix, which is listed as one of the document's servers, so mounting the
+// same routes at several prefixes documents them once.
+func Mount(r martini.Router, reg *Registry, prefix string, fn func(Router), handlers ...martini.Handler) {
+	reg.addServer(prefix)
+	r.Group(prefix, func(r martini.Router) {
+		fn(&router{
+			r:         r,
+			reg:       reg,
+			mount:     prefix,
+			inherited: toInterfaces(handlers),
+		})
+	}, handlers...)
+}
+
+func (rt *router) Group(prefix string, fn func(Router), handlers ...martini.Handler) {
+	inherited := append(append([]interface{}{}, rt.inherited...), toInterfaces(handlers)...)
+	rt.r.Group(prefix, func(r martini.Router) {
+		fn(&router{
+			r:         r,
+			reg:       rt.reg,
+			mount:     rt.mount,
+			prefix:    rt.prefix + prefix,
+			inherited: inherited,
+		})
+	}, handlers...)
+}
+
+func (rt *router) Get(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Get(pattern, rt.add("GET", pattern, handlers)...)
+}
+
+func (rt *router) Post(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Post(pattern, rt.add("POST", pattern, handlers)...)
+}
+
+func (rt *router) Put(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Put(pattern, rt.add("PUT", pattern, handlers)...)
+}
+
+func (rt *router) Patch(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Patch(pattern, rt.add("PATCH", pattern, handlers)...)
+}
+
+func (rt *router) Delete(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Delete(pattern, rt.add("DELETE", pattern, handlers)...)
+}
+
+func (rt *router) Options(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Options(pattern, rt.add("OPTIONS", pattern, handlers)...)
+}
+
+func (rt *router) Head(pattern string, handlers ...martini.Handler) martini.Route {
+	return rt.r.Head(pattern, rt.add("HEAD", pattern, handlers)...)
+}
+
+func (rt *router) add(method, pattern string, handlers []martini.Handler) []martini.Handler {
+	remaining := rt.reg.add(method, rt.mount, rt.prefix+pattern, rt.inherited, toInterfaces(handlers))
+	out := make([]martini.Handler, len(remaining))
+	for i, h := range remaining {
+		out[i] = h
+	}
+	return out
+}
+
+func toInterfaces(handlers []martini.Handler) []interface{} {
+	out := make([]interface{}, len(handlers))
+	for i, h := range handlers {
+		out[i] = h
+	}
+	return out
+}
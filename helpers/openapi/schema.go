@@ -0,0 +1,123 @@
+package openapi
+
+import (
+	"net/url"
+	"reflect"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/curt-labs/API/helpers/encoding"
+)
+
+var (
+	timeType = reflect.TypeOf(time.Time{})
+	urlType  = reflect.TypeOf(url.URL{})
+)
+
+// schemaSet builds JSON schemas from Go types, collecting named structs
+// under components/schemas so that they're described once and referenced
+// everywhere else.
+type schemaSet struct {
+	defs map[string]interface{}
+}
+
+func newSchemaSet() *schemaSet {
+	return &schemaSet{defs: make(map[string]interface{})}
+}
+
+// of returns the schema for t, registering any structs it contains.
+func (s *schemaSet) of(t reflect.Type) map[string]interface{} {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch t {
+	case timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case urlType:
+		return map[string]interface{}{"type": "string", "format": "uri"}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": s.of(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		name := schemaName(t)
+		if _, ok := s.defs[name]; !ok {
+			// reserve the name first so recursive types terminate
+			s.defs[name] = map[string]interface{}{}
+			s.defs[name] = s.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	}
+	// interfaces, funcs and channels: anything goes
+	return map[string]interface{}{}
+}
+
+func (s *schemaSet) object(t reflect.Type) map[string]interface{} {
+	props := make(map[string]interface{})
+	for name, index := range encoding.JSONFields(t) {
+		props[name] = s.of(t.FieldByIndex(index).Type)
+	}
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// schemaName qualifies a type with its package, so products.Price and
+// cartIntegration.Price don't collide.
+func schemaName(t reflect.Type) string {
+	pkg := t.PkgPath()
+	if idx := strings.LastIndex(pkg, "/"); idx >= 0 {
+		pkg = pkg[idx+1:]
+	}
+	if pkg == "" {
+		return t.Name()
+	}
+	return pkg + "." + t.Name()
+}
+
+func funcPointer(h interface{}) uintptr {
+	v := reflect.ValueOf(h)
+	if v.Kind() != reflect.Func {
+		return 0
+	}
+	return v.Pointer()
+}
+
+// funcName returns the unqualified name of a handler, e.g. "PartNumber" for
+// part_ctlr.PartNumber. Closures have no useful name and return "".
+func funcName(h interface{}) string {
+	ptr := funcPointer(h)
+	if ptr == 0 {
+		return ""
+	}
+	fn := runtime.FuncForPC(ptr)
+	if fn == nil {
+		return ""
+	}
+	name := fn.Name()
+	if idx := strings.LastIndex(name, "."); idx >= 0 {
+		name = name[idx+1:]
+	}
+	if strings.HasPrefix(name, "func") {
+		return ""
+	}
+	return name
+}
@@ -6,8 +6,10 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
+	"github.com/curt-labs/API/helpers/background"
 	redix "github.com/garyburd/redigo/redis"
 )
 
@@ -17,7 +19,79 @@ const (
 	CacheTimeout      = 86400
 )
 
+var (
+	poolMu     sync.Mutex
+	masterPool *redix.Pool
+	clientPool *redix.Pool
+)
+
+// RedisPool returns the shared connection pool for either the master or the
+// read client, creating it on first use.
 func RedisPool(master bool) *redix.Pool {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	if master {
+		if masterPool == nil {
+			masterPool = newPool(master)
+		}
+		return masterPool
+	}
+	if clientPool == nil {
+		clientPool = newPool(master)
+	}
+	return clientPool
+}
+
+// Close closes both connection pools. Any later call will open new ones.
+func Close() error {
+	poolMu.Lock()
+	defer poolMu.Unlock()
+
+	var err error
+	if masterPool != nil {
+		err = masterPool.Close()
+		masterPool = nil
+	}
+	if clientPool != nil {
+		if cerr := clientPool.Close(); cerr != nil {
+			err = cerr
+		}
+		clientPool = nil
+	}
+	return err
+}
+
+// SetexAsync is Setex run as tracked background work, so a shutdown waits
+// for the write instead of dropping it.
+func SetexAsync(key string, obj interface{}, exp int) {
+	background.Go(func() {
+		Setex(key, obj, exp)
+	})
+}
+
+// SetexBytesAsync is SetexBytes run as tracked background work.
+func SetexBytesAsync(key string, data []byte, exp int) {
+	background.Go(func() {
+		SetexBytes(key, data, exp)
+	})
+}
+
+// SetAsync is Set run as tracked background work.
+func SetAsync(key string, obj interface{}) {
+	background.Go(func() {
+		Set(key, obj)
+	})
+}
+
+// DeleteAsync is Delete run as tracked background work.
+func DeleteAsync(key string) {
+	background.Go(func() {
+		Delete(key)
+	})
+}
+
+func newPool(master bool) *redix.Pool {
 	addr := "127.0.0.1:6379"
 	password := os.Getenv("REDIS_PASSWORD")
 
@@ -66,10 +140,9 @@ func Get(key string) ([]byte, error) {
 		return data, errors.New(PoolAllocationErr)
 	}
 
-	conn, err := pool.Dial()
-	if err != nil {
-		return data, err
-	} else if conn.Err() != nil {
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
 		return data, err
 	}
 
@@ -95,6 +168,7 @@ func Setex(key string, obj interface{}, exp int) error {
 		return errors.New(PoolAllocationErr)
 	}
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return err
 	}
@@ -103,6 +177,24 @@ func Setex(key string, obj interface{}, exp int) error {
 	return err
 }
 
+// SetexBytes is Setex for data that's already encoded, such as a gzipped
+// file, which is stored as is rather than as JSON.
+func SetexBytes(key string, data []byte, exp int) error {
+	pool := RedisPool(true)
+	if pool == nil {
+		return errors.New(PoolAllocationErr)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("SETEX", fmt.Sprintf("%s:%s", Prefix, key), exp, data)
+	return err
+}
+
 func Set(key string, obj interface{}) error {
 	pool := RedisPool(true)
 	if pool == nil {
@@ -114,6 +206,7 @@ func Set(key string, obj interface{}) error {
 	}
 
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return err
 	}
@@ -133,6 +226,7 @@ func Lpush(key string, obj interface{}) error {
 	}
 
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return err
 	}
@@ -141,6 +235,53 @@ func Lpush(key string, obj interface{}) error {
 	return err
 }
 
+func Hincrby(key, field string, n int64) error {
+	pool := RedisPool(true)
+	if pool == nil {
+		return errors.New(PoolAllocationErr)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("HINCRBY", fmt.Sprintf("%s:%s", Prefix, key), field, n)
+	return err
+}
+
+func Hset(key, field string, value interface{}) error {
+	pool := RedisPool(true)
+	if pool == nil {
+		return errors.New(PoolAllocationErr)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return err
+	}
+
+	_, err := conn.Do("HSET", fmt.Sprintf("%s:%s", Prefix, key), field, value)
+	return err
+}
+
+func Hgetall(key string) (map[string]string, error) {
+	pool := RedisPool(false)
+	if pool == nil {
+		return nil, errors.New(PoolAllocationErr)
+	}
+
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
+		return nil, err
+	}
+
+	return redix.StringMap(conn.Do("HGETALL", fmt.Sprintf("%s:%s", Prefix, key)))
+}
+
 func Delete(key string) error {
 	var err error
 	pool := RedisPool(true)
@@ -149,6 +290,7 @@ func Delete(key string) error {
 	}
 
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return err
 	}
@@ -165,6 +307,7 @@ func GetNamespaces() (namespaces map[string][]string, err error) {
 	}
 
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return namespaces, err
 	}
@@ -193,6 +336,7 @@ func DeleteFullPath(key string) error {
 	}
 
 	conn := pool.Get()
+	defer conn.Close()
 	if conn.Err() != nil {
 		return err
 	}
@@ -209,10 +353,9 @@ func GetFullPath(key string) ([]string, error) {
 		return data, errors.New(PoolAllocationErr)
 	}
 
-	conn, err := pool.Dial()
-	if err != nil {
-		return data, err
-	} else if conn.Err() != nil {
+	conn := pool.Get()
+	defer conn.Close()
+	if err := conn.Err(); err != nil {
 		return data, err
 	}
 
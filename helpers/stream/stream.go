@@ -0,0 +1,38 @@
+// Package stream helps handlers write responses that are generated as
+// they're sent, such as catalog exports.
+package stream
+
+import (
+	"net/http"
+)
+
+// Writer records whether anything has been written, so that errors before
+// the response starts can still be reported, and flushes each chunk to the
+// client.
+type Writer struct {
+	http.ResponseWriter
+	written bool
+}
+
+// NewWriter returns a Writer for rw.
+func NewWriter(rw http.ResponseWriter) *Writer {
+	return &Writer{ResponseWriter: rw}
+}
+
+func (w *Writer) Write(b []byte) (int, error) {
+	w.written = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what has been written so far, if the ResponseWriter can.
+func (w *Writer) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Written reports whether the response has started, after which it's too
+// late for an error response.
+func (w *Writer) Written() bool {
+	return w.written
+}
@@ -0,0 +1,28 @@
+package stream
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestWriter(t *testing.T) {
+	Convey("Testing stream writer", t, func() {
+		rec := httptest.NewRecorder()
+		w := NewWriter(rec)
+		So(w.Written(), ShouldBeFalse)
+
+		w.Header().Set("Content-Type", "text/csv")
+		So(w.Written(), ShouldBeFalse)
+
+		_, err := w.Write([]byte("a,b\n"))
+		So(err, ShouldBeNil)
+		So(w.Written(), ShouldBeTrue)
+		So(rec.Flushed, ShouldBeFalse)
+
+		w.Flush()
+		So(rec.Flushed, ShouldBeTrue)
+		So(rec.Body.String(), ShouldEqual, "a,b\n")
+	})
+}
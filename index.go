@@ -1,6 +1,7 @@
 package main
 
 import (
+	"context"
 	"flag"
 
 	"github.com/curt-labs/API/controllers/acesFile"
@@ -11,32 +12,68 @@ import (
 	"github.com/curt-labs/API/controllers/cartIntegration"
 	"github.com/curt-labs/API/controllers/category"
 	"github.com/curt-labs/API/controllers/contact"
+	"github.com/curt-labs/API/controllers/currency"
 	"github.com/curt-labs/API/controllers/customer"
 	"github.com/curt-labs/API/controllers/dealers"
+	"github.com/curt-labs/API/controllers/deprecation"
+	"github.com/curt-labs/API/controllers/exports"
 	"github.com/curt-labs/API/controllers/geography"
 	"github.com/curt-labs/API/controllers/landingPages"
 	"github.com/curt-labs/API/controllers/luverne"
 	"github.com/curt-labs/API/controllers/middleware"
 	"github.com/curt-labs/API/controllers/news"
 	"github.com/curt-labs/API/controllers/part"
+	"github.com/curt-labs/API/controllers/pies"
 	"github.com/curt-labs/API/controllers/search"
 	"github.com/curt-labs/API/controllers/site"
 	"github.com/curt-labs/API/controllers/testimonials"
 	"github.com/curt-labs/API/controllers/vehicle"
 	"github.com/curt-labs/API/controllers/videos"
+	"github.com/curt-labs/API/helpers/background"
+	"github.com/curt-labs/API/helpers/blobstore"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/deprecation"
 	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/openapi"
+	"github.com/curt-labs/API/helpers/rabbitmq"
+	"github.com/curt-labs/API/helpers/redis"
+	acesFileModel "github.com/curt-labs/API/models/acesFile"
+	"github.com/curt-labs/API/models/brand"
+	cartPricing "github.com/curt-labs/API/models/cartIntegration"
+	"github.com/curt-labs/API/models/category"
+	"github.com/curt-labs/API/models/currency"
+	"github.com/curt-labs/API/models/products"
 	"github.com/go-martini/martini"
 	"github.com/martini-contrib/cors"
 	// "github.com/martini-contrib/gzip"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/martini-contrib/sessions"
 )
 
 var (
-	listenAddr = flag.String("http", ":8080", "http listen address")
+	listenAddr     = flag.String("http", ":8080", "http listen address")
+	requestTimeout = flag.Duration("timeout", 60*time.Second, "default amount of time a request may run before it is cancelled")
+	drainDelay     = flag.Duration("drain-delay", 5*time.Second, "how long /status reports draining before the listener is closed")
+	shutdownWait   = flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests and background work on shutdown")
+	saleArchive    = flag.Duration("sale-archive-interval", time.Hour, "how often expired customer sales are archived, 0 to disable")
+	webhookTick    = flag.Duration("webhook-interval", 30*time.Second, "how often price change webhooks are batched and sent, 0 to disable")
+	catalogScan    = flag.Duration("catalog-scan-interval", 15*time.Minute, "how often the catalog is scanned for the part change feed, 0 to disable")
+	exportDir      = flag.String("export-dir", "exports", "directory catalog exports are kept in")
+	exportTick     = flag.Duration("export-interval", 24*time.Hour, "how often every brand's catalog is exported, 0 to only export on request")
+	feedQueue      = flag.String("inventory-feed-queue", "", "queue to consume warehouse inventory feeds from, empty to disable")
+	feedMaxBytes   = flag.Int64("inventory-feed-max-bytes", 64<<20, "largest inventory feed, in bytes, that may be posted to the API")
+	inventoryEvts  = flag.Bool("inventory-events", false, "publish inventory changes to the inventory exchange")
+
+	// draining is set once we've received a shutdown signal, so that
+	// readiness checks start failing before we stop accepting connections.
+	draining int32
 )
 
 /**
@@ -64,16 +101,183 @@ func main() {
 	m.Use(sessions.Sessions("api_sessions", store))
 	m.Use(encoding.MapEncoder)
 
-	m.Group("/aces", func(r martini.Router) {
-		r.Get("/:version", acesFile.GetAcesFile)
+	// Count calls to deprecated routes in redis, so the report covers every
+	// instance and survives deploys.
+	deprecation.SetStore(deprecation.RedisStore{})
+
+	// The API is mounted once per version, sharing the middleware above.
+	// Unversioned paths are v3, the shape existing clients depend on; v4
+	// reshapes responses on their way out (see versions.go). Each version
+	// is described by its own OpenAPI document.
+	specV3 := newSpec("3")
+	specV4 := newSpec("4")
+	openapi.Mount(m, specV3, "/v3", registerRoutes)
+	openapi.Mount(m, specV4, "/v4", registerRoutes, v4(specV4).MapEncoder)
+	openapi.Mount(m, specV3, "", registerRoutes)
+
+	api := openapi.Wrap(m, specV3)
+
+	api.Get("/deprecations", openapi.Operation{
+		Summary:  "Report calls to deprecated routes by API key",
+		Response: []deprecation.Usage{},
+	}, middleware.InternalKeyAuthentication, deprecation_ctlr.Report)
+
+	api.Get("/openapi.json", openapi.Operation{Summary: "This document"}, specV3.Handler)
+	m.Get("/v3/openapi.json", specV3.Handler)
+	m.Get("/v4/openapi.json", specV4.Handler)
+
+	api.Get("/status", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&draining) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("draining"))
+			return
+		}
+		w.WriteHeader(200)
+		w.Write([]byte("running"))
+	})
+
+	api.Get("/", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://labs.curtmfg.com/", http.StatusFound)
+	})
+
+	// Per-route deadlines; anything not listed here gets the -timeout default.
+	// Routes that stream files back to the client, or take large uploads,
+	// are left unbounded, and aren't held to the server's timeouts either.
+	deadlines := middleware.NewDeadlines(*requestTimeout)
+	for _, version := range []string{"", "/v3", "/v4"} {
+		deadlines.Set(version+"/vehicle", 30*time.Second)
+		deadlines.Set(version+"/vehicle/mongo/allCollections", 45*time.Second)
+		deadlines.Set(version+"/vehicle/mongo/import", 0)
+		deadlines.Set(version+"/part/multi", 30*time.Second)
+		deadlines.Set(version+"/part/inventory/feed", 0)
+		deadlines.Set(version+"/exports", 0)
+		deadlines.Set(version+"/search", 20*time.Second)
+		deadlines.Set(version+"/aces", 0)
+		deadlines.Set(version+"/pies", 0)
+		deadlines.Set(version+"/cartIntegration/upload", 0)
+		deadlines.Set(version+"/cartIntegration/download", 0)
+		deadlines.Set(version+"/cartIntegration/export", 0)
+		deadlines.Set(version+"/cartIntegration/rules/apply", 0)
+		deadlines.Set(version+"/cartIntegration/resetToMap", 0)
+		deadlines.Set(version+"/cartIntegration/global", 0)
+	}
+
+	srv := &http.Server{
+		Addr:         *listenAddr,
+		Handler:      deadlines.Handler(m),
+		ReadTimeout:  90 * time.Second,
+		WriteTimeout: 90 * time.Second,
+	}
+
+	go func() {
+		log.Printf("Starting server on 127.0.0.1%s\n", *listenAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	// Jobs run until shutdown begins, and are waited on with the rest of
+	// the background work.
+	jobs, stopJobs := context.WithCancel(context.Background())
+	if *saleArchive > 0 {
+		background.Go(func() {
+			cartPricing.ArchiveSalesEvery(jobs, *saleArchive)
+		})
+	}
+	if *webhookTick > 0 {
+		background.Go(func() {
+			cartPricing.DispatchWebhooksEvery(jobs, *webhookTick)
+		})
+	}
+	products.SnapshotStore = blobstore.NewLocal(*exportDir)
+	background.Go(func() {
+		products.RunSnapshots(jobs, *exportTick)
+	})
+	if *catalogScan > 0 {
+		background.Go(func() {
+			products.ScanChangesEvery(jobs, *catalogScan)
+		})
+	}
+	if *inventoryEvts {
+		producer, err := rabbitmq.NewProducer(products.ChangeExchange, nil)
+		if err != nil {
+			log.Printf("Inventory changes won't be published: %s\n", err)
+		} else {
+			products.InventoryEvents = producer
+		}
+	}
+	products.MaxFeedSize = *feedMaxBytes
+	if *feedQueue != "" {
+		background.Go(func() {
+			products.ConsumeInventoryFeeds(jobs, *feedQueue)
+		})
+	}
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-stop
+
+	log.Printf("Received %s, draining for %s\n", sig, *drainDelay)
+	atomic.StoreInt32(&draining, 1)
+	time.Sleep(*drainDelay)
+
+	stopJobs()
+	shutdown(srv, *shutdownWait)
+}
+
+// newSpec returns the registry documenting one version of the API. Routes
+// handled by Deprecated are left out of the document.
+func newSpec(version string) *openapi.Registry {
+	spec := openapi.NewRegistry("CURT API", version)
+	spec.MarkDeprecated(Deprecated)
+	spec.Policy(middleware.InternalKeyAuthentication, openapi.AuthInternal)
+	spec.Unauthenticated(middleware.ExcusedRoutes...)
+	return spec
+}
+
+// registerRoutes declares every versioned route. It's mounted once per API
+// version.
+func registerRoutes(api openapi.Router) {
+	api.Group("/aces", func(r openapi.Router) {
+		r.Get("/:version", openapi.Operation{
+			Summary:     "Download an ACES XML file",
+			Description: "Serves the ACES file of the API key's brand, generated from its parts' fitment as version 3.2 or 4.1. Base vehicles and submodels are VCDB IDs, configurations the VCDB knows are vehicle attribute qualifiers and others notes. Generated files are streamed, and cached for 6 hours.",
+			Params: []openapi.Param{
+				{Name: "source", In: "query", Description: "ftp serves the file last uploaded to FTP instead"},
+			},
+		}, acesFile.GetAcesFile)
+		r.Post("/:version/refresh", openapi.Operation{
+			Summary:     "Regenerate an ACES XML file",
+			Description: "Generates the ACES file of the API key's brand in version 3.2 or 4.1 again, rather than serving it from the cache, caches it in place of the last one and serves it.",
+		}, middleware.InternalKeyAuthentication, acesFile.RefreshAcesFile)
+		r.Post("/import", openapi.Operation{
+			Summary:     "Check an ACES XML file against the catalog's fitment",
+			Description: "Validates each app's BaseVehicle, SubModel and vehicle attribute IDs against the VCDB and its part against the brand's catalog, and reports invalid apps and the fitment added to and removed from each part. A FULL submission replaces the fitment of the parts it names; an UPDATE adds its A apps and removes its D apps. Nothing is removed from a part with any invalid apps. With apply=true the differences are saved to the parts' ACES vehicles.",
+			Params: []openapi.Param{
+				{Name: "file", In: "formData", Description: "ACES XML file", Required: true},
+				{Name: "brandID", In: "query", Description: "The brand, if not the key's", Type: "integer"},
+				{Name: "apply", In: "query", Description: "Save the differences", Type: "boolean"},
+			},
+			Response: acesFileModel.AcesImport{},
+		}, middleware.InternalKeyAuthentication, acesFile.ImportAcesFile)
+	})
+
+	api.Group("/pies", func(r openapi.Router) {
+		r.Get("/:version", openapi.Operation{
+			Summary:     "Download a PIES XML file",
+			Description: "version is 6.5 or 7.1. Streams a PIES file of the brand's active parts, with their descriptions, attributes, packages, prices (on a price sheet per price type and currency), images and install sheets as digital assets, UPC and tariff code.",
+			Params: []openapi.Param{
+				{Name: "brandID", In: "query", Description: "The brand, if the key has more than one", Type: "integer"},
+			},
+		}, pies.GetPIESFile)
 	})
 
-	m.Group("/apiKeyTypes", func(r martini.Router) {
+	api.Group("/apiKeyTypes", func(r openapi.Router) {
 		r.Get("", apiKeyType.GetApiKeyTypes)
 	})
 
 	//Creating, updating, and deleting Appguides are all handled in GoAdmin directly
-	m.Group("/applicationGuide", func(r martini.Router) {
+	api.Group("/applicationGuide", func(r openapi.Router) {
 		r.Get("/website/:id", applicationGuide.GetApplicationGuidesByWebsite)
 		r.Get("/:id", applicationGuide.GetApplicationGuide)
 		r.Delete("/:id", Deprecated)
@@ -81,7 +285,7 @@ func main() {
 	})
 
 	//Creating, updating, and deleting all Blog related objects are handled in GoAdmin directly
-	m.Group("/blogs", func(r martini.Router) {
+	api.Group("/blogs", func(r openapi.Router) {
 		r.Get("", Deprecated)            //sort on any field e.g. ?sort=Name&direction=descending
 		r.Get("/categories", Deprecated) //all categories; sort on any field e.g. ?sort=Name&direction=descending
 		r.Get("/category/:id", Deprecated)
@@ -97,24 +301,32 @@ func main() {
 
 	//Creating, updating, and deleting Brands is not handled anywhere, but it does need to be
 	//locked down for security.
-	m.Group("/brands", func(r martini.Router) {
-		r.Get("", brand_ctlr.GetAllBrands)
-		r.Post("", middleware.InternalKeyAuthentication, brand_ctlr.CreateBrand)
-		r.Get("/:id", brand_ctlr.GetBrand)
-		r.Put("/:id", middleware.InternalKeyAuthentication, brand_ctlr.UpdateBrand)
-		r.Delete("/:id", middleware.InternalKeyAuthentication, brand_ctlr.DeleteBrand)
+	api.Group("/brands", func(r openapi.Router) {
+		r.Get("", openapi.Operation{Summary: "List all brands", Response: brand.Brands{}}, brand_ctlr.GetAllBrands)
+		r.Post("", openapi.Operation{Summary: "Create a brand", Request: brand.Brand{}, Response: brand.Brand{}}, middleware.InternalKeyAuthentication, brand_ctlr.CreateBrand)
+		r.Get("/:id", openapi.Operation{Summary: "Get a brand", Response: brand.Brand{}}, brand_ctlr.GetBrand)
+		r.Put("/:id", openapi.Operation{Summary: "Update a brand", Request: brand.Brand{}, Response: brand.Brand{}}, middleware.InternalKeyAuthentication, brand_ctlr.UpdateBrand)
+		r.Delete("/:id", openapi.Operation{Summary: "Delete a brand", Response: brand.Brand{}}, middleware.InternalKeyAuthentication, brand_ctlr.DeleteBrand)
 	})
 
-	m.Group("/category", func(r martini.Router) {
-		r.Get("/:id/parts", category_ctlr.GetCategoryParts)
-		r.Get("/:id", category_ctlr.GetCategory)
-		r.Get("", category_ctlr.GetCategoryTree)
+	api.Group("/category", func(r openapi.Router) {
+		r.Get("/:id/parts", openapi.Operation{
+			Summary:  "List the parts in a category",
+			Params:   []openapi.Param{pageParam, countParam},
+			Response: category.PartResponse{},
+		}, category_ctlr.GetCategoryParts)
+		r.Get("/:id", openapi.Operation{
+			Summary:  "Get a category",
+			Params:   []openapi.Param{pageParam, countParam},
+			Response: category.Category{},
+		}, category_ctlr.GetCategory)
+		r.Get("", openapi.Operation{Summary: "Get the category tree", Response: []category.Category{}}, category_ctlr.GetCategoryTree)
 	})
 
 	//Creating, updating, and deleting all Contact related entities is handled
 	//in GoAdmin directly
-	m.Group("/contact", func(r martini.Router) {
-		m.Group("/types", func(r martini.Router) {
+	api.Group("/contact", func(r openapi.Router) {
+		r.Group("/types", func(r openapi.Router) {
 			r.Get("/receivers/:id", contact.GetReceiversByContactType)
 			r.Get("", contact.GetAllContactTypes)
 			r.Get("/:id", contact.GetContactType)
@@ -122,7 +334,7 @@ func main() {
 			r.Put("/:id", Deprecated)
 			r.Delete("/:id", Deprecated)
 		})
-		m.Group("/receivers", func(r martini.Router) {
+		r.Group("/receivers", func(r openapi.Router) {
 			r.Get("", Deprecated)
 			r.Get("/:id", Deprecated)
 			r.Post("", Deprecated)
@@ -139,7 +351,7 @@ func main() {
 
 	//These shopify endpoints appear to not be used at all. Due to their customer related nature,
 	//They are being locked down for security.
-	m.Group("/shopify/customers", func(r martini.Router) {
+	api.Group("/shopify/customers", func(r openapi.Router) {
 		// Customers - shop endpoints
 		r.Get("", Deprecated)
 		r.Post("", Deprecated)
@@ -159,12 +371,12 @@ func main() {
 
 	})
 
-	m.Group("/shopify/order", func(r martini.Router) {
+	api.Group("/shopify/order", func(r openapi.Router) {
 		// Orders
 		r.Post("/order", Deprecated)
 	})
 
-	m.Group("/shopify/account", func(r martini.Router) {
+	api.Group("/shopify/account", func(r openapi.Router) {
 		// Account - user endpoints
 		r.Get("", Deprecated)
 		r.Post("", Deprecated)
@@ -172,46 +384,174 @@ func main() {
 		r.Post("/login", Deprecated)
 	})
 
-	//Used on the dealer site, no lockdown for now
-	m.Group("/cartIntegration", func(r martini.Router) {
-		r.Get("/part/:part", cartIntegration.GetPartPricesByPartID)
-		r.Get("/part", cartIntegration.GetAllPartPrices)
-		r.Get("/count", cartIntegration.GetPricingCount)
-		r.Get("", cartIntegration.GetPricing)
-		r.Get("/:page/:count", cartIntegration.GetPricingPaged)
-		r.Post("/part", cartIntegration.CreatePrice)
-		r.Put("/part", cartIntegration.UpdatePrice)
-		r.Get("/priceTypes", cartIntegration.GetAllPriceTypes)
-
-		r.Post("/resetToMap", cartIntegration.ResetAllToMap)
-		r.Post("/global/:type/:percentage", cartIntegration.Global)
-
-		r.Post("/upload", cartIntegration.Upload)
-		r.Post("/download", cartIntegration.Download)
+	//Used on the dealer site, scoped to the customer that owns the key
+	api.Group("/cartIntegration", func(r openapi.Router) {
+		r.Get("/part/:part", openapi.Operation{Summary: "List the prices of a part", Params: []openapi.Param{currencyParam}, Response: []cartPricing.Price{}}, cartIntegration.GetPartPricesByPartID)
+		r.Get("/part", openapi.Operation{Summary: "List the prices of all parts", Params: []openapi.Param{currencyParam}, Response: []cartPricing.Price{}}, cartIntegration.GetAllPartPrices)
+		r.Get("/count", openapi.Operation{Summary: "Count the customer's prices", Response: 0}, cartIntegration.GetPricingCount)
+		r.Get("/sales", openapi.Operation{
+			Summary:     "List the customer's active and upcoming sales",
+			Description: "A sale is the customer's price for a part from its start date through its end date; outside of that the regular price applies. Ended sales are archived.",
+			Params:      []openapi.Param{currencyParam},
+			Response:    cartPricing.SalesResp{},
+		}, cartIntegration.GetSales)
+		r.Get("/map/violations", openapi.Operation{
+			Summary:     "List customer prices below MAP",
+			Description: "Reads current prices and MAP on every call, so it can be re-run whenever MAP changes. Only enforced MAP is checked unless all=true. Prices allowed by a MAP override are listed with it.",
+			Params: []openapi.Param{
+				{Name: "brandID", In: "query", Description: "Limit to a brand", Type: "integer"},
+				{Name: "custID", In: "query", Description: "Limit to a customer", Type: "integer"},
+				{Name: "all", In: "query", Description: "Include MAP that isn't enforced", Type: "boolean"},
+			},
+			Response: cartPricing.MAPReport{},
+		}, middleware.InternalKeyAuthentication, cartIntegration.GetMAPViolations)
+		r.Get("/map/overrides", openapi.Operation{
+			Summary:  "List MAP overrides",
+			Params:   []openapi.Param{{Name: "custID", In: "query", Description: "Limit to a customer", Type: "integer"}},
+			Response: []cartPricing.MAPOverride{},
+		}, middleware.InternalKeyAuthentication, cartIntegration.GetMAPOverrides)
+		r.Post("/map/overrides", openapi.Operation{
+			Summary:     "Allow a customer to price a part below enforced MAP",
+			Description: "The customer may price the part down to the override's price until it expires.",
+			Request:     cartPricing.MAPOverride{},
+			Response:    cartPricing.MAPOverride{},
+		}, middleware.InternalKeyAuthentication, cartIntegration.CreateMAPOverride)
+		r.Delete("/map/overrides/:id", openapi.Operation{Summary: "Revoke a MAP override", Response: cartPricing.MAPOverride{}}, middleware.InternalKeyAuthentication, cartIntegration.DeleteMAPOverride)
+
+		r.Get("/webhooks", openapi.Operation{Summary: "List the customer's webhooks", Response: []cartPricing.Webhook{}}, cartIntegration.GetWebhooks)
+		r.Get("/webhooks/dead", openapi.Operation{
+			Summary:     "List dead webhook deliveries",
+			Description: "Deliveries that failed every attempt. They can be redelivered.",
+			Params:      []openapi.Param{pageParam, countParam},
+			Response:    cartPricing.WebhookDeliveryResp{},
+		}, cartIntegration.GetDeadDeliveries)
+		r.Get("/webhooks/:id", openapi.Operation{Summary: "Get a webhook", Response: cartPricing.Webhook{}}, cartIntegration.GetWebhook)
+		r.Post("/webhooks", openapi.Operation{
+			Summary:     "Register a webhook for price changes",
+			Description: "Changes to the customer's prices and to the brand's MAP and List prices are POSTed in batches. Each delivery is signed: X-Curt-Signature is sha256= and the hex HMAC-SHA256, keyed by the secret, of X-Curt-Timestamp, a period, and the body. A secret is generated if none is given, and is only returned here. Failed deliveries are retried with backoff, in order, and are dead after 8 attempts.",
+			Request:     cartPricing.Webhook{},
+			Response:    cartPricing.Webhook{},
+		}, cartIntegration.CreateWebhook)
+		r.Put("/webhooks/:id", openapi.Operation{Summary: "Change a webhook's URL or deactivate it", Request: cartPricing.Webhook{}, Response: cartPricing.Webhook{}}, cartIntegration.UpdateWebhook)
+		r.Delete("/webhooks/:id", openapi.Operation{Summary: "Delete a webhook and its deliveries", Response: cartPricing.Webhook{}}, cartIntegration.DeleteWebhook)
+		r.Get("/webhooks/:id/deliveries", openapi.Operation{
+			Summary:  "List a webhook's deliveries, most recent first",
+			Params:   []openapi.Param{{Name: "status", In: "query", Description: "pending, delivered or dead"}, pageParam, countParam},
+			Response: cartPricing.WebhookDeliveryResp{},
+		}, cartIntegration.GetDeliveries)
+		r.Post("/webhooks/:id/deliveries/:delivery/redeliver", openapi.Operation{Summary: "Send a dead delivery again", Response: ""}, cartIntegration.Redeliver)
+
+		r.Get("/rules", openapi.Operation{Summary: "List the customer's pricing rules in priority order", Response: []cartPricing.PricingRule{}}, cartIntegration.GetRules)
+		r.Get("/rules/:id", openapi.Operation{Summary: "Get a pricing rule", Response: cartPricing.PricingRule{}}, cartIntegration.GetRule)
+		r.Post("/rules", openapi.Operation{
+			Summary:     "Create a pricing rule",
+			Description: "Rules are tried in priority order, lowest first, and the first to match a part's brand, category, class and price code prices it from its Map, List or Jobber price, adjusted by percent then amount, rounded down to the rounding ending (e.g. .99) and, with floorMap, never below MAP.",
+			Request:     cartPricing.PricingRule{},
+			Response:    cartPricing.PricingRule{},
+		}, cartIntegration.CreateRule)
+		r.Put("/rules/:id", openapi.Operation{Summary: "Update a pricing rule", Request: cartPricing.PricingRule{}, Response: cartPricing.PricingRule{}}, cartIntegration.UpdateRule)
+		r.Delete("/rules/:id", openapi.Operation{Summary: "Delete a pricing rule", Response: cartPricing.PricingRule{}}, cartIntegration.DeleteRule)
+		r.Post("/rules/preview", openapi.Operation{
+			Summary:     "Preview the prices the pricing rules would set",
+			Description: "Nothing is written. Parts no rule matches are left out.",
+			Response:    cartPricing.UploadReport{},
+		}, cartIntegration.PreviewRules)
+		r.Post("/rules/apply", openapi.Operation{
+			Summary:     "Apply the pricing rules to the customer's prices",
+			Description: "Writes every changed price in a single transaction, or nothing if any part can't be priced (422).",
+			Response:    cartPricing.UploadReport{},
+		}, cartIntegration.ApplyRules)
+		r.Get("/export/:platform", openapi.Operation{
+			Summary:     "Export the catalog as a cart platform's product import CSV",
+			Description: "platform is shopify, bigcommerce or woocommerce. Streams every active part with the customer's price (List if it has none), images, description, attributes as metafields or custom fields, and fitment text.",
+			Params: []openapi.Param{
+				{Name: "brandID", In: "query", Description: "Limit to a brand", Type: "integer"},
+				{Name: "categoryID", In: "query", Description: "Limit to a category and the categories directly under it", Type: "integer"},
+			},
+		}, cartIntegration.Export)
+		r.Get("/history", openapi.Operation{
+			Summary:     "List the customer's price changes",
+			Description: "Every write to a customer price is recorded with its old and new value, the API key and user that made it, its source (manual, upload, global, resetToMap, rules, revert or expired) and a batch ID shared by the writes of one request. Most recent first.",
+			Params: []openapi.Param{
+				{Name: "part", In: "query", Description: "Limit to a part number"},
+				{Name: "batch", In: "query", Description: "Limit to a batch"},
+				{Name: "source", In: "query", Description: "Limit to a source"},
+				{Name: "since", In: "query", Description: "Date or RFC 3339 time to start from"},
+				{Name: "until", In: "query", Description: "Date or RFC 3339 time to end before"},
+				pageParam, countParam,
+			},
+			Response: cartPricing.PriceHistoryResp{},
+		}, cartIntegration.GetHistory)
+		r.Post("/history/:batch/revert", openapi.Operation{
+			Summary:     "Revert a batch of price changes",
+			Description: "Undoes the batch's changes as a new batch. Prices changed again since are reported as conflicts and left alone unless force=true.",
+			Params:      []openapi.Param{{Name: "force", In: "query", Description: "Revert prices that have changed since", Type: "boolean"}},
+			Response:    cartPricing.RevertReport{},
+		}, cartIntegration.RevertBatch)
+		r.Get("", openapi.Operation{
+			Summary:     "List the customer's prices",
+			Description: "Returns an array of prices, or a paginated object when format=json-obj.",
+			Params:      []openapi.Param{formatParam, pageParam, countParam, currencyParam},
+			Response:    []cartPricing.CustomerPrice{},
+		}, cartIntegration.GetPricing)
+		r.Get("/:page/:count", openapi.Operation{Summary: "List a page of the customer's prices", Params: []openapi.Param{currencyParam}, Response: []cartPricing.CustomerPrice{}}, cartIntegration.GetPricingPaged)
+		r.Post("/part", openapi.Operation{Summary: "Create a customer price", Description: "Prices are set in USD. Prices below an enforced MAP are refused (422) unless the customer has a MAP override for the part.", Request: cartPricing.CustomerPrice{}, Response: cartPricing.CustomerPrice{}}, cartIntegration.CreatePrice)
+		r.Put("/part", openapi.Operation{Summary: "Update a customer price", Description: "Prices are set in USD. Prices below an enforced MAP are refused (422) unless the customer has a MAP override for the part.", Request: cartPricing.CustomerPrice{}, Response: cartPricing.CustomerPrice{}}, cartIntegration.UpdatePrice)
+		r.Get("/priceTypes", openapi.Operation{Summary: "List the price types", Response: []string{}}, cartIntegration.GetAllPriceTypes)
+
+		r.Post("/resetToMap", openapi.Operation{
+			Summary:     "Reset all of the customer's prices to MAP",
+			Description: "Writes every price in a single transaction, recorded in the price history as one batch.",
+			Response:    []cartPricing.CustomerPrice{},
+		}, cartIntegration.ResetAllToMap)
+		r.Post("/global/:type/:percentage", openapi.Operation{
+			Summary:     "Set every price to a percentage of a price type",
+			Description: "Writes every price in a single transaction, recorded in the price history as one batch, or nothing if any price is refused (422 with the report).",
+			Response:    []cartPricing.CustomerPrice{},
+		}, cartIntegration.Global)
+
+		r.Post("/upload", openapi.Operation{
+			Summary:     "Upload a CSV or XLSX sheet of customer prices",
+			Description: "Columns are matched by the header row. Validates every row and applies the file in a single transaction, or not at all if any row has an error (422). With dry_run=true nothing is written and a valid report's batchId can be confirmed.",
+			Params: []openapi.Param{
+				{Name: "file", In: "formData", Description: "CSV or XLSX file", Required: true},
+				{Name: "dry_run", In: "query", Description: "Validate and report without applying", Type: "boolean"},
+			},
+			Response: cartPricing.UploadReport{},
+		}, cartIntegration.Upload)
+		r.Post("/upload/:batch", openapi.Operation{
+			Summary:     "Apply a dry run upload",
+			Description: "Revalidates and applies an upload reported by dry_run=true within the last hour.",
+			Response:    cartPricing.UploadReport{},
+		}, cartIntegration.ConfirmUpload)
+		r.Post("/download", openapi.Operation{
+			Summary: "Download the customer's prices as CSV or XLSX",
+			Params:  []openapi.Param{{Name: "format", In: "query", Description: "csv (default) or xlsx"}},
+		}, cartIntegration.Download)
 
 	})
 
 	//Cache should definitely be locked down
-	m.Group("/cache", func(r martini.Router) { // different endpoint because partial matching matches this to another excused route
+	api.Group("/cache", func(r openapi.Router) { // different endpoint because partial matching matches this to another excused route
 		r.Get("/key", cache.GetByKey)
 		r.Get("/keys", cache.GetKeys)
 		r.Delete("/keys", middleware.InternalKeyAuthentication, cache.DeleteKey)
 	})
 
 	//No lockdown of customer related endpoints for now
-	m.Group("/cust", func(r martini.Router) { // different endpoint because partial matching matches this to another excused route
+	api.Group("/cust", func(r openapi.Router) { // different endpoint because partial matching matches this to another excused route
 		r.Post("/user/changePassword", customer_ctlr.ChangePassword)
 	})
 
 	//Literally exact same as above? Copy paste error?
-	m.Group("/cache", func(r martini.Router) { // different endpoint because partial matching matches this to another excused route
+	api.Group("/cache", func(r openapi.Router) { // different endpoint because partial matching matches this to another excused route
 		r.Get("/key", cache.GetByKey)
 		r.Get("/keys", cache.GetKeys)
 		r.Delete("/keys", middleware.InternalKeyAuthentication, cache.DeleteKey)
 	})
 
 	//No lockdown of customer related endpoints for now
-	m.Group("/customer", func(r martini.Router) {
+	api.Group("/customer", func(r openapi.Router) {
 		r.Get("", customer_ctlr.GetCustomer)
 		r.Post("", customer_ctlr.GetCustomer)
 
@@ -283,7 +623,7 @@ func main() {
 		r.Put("", Deprecated)
 	})
 
-	m.Group("/dealers", func(r martini.Router) {
+	api.Group("/dealers", func(r openapi.Router) {
 		r.Get("/business/classes", dealers_ctlr.GetAllBusinessClasses)
 		r.Get("/etailer", dealers_ctlr.GetEtailers)
 		r.Get("/local", dealers_ctlr.GetLocalDealers)
@@ -298,7 +638,7 @@ func main() {
 	})
 
 	//Creating, updating, and deleting FAQs are done in GoAdmin directly
-	m.Group("/faqs", func(r martini.Router) {
+	api.Group("/faqs", func(r openapi.Router) {
 		r.Get("", Deprecated)          //get all faqs; takes optional sort param {sort=true} to sort by question
 		r.Get("/search", Deprecated)   //takes {question, answer, page, results} - all parameters are optional
 		r.Get("/(:id)", Deprecated)    //get by id {id}
@@ -310,7 +650,7 @@ func main() {
 
 	//All creating, updating, and deleting of things related to Forums
 	//is done in GoAdmin directly
-	m.Group("/forum", func(r martini.Router) {
+	api.Group("/forum", func(r openapi.Router) {
 		//groups
 		r.Get("/groups", Deprecated)
 		r.Get("/groups/:id", Deprecated)
@@ -335,14 +675,53 @@ func main() {
 		r.Delete("/posts/:id", Deprecated)
 	})
 
-	m.Group("/geography", func(r martini.Router) {
+	// Prices are kept in USD; exchange rates convert them for customers
+	// that work in other currencies
+	api.Group("/currency", func(r openapi.Router) {
+		r.Get("/rates", openapi.Operation{
+			Summary:  "List exchange rates, most recent first",
+			Params:   []openapi.Param{{Name: "currency", Description: "Limit to an ISO 4217 code"}},
+			Response: []currency.ExchangeRate{},
+		}, currency_ctlr.GetRates)
+		r.Post("/rates", openapi.Operation{
+			Summary:     "Add an exchange rate",
+			Description: "rate is units of the currency per USD, in effect from effective (default now) until a later rate. Converted prices are rounded to a multiple of increment (default 0.01) by rounding: nearest (default), up or down.",
+			Request:     currency.ExchangeRate{},
+			Response:    currency.ExchangeRate{},
+		}, middleware.InternalKeyAuthentication, currency_ctlr.CreateRate)
+		r.Delete("/rates/:id", openapi.Operation{Summary: "Delete an exchange rate", Response: currency.ExchangeRate{}}, middleware.InternalKeyAuthentication, currency_ctlr.DeleteRate)
+		r.Get("/customers/:id", openapi.Operation{Summary: "Get a customer's default currency", Response: currency_ctlr.CustomerCurrency{}}, middleware.InternalKeyAuthentication, currency_ctlr.GetCustomerCurrency)
+		r.Put("/customers/:id", openapi.Operation{
+			Summary:     "Set a customer's default currency",
+			Description: "Prices are returned in it unless a request asks for another currency. The currency must have a rate in effect; USD clears it.",
+			Request:     currency_ctlr.CustomerCurrency{},
+			Response:    currency_ctlr.CustomerCurrency{},
+		}, middleware.InternalKeyAuthentication, currency_ctlr.SetCustomerCurrency)
+	})
+
+	api.Group("/exports", func(r openapi.Router) {
+		r.Get("", openapi.Operation{Summary: "List catalog exports", Description: "Lists the exports of the key's brands, newest first.", Params: []openapi.Param{countParam}, Response: []products.Snapshot{}}, exports.List)
+		r.Post("", openapi.Operation{
+			Summary:     "Export catalogs",
+			Description: "Queues exports of the brands' parts, with attributes, pricing, images, categories and fitment, as gzipped NDJSON and/or CSV. No brands exports every brand, and no formats both. Exports are also made on a schedule.",
+			Request:     exports.Request{},
+			Response:    []products.Snapshot{},
+		}, middleware.InternalKeyAuthentication, exports.Create)
+		r.Get("/:id", openapi.Operation{Summary: "Get a catalog export's status", Response: products.Snapshot{}}, exports.Get)
+		r.Get("/:id/download", openapi.Operation{
+			Summary:     "Download a catalog export",
+			Description: "Streams the gzipped file. X-Checksum-SHA256 is the hex SHA-256 of the file, as in the export's checksum.",
+		}, exports.Download)
+	})
+
+	api.Group("/geography", func(r openapi.Router) {
 		r.Get("/states", geography.GetAllStates)
 		r.Get("/countries", geography.GetAllCountries)
 		r.Get("/countrystates", geography.GetAllCountriesAndStates)
 	})
 
 	//Creating, updating, and deleting of News entites is done in GoAdmin directly
-	m.Group("/news", func(r martini.Router) {
+	api.Group("/news", func(r openapi.Router) {
 		r.Get("", news_controller.GetAll)           //get all news; takes optional sort param {sort=title||lead||content||startDate||endDate||active||slug} to sort by question
 		r.Get("/titles", news_controller.GetTitles) //get titles!{page, results} - all parameters are optional
 		r.Get("/leads", news_controller.GetLeads)   //get leads!{page, results} - all parameters are optional
@@ -354,32 +733,93 @@ func main() {
 		r.Delete("", Deprecated)                    //{id}
 	})
 
-	m.Group("/part", func(r martini.Router) {
-		r.Get("/featured", part_ctlr.Featured)
-		r.Get("/latest", part_ctlr.Latest)
-		r.Post("/multi", part_ctlr.GetMulti) //Actually a GET request, because of some "max length" myth
-		r.Get("/:part/vehicles", part_ctlr.Vehicles)
-		r.Get("/:part/attributes", part_ctlr.Attributes)
-		r.Get("/:part/reviews", part_ctlr.ActiveApprovedReviews)
-		r.Get("/:part/categories", part_ctlr.Categories)
-		r.Get("/:part/content", part_ctlr.GetContent)
-		r.Get("/:part/images", part_ctlr.Images)
-		r.Get("/:part((.*?)\\.(PDF|pdf)$)", part_ctlr.InstallSheet)
-		r.Get("/:part/packages", part_ctlr.Packaging)
-		r.Get("/:part/pricing", part_ctlr.Prices)
-		r.Get("/:part/related", part_ctlr.GetRelated)
-		r.Get("/:part/videos", part_ctlr.Videos)
+	api.Group("/part", func(r openapi.Router) {
+		r.Get("/featured", openapi.Operation{Summary: "List featured parts", Params: []openapi.Param{countParam, currencyParam}, Response: []products.Part{}}, part_ctlr.Featured)
+		r.Get("/latest", openapi.Operation{Summary: "List the latest parts", Params: []openapi.Param{countParam, currencyParam}, Response: []products.Part{}}, part_ctlr.Latest)
+		r.Post("/multi", openapi.Operation{
+			Summary:     "Get several parts",
+			Description: "Takes a JSON array of part numbers. This is a POST only so that long lists fit in the request.",
+			Params:      []openapi.Param{currencyParam, expandParam},
+			Request:     []string{},
+			Response:    []products.Part{},
+		}, part_ctlr.GetMulti) //Actually a GET request, because of some "max length" myth
+		r.Post("/inventory", openapi.Operation{
+			Summary:     "Get the availability of several parts",
+			Description: "Takes a JSON array of part numbers, up to 500.",
+			Params:      inventoryParams,
+			Request:     []string{},
+			Response:    []products.PartAvailability{},
+		}, part_ctlr.InventoryMulti)
+		r.Post("/supersessions", openapi.Operation{
+			Summary:     "Map part numbers to the current parts",
+			Description: "Takes a JSON array of part numbers, up to 500, and follows each one's supersessions to the part that replaces it. Chains that cycle, or lead to a part that can't be found, are flagged. A chain that ends on a discontinued part is flagged too, and its current part is the last one in the chain that's still active.",
+			Request:     []string{},
+			Response:    []products.Supersession{},
+		}, part_ctlr.CurrentPartNumbers)
+		r.Post("/inventory/feed", openapi.Operation{
+			Summary:     "Ingest a warehouse inventory feed",
+			Description: "Takes CSV, with warehouse, part (number), quantity and date_updated columns, or a JSON array of products.FeedRecord. A record only replaces a warehouse's quantity of a part if its date_updated is later. Feeds larger than -inventory-feed-max-bytes are refused with a 413. Returns the feed's report.",
+			Params:      []openapi.Param{{Name: "format", Description: "csv or json; defaults to the Content-Type"}},
+			Request:     []products.FeedRecord{},
+			Response:    products.FeedReport{},
+		}, middleware.InternalKeyAuthentication, part_ctlr.IngestFeed)
+		r.Get("/inventory/feeds", openapi.Operation{Summary: "List the reports of recent inventory feeds", Params: []openapi.Param{countParam}, Response: []products.FeedReport{}}, middleware.InternalKeyAuthentication, part_ctlr.FeedReports)
+		r.Get("/inventory/feeds/:id", openapi.Operation{Summary: "Get an inventory feed's report", Response: products.FeedReport{}}, middleware.InternalKeyAuthentication, part_ctlr.FeedReport)
+		r.Get("/:part/inventory", openapi.Operation{
+			Summary:     "Get a part's availability, total and by warehouse",
+			Description: "Warehouses are ranked by distance from lat/lng or postal_code when given. The customer's default warehouse comes first.",
+			Params:      inventoryParams,
+			Response:    products.PartInventory{},
+		}, part_ctlr.Inventory)
+		r.Get("/:part/vehicles", openapi.Operation{Summary: "List the vehicles a part fits"}, part_ctlr.Vehicles)
+		r.Get("/:part/attributes", openapi.Operation{Summary: "List a part's attributes", Response: []products.Attribute{}}, part_ctlr.Attributes)
+		r.Get("/:part/reviews", openapi.Operation{Summary: "List a part's approved reviews"}, part_ctlr.ActiveApprovedReviews)
+		r.Get("/:part/categories", openapi.Operation{Summary: "List a part's categories", Response: []products.Category{}}, part_ctlr.Categories)
+		r.Get("/:part/content", openapi.Operation{Summary: "List a part's content", Response: []products.Content{}}, part_ctlr.GetContent)
+		r.Get("/:part/images", openapi.Operation{Summary: "List a part's images", Response: []products.Image{}}, part_ctlr.Images)
+		r.Get("/:part((.*?)\\.(PDF|pdf)$)", openapi.Operation{Summary: "Download a part's install sheet"}, part_ctlr.InstallSheet)
+		r.Get("/:part/packages", openapi.Operation{Summary: "List a part's packaging", Response: []products.Package{}}, part_ctlr.Packaging)
+		r.Get("/:part/pricing", openapi.Operation{Summary: "List a part's prices", Params: []openapi.Param{currencyParam}, Response: []products.Price{}}, part_ctlr.Prices)
+		r.Get("/:part/related", openapi.Operation{Summary: "List parts related to a part", Params: []openapi.Param{currencyParam}, Response: []products.Part{}}, part_ctlr.GetRelated)
+		r.Get("/:part/videos", openapi.Operation{Summary: "List a part's videos"}, part_ctlr.Videos)
 		r.Get("/:part/:year/:make/:model", Deprecated)
 		r.Get("/:part/:year/:make/:model/:submodel", Deprecated)
 		r.Get("/:part/:year/:make/:model/:submodel/:config(.+)", Deprecated)
-		r.Get("/id/:part", part_ctlr.Get)
-		r.Get("/identifiers", part_ctlr.Identifiers)
-		r.Get("/:part", part_ctlr.PartNumber)
-		r.Get("", part_ctlr.All)
+		r.Get("/changes", openapi.Operation{
+			Summary:     "List changes to the catalog",
+			Description: "Returns the changes to parts after the since cursor, oldest first: created, updated, status-changed and deleted, with the sections of the part that changed (status, content, pricing, categories, fitment). Parts of every status are included, and deleted parts leave a deleted change. Pass the returned cursor as since to resume; more is set when there are further changes already.",
+			Params: []openapi.Param{
+				{Name: "since", Description: "cursor from a previous page; empty starts from the beginning"},
+				{Name: "count", Type: "integer", Description: "up to 1000, default 100"},
+			},
+			Response: products.ChangeFeed{},
+		}, part_ctlr.Changes)
+		r.Get("/id/:part", openapi.Operation{Summary: "Get a part by ID", Params: []openapi.Param{currencyParam, expandParam}, Response: products.Part{}}, part_ctlr.Get)
+		r.Get("/identifiers", openapi.Operation{
+			Summary:  "List part identifiers",
+			Params:   []openapi.Param{{Name: "brand", Type: "integer"}},
+			Response: []string{},
+		}, part_ctlr.Identifiers)
+		r.Get("/:part", openapi.Operation{
+			Summary:     "Get a part by part number",
+			Description: "With follow=true, a superseded part is replaced by the part that's current, with the chain of supersessions between them. A chain that cycles is a 409.",
+			Params:      []openapi.Param{currencyParam, expandParam, {Name: "follow", Type: "boolean"}},
+			Response:    products.Part{},
+		}, part_ctlr.PartNumber)
+		r.Get("", openapi.Operation{
+			Summary:     "List parts",
+			Description: "Returns an array of parts, or a products.PaginatedProductListing when format=json-obj.",
+			Params: []openapi.Param{
+				formatParam, pageParam, countParam, currencyParam,
+				{Name: "modified-from", Description: "RFC 3339 timestamp"},
+				{Name: "modified-to", Description: "RFC 3339 timestamp"},
+			},
+			Response: []products.Part{},
+		}, part_ctlr.All)
 	})
 
 	//Creating, updating, and Deleting of salesRep entities is all done in GoAdmin directly
-	m.Group("/salesrep", func(r martini.Router) {
+	api.Group("/salesrep", func(r openapi.Router) {
 		r.Get("", Deprecated)
 		r.Post("", Deprecated)
 		r.Get("/:id", Deprecated)
@@ -387,13 +827,19 @@ func main() {
 		r.Delete("/:id", Deprecated)
 	})
 
-	m.Get("/search/:term", search_ctlr.Search)
-	m.Get("/searchExactAndClose/:term", search_ctlr.SearchExactAndClose)
+	api.Get("/search/:term", openapi.Operation{
+		Summary: "Search parts and content",
+		Params: []openapi.Param{
+			pageParam, countParam, {Name: "brand", Type: "integer"},
+			{Name: "raw", Description: "raw part number to match exactly"},
+		},
+	}, search_ctlr.Search)
+	api.Get("/searchExactAndClose/:term", search_ctlr.SearchExactAndClose)
 
 	//POST, PUT, and DELETE for these don't seem to be used, but even if they are,
 	//they shouldn't, so they're getting locked down
-	m.Group("/site", func(r martini.Router) {
-		m.Group("/menu", func(r martini.Router) {
+	api.Group("/site", func(r openapi.Router) {
+		r.Group("/menu", func(r openapi.Router) {
 			r.Get("/all", site.GetAllMenus)
 			r.Get("/:id", site.GetMenu)        //may pass id (int) or name(string)
 			r.Get("/contents/:id", Deprecated) //may pass id (int) or name(string)
@@ -401,7 +847,7 @@ func main() {
 			r.Put("/:id", Deprecated)
 			r.Delete("/:id", Deprecated)
 		})
-		m.Group("/content", func(r martini.Router) {
+		r.Group("/content", func(r openapi.Router) {
 			r.Get("/all", site.GetAllContents)
 			r.Get("/:id", site.GetContent) //may pass id (int) or slug(string)
 			r.Get("/:id/revisions", Deprecated)
@@ -415,19 +861,19 @@ func main() {
 		r.Delete("/:id", Deprecated)
 	})
 
-	m.Group("/lp", func(r martini.Router) {
+	api.Group("/lp", func(r openapi.Router) {
 		r.Get("/:id", landingPage.Get)
 	})
 
 	//Creating of showcases is handled by GoAdmin directly
-	m.Group("/showcase", func(r martini.Router) {
+	api.Group("/showcase", func(r openapi.Router) {
 		r.Get("", Deprecated)
 		r.Get("/:id", Deprecated)
 		r.Post("", Deprecated)
 	})
 
 	//Completely unused
-	m.Group("/techSupport", func(r martini.Router) {
+	api.Group("/techSupport", func(r openapi.Router) {
 		r.Get("/all", Deprecated)
 		r.Get("/contact/:id", Deprecated)
 		r.Get("/:id", Deprecated)
@@ -436,7 +882,7 @@ func main() {
 	})
 
 	//Creating, updating, and deleting of testimonials is done in GoAdmin directly
-	m.Group("/testimonials", func(r martini.Router) {
+	api.Group("/testimonials", func(r openapi.Router) {
 		r.Get("", testimonials.GetAllTestimonials)
 		r.Get("/:id", testimonials.GetTestimonial)
 		r.Post("", middleware.InternalKeyAuthentication, testimonials.Save)
@@ -445,7 +891,7 @@ func main() {
 	})
 
 	//warranty related actions are handled in Survey
-	m.Group("/warranty", func(r martini.Router) {
+	api.Group("/warranty", func(r openapi.Router) {
 		r.Get("/all", Deprecated)
 		r.Get("/contact/:id", Deprecated)
 		r.Get("/:id", Deprecated)
@@ -454,7 +900,7 @@ func main() {
 	})
 
 	//This is unholy and should not exist
-	m.Group("/webProperties", func(r martini.Router) {
+	api.Group("/webProperties", func(r openapi.Router) {
 		r.Post("/requirement/:id", Deprecated)
 		r.Put("/requirement", Deprecated)
 		r.Delete("/requirement/:id", Deprecated)
@@ -488,37 +934,70 @@ func main() {
 	})
 
 	// ARIES Year/Make/Model/Style
-	m.Post("/vehicle", vehicle.Query)
-	m.Post("/findVehicle", Deprecated)
-	m.Post("/vehicle/inquire", Deprecated)
+	api.Post("/vehicle", openapi.Operation{
+		Summary: "Look up vehicles and their parts",
+		Params: []openapi.Param{
+			{Name: "year", In: "formData"}, {Name: "make", In: "formData"},
+			{Name: "model", In: "formData"}, {Name: "submodel", In: "formData"},
+			pageParam, countParam,
+		},
+		Response: products.Lookup{},
+	}, vehicle.Query)
+	api.Post("/findVehicle", Deprecated)
+	api.Post("/vehicle/inquire", Deprecated)
 
 	// Used by ARIES ProductWidget
-	m.Get("/vehicle/mongo/cols", vehicle.Collections)
+	api.Get("/vehicle/mongo/cols", vehicle.Collections)
 
 	// Used for ARIES Application Guides page
-	m.Post("/vehicle/mongo/apps", vehicle.ByCategory)
-	m.Post("/vehicle/mongo/allCollections", vehicle.AllCollectionsLookup)
+	api.Post("/vehicle/mongo/apps", vehicle.ByCategory)
+	api.Post("/vehicle/mongo/allCollections", vehicle.AllCollectionsLookup)
+	api.Post("/vehicle/mongo/import", openapi.Operation{
+		Summary:     "Replace an ARIES application collection from a CSV",
+		Description: "Columns are make, model, style, part number and year. Rows are checked for a known part and a valid year and staged beside the collection, which is replaced in one operation if no more than max_error_rate of the rows had conversion or insert errors. The report lists each row's errors and the applications added, removed and changed. The replaced collection is kept for rollback until the next import. A collection is imported or rolled back by one request at a time; another gets a 409.",
+		Params: []openapi.Param{
+			{Name: "file", In: "formData", Description: "CSV file", Required: true},
+			{Name: "collection", In: "formData", Description: "The application collection", Required: true},
+			{Name: "max_error_rate", In: "formData", Description: "Share of rows that may fail, 0.05 by default", Type: "number"},
+			{Name: "dry_run", In: "formData", Description: "Stage and report without replacing the collection", Type: "boolean"},
+		},
+		Response: products.ImportReport{},
+	}, middleware.InternalKeyAuthentication, vehicle.ImportApplications)
+	api.Post("/vehicle/mongo/import/rollback", openapi.Operation{
+		Summary: "Put back the application collection replaced by the last import",
+		Params: []openapi.Param{
+			{Name: "collection", In: "formData", Description: "The application collection", Required: true},
+		},
+	}, middleware.InternalKeyAuthentication, vehicle.RollbackApplications)
 
 	// Used by the ARIES website
-	m.Get("/vehicle/category", vehicle.QueryCategoryStyle)
-	m.Get("/vehicle/category/:year", vehicle.QueryCategoryStyle)
-	m.Get("/vehicle/category/:year/:make", vehicle.QueryCategoryStyle)
-	m.Get("/vehicle/category/:year/:make/:model", vehicle.QueryCategoryStyle)
-	m.Get("/vehicle/category/:year/:make/:model/:category", vehicle.QueryCategoryStyle)
+	api.Get("/vehicle/category", vehicle.QueryCategoryStyle)
+	api.Get("/vehicle/category/:year", vehicle.QueryCategoryStyle)
+	api.Get("/vehicle/category/:year/:make", vehicle.QueryCategoryStyle)
+	api.Get("/vehicle/category/:year/:make/:model", vehicle.QueryCategoryStyle)
+	api.Get("/vehicle/category/:year/:make/:model/:category", vehicle.QueryCategoryStyle)
 
 	// Used by the Luverne website
-	m.Get("/luverne/vehicle", luverne.QueryCategoryStyle)
-	m.Get("/luverne/vehicle/:year", luverne.QueryCategoryStyle)
-	m.Get("/luverne/vehicle/:year/:make", luverne.QueryCategoryStyle)
-	m.Get("/luverne/vehicle/:year/:make/:model", luverne.QueryCategoryStyle)
-	m.Get("/luverne/vehicle/:year/:make/:model/:category", luverne.QueryCategoryStyle)
+	api.Get("/luverne/vehicle", luverne.QueryCategoryStyle)
+	api.Get("/luverne/vehicle/:year", luverne.QueryCategoryStyle)
+	api.Get("/luverne/vehicle/:year/:make", luverne.QueryCategoryStyle)
+	api.Get("/luverne/vehicle/:year/:make/:model", luverne.QueryCategoryStyle)
+	api.Get("/luverne/vehicle/:year/:make/:model/:category", luverne.QueryCategoryStyle)
 
 	// CURT Year/Make/Model/Style
-	m.Post("/vehicle/curt", vehicle.CurtLookup)
-	m.Get("/vehicle/curt", vehicle.CurtLookupGet)
+	api.Post("/vehicle/curt", openapi.Operation{
+		Summary:  "Look up CURT vehicles and their parts",
+		Params:   curtLookupParams,
+		Response: products.CurtLookup{},
+	}, vehicle.CurtLookup)
+	api.Get("/vehicle/curt", openapi.Operation{
+		Summary:  "Look up CURT vehicles and their parts",
+		Params:   curtLookupParams,
+		Response: products.CurtLookup{},
+	}, vehicle.CurtLookupGet)
 
 	//videos are handled in GoAdmin
-	m.Group("/videos", func(r martini.Router) {
+	api.Group("/videos", func(r openapi.Router) {
 		r.Get("/distinct", videos_ctlr.DistinctVideos) //old "videos" table - curtmfg?
 		r.Get("/channel/type", videos_ctlr.GetAllChannelTypes)
 		r.Get("/channel/type/:id", videos_ctlr.GetChannelType)
@@ -536,7 +1015,7 @@ func main() {
 		r.Get("/:id", videos_ctlr.Get)
 	})
 
-	m.Group("/vin", func(r martini.Router) {
+	api.Group("/vin", func(r openapi.Router) {
 		//option 1 - two calls - ultimately returns parts
 		r.Get("/configs/:vin", Deprecated)         //returns vehicles - user must call vin/vehicle with vehicleID to get parts
 		r.Get("/vehicleID/:vehicleID", Deprecated) //returns an array of parts
@@ -544,29 +1023,61 @@ func main() {
 		//option 2 - one call - returns vehicles with parts
 		r.Get("/:vin", Deprecated) //returns vehicles + configs with associates parts -or- an array of parts if only one vehicle config matches
 	})
+}
 
-	m.Get("/status", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(200)
-		w.Write([]byte("running"))
-	})
-
-	m.Get("/", func(w http.ResponseWriter, r *http.Request) {
-		http.Redirect(w, r, "http://labs.curtmfg.com/", http.StatusFound)
-	})
+// shutdown stops accepting connections, waits for in-flight requests and
+// background work (analytics, cache writes, uploads) to finish, then closes
+// the database and cache connections. Anything still running when the
+// timeout expires is abandoned.
+func shutdown(srv *http.Server, timeout time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 
-	srv := &http.Server{
-		Addr:         *listenAddr,
-		Handler:      m,
-		ReadTimeout:  90 * time.Second,
-		WriteTimeout: 90 * time.Second,
+	if err := srv.Shutdown(ctx); err != nil {
+		log.Printf("Error shutting down server: %s\n", err)
+	}
+	if err := background.Wait(ctx); err != nil {
+		log.Printf("Gave up waiting on background work: %s\n", err)
 	}
 
-	log.Printf("Starting server on 127.0.0.1%s\n", *listenAddr)
-	log.Fatal(srv.ListenAndServe())
+	if err := database.Close(); err != nil {
+		log.Printf("Error closing database connections: %s\n", err)
+	}
+	if err := redis.Close(); err != nil {
+		log.Printf("Error closing redis pools: %s\n", err)
+	}
+	log.Println("Shutdown complete")
 }
 
-func Deprecated(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusGone)
-	w.Header().Set("Content-Type", "text/plain")
-	w.Write([]byte("This API Endpoint has been deprecated. Please contact websupport@curtgroup.com if you have any questions or comments."))
+// Parameters shared by several documented routes.
+var (
+	formatParam = openapi.Param{Name: "format", Description: "json-obj returns a paginated object instead of an array"}
+	pageParam   = openapi.Param{Name: "page", Type: "integer"}
+	countParam  = openapi.Param{Name: "count", Type: "integer"}
+	// prices are converted from USD at the exchange rate in effect; without
+	// currency, the customer's default currency is used
+	currencyParam = openapi.Param{Name: "currency", Description: "ISO 4217 code to return prices in; defaults to the customer's currency"}
+
+	expandParam = openapi.Param{
+		Name:        "expand",
+		Description: "components embeds a kit's component parts in its kit, with its total prices and how many can be built from the components on hand",
+	}
+
+	inventoryParams = []openapi.Param{
+		{Name: "lat", Type: "number"}, {Name: "lng", Type: "number"},
+		{Name: "postal_code", Description: "Used in place of lat and lng"},
+	}
+
+	curtLookupParams = []openapi.Param{
+		{Name: "year"}, {Name: "make"}, {Name: "model"}, {Name: "style"},
+		{Name: "heavyduty", Type: "boolean"},
+		{Name: "customerprices", Type: "boolean", Description: "include the customer's prices on each part"},
+	}
+)
+
+// Deprecated handles routes that have been turned off. Calls are still
+// counted, so the deprecation report shows who's relying on them.
+func Deprecated(w http.ResponseWriter, r *http.Request, route martini.Route) {
+	deprecation.Record(r, route.Method(), route.Pattern())
+	deprecation.Gone(w)
 }
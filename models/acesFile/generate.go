@@ -0,0 +1,614 @@
+package acesFile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/redis"
+	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/products"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Generated ACES files are built from the fitment kept on each part in
+// Mongo: aces_vehicles, which name a base vehicle, submodel and
+// configurations, and the older vehicle_applications, which only name a
+// base vehicle. Names are resolved to VCDB IDs, configurations the VCDB
+// knows become vehicle attribute qualifiers, and anything else a note.
+
+var (
+	// AcesVersions are the ACES versions files are generated for.
+	AcesVersions = []string{"3.2", "4.1"}
+
+	ErrUnknownAcesVersion = errors.New("ACES files are generated as version 3.2 or 4.1")
+)
+
+const (
+	// acesCacheTTL is how long a generated file is served from the cache,
+	// in seconds.
+	acesCacheTTL = 6 * 60 * 60
+	// acesChunkSize is how many apps are written between flushes.
+	acesChunkSize = 500
+)
+
+var (
+	// The sender is who to contact about the file. The QDB and PCDB aren't
+	// kept alongside the VCDB, so the versions of them the fitment was
+	// mapped to are configured too. Auto Care publishes all three
+	// monthly, so they default to the VCDB's version.
+	acesSenderName  = os.Getenv("ACES_SENDER_NAME")
+	acesSenderPhone = os.Getenv("ACES_SENDER_PHONE")
+	qdbVersionDate  = os.Getenv("ACES_QDB_VERSION_DATE")
+	pcdbVersionDate = os.Getenv("ACES_PCDB_VERSION_DATE")
+)
+
+var (
+	getBaseVehiclesStmt = `
+		select bv.BaseVehicleID, bv.YearID, ma.MakeName, mo.ModelName from BaseVehicle as bv
+		join Make as ma on bv.MakeID = ma.MakeID
+		join Model as mo on bv.ModelID = mo.ModelID`
+	getSubmodelsStmt         = `select SubmodelID, SubmodelName from Submodel`
+	getVcdbVersionStmt       = `select VersionDate from Version`
+	getConfigAttributeIDStmt = `select cat.name, ca.value, ca.vcdbID
+		from ConfigAttributeType as cat
+		join ConfigAttribute as ca on ca.ConfigAttributeTypeID = cat.ID
+		where cat.AcesTypeID > 0 && ca.vcdbID > 0`
+)
+
+// acesAttributes are the ACES vehicle attribute elements, keyed by
+// configuration type with its spaces removed, in the order the ACES schema
+// lists them, with the VCDB table, ID and name columns of their values.
+var acesAttributes = []struct {
+	Type    string
+	Element string
+	Table   string
+	IDCol   string
+	NameCol string
+}{
+	{"mfrbodycode", "MfrBodyCode", "MfrBodyCode", "MfrBodyCodeID", "MfrBodyCodeName"},
+	{"numberofdoors", "BodyNumDoors", "BodyNumDoors", "BodyNumDoorsID", "BodyNumDoors"},
+	{"bodytype", "BodyType", "BodyType", "BodyTypeID", "BodyTypeName"},
+	{"drivetype", "DriveType", "DriveType", "DriveTypeID", "DriveTypeName"},
+	{"enginedesignation", "EngineDesignation", "EngineDesignation", "EngineDesignationID", "EngineDesignationName"},
+	{"enginevin", "EngineVIN", "EngineVIN", "EngineVINID", "EngineVINName"},
+	{"engineversion", "EngineVersion", "EngineVersion", "EngineVersionID", "EngineVersion"},
+	{"fueldeliverytype", "FuelDeliveryType", "FuelDeliveryType", "FuelDeliveryTypeID", "FuelDeliveryTypeName"},
+	{"fueldeliverysubtype", "FuelDeliverySubType", "FuelDeliverySubType", "FuelDeliverySubTypeID", "FuelDeliverySubTypeName"},
+	{"fuelsystemcontroltype", "FuelSystemControlType", "FuelSystemControlType", "FuelSystemControlTypeID", "FuelSystemControlTypeName"},
+	{"fuelsystemdesign", "FuelSystemDesign", "FuelSystemDesign", "FuelSystemDesignID", "FuelSystemDesignName"},
+	{"aspiration", "Aspiration", "Aspiration", "AspirationID", "AspirationName"},
+	{"cylinderheadtype", "CylinderHeadType", "CylinderHeadType", "CylinderHeadTypeID", "CylinderHeadTypeName"},
+	{"fueltype", "FuelType", "FuelType", "FuelTypeID", "FuelTypeName"},
+	{"ignitionsystem", "IgnitionSystemType", "IgnitionSystemType", "IgnitionSystemTypeID", "IgnitionSystemTypeName"},
+	{"transmissionmanufacturercode", "TransmissionMfrCode", "TransmissionMfrCode", "TransmissionMfrCodeID", "TransmissionMfrCode"},
+	{"transmissiontype", "TransmissionType", "TransmissionType", "TransmissionTypeID", "TransmissionTypeName"},
+	{"transmissioncontroltype", "TransmissionControlType", "TransmissionControlType", "TransmissionControlTypeID", "TransmissionControlTypeName"},
+	{"transmissionnumspeeds", "TransmissionNumSpeeds", "TransmissionNumSpeeds", "TransmissionNumSpeedsID", "TransmissionNumSpeeds"},
+	{"transmissionelectroniccontrolled", "TransElecControlled", "ElecControlled", "ElecControlledID", "ElecControlled"},
+	{"bedlength", "BedLength", "BedLength", "BedLengthID", "BedLength"},
+	{"bedtype", "BedType", "BedType", "BedTypeID", "BedTypeName"},
+	{"wheelbase", "WheelBase", "WheelBase", "WheelBaseID", "WheelBase"},
+	{"brakesystem", "BrakeSystem", "BrakeSystem", "BrakeSystemID", "BrakeSystemName"},
+	{"frontbraketype", "FrontBrakeType", "BrakeType", "BrakeTypeID", "BrakeTypeName"},
+	{"rearbraketype", "RearBrakeType", "BrakeType", "BrakeTypeID", "BrakeTypeName"},
+	{"brakeabs", "BrakeABS", "BrakeABS", "BrakeABSID", "BrakeABSName"},
+	{"frontspringtype", "FrontSpringType", "SpringType", "SpringTypeID", "SpringTypeName"},
+	{"rearspringtype", "RearSpringType", "SpringType", "SpringTypeID", "SpringTypeName"},
+	{"steeringsystem", "SteeringSystem", "SteeringSystem", "SteeringSystemID", "SteeringSystemName"},
+	{"steeringtype", "SteeringType", "SteeringType", "SteeringTypeID", "SteeringTypeName"},
+	{"valves", "ValvesPerEngine", "Valves", "ValvesID", "ValvesPerEngine"},
+}
+
+// acesHeader is in the order both the 3.x and 4.x schemas list its
+// elements. BrandAAIAID was added in 4.0.
+type acesHeader struct {
+	Company         string
+	SenderName      string
+	SenderPhone     string
+	TransferDate    string
+	BrandAAIAID     string `xml:",omitempty"`
+	DocumentTitle   string
+	EffectiveDate   string
+	SubmissionType  string
+	VcdbVersionDate string
+	QdbVersionDate  string
+	PcdbVersionDate string
+}
+
+type acesApp struct {
+	Action      string `xml:"action,attr"`
+	ID          int    `xml:"id,attr"`
+	BaseVehicle acesID
+	SubModel    *acesID `xml:",omitempty"`
+	// Qualifiers are named for their vehicle attribute, such as
+	// DriveType.
+	Qualifiers []acesQualifier
+	Notes      []string `xml:"Note"`
+	Qty        int
+	PartType   acesID
+	Part       string
+}
+
+type acesID struct {
+	ID int `xml:"id,attr"`
+}
+
+type acesQualifier struct {
+	XMLName xml.Name
+	ID      int `xml:"id,attr"`
+}
+
+type acesFooter struct {
+	RecordCount int
+}
+
+// vcdbIndex resolves fitment names to VCDB IDs, and VCDB IDs back to
+// names.
+type vcdbIndex struct {
+	// baseVehicles are keyed by year, make and model, lowercased and
+	// separated by "|".
+	baseVehicles map[string]int
+	submodels    map[string]int
+	// attributes are keyed by configuration type, with its spaces
+	// removed, and value, lowercased and separated by "|".
+	attributes map[string]int
+
+	baseVehicleNames map[int]products.BaseVehicle
+	submodelNames    map[int]string
+	// attributeNames are the VCDB's values of vehicle attributes, keyed by
+	// element and ID separated by "|". They're only loaded to check
+	// imports.
+	attributeNames map[string]string
+	// typeNames are the names of configuration types, keyed by type with
+	// its spaces removed.
+	typeNames map[string]string
+	// versionDate is the date the VCDB was published.
+	versionDate time.Time
+}
+
+func baseVehicleKey(year int, makeName, modelName string) string {
+	return strconv.Itoa(year) + "|" + strings.ToLower(strings.TrimSpace(makeName)) + "|" + strings.ToLower(strings.TrimSpace(modelName))
+}
+
+func attributeKey(typ, value string) string {
+	return configType(typ) + "|" + strings.ToLower(strings.TrimSpace(value))
+}
+
+func configType(typ string) string {
+	return strings.ToLower(strings.Replace(typ, " ", "", -1))
+}
+
+// GetCachedAcesFile writes brand's generated ACES file in version to w,
+// from the cache if it was generated in the last few hours.
+func GetCachedAcesFile(ctx context.Context, w io.Writer, b brand.Brand, version string) error {
+	if !acesVersion(version) {
+		return ErrUnknownAcesVersion
+	}
+	if gz, err := redis.Get(acesCacheKey(b, version)); err == nil && len(gz) > 0 {
+		if zr, err := gzip.NewReader(bytes.NewReader(gz)); err == nil {
+			defer zr.Close()
+			_, err = io.Copy(w, zr)
+			return err
+		}
+	}
+	return generateCachedAcesFile(ctx, w, b, version, redis.SetexBytesAsync)
+}
+
+// RefreshAcesFile generates brand's ACES file in version again, writing it
+// to w, and caches it in place of the last one.
+func RefreshAcesFile(ctx context.Context, w io.Writer, b brand.Brand, version string) error {
+	return generateCachedAcesFile(ctx, w, b, version, func(key string, data []byte, exp int) {
+		if err := redis.SetexBytes(key, data, exp); err != nil {
+			log.Printf("Error caching brand %d's ACES file: %s\n", b.ID, err)
+		}
+	})
+}
+
+// generateCachedAcesFile generates the file to w, gzipping a copy for the
+// cache as it goes. The copy is only cached if the whole file was written.
+func generateCachedAcesFile(ctx context.Context, w io.Writer, b brand.Brand, version string, cache func(string, []byte, int)) error {
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	if err := GenerateAcesFile(ctx, teeWriter{w, zw}, b, version); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	cache(acesCacheKey(b, version), gz.Bytes(), acesCacheTTL)
+	return nil
+}
+
+func acesCacheKey(b brand.Brand, version string) string {
+	return "aces:" + strconv.Itoa(b.ID) + ":" + version
+}
+
+// GenerateAcesFile writes an ACES file in version of the fitment of
+// brand's active parts to w, flushing it every few hundred apps if it can
+// be. Fitment whose vehicle can't be found in the VCDB, and parts without
+// a part type, are left out.
+func GenerateAcesFile(ctx context.Context, w io.Writer, b brand.Brand, version string) error {
+	if !acesVersion(version) {
+		return ErrUnknownAcesVersion
+	}
+	if err := database.Init(); err != nil {
+		return err
+	}
+	idx, err := loadVcdbIndex(ctx)
+	if err != nil {
+		return err
+	}
+
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	query := bson.M{
+		"brand.id": b.ID,
+		"status":   bson.M{"$in": []int{700, 800, 810, 815, 850, 870, 888, 900, 910, 950}},
+	}
+	fields := bson.M{"part_number": 1, "acesPartTypeId": 1, "aces_vehicles": 1, "vehicle_applications": 1}
+	iter := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(query).Select(fields).Sort("part_number").Iter()
+	defer iter.Close()
+
+	aw, err := newAcesWriter(w, version, newAcesHeader(version, b, idx.versionDate, time.Now()))
+	if err != nil {
+		return err
+	}
+	var unresolved, untyped int
+	var p products.Part
+	for iter.Next(&p) {
+		if p.AcesPartTypeID == 0 {
+			untyped++
+			p = products.Part{}
+			continue
+		}
+		apps, missed := idx.apps(&p)
+		unresolved += missed
+		for _, app := range apps {
+			if err = aw.app(app); err != nil {
+				return err
+			}
+			if aw.count%acesChunkSize == 0 {
+				if err = aw.flush(); err != nil {
+					return err
+				}
+				if err = ctx.Err(); err != nil {
+					return err
+				}
+			}
+		}
+		p = products.Part{}
+	}
+	if err = iter.Err(); err != nil {
+		return err
+	}
+	if err = aw.close(); err != nil {
+		return err
+	}
+	if unresolved > 0 || untyped > 0 {
+		log.Printf("Brand %d's ACES file left out %d vehicles not in the VCDB and %d parts without a part type\n", b.ID, unresolved, untyped)
+	}
+	return nil
+}
+
+func acesVersion(version string) bool {
+	for _, v := range AcesVersions {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func loadVcdbIndex(ctx context.Context) (*vcdbIndex, error) {
+	idx := &vcdbIndex{
+		baseVehicles:     make(map[string]int),
+		submodels:        make(map[string]int),
+		attributes:       make(map[string]int),
+		baseVehicleNames: make(map[int]products.BaseVehicle),
+		submodelNames:    make(map[int]string),
+		typeNames:        make(map[string]string),
+	}
+
+	err := database.VcdbDB.QueryRowContext(ctx, getVcdbVersionStmt).Scan(&idx.versionDate)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.VcdbDB.QueryContext(ctx, getBaseVehiclesStmt)
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id, year int
+		var makeName, modelName string
+		if err = rows.Scan(&id, &year, &makeName, &modelName); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx.baseVehicles[baseVehicleKey(year, makeName, modelName)] = id
+		idx.baseVehicleNames[id] = products.BaseVehicle{Year: year, Make: makeName, Model: modelName}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rows, err = database.VcdbDB.QueryContext(ctx, getSubmodelsStmt); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var id int
+		var name string
+		if err = rows.Scan(&id, &name); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx.submodels[strings.ToLower(strings.TrimSpace(name))] = id
+		idx.submodelNames[id] = name
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if rows, err = database.DB.QueryContext(ctx, getConfigAttributeIDStmt); err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var typ, value string
+		var id int
+		if err = rows.Scan(&typ, &value, &id); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		idx.attributes[attributeKey(typ, value)] = id
+		idx.typeNames[configType(typ)] = typ
+	}
+	rows.Close()
+	return idx, rows.Err()
+}
+
+// apps returns the ACES applications of the part's fitment, without
+// duplicates, and how many of its vehicles couldn't be resolved. Each
+// configuration of an ACES vehicle is an application of its own.
+func (idx *vcdbIndex) apps(p *products.Part) ([]acesApp, int) {
+	var apps []acesApp
+	unresolved := 0
+	seen := map[string]bool{}
+	add := func(app acesApp) {
+		app.Action = "A"
+		app.Qty = 1
+		app.PartType = acesID{p.AcesPartTypeID}
+		app.Part = p.PartNumber
+		key, _ := json.Marshal(app)
+		if !seen[string(key)] {
+			seen[string(key)] = true
+			apps = append(apps, app)
+		}
+	}
+
+	for _, v := range p.AcesVehicles {
+		base, ok := idx.baseVehicles[baseVehicleKey(v.Base.Year, v.Base.Make, v.Base.Model)]
+		if !ok {
+			unresolved++
+			continue
+		}
+		app := acesApp{BaseVehicle: acesID{base}}
+		if v.Submodel != "" {
+			sub, ok := idx.submodels[strings.ToLower(strings.TrimSpace(v.Submodel))]
+			if !ok {
+				unresolved++
+				continue
+			}
+			app.SubModel = &acesID{sub}
+		}
+		if len(v.Attributes) == 0 {
+			add(app)
+		}
+		for _, config := range v.Attributes {
+			configured := app
+			configured.Qualifiers, configured.Notes = idx.qualifiers(config.Options)
+			add(configured)
+		}
+	}
+
+	for _, v := range p.Vehicles {
+		years, ok := applicationYears(v.Year)
+		if !ok {
+			unresolved++
+			continue
+		}
+		var notes []string
+		if style := strings.TrimSpace(v.Style); style != "" && !strings.EqualFold(style, "all") {
+			notes = append(notes, style)
+		}
+		if v.Drilling != "" {
+			notes = append(notes, "Drilling: "+v.Drilling)
+		}
+		if v.Exposed != "" {
+			notes = append(notes, "Exposed: "+v.Exposed)
+		}
+		for _, year := range years {
+			base, ok := idx.baseVehicles[baseVehicleKey(year, v.Make, v.Model)]
+			if !ok {
+				unresolved++
+				continue
+			}
+			add(acesApp{BaseVehicle: acesID{base}, Notes: notes})
+		}
+	}
+	return apps, unresolved
+}
+
+// qualifiers returns the options the VCDB knows as vehicle attributes, in
+// schema order, and the rest as notes.
+func (idx *vcdbIndex) qualifiers(options []products.ConfigOption) ([]acesQualifier, []string) {
+	byType := make(map[string]acesQualifier)
+	var notes []string
+	for _, o := range options {
+		id, known := idx.attributes[attributeKey(o.Key, o.Value)]
+		element := ""
+		for _, a := range acesAttributes {
+			if a.Type == configType(o.Key) {
+				element = a.Element
+			}
+		}
+		if !known || element == "" {
+			notes = append(notes, o.Key+": "+o.Value)
+			continue
+		}
+		byType[configType(o.Key)] = acesQualifier{XMLName: xml.Name{Local: element}, ID: id}
+	}
+	var quals []acesQualifier
+	for _, a := range acesAttributes {
+		if q, ok := byType[a.Type]; ok {
+			quals = append(quals, q)
+		}
+	}
+	sort.Strings(notes)
+	return quals, notes
+}
+
+// applicationYears parses a vehicle application's year, which may be a
+// range such as "2010-2012".
+func applicationYears(year string) ([]int, bool) {
+	parts := strings.SplitN(strings.TrimSpace(year), "-", 2)
+	from, err := strconv.Atoi(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return nil, false
+	}
+	to := from
+	if len(parts) == 2 {
+		if to, err = strconv.Atoi(strings.TrimSpace(parts[1])); err != nil || to < from {
+			return nil, false
+		}
+	}
+	var years []int
+	for y := from; y <= to; y++ {
+		years = append(years, y)
+	}
+	return years, true
+}
+
+// newAcesHeader returns the header of b's file in version, generated at
+// now from the VCDB published at vcdbVersion.
+func newAcesHeader(version string, b brand.Brand, vcdbVersion, now time.Time) acesHeader {
+	company := b.FormalName
+	if company == "" {
+		company = b.Name
+	}
+	h := acesHeader{
+		Company:         company,
+		SenderName:      acesSenderName,
+		SenderPhone:     acesSenderPhone,
+		TransferDate:    now.Format("2006-01-02"),
+		DocumentTitle:   b.Name + " ACES " + version,
+		EffectiveDate:   now.Format("2006-01-02"),
+		SubmissionType:  "FULL",
+		VcdbVersionDate: vcdbVersion.Format("2006-01-02"),
+		QdbVersionDate:  qdbVersionDate,
+		PcdbVersionDate: pcdbVersionDate,
+	}
+	if h.SenderName == "" {
+		h.SenderName = company
+	}
+	if h.QdbVersionDate == "" {
+		h.QdbVersionDate = h.VcdbVersionDate
+	}
+	if h.PcdbVersionDate == "" {
+		h.PcdbVersionDate = h.VcdbVersionDate
+	}
+	if !strings.HasPrefix(version, "3.") {
+		h.BrandAAIAID = b.AutocareID
+	}
+	return h
+}
+
+// acesWriter writes an ACES file an application at a time.
+type acesWriter struct {
+	w     io.Writer
+	enc   *xml.Encoder
+	count int
+}
+
+// newAcesWriter writes the file up to its first application.
+func newAcesWriter(w io.Writer, version string, header acesHeader) (*acesWriter, error) {
+	aw := &acesWriter{w: w, enc: xml.NewEncoder(w)}
+	aw.enc.Indent("", "  ")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	root := xml.StartElement{Name: xml.Name{Local: "ACES"}, Attr: []xml.Attr{{Name: xml.Name{Local: "version"}, Value: version}}}
+	if err := aw.enc.EncodeToken(root); err != nil {
+		return nil, err
+	}
+	if err := aw.enc.EncodeElement(header, xml.StartElement{Name: xml.Name{Local: "Header"}}); err != nil {
+		return nil, err
+	}
+	return aw, nil
+}
+
+func (aw *acesWriter) app(app acesApp) error {
+	aw.count++
+	app.ID = aw.count
+	return aw.enc.EncodeElement(app, xml.StartElement{Name: xml.Name{Local: "App"}})
+}
+
+// flush sends what has been encoded on to the writer, and flushes it too
+// if it can be.
+func (aw *acesWriter) flush() error {
+	if err := aw.enc.Flush(); err != nil {
+		return err
+	}
+	if fl, ok := aw.w.(flusher); ok {
+		fl.Flush()
+	}
+	return nil
+}
+
+// close writes the footer and ends the file.
+func (aw *acesWriter) close() error {
+	if err := aw.enc.EncodeElement(acesFooter{RecordCount: aw.count}, xml.StartElement{Name: xml.Name{Local: "Footer"}}); err != nil {
+		return err
+	}
+	if err := aw.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "ACES"}}); err != nil {
+		return err
+	}
+	return aw.flush()
+}
+
+type flusher interface {
+	Flush()
+}
+
+// teeWriter writes to w and a gzipped copy, and flushes w if it can be.
+type teeWriter struct {
+	w  io.Writer
+	zw *gzip.Writer
+}
+
+func (t teeWriter) Write(b []byte) (int, error) {
+	n, err := t.w.Write(b)
+	if err != nil {
+		return n, err
+	}
+	return t.zw.Write(b)
+}
+
+func (t teeWriter) Flush() {
+	if fl, ok := t.w.(flusher); ok {
+		fl.Flush()
+	}
+}
@@ -0,0 +1,187 @@
+package acesFile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/products"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestGenerateAcesFile(t *testing.T) {
+	Convey("Testing ACES generation", t, func() {
+		idx := &vcdbIndex{
+			baseVehicles: map[string]int{
+				baseVehicleKey(2012, "Ford", "F-150"): 5911,
+				baseVehicleKey(2013, "Ford", "F-150"): 5912,
+			},
+			submodels: map[string]int{"xlt": 20},
+			attributes: map[string]int{
+				attributeKey("Drive Type", "4WD"):    8,
+				attributeKey("Body Type", "Pickup"):  6,
+				attributeKey("Unlisted Type", "Yes"): 4,
+			},
+		}
+		p := &products.Part{
+			PartNumber: "13386", AcesPartTypeID: 5316,
+			AcesVehicles: []products.AcesVehicle{
+				{
+					Base:     products.BaseVehicle{Year: 2012, Make: "FORD", Model: "F-150"},
+					Submodel: "XLT",
+					Attributes: []products.AcesConfiguration{
+						{Options: []products.ConfigOption{{Key: "Drive Type", Value: "4WD"}, {Key: "Trailer Wiring", Value: "7"}, {Key: "Body Type", Value: "Pickup"}}},
+						{Options: []products.ConfigOption{{Key: "Unlisted Type", Value: "Yes"}}},
+					},
+				},
+				{Base: products.BaseVehicle{Year: 2012, Make: "Ford", Model: "F-150"}, Submodel: "Raptor"},
+				{Base: products.BaseVehicle{Year: 1999, Make: "Ford", Model: "Model T"}},
+			},
+			Vehicles: []products.VehicleApplication{
+				{Year: "2012-2013", Make: "Ford", Model: "F-150", Style: "All", Drilling: "No"},
+				{Year: "2012-2013", Make: "Ford", Model: "F-150", Style: "All", Drilling: "No"},
+				{Year: "later", Make: "Ford", Model: "F-150"},
+			},
+		}
+
+		Convey("fitment is resolved to VCDB IDs", func() {
+			apps, unresolved := idx.apps(p)
+			So(unresolved, ShouldEqual, 3)
+			So(len(apps), ShouldEqual, 4)
+
+			So(apps[0].BaseVehicle.ID, ShouldEqual, 5911)
+			So(apps[0].SubModel.ID, ShouldEqual, 20)
+			So(apps[0].Qualifiers, ShouldResemble, []acesQualifier{
+				{XMLName: xml.Name{Local: "BodyType"}, ID: 6},
+				{XMLName: xml.Name{Local: "DriveType"}, ID: 8},
+			})
+			So(apps[0].Notes, ShouldResemble, []string{"Trailer Wiring: 7"})
+			So(apps[0].PartType.ID, ShouldEqual, 5316)
+
+			So(apps[1].Qualifiers, ShouldBeEmpty)
+			So(apps[1].Notes, ShouldResemble, []string{"Unlisted Type: Yes"})
+
+			So(apps[2].SubModel, ShouldBeNil)
+			So(apps[2].Notes, ShouldResemble, []string{"Drilling: No"})
+			So(apps[3].BaseVehicle.ID, ShouldEqual, 5912)
+		})
+
+		Convey("application years may be ranges", func() {
+			years, ok := applicationYears(" 2010 - 2012")
+			So(ok, ShouldBeTrue)
+			So(years, ShouldResemble, []int{2010, 2011, 2012})
+			_, ok = applicationYears("2012-2010")
+			So(ok, ShouldBeFalse)
+		})
+
+		b := brand.Brand{ID: 1, Name: "CURT", FormalName: "CURT Manufacturing", AutocareID: "BKDK"}
+		vcdbVersion := time.Date(2016, 2, 26, 0, 0, 0, 0, time.UTC)
+		now := time.Date(2016, 3, 1, 0, 0, 0, 0, time.UTC)
+
+		Convey("files have a header, numbered apps and a footer", func() {
+			var buf bytes.Buffer
+			aw, err := newAcesWriter(&buf, "4.1", newAcesHeader("4.1", b, vcdbVersion, now))
+			So(err, ShouldBeNil)
+			apps, _ := idx.apps(p)
+			for _, app := range apps {
+				So(aw.app(app), ShouldBeNil)
+			}
+			So(aw.close(), ShouldBeNil)
+
+			var doc struct {
+				Version string     `xml:"version,attr"`
+				Header  acesHeader `xml:"Header"`
+				Apps    []struct {
+					ID        int `xml:"id,attr"`
+					DriveType *acesID
+					Notes     []string `xml:"Note"`
+					Part      string
+				} `xml:"App"`
+				Footer acesFooter `xml:"Footer"`
+			}
+			So(xml.Unmarshal(buf.Bytes(), &doc), ShouldBeNil)
+			So(doc.Version, ShouldEqual, "4.1")
+			So(doc.Header.Company, ShouldEqual, "CURT Manufacturing")
+			So(doc.Header.SenderName, ShouldEqual, "CURT Manufacturing")
+			So(doc.Header.BrandAAIAID, ShouldEqual, "BKDK")
+			So(doc.Header.TransferDate, ShouldEqual, "2016-03-01")
+			So(doc.Header.VcdbVersionDate, ShouldEqual, "2016-02-26")
+			So(doc.Header.QdbVersionDate, ShouldEqual, "2016-02-26")
+			So(doc.Header.PcdbVersionDate, ShouldEqual, "2016-02-26")
+			So(len(doc.Apps), ShouldEqual, 4)
+			So(doc.Apps[3].ID, ShouldEqual, 4)
+			So(doc.Apps[0].DriveType.ID, ShouldEqual, 8)
+			So(doc.Apps[0].Part, ShouldEqual, "13386")
+			So(doc.Footer.RecordCount, ShouldEqual, 4)
+		})
+
+		Convey("3.x headers have no brand", func() {
+			var buf bytes.Buffer
+			aw, err := newAcesWriter(&buf, "3.2", newAcesHeader("3.2", b, vcdbVersion, now))
+			So(err, ShouldBeNil)
+			So(aw.close(), ShouldBeNil)
+			So(buf.String(), ShouldNotContainSubstring, "BrandAAIAID")
+			So(buf.String(), ShouldContainSubstring, "<PcdbVersionDate>2016-02-26</PcdbVersionDate>")
+		})
+
+		Convey("streamed files are flushed to the client and gzipped for the cache", func() {
+			rec := httptest.NewRecorder()
+			var gz bytes.Buffer
+			zw := gzip.NewWriter(&gz)
+			aw, err := newAcesWriter(teeWriter{rec, zw}, "4.1", newAcesHeader("4.1", b, vcdbVersion, now))
+			So(err, ShouldBeNil)
+			So(aw.close(), ShouldBeNil)
+			So(rec.Flushed, ShouldBeTrue)
+			So(zw.Close(), ShouldBeNil)
+
+			zr, err := gzip.NewReader(&gz)
+			So(err, ShouldBeNil)
+			cached, err := ioutil.ReadAll(zr)
+			So(err, ShouldBeNil)
+			So(string(cached), ShouldEqual, rec.Body.String())
+		})
+
+		// The ACES schemas are only published to Auto Care Association
+		// members, so they aren't kept in the repo. Set ACES_XSD_DIR to a
+		// directory holding them as aces_3.2.xsd and aces_4.1.xsd to
+		// validate against them.
+		Convey("files validate against the ACES schema", func() {
+			schemas := os.Getenv("ACES_XSD_DIR")
+			if schemas == "" {
+				t.Skip("ACES_XSD_DIR isn't set")
+			}
+			xmllint, err := exec.LookPath("xmllint")
+			if err != nil {
+				t.Skip("xmllint isn't installed")
+			}
+			dir, err := ioutil.TempDir("", "aces")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			for _, version := range AcesVersions {
+				var buf bytes.Buffer
+				aw, err := newAcesWriter(&buf, version, newAcesHeader(version, b, vcdbVersion, now))
+				So(err, ShouldBeNil)
+				apps, _ := idx.apps(p)
+				for _, app := range apps {
+					So(aw.app(app), ShouldBeNil)
+				}
+				So(aw.close(), ShouldBeNil)
+
+				file := filepath.Join(dir, "aces_"+version+".xml")
+				So(ioutil.WriteFile(file, buf.Bytes(), 0644), ShouldBeNil)
+				out, err := exec.Command(xmllint, "--noout", "--schema", filepath.Join(schemas, "aces_"+version+".xsd"), file).CombinedOutput()
+				So(string(out), ShouldContainSubstring, "validates")
+				So(err, ShouldBeNil)
+			}
+		})
+	})
+}
@@ -0,0 +1,471 @@
+package acesFile
+
+import (
+	"context"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/models/products"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Imported ACES files are checked against the VCDB and the catalog, and
+// their apps compared with the aces_vehicles of the parts they name. A FULL
+// submission replaces the fitment of each of its parts, so fitment the
+// file doesn't have is removed. An UPDATE only adds its "A" apps and
+// removes its "D" apps. Nothing is removed from a part with invalid apps.
+
+// AcesDocument is a parsed ACES file.
+type AcesDocument struct {
+	Version        string    `json:"version"`
+	SubmissionType string    `json:"submission_type"`
+	Apps           []AcesApp `json:"apps"`
+}
+
+// AcesApp is an application in an ACES file.
+type AcesApp struct {
+	ID          int         `json:"id"`
+	Action      string      `json:"action"`
+	BaseVehicle int         `json:"base_vehicle"`
+	SubModel    int         `json:"submodel,omitempty"`
+	Qualifiers  []Qualifier `json:"qualifiers,omitempty"`
+	Notes       []string    `json:"notes,omitempty"`
+	PartType    int         `json:"part_type"`
+	Part        string      `json:"part"`
+}
+
+// Qualifier is a vehicle attribute an app is limited to, such as
+// DriveType 8.
+type Qualifier struct {
+	Attribute string `json:"attribute"`
+	ID        int    `json:"id"`
+}
+
+// AcesImport reports an imported ACES file's invalid apps, and the
+// fitment it adds to and removes from each part.
+type AcesImport struct {
+	Version        string            `json:"version"`
+	SubmissionType string            `json:"submission_type"`
+	Apps           int               `json:"apps"`
+	Invalid        []InvalidApp      `json:"invalid"`
+	Parts          []PartFitmentDiff `json:"parts"`
+	Applied        bool              `json:"applied"`
+}
+
+// InvalidApp is an app that was left out of the diff, and why.
+type InvalidApp struct {
+	ID       int      `json:"id"`
+	Part     string   `json:"part"`
+	Problems []string `json:"problems"`
+}
+
+// PartFitmentDiff is the fitment an ACES file adds to and removes from a
+// part.
+type PartFitmentDiff struct {
+	PartID     int       `json:"part_id"`
+	PartNumber string    `json:"part_number"`
+	Added      []Fitment `json:"added"`
+	Removed    []Fitment `json:"removed"`
+	Unchanged  int       `json:"unchanged"`
+}
+
+// Fitment is a vehicle, and a configuration of it, that a part fits.
+type Fitment struct {
+	Year          int                     `json:"year"`
+	Make          string                  `json:"make"`
+	Model         string                  `json:"model"`
+	Submodel      string                  `json:"submodel,omitempty"`
+	Configuration []products.ConfigOption `json:"configuration,omitempty"`
+}
+
+var (
+	ErrNotAcesFile = errors.New("that isn't an ACES file")
+)
+
+type rawApp struct {
+	Action      string   `xml:"action,attr"`
+	ID          int      `xml:"id,attr"`
+	BaseVehicle *acesID  `xml:"BaseVehicle"`
+	SubModel    *acesID  `xml:"SubModel"`
+	Notes       []string `xml:"Note"`
+	PartType    acesID
+	Part        string
+	Other       []acesQualifier `xml:",any"`
+}
+
+// ParseAces reads an ACES file.
+func ParseAces(r io.Reader) (*AcesDocument, error) {
+	doc := &AcesDocument{}
+	dec := xml.NewDecoder(r)
+	root := false
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		start, ok := tok.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		switch start.Name.Local {
+		case "ACES":
+			root = true
+			for _, a := range start.Attr {
+				if a.Name.Local == "version" {
+					doc.Version = a.Value
+				}
+			}
+		case "Header":
+			var h struct {
+				SubmissionType string
+			}
+			if err = dec.DecodeElement(&h, &start); err != nil {
+				return nil, err
+			}
+			doc.SubmissionType = strings.ToUpper(strings.TrimSpace(h.SubmissionType))
+		case "App":
+			var raw rawApp
+			if err = dec.DecodeElement(&raw, &start); err != nil {
+				return nil, err
+			}
+			doc.Apps = append(doc.Apps, raw.app())
+		}
+	}
+	if !root {
+		return nil, ErrNotAcesFile
+	}
+	return doc, nil
+}
+
+func (raw rawApp) app() AcesApp {
+	app := AcesApp{
+		ID:       raw.ID,
+		Action:   strings.ToUpper(raw.Action),
+		PartType: raw.PartType.ID,
+		Part:     strings.TrimSpace(raw.Part),
+	}
+	if raw.BaseVehicle != nil {
+		app.BaseVehicle = raw.BaseVehicle.ID
+	}
+	if raw.SubModel != nil {
+		app.SubModel = raw.SubModel.ID
+	}
+	for _, n := range raw.Notes {
+		if n = strings.TrimSpace(n); n != "" {
+			app.Notes = append(app.Notes, n)
+		}
+	}
+	for _, q := range raw.Other {
+		for _, a := range acesAttributes {
+			if a.Element == q.XMLName.Local {
+				app.Qualifiers = append(app.Qualifiers, Qualifier{Attribute: a.Element, ID: q.ID})
+			}
+		}
+	}
+	return app
+}
+
+// ImportAces checks an ACES file of brand's fitment, and compares it with
+// the fitment of the parts it names. If apply is set, each part's
+// aces_vehicles are updated with the differences.
+func ImportAces(ctx context.Context, r io.Reader, brandID int, apply bool) (*AcesImport, error) {
+	doc, err := ParseAces(r)
+	if err != nil {
+		return nil, err
+	}
+	if err = database.Init(); err != nil {
+		return nil, err
+	}
+	idx, err := loadVcdbIndex(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if err = idx.loadAttributeNames(ctx); err != nil {
+		return nil, err
+	}
+
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	c := session.DB(database.ProductDatabase).C(database.ProductCollectionName)
+
+	numbers := map[string]bool{}
+	for _, app := range doc.Apps {
+		numbers[app.Part] = true
+	}
+	partNumbers := make([]string, 0, len(numbers))
+	for pn := range numbers {
+		partNumbers = append(partNumbers, pn)
+	}
+	var parts []products.Part
+	query := bson.M{"brand.id": brandID, "part_number": bson.M{"$in": partNumbers}}
+	fields := bson.M{"id": 1, "part_number": 1, "aces_vehicles": 1}
+	if err = c.Find(query).Select(fields).All(&parts); err != nil {
+		return nil, err
+	}
+
+	imp := diffAces(doc, idx, parts)
+	if !apply {
+		return imp, nil
+	}
+	current := make(map[int][]products.AcesVehicle, len(parts))
+	for _, p := range parts {
+		current[p.ID] = p.AcesVehicles
+	}
+	for _, d := range imp.Parts {
+		if len(d.Added) == 0 && len(d.Removed) == 0 {
+			continue
+		}
+		vehicles := applyFitmentDiff(current[d.PartID], d)
+		update := bson.M{"$set": bson.M{"aces_vehicles": vehicles}}
+		if err = c.Update(bson.M{"id": d.PartID, "brand.id": brandID}, update); err != nil {
+			return imp, err
+		}
+	}
+	imp.Applied = true
+	return imp, nil
+}
+
+// diffAces checks the document's apps, and compares the valid ones with
+// the fitment of parts.
+func diffAces(doc *AcesDocument, idx *vcdbIndex, parts []products.Part) *AcesImport {
+	imp := &AcesImport{
+		Version:        doc.Version,
+		SubmissionType: doc.SubmissionType,
+		Apps:           len(doc.Apps),
+		Invalid:        []InvalidApp{},
+		Parts:          []PartFitmentDiff{},
+	}
+	byNumber := make(map[string]products.Part, len(parts))
+	for _, p := range parts {
+		byNumber[strings.ToUpper(p.PartNumber)] = p
+	}
+
+	added := map[string][]Fitment{}
+	deleted := map[string][]Fitment{}
+	// fitment a part's invalid apps meant can't be told from the fitment
+	// a submission drops, so nothing is removed from those parts
+	invalid := map[string]bool{}
+	for _, app := range doc.Apps {
+		f, problems := idx.fitmentOf(app)
+		if _, ok := byNumber[strings.ToUpper(app.Part)]; !ok && app.Part != "" {
+			problems = append(problems, fmt.Sprintf("part %q isn't in the catalog", app.Part))
+		}
+		pn := strings.ToUpper(app.Part)
+		if len(problems) > 0 {
+			imp.Invalid = append(imp.Invalid, InvalidApp{ID: app.ID, Part: app.Part, Problems: problems})
+			invalid[pn] = true
+			continue
+		}
+		if app.Action == "D" {
+			deleted[pn] = append(deleted[pn], f)
+		} else {
+			added[pn] = append(added[pn], f)
+		}
+	}
+
+	full := doc.SubmissionType != "UPDATE"
+	var numbers []string
+	for pn := range byNumber {
+		if len(added[pn]) > 0 || len(deleted[pn]) > 0 {
+			numbers = append(numbers, pn)
+		}
+	}
+	sort.Strings(numbers)
+	for _, pn := range numbers {
+		p := byNumber[pn]
+		d := PartFitmentDiff{PartID: p.ID, PartNumber: p.PartNumber, Added: []Fitment{}, Removed: []Fitment{}}
+		have := map[string]Fitment{}
+		for _, f := range fitmentsOf(p.AcesVehicles) {
+			have[f.key()] = f
+		}
+		want := map[string]bool{}
+		for _, f := range added[pn] {
+			k := f.key()
+			if want[k] {
+				continue
+			}
+			want[k] = true
+			if _, ok := have[k]; ok {
+				d.Unchanged++
+			} else {
+				d.Added = append(d.Added, f)
+			}
+		}
+		switch {
+		case invalid[pn]:
+		case full:
+			for k, f := range have {
+				if !want[k] {
+					d.Removed = append(d.Removed, f)
+				}
+			}
+		default:
+			for _, f := range deleted[pn] {
+				if cur, ok := have[f.key()]; ok && !want[f.key()] {
+					d.Removed = append(d.Removed, cur)
+					delete(have, f.key())
+				}
+			}
+		}
+		sort.Slice(d.Removed, func(i, j int) bool { return d.Removed[i].key() < d.Removed[j].key() })
+		imp.Parts = append(imp.Parts, d)
+	}
+	return imp
+}
+
+// fitmentOf returns the app's vehicle by name, or why it isn't valid.
+// Notes such as "Trailer Wiring: 7", as generated for configurations the
+// VCDB doesn't know, are read back as configurations; other notes are
+// ignored.
+func (idx *vcdbIndex) fitmentOf(app AcesApp) (Fitment, []string) {
+	var f Fitment
+	var problems []string
+	base, ok := idx.baseVehicleNames[app.BaseVehicle]
+	switch {
+	case app.BaseVehicle == 0:
+		problems = append(problems, "apps must name a BaseVehicle")
+	case !ok:
+		problems = append(problems, fmt.Sprintf("BaseVehicle %d isn't in the VCDB", app.BaseVehicle))
+	default:
+		f.Year, f.Make, f.Model = base.Year, base.Make, base.Model
+	}
+	if app.SubModel != 0 {
+		if f.Submodel, ok = idx.submodelNames[app.SubModel]; !ok {
+			problems = append(problems, fmt.Sprintf("SubModel %d isn't in the VCDB", app.SubModel))
+		}
+	}
+	if app.Part == "" {
+		problems = append(problems, "apps must name a part")
+	}
+	if app.Action != "A" && app.Action != "D" {
+		problems = append(problems, fmt.Sprintf("action %q isn't A or D", app.Action))
+	}
+
+	for _, q := range app.Qualifiers {
+		value, ok := idx.attributeNames[q.Attribute+"|"+strconv.Itoa(q.ID)]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("%s %d isn't in the VCDB", q.Attribute, q.ID))
+			continue
+		}
+		f.Configuration = append(f.Configuration, products.ConfigOption{Key: idx.typeName(q.Attribute), Value: value})
+	}
+	for _, n := range app.Notes {
+		if i := strings.Index(n, ": "); i > 0 {
+			f.Configuration = append(f.Configuration, products.ConfigOption{Key: n[:i], Value: n[i+2:]})
+		}
+	}
+	return f, problems
+}
+
+// typeName returns the configuration type of an ACES vehicle attribute.
+func (idx *vcdbIndex) typeName(element string) string {
+	for _, a := range acesAttributes {
+		if a.Element == element {
+			if name, ok := idx.typeNames[a.Type]; ok {
+				return name
+			}
+		}
+	}
+	return element
+}
+
+// loadAttributeNames reads the values of each vehicle attribute from the
+// VCDB.
+func (idx *vcdbIndex) loadAttributeNames(ctx context.Context) error {
+	idx.attributeNames = make(map[string]string)
+	for _, a := range acesAttributes {
+		rows, err := database.VcdbDB.QueryContext(ctx, "select "+a.IDCol+", "+a.NameCol+" from "+a.Table)
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id int
+			var name string
+			if err = rows.Scan(&id, &name); err != nil {
+				rows.Close()
+				return err
+			}
+			idx.attributeNames[a.Element+"|"+strconv.Itoa(id)] = name
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// key identifies the fitment regardless of case and configuration order.
+func (f Fitment) key() string {
+	opts := make([]string, 0, len(f.Configuration))
+	for _, o := range f.Configuration {
+		opts = append(opts, configType(o.Key)+"="+strings.ToLower(strings.TrimSpace(o.Value)))
+	}
+	sort.Strings(opts)
+	return baseVehicleKey(f.Year, f.Make, f.Model) + "|" + strings.ToLower(strings.TrimSpace(f.Submodel)) + "|" + strings.Join(opts, ",")
+}
+
+// fitmentsOf flattens ACES vehicles to a fitment for each of their
+// configurations.
+func fitmentsOf(vehicles []products.AcesVehicle) []Fitment {
+	var fitments []Fitment
+	for _, v := range vehicles {
+		f := Fitment{Year: v.Base.Year, Make: v.Base.Make, Model: v.Base.Model, Submodel: v.Submodel}
+		if len(v.Attributes) == 0 {
+			fitments = append(fitments, f)
+		}
+		for _, config := range v.Attributes {
+			configured := f
+			configured.Configuration = config.Options
+			fitments = append(fitments, configured)
+		}
+	}
+	return fitments
+}
+
+// applyFitmentDiff returns the vehicles with the diff's fitment removed and
+// added. Vehicles keep their order, and added configurations join the
+// vehicle they're of. Fitment without a configuration, which fits every
+// configuration of its vehicle, is a vehicle of its own, without
+// attributes.
+func applyFitmentDiff(vehicles []products.AcesVehicle, d PartFitmentDiff) []products.AcesVehicle {
+	removed := map[string]bool{}
+	for _, f := range d.Removed {
+		removed[f.key()] = true
+	}
+	kept := make([]Fitment, 0)
+	for _, f := range fitmentsOf(vehicles) {
+		if !removed[f.key()] {
+			kept = append(kept, f)
+		}
+	}
+	kept = append(kept, d.Added...)
+
+	result := make([]products.AcesVehicle, 0)
+	byVehicle := map[string]int{}
+	for _, f := range kept {
+		vk := baseVehicleKey(f.Year, f.Make, f.Model) + "|" + strings.ToLower(strings.TrimSpace(f.Submodel)) + "|" + strconv.FormatBool(len(f.Configuration) > 0)
+		i, ok := byVehicle[vk]
+		if !ok {
+			i = len(result)
+			byVehicle[vk] = i
+			result = append(result, products.AcesVehicle{
+				Base:     products.BaseVehicle{Year: f.Year, Make: f.Make, Model: f.Model},
+				Submodel: f.Submodel,
+			})
+		}
+		if len(f.Configuration) > 0 {
+			result[i].Attributes = append(result[i].Attributes, products.AcesConfiguration{Options: f.Configuration})
+		}
+	}
+	return result
+}
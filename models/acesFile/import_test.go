@@ -0,0 +1,176 @@
+package acesFile
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/curt-labs/API/models/products"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+const testAcesFile = `<?xml version="1.0" encoding="UTF-8"?>
+<ACES version="4.1">
+	<Header>
+		<Company>CURT Manufacturing</Company>
+		<SubmissionType>%s</SubmissionType>
+	</Header>
+	<App action="A" id="1">
+		<BaseVehicle id="5911"/>
+		<SubModel id="20"/>
+		<DriveType id="8"/>
+		<Note>Trailer Wiring: 7</Note>
+		<Qty>1</Qty>
+		<PartType id="5316"/>
+		<Part>13386</Part>
+	</App>
+	<App action="A" id="2">
+		<BaseVehicle id="5912"/>
+		<Qty>1</Qty>
+		<PartType id="5316"/>
+		<Part>13386</Part>
+	</App>
+	<App action="D" id="3">
+		<BaseVehicle id="5911"/>
+		<Note>Drilling: No</Note>
+		<Qty>1</Qty>
+		<PartType id="5316"/>
+		<Part>13386</Part>
+	</App>
+	<App action="A" id="4">
+		<BaseVehicle id="1"/>
+		<SubModel id="2"/>
+		<BodyType id="99"/>
+		<Qty>1</Qty>
+		<PartType id="5316"/>
+		<Part>99999</Part>
+	</App>
+	<Footer><RecordCount>4</RecordCount></Footer>
+</ACES>`
+
+func TestImportAces(t *testing.T) {
+	Convey("Testing ACES imports", t, func() {
+		idx := &vcdbIndex{
+			baseVehicleNames: map[int]products.BaseVehicle{
+				5911: {Year: 2012, Make: "Ford", Model: "F-150"},
+				5912: {Year: 2013, Make: "Ford", Model: "F-150"},
+			},
+			submodelNames:  map[int]string{20: "XLT"},
+			attributeNames: map[string]string{"DriveType|8": "4WD", "BodyType|6": "Pickup"},
+			typeNames:      map[string]string{"drivetype": "Drive Type"},
+		}
+		parts := []products.Part{{
+			ID: 13386, PartNumber: "13386",
+			AcesVehicles: []products.AcesVehicle{
+				{
+					Base:     products.BaseVehicle{Year: 2012, Make: "FORD", Model: "F-150"},
+					Submodel: "XLT",
+					Attributes: []products.AcesConfiguration{
+						{Options: []products.ConfigOption{{Key: "Trailer Wiring", Value: "7"}, {Key: "Drive Type", Value: "4wd"}}},
+					},
+				},
+				{Base: products.BaseVehicle{Year: 2012, Make: "Ford", Model: "F-150"}, Attributes: []products.AcesConfiguration{
+					{Options: []products.ConfigOption{{Key: "Drilling", Value: "No"}}},
+				}},
+				{Base: products.BaseVehicle{Year: 2010, Make: "Ford", Model: "F-150"}},
+			},
+		}}
+
+		Convey("files are parsed with their qualifiers and notes", func() {
+			doc, err := ParseAces(strings.NewReader(strings.Replace(testAcesFile, "%s", "FULL", 1)))
+			So(err, ShouldBeNil)
+			So(doc.Version, ShouldEqual, "4.1")
+			So(doc.SubmissionType, ShouldEqual, "FULL")
+			So(len(doc.Apps), ShouldEqual, 4)
+			So(doc.Apps[0], ShouldResemble, AcesApp{
+				ID: 1, Action: "A", BaseVehicle: 5911, SubModel: 20,
+				Qualifiers: []Qualifier{{Attribute: "DriveType", ID: 8}},
+				Notes:      []string{"Trailer Wiring: 7"},
+				PartType:   5316, Part: "13386",
+			})
+
+			_, err = ParseAces(strings.NewReader("<PIES></PIES>"))
+			So(err, ShouldEqual, ErrNotAcesFile)
+		})
+
+		Convey("invalid apps are reported", func() {
+			doc, _ := ParseAces(strings.NewReader(strings.Replace(testAcesFile, "%s", "UPDATE", 1)))
+			imp := diffAces(doc, idx, parts)
+			So(len(imp.Invalid), ShouldEqual, 1)
+			So(imp.Invalid[0].ID, ShouldEqual, 4)
+			So(imp.Invalid[0].Problems, ShouldResemble, []string{
+				"BaseVehicle 1 isn't in the VCDB",
+				"SubModel 2 isn't in the VCDB",
+				"BodyType 99 isn't in the VCDB",
+				`part "99999" isn't in the catalog`,
+			})
+		})
+
+		Convey("updates add their A apps and remove their D apps", func() {
+			doc, _ := ParseAces(strings.NewReader(strings.Replace(testAcesFile, "%s", "UPDATE", 1)))
+			imp := diffAces(doc, idx, parts)
+			So(len(imp.Parts), ShouldEqual, 1)
+			d := imp.Parts[0]
+			So(d.Unchanged, ShouldEqual, 1)
+			So(d.Added, ShouldResemble, []Fitment{{Year: 2013, Make: "Ford", Model: "F-150"}})
+			So(len(d.Removed), ShouldEqual, 1)
+			So(d.Removed[0].Configuration, ShouldResemble, []products.ConfigOption{{Key: "Drilling", Value: "No"}})
+
+			vehicles := applyFitmentDiff(parts[0].AcesVehicles, d)
+			So(len(vehicles), ShouldEqual, 3)
+			So(vehicles[0].Submodel, ShouldEqual, "XLT")
+			So(vehicles[1].Base.Year, ShouldEqual, 2010)
+			So(vehicles[2].Base.Year, ShouldEqual, 2013)
+			So(vehicles[2].Attributes, ShouldBeEmpty)
+		})
+
+		Convey("full submissions replace the fitment of their parts", func() {
+			doc, _ := ParseAces(strings.NewReader(strings.Replace(testAcesFile, "%s", "FULL", 1)))
+			imp := diffAces(doc, idx, parts)
+			d := imp.Parts[0]
+			So(d.Unchanged, ShouldEqual, 1)
+			So(len(d.Added), ShouldEqual, 1)
+			So(len(d.Removed), ShouldEqual, 2)
+			So(d.Removed[0].Year, ShouldEqual, 2010)
+			So(d.Removed[1].Year, ShouldEqual, 2012)
+		})
+
+		Convey("full submissions remove nothing from parts with invalid apps", func() {
+			file := strings.Replace(testAcesFile, "%s", "FULL", 1)
+			file = strings.Replace(file, "<Part>99999</Part>", "<Part>13386</Part>", 1)
+			doc, _ := ParseAces(strings.NewReader(file))
+			imp := diffAces(doc, idx, parts)
+			So(len(imp.Invalid), ShouldEqual, 1)
+			d := imp.Parts[0]
+			So(len(d.Added), ShouldEqual, 1)
+			So(d.Removed, ShouldBeEmpty)
+		})
+
+		Convey("applied fitment keeps vehicles without a configuration apart from configured ones", func() {
+			file := `<ACES version="4.1">
+				<Header><SubmissionType>FULL</SubmissionType></Header>
+				<App action="A" id="1"><BaseVehicle id="5911"/><PartType id="5316"/><Part>13386</Part></App>
+				<App action="A" id="2"><BaseVehicle id="5911"/><DriveType id="8"/><PartType id="5316"/><Part>13386</Part></App>
+				<App action="A" id="3"><BaseVehicle id="5912"/><PartType id="5316"/><Part>13386</Part></App>
+			</ACES>`
+			doc, err := ParseAces(strings.NewReader(file))
+			So(err, ShouldBeNil)
+			imp := diffAces(doc, idx, parts)
+			So(imp.Invalid, ShouldBeEmpty)
+			vehicles := applyFitmentDiff(parts[0].AcesVehicles, imp.Parts[0])
+			So(vehicles, ShouldResemble, []products.AcesVehicle{
+				{Base: products.BaseVehicle{Year: 2012, Make: "Ford", Model: "F-150"}},
+				{Base: products.BaseVehicle{Year: 2012, Make: "Ford", Model: "F-150"}, Attributes: []products.AcesConfiguration{
+					{Options: []products.ConfigOption{{Key: "Drive Type", Value: "4WD"}}},
+				}},
+				{Base: products.BaseVehicle{Year: 2013, Make: "Ford", Model: "F-150"}},
+			})
+
+			// applying the file again changes nothing
+			applied := []products.Part{{ID: 13386, PartNumber: "13386", AcesVehicles: vehicles}}
+			d := diffAces(doc, idx, applied).Parts[0]
+			So(d.Added, ShouldBeEmpty)
+			So(d.Removed, ShouldBeEmpty)
+			So(d.Unchanged, ShouldEqual, 3)
+		})
+	})
+}
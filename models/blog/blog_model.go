@@ -141,7 +141,7 @@ func GetAll(dtx *apicontext.DataContext) (Blogs, error) {
 	}
 
 	defer res.Close()
-	go redis.Setex(redis_key, bs, 86400)
+	redis.SetexAsync(redis_key, bs, 86400)
 	return bs, err
 }
 
@@ -205,7 +205,7 @@ func GetAllCategories(dtx *apicontext.DataContext) (Categories, error) {
 
 	defer res.Close()
 
-	go redis.Setex(redis_key, cs, 86400)
+	redis.SetexAsync(redis_key, cs, 86400)
 
 	return cs, err
 }
@@ -274,7 +274,7 @@ func (b *Blog) Get(dtx *apicontext.DataContext) error {
 
 	defer res.Close()
 
-	go redis.Setex(redis_key, b, 86400)
+	redis.SetexAsync(redis_key, b, 86400)
 
 	return err
 }
@@ -452,7 +452,7 @@ func (c *Category) Get(dtx *apicontext.DataContext) error {
 	defer stmt.Close()
 	err = stmt.QueryRow(dtx.APIKey, dtx.BrandID, dtx.BrandID, c.ID).Scan(&c.ID, &c.Name, &c.Slug, &c.Active)
 
-	go redis.Setex(redis_key, c, 86400)
+	redis.SetexAsync(redis_key, c, 86400)
 	if err != sql.ErrNoRows {
 		return err
 	}
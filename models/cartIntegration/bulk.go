@@ -0,0 +1,109 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Global prices every active part in the brand at percentage of its
+// priceType price, e.g. 90 for 10% off List, in a single transaction
+// recorded as one batch. Parts without a priceType price are left alone.
+// If any price is refused, such as one below an enforced MAP, the report is
+// returned with ErrInvalidUpload and nothing is written.
+func Global(dtx *apicontext.DataContext, priceType string, percentage float64) (*UploadReport, error) {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	lk, err := loadUploadLookups(dtx)
+	if err != nil {
+		return nil, err
+	}
+	prices, err := GetPartPrices(dtx)
+	if err != nil {
+		return nil, err
+	}
+	bases := make(map[int]float64)
+	for _, p := range prices {
+		if strings.EqualFold(p.Type, priceType) {
+			bases[p.PartID] = p.Price
+		}
+	}
+	return applyAll(dtx, priceAll(custID, lk, bases, priceType, percentage), SourceGlobal)
+}
+
+// ResetToMap sets every price the customer has in the brand to MAP, as
+// Global does.
+func ResetToMap(dtx *apicontext.DataContext) (*UploadReport, error) {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	lk, err := loadUploadLookups(dtx)
+	if err != nil {
+		return nil, err
+	}
+	return applyAll(dtx, priceAll(custID, lk, lk.mapPrices, "MAP", 100), SourceResetToMap)
+}
+
+func applyAll(dtx *apicontext.DataContext, report *UploadReport, source string) (*UploadReport, error) {
+	var err error
+	report.BatchID, err = newBatchID()
+	if err != nil {
+		return nil, err
+	}
+	return report, applyReport(dtx, report, source)
+}
+
+// priceAll prices every part in lk at percentage of its base price, diffed
+// against the customer's regular prices. Sales and cart integrations are
+// left as they are.
+func priceAll(custID int, lk uploadLookups, bases map[int]float64, priceType string, percentage float64) *UploadReport {
+	partNumbers := make([]string, 0, len(lk.parts))
+	for pn := range lk.parts {
+		partNumbers = append(partNumbers, pn)
+	}
+	sort.Strings(partNumbers)
+
+	report := &UploadReport{}
+	for _, pn := range partNumbers {
+		id := lk.parts[pn]
+		row := UploadRow{
+			Line:            len(report.Rows) + 1,
+			PartNumber:      pn,
+			keepIntegration: true,
+		}
+		base, ok := bases[id]
+		if !ok || base <= 0 {
+			row.Action = ActionUnchanged
+			row.Warnings = append(row.Warnings, fmt.Sprintf("no %s price; left unchanged", priceType))
+			report.add(row)
+			continue
+		}
+
+		price := float64(cents(base*percentage/100)) / 100
+		row.Price = &CustomerPrice{CustID: custID, PartID: id, PartNumber: pn, Price: price}
+		row.diff(lk)
+		if len(row.Errors) > 0 {
+			row.Price = nil
+			row.Previous = nil
+			row.Action = ""
+		}
+		report.add(row)
+	}
+	return report
+}
+
+// Prices returns the price every row of the report sets or keeps.
+func (u *UploadReport) Prices() []CustomerPrice {
+	prices := []CustomerPrice{}
+	for _, row := range u.Rows {
+		if row.Price != nil {
+			prices = append(prices, *row.Price)
+		}
+	}
+	return prices
+}
@@ -1,24 +1,36 @@
 package cartIntegration
 
 import (
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/models/currency"
 	_ "github.com/go-sql-driver/mysql"
 
 	"database/sql"
 	. "github.com/smartystreets/goconvey/convey"
 
+	"context"
 	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
 	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestCartIntegration(t *testing.T) {
 	var err error
-	Brand_ID = 1
-	Customer_ID = 1
-
 	key, _ := getCustomerKey()
+	dtx := &apicontext.DataContext{APIKey: key, BrandID: 1, CustomerID: 1}
 
 	Convey("Testing CustomerPrices", t, func() {
 		cp := CustomerPrice{
@@ -28,38 +40,38 @@ func TestCartIntegration(t *testing.T) {
 			IsSale: 0,
 		}
 
-		err = cp.Create()
+		err = cp.Create(dtx)
 		So(err, ShouldBeNil)
 
 		cp.CustomerPartID = 1
-		err = cp.Update()
+		err = cp.Update(dtx)
 		So(err, ShouldBeNil)
 
-		custprices, err := GetCustomerPrices()
+		resp, err := GetCustomerPrices(dtx, 0, 0)
 		So(err, ShouldBeNil)
-		So(len(custprices), ShouldBeGreaterThan, 0)
+		So(len(resp.Items), ShouldBeGreaterThan, 0)
 
-		custprices, err = GetPricingPaged(1, 1)
+		custprices, err := GetPricingPaged(dtx, 1, 1)
 		So(err, ShouldBeNil)
 		So(len(custprices), ShouldBeGreaterThan, 0)
 
-		count, err := GetPricingCount()
+		count, err := GetPricingCount(dtx)
 		So(err, ShouldBeNil)
 		So(count, ShouldBeGreaterThan, 0)
 
-		prices, err := GetPartPrices()
+		prices, err := GetPartPrices(dtx)
 		So(err, ShouldBeNil)
 		So(len(prices), ShouldBeGreaterThan, 0)
 
-		prices, err = GetPartPricesByPartID(cp.PartID)
+		prices, err = GetPartPricesByPartID(dtx, "11000")
 		So(err, ShouldBeNil)
 		So(len(prices), ShouldBeGreaterThanOrEqualTo, 1)
 
-		prices, err = GetMAPPartPrices()
+		prices, err = GetMAPPartPrices(dtx)
 		So(err, ShouldBeNil)
 		So(len(prices), ShouldBeGreaterThanOrEqualTo, 1)
 
-		err = cp.Delete()
+		err = cp.Delete(dtx)
 		So(err, ShouldBeNil)
 	})
 
@@ -72,22 +84,22 @@ func TestCartIntegration(t *testing.T) {
 			IsSale:         0,
 		}
 
-		err = cp.InsertCartIntegration()
+		err = cp.InsertCartIntegration(dtx)
 		So(err, ShouldBeNil)
 
-		err = cp.UpdateCartIntegration()
+		err = cp.UpdateCartIntegration(dtx)
 		So(err, ShouldBeNil)
 
-		custprices, err := GetCustomerCartIntegrations(key)
+		custprices, err := GetCustomerCartIntegrations(dtx)
 		So(err, ShouldBeNil)
 		So(len(custprices), ShouldBeGreaterThanOrEqualTo, 0)
 
-		err = cp.DeleteCartIntegration()
+		err = cp.DeleteCartIntegration(dtx)
 		So(err, ShouldBeNil)
 	})
 
 	Convey("Testing PriceTypes", t, func() {
-		types, err := GetAllPriceTypes()
+		types, err := GetAllPriceTypes(context.Background())
 		So(err, ShouldBeNil)
 		So(len(types), ShouldBeGreaterThanOrEqualTo, 1)
 	})
@@ -97,8 +109,11 @@ func TestCartIntegration(t *testing.T) {
 		So(err, ShouldBeNil)
 		t.Log(file.Read(nil))
 
-		err = UploadFile(file, key)
+		lines, err := ReadCSV(file)
 		So(err, ShouldBeNil)
+		report, err := UploadFile(dtx, lines)
+		So(err, ShouldBeNil)
+		So(report.Applied, ShouldBeTrue)
 
 		os.Remove("test.csv") //cleanup
 
@@ -106,6 +121,746 @@ func TestCartIntegration(t *testing.T) {
 
 }
 
+func TestTenants(t *testing.T) {
+	Convey("Testing tenant", t, func() {
+		_, _, err := tenant(nil)
+		So(err, ShouldEqual, ErrNoCustomer)
+
+		_, _, err = tenant(&apicontext.DataContext{BrandID: 1})
+		So(err, ShouldEqual, ErrNoCustomer)
+
+		_, _, err = tenant(&apicontext.DataContext{CustomerID: 1, BrandArray: []int{1, 3}})
+		So(err, ShouldEqual, ErrNoBrand)
+
+		cust, brand, err := tenant(&apicontext.DataContext{CustomerID: 7, BrandArray: []int{3}})
+		So(err, ShouldBeNil)
+		So(cust, ShouldEqual, 7)
+		So(brand, ShouldEqual, 3)
+	})
+
+	Convey("Testing many customers in parallel", t, func() {
+		now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+
+		var wg sync.WaitGroup
+		errs := make(chan error, 100)
+		for cust := 1; cust <= 100; cust++ {
+			wg.Add(1)
+			go func(cust int) {
+				defer wg.Done()
+				dtx := &apicontext.DataContext{CustomerID: cust, BrandID: cust%3 + 1}
+				custID, _, err := tenant(dtx)
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				// each customer already has a price for 11000 only
+				lk := testLookups()
+				lk.mapPrices = nil
+				lk.prices[1] = CustomerPrice{ID: cust*10 + 1, CustID: cust, PartID: 1, Price: 1000}
+
+				lines := [][]string{
+					{"11000", strconv.Itoa(cust), strconv.Itoa(cust) + ".00"},
+					{"11001", strconv.Itoa(cust), "$" + strconv.Itoa(cust) + ".50", "2026-10-19", "2026-11-19"},
+					{"11000", "0", "0"},
+				}
+				report := validateUpload(custID, lines, lk, now)
+				if !report.Valid() || report.Summary.Updated != 1 || report.Summary.Created != 1 {
+					errs <- fmt.Errorf("customer %d: got %+v", cust, report.Summary)
+					return
+				}
+				for _, row := range report.Rows[:2] {
+					cp := row.Price
+					if cp.CustID != cust || cp.CustomerPartID != cust || int(cp.Price) != cust {
+						errs <- fmt.Errorf("customer %d: got %+v", cust, cp)
+					}
+				}
+				if id := report.Rows[0].Price.ID; id != cust*10+1 {
+					errs <- fmt.Errorf("customer %d: updating price %d", cust, id)
+				}
+			}(cust)
+		}
+		wg.Wait()
+		close(errs)
+
+		var failures []error
+		for err := range errs {
+			failures = append(failures, err)
+		}
+		So(failures, ShouldBeEmpty)
+	})
+}
+
+func TestUploadValidation(t *testing.T) {
+	Convey("Testing upload validation", t, func() {
+		now := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+		validate := func(lines ...[]string) *UploadReport {
+			lk := testLookups()
+			lk.prices[1] = CustomerPrice{ID: 10, CustID: 1, PartID: 1, Price: 120}
+			lk.integrations[1] = 501
+			return validateUpload(1, lines, lk, now)
+		}
+
+		Convey("a header row is skipped", func() {
+			report := validate(
+				[]string{"CURT Part Number", "Customer Part ID", "Sale Price", "Sale Start Date", "Sale End Date", "Map Price", "List Price"},
+				[]string{"11001", "502", "110.00", "", "", "100.00", "150.00"},
+			)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Rows, ShouldHaveLength, 1)
+			So(report.Rows[0].Line, ShouldEqual, 2)
+			So(report.Rows[0].Action, ShouldEqual, ActionCreate)
+		})
+
+		Convey("every row is checked and the diff is reported", func() {
+			report := validate(
+				[]string{"11000", "501", "120.00"},
+				[]string{"11000", "501", "125.00"},
+				[]string{"11001", "", "99.99"},
+				[]string{"11002", "abc", "1,200.00"},
+				[]string{"99999", "1", "10"},
+				[]string{"11003", "1", "ten"},
+			)
+			So(report.Valid(), ShouldBeFalse)
+			So(report.Summary, ShouldResemble, UploadSummary{Rows: 6, Unchanged: 1, Errors: 4, Warnings: 1})
+
+			So(report.Rows[0].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[1].Warnings, ShouldResemble, []string{"duplicate of line 1; ignored"})
+			So(report.Rows[2].Errors, ShouldResemble, []string{"price 99.99 is below the MAP price of 100.00"})
+			So(report.Rows[3].Errors, ShouldResemble, []string{`customer part ID "abc" is not a whole number`})
+			So(report.Rows[4].Errors, ShouldResemble, []string{"99999 is not an active part number for this brand"})
+			So(report.Rows[5].Errors, ShouldResemble, []string{`price "ten" is not a number`})
+			for _, row := range report.Rows[2:] {
+				So(row.Price, ShouldBeNil)
+				So(row.Action, ShouldEqual, "")
+			}
+		})
+
+		Convey("updates carry the previous price", func() {
+			report := validate([]string{"11000", "777", "$130"})
+			So(report.Valid(), ShouldBeTrue)
+			row := report.Rows[0]
+			So(row.Action, ShouldEqual, ActionUpdate)
+			So(row.Price.ID, ShouldEqual, 10)
+			So(row.Price.Price, ShouldEqual, 130)
+			So(row.Previous.Price, ShouldEqual, 120)
+			So(row.Previous.CustomerPartID, ShouldEqual, 501)
+			So(row.integrationExists, ShouldBeTrue)
+			So(row.integrationChanged, ShouldBeTrue)
+		})
+
+		Convey("sales are prices of their own, told apart by their start date", func() {
+			start := time.Date(2026, time.November, 1, 0, 0, 0, 0, time.UTC)
+			end := time.Date(2026, time.November, 30, 0, 0, 0, 0, time.UTC)
+			lk := testLookups()
+			lk.prices[1] = CustomerPrice{ID: 10, CustID: 1, PartID: 1, Price: 120}
+			lk.sales[1] = []CustomerPrice{{ID: 11, CustID: 1, PartID: 1, Price: 105, IsSale: 1, SaleStart: &start, SaleEnd: &end}}
+
+			report := validateUpload(1, [][]string{
+				{"11000", "", "120"},
+				{"11000", "", "105", "2026-11-01", "2026-11-30"},
+				{"11000", "", "101", "2026-11-01", "2026-11-15"},
+				{"11000", "", "102", "2026-12-01", "2026-12-31"},
+			}, lk, now)
+			So(report.Rows[0].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[1].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[1].Price.ID, ShouldEqual, 11)
+			So(report.Rows[2].Warnings, ShouldResemble, []string{"duplicate of line 2; ignored"})
+			So(report.Rows[3].Action, ShouldEqual, ActionCreate)
+			So(report.Rows[3].Price.IsSale, ShouldEqual, 1)
+
+			report = validateUpload(1, [][]string{{"11000", "", "101", "2026-11-01", "2026-11-15"}}, lk, now)
+			So(report.Rows[0].Action, ShouldEqual, ActionUpdate)
+			So(report.Rows[0].Price.ID, ShouldEqual, 11)
+			So(report.Rows[0].Previous.Price, ShouldEqual, 105)
+
+			// a regular price leaves the sales alone
+			report = validateUpload(1, [][]string{{"11000", "", "130"}}, lk, now)
+			So(report.Rows[0].Action, ShouldEqual, ActionUpdate)
+			So(report.Rows[0].Price.ID, ShouldEqual, 10)
+			So(report.Rows[0].Price.IsSale, ShouldEqual, 0)
+		})
+
+		Convey("sale dates must be complete and in order", func() {
+			report := validate(
+				[]string{"11001", "1", "110", "2026-11-01", "2026-12-01"},
+				[]string{"11002", "1", "110", "2026-12-01", "2026-11-01"},
+				[]string{"11003", "1", "110", "2026-11-01", ""},
+				[]string{"11004", "1", "110", "11/01/2026", "2026-12-01"},
+				[]string{"11005", "1", "0", "2026-01-01", "2026-02-01"},
+			)
+			So(report.Rows[0].Errors, ShouldBeEmpty)
+			So(report.Rows[0].Price.IsSale, ShouldEqual, 1)
+			So(report.Rows[1].Errors, ShouldResemble, []string{"sale start date is after the sale end date"})
+			So(report.Rows[2].Errors, ShouldResemble, []string{"a sale needs both a start and an end date"})
+			So(report.Rows[3].Errors, ShouldResemble, []string{`sale start date "11/01/2026" is not in the format 2006-01-02`})
+			So(report.Rows[4].Errors, ShouldBeEmpty)
+			So(report.Rows[4].Warnings, ShouldResemble, []string{"price is zero", "sale has already ended"})
+		})
+
+		Convey("columns are mapped by the header row", func() {
+			report := validate(
+				[]string{"Category", "Sale Price", "Description", "Part Number", "Map Price"},
+				[]string{"Hitches", "130", "Class III", "11000", "100.00"},
+				[]string{"Hitches", "", "Class III", "11001", "100.00"},
+				[]string{"", "", "", "", ""},
+			)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Rows, ShouldHaveLength, 2)
+
+			// no customer part ID column: the existing one is kept
+			row := report.Rows[0]
+			So(row.Action, ShouldEqual, ActionUpdate)
+			So(row.Price.CustomerPartID, ShouldEqual, 501)
+			So(row.integrationChanged, ShouldBeFalse)
+
+			// a blank price leaves the part alone
+			So(report.Rows[1].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[1].Price, ShouldBeNil)
+		})
+
+		Convey("a header without a part number or price is rejected", func() {
+			report := validate([]string{"Part Number", "Customer Part ID"}, []string{"11000", "501"})
+			So(report.Valid(), ShouldBeFalse)
+			So(report.Errors, ShouldResemble, []string{"the header row needs a part number and a price column"})
+		})
+
+		Convey("prices already below MAP can be re-uploaded unchanged", func() {
+			lk := testLookups()
+			lk.prices[1] = CustomerPrice{ID: 10, CustID: 1, PartID: 1, Price: 90}
+			report := validateUpload(1, [][]string{
+				ExportHeader,
+				{"11000", "", "90.00", "", "", "100.00", "150.00", "Class III", "Hitches"},
+				{"11001", "", "", "", "", "100.00", "150.00", "Class III", "Hitches"},
+			}, lk, now)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Summary, ShouldResemble, UploadSummary{Rows: 2, Unchanged: 2})
+
+			report = validateUpload(1, [][]string{{"11000", "", "95.00"}}, lk, now)
+			So(report.Rows[0].Errors, ShouldResemble, []string{"price 95.00 is below the MAP price of 100.00"})
+		})
+
+		Convey("invalid uploads are never applied", func() {
+			report := validate([]string{"99999", "1", "10"})
+			So(ApplyUpload(&apicontext.DataContext{CustomerID: 1, BrandID: 1}, report), ShouldEqual, ErrInvalidUpload)
+			So(report.Applied, ShouldBeFalse)
+		})
+	})
+}
+
+func TestSheets(t *testing.T) {
+	Convey("Testing sheets", t, func() {
+		cols, ok := uploadColumns(ExportHeader)
+		So(ok, ShouldBeTrue)
+		So(cols, ShouldResemble, positional)
+
+		cols, ok = uploadColumns([]string{" sale_END date ", "PRICE", "curt part #"})
+		So(ok, ShouldBeTrue)
+		So(cols, ShouldResemble, columns{part: -1, custPart: -1, price: 1, saleStart: -1, saleEnd: 0})
+
+		_, ok = uploadColumns([]string{"11000", "501", "120.00"})
+		So(ok, ShouldBeFalse)
+
+		So(isDateFormat("yyyy-mm-dd"), ShouldBeTrue)
+		So(isDateFormat("[$-409]d-mmm-yy;@"), ShouldBeTrue)
+		So(isDateFormat(`0.00" days"`), ShouldBeFalse)
+		So(isDateFormat("[Red]0.00"), ShouldBeFalse)
+		So(isDateFormat("General"), ShouldBeFalse)
+
+		lines, err := ReadCSV(strings.NewReader("CURT Part Number, Sale Price\n11000,\"1,200.00\"\n\n11001,5,extra\n"))
+		So(err, ShouldBeNil)
+		So(lines, ShouldResemble, [][]string{{"CURT Part Number", "Sale Price"}, {"11000", "1,200.00"}, {"11001", "5", "extra"}})
+	})
+}
+
+func TestSales(t *testing.T) {
+	Convey("Testing sales", t, func() {
+		day := func(d int) *time.Time {
+			t := time.Date(2026, time.October, d, 0, 0, 0, 0, time.UTC)
+			return &t
+		}
+		now := time.Date(2026, time.October, 19, 15, 0, 0, 0, time.UTC)
+		regular := CustomerPrice{ID: 1, Price: 100}
+		ended := CustomerPrice{ID: 2, Price: 80, IsSale: 1, SaleStart: day(1), SaleEnd: day(18)}
+		endsToday := CustomerPrice{ID: 3, Price: 90, IsSale: 1, SaleStart: day(10), SaleEnd: day(19)}
+		startsToday := CustomerPrice{ID: 4, Price: 85, IsSale: 1, SaleStart: day(19), SaleEnd: day(25)}
+		upcoming := CustomerPrice{ID: 5, Price: 70, IsSale: 1, SaleStart: day(20), SaleEnd: day(25)}
+
+		Convey("sales run through the whole of their end date", func() {
+			So(endsToday.SaleActive(now), ShouldBeTrue)
+			So(endsToday.SaleActive(day(20).Add(-time.Nanosecond)), ShouldBeTrue)
+			So(endsToday.SaleActive(*day(20)), ShouldBeFalse)
+			So(startsToday.SaleActive(now), ShouldBeTrue)
+			So(startsToday.SaleActive(day(19).Add(-time.Nanosecond)), ShouldBeFalse)
+			So(regular.SaleActive(now), ShouldBeFalse)
+
+			end := time.Date(2026, time.October, 19, 12, 0, 0, 0, time.UTC)
+			timed := CustomerPrice{IsSale: 1, SaleStart: day(1), SaleEnd: &end}
+			So(timed.SaleActive(now), ShouldBeFalse)
+		})
+
+		Convey("sale days are whole days in the dates' own time zone", func() {
+			chicago, err := time.LoadLocation("America/Chicago")
+			So(err, ShouldBeNil)
+			start := time.Date(2026, time.October, 10, 0, 0, 0, 0, chicago)
+			end := time.Date(2026, time.October, 19, 0, 0, 0, 0, chicago)
+			sale := CustomerPrice{IsSale: 1, SaleStart: &start, SaleEnd: &end}
+
+			So(sale.SaleActive(time.Date(2026, time.October, 19, 12, 0, 0, 0, chicago)), ShouldBeTrue)
+			So(sale.SaleActive(time.Date(2026, time.October, 19, 23, 59, 0, 0, chicago)), ShouldBeTrue)
+			So(sale.SaleActive(time.Date(2026, time.October, 20, 0, 0, 0, 0, chicago)), ShouldBeFalse)
+			So(saleEnds(end), ShouldResemble, time.Date(2026, time.October, 20, 0, 0, 0, 0, chicago))
+
+			now := time.Date(2026, time.October, 20, 3, 0, 0, 0, time.UTC)
+			So(startOfDay(now.In(chicago)).Equal(time.Date(2026, time.October, 19, 5, 0, 0, 0, time.UTC)), ShouldBeTrue)
+		})
+
+		Convey("the lowest active sale is in effect, or else the regular price", func() {
+			cp, ok := EffectivePrice([]CustomerPrice{regular, ended, endsToday, startsToday, upcoming}, now)
+			So(ok, ShouldBeTrue)
+			So(cp.ID, ShouldEqual, 4)
+
+			cp, ok = EffectivePrice([]CustomerPrice{ended, regular, upcoming}, now)
+			So(ok, ShouldBeTrue)
+			So(cp.ID, ShouldEqual, 1)
+
+			_, ok = EffectivePrice([]CustomerPrice{ended, upcoming}, now)
+			So(ok, ShouldBeFalse)
+			_, ok = EffectivePrice(nil, now)
+			So(ok, ShouldBeFalse)
+
+			// a sale flag without dates is never in effect
+			cp, ok = EffectivePrice([]CustomerPrice{{ID: 6, Price: 1, IsSale: 1}, regular}, now)
+			So(cp.ID, ShouldEqual, 1)
+		})
+
+		Convey("sales are split into active and upcoming", func() {
+			sales := splitSales([]CustomerPrice{upcoming, regular, ended, startsToday, endsToday}, now)
+			So(sales.Active, ShouldResemble, []CustomerPrice{endsToday, startsToday})
+			So(sales.Upcoming, ShouldResemble, []CustomerPrice{upcoming})
+
+			sales = splitSales(nil, now)
+			So(sales.Active, ShouldNotBeNil)
+			So(sales.Upcoming, ShouldNotBeNil)
+		})
+	})
+}
+
+func TestMAPPolicy(t *testing.T) {
+	Convey("Testing MAP policy", t, func() {
+		Convey("only prices below MAP are violations", func() {
+			So(checkMAP(100, 100, true, nil), ShouldBeNil)
+			So(checkMAP(99.999, 100, true, nil), ShouldBeNil)
+			So(checkMAP(50, 0, true, nil), ShouldBeNil)
+
+			v := checkMAP(99.99, 100, true, nil)
+			So(v.Error(), ShouldEqual, "price 99.99 is below the MAP price of 100.00")
+			So(v.Allowed(), ShouldBeFalse)
+			So(checkMAP(99.99, 100, false, nil).Allowed(), ShouldBeTrue)
+		})
+
+		Convey("overrides allow prices down to their own", func() {
+			o := &MAPOverride{Price: 90}
+			So(checkMAP(90, 100, true, o).Allowed(), ShouldBeTrue)
+			So(checkMAP(89.99, 100, true, o).Allowed(), ShouldBeFalse)
+		})
+
+		Convey("uploads refuse enforced MAP and flag the rest", func() {
+			lk := testLookups()
+			lk.overrides[2] = MAPOverride{ID: 7, CustID: 1, PartID: 2, Price: 80}
+			report := validateUpload(1, [][]string{
+				{"11000", "", "95"},
+				{"11001", "", "85"},
+				{"11001", "", "75"},
+				{"11002", "", "75"},
+				{"11003", "", "75"},
+			}, lk, time.Now())
+			So(report.Rows[0].Errors, ShouldResemble, []string{"price 95.00 is below the MAP price of 100.00"})
+			So(report.Rows[1].Errors, ShouldBeEmpty)
+			So(report.Rows[1].Warnings, ShouldResemble, []string{"price 85.00 is below the MAP price of 100.00, allowed by a MAP override"})
+			So(report.Rows[2].Warnings, ShouldResemble, []string{"duplicate of line 2; ignored"})
+			So(report.Rows[3].Errors, ShouldBeEmpty)
+			So(report.Rows[3].Warnings, ShouldBeEmpty)
+			So(report.Rows[4].Errors, ShouldBeEmpty)
+			So(report.Rows[4].Warnings, ShouldResemble, []string{"price 75.00 is below the MAP price of 100.00, which isn't enforced"})
+
+			report = validateUpload(1, [][]string{{"11001", "", "79.99"}}, lk, time.Now())
+			So(report.Rows[0].Errors, ShouldResemble, []string{"price 79.99 is below the MAP price of 100.00"})
+		})
+
+		Convey("the report counts the violations that are refused", func() {
+			var report MAPReport
+			report.add(MAPViolation{Price: 90, MAP: 100, Enforced: true})
+			report.add(MAPViolation{Price: 90, MAP: 100, Enforced: true, Override: &MAPOverride{Price: 85}})
+			report.add(MAPViolation{Price: 90, MAP: 100})
+			So(report.Total, ShouldEqual, 3)
+			So(report.Refused, ShouldEqual, 1)
+		})
+
+		Convey("overrides need a customer, part and reason", func() {
+			So((&MAPOverride{CustID: 1, PartID: 1, Reason: "clearance"}).Validate(), ShouldBeNil)
+			So((&MAPOverride{PartID: 1, Reason: "clearance"}).Validate(), ShouldNotBeNil)
+			So((&MAPOverride{CustID: 1, Reason: "clearance"}).Validate(), ShouldNotBeNil)
+			So((&MAPOverride{CustID: 1, PartID: 1}).Validate(), ShouldNotBeNil)
+			past := time.Now().Add(-time.Hour)
+			So((&MAPOverride{CustID: 1, PartID: 1, Reason: "clearance", Expires: &past}).Validate(), ShouldNotBeNil)
+		})
+	})
+}
+
+func TestPricingRules(t *testing.T) {
+	Convey("Testing pricing rules", t, func() {
+		// 11000 is an ARIES running board, 11001 and 11002 are CURT class
+		// III hitches and 11003 is a CURT hitch with no class
+		parents := map[int]int{10: 0, 11: 10, 20: 0}
+		subjects := []ruleSubject{
+			{partID: 1, partNumber: "11000", brandID: 3, categories: []int{20}, prices: map[string]float64{"map": 100, "list": 150}},
+			{partID: 2, partNumber: "11001", brandID: 1, categories: []int{11}, class: "Class III", priceCode: "A", prices: map[string]float64{"map": 100, "list": 120}},
+			{partID: 3, partNumber: "11002", brandID: 1, categories: []int{11}, class: "Class III", priceCode: "B", prices: map[string]float64{"list": 45.30}},
+			{partID: 4, partNumber: "11003", brandID: 1, categories: []int{10}, prices: map[string]float64{"list": 20}},
+		}
+		rules := []PricingRule{
+			{ID: 3, Priority: 30, Base: "List", Percent: -10, CategoryID: 10, Class: "class iii", FloorMAP: true, Rounding: ".99"},
+			{ID: 1, Priority: 10, Base: "Map", BrandID: 3},
+			{ID: 2, Priority: 20, Base: "Jobber", PriceCode: "B"},
+		}
+		evaluate := func(rules ...PricingRule) *UploadReport {
+			lk := testLookups()
+			lk.prices[1] = CustomerPrice{ID: 10, CustID: 1, PartID: 1, Price: 100}
+			return evaluateRules(1, rules, subjects, parents, lk)
+		}
+
+		Convey("the first matching rule by priority prices the part", func() {
+			report := evaluate(rules...)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Summary, ShouldResemble, UploadSummary{Rows: 3, Created: 1, Unchanged: 2, Warnings: 1})
+
+			So(report.Rows[0].PartNumber, ShouldEqual, "11000")
+			So(report.Rows[0].RuleID, ShouldEqual, 1)
+			So(report.Rows[0].Action, ShouldEqual, ActionUnchanged)
+
+			// List -10% is 108.00, rounded down to 107.99
+			So(report.Rows[1].RuleID, ShouldEqual, 3)
+			So(report.Rows[1].Action, ShouldEqual, ActionCreate)
+			So(report.Rows[1].Price.Price, ShouldEqual, 107.99)
+
+			// matched by price code before the class rule, with no jobber price
+			So(report.Rows[2].RuleID, ShouldEqual, 2)
+			So(report.Rows[2].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[2].Price, ShouldBeNil)
+			So(report.Rows[2].Warnings, ShouldResemble, []string{"no Jobber price; left unchanged"})
+		})
+
+		Convey("never below MAP rounds up to the next ending", func() {
+			rule := PricingRule{ID: 4, Base: "List", Percent: -50, Rounding: ".99", FloorMAP: true}
+			report := evaluate(rule)
+			So(report.Rows[1].Price.Price, ShouldEqual, 100.99)
+			So(report.Rows[2].Price.Price, ShouldEqual, 21.99)
+
+			rule.Rounding = ""
+			report = evaluate(rule)
+			So(report.Rows[1].Price.Price, ShouldEqual, 100)
+			So(report.Rows[2].Price.Price, ShouldEqual, 22.65)
+		})
+
+		Convey("prices below MAP are errors without the floor", func() {
+			report := evaluate(PricingRule{ID: 4, Base: "List", Percent: -50, BrandID: 1, Class: "Class III"})
+			So(report.Valid(), ShouldBeFalse)
+			So(report.Rows[0].Errors, ShouldResemble, []string{"price 60.00 is below the MAP price of 100.00"})
+			So(report.Rows[1].Action, ShouldEqual, ActionCreate)
+			So(ApplyUpload(&apicontext.DataContext{CustomerID: 1, BrandID: 1}, report), ShouldEqual, ErrInvalidUpload)
+		})
+
+		Convey("amounts are added after the percentage", func() {
+			report := evaluate(PricingRule{ID: 5, Base: "List", Percent: 10, Amount: -2.5, CategoryID: 10})
+			So(report.Rows, ShouldHaveLength, 3)
+			So(report.Rows[2].Price.Price, ShouldEqual, 19.5)
+
+			report = evaluate(PricingRule{ID: 6, Base: "List", Amount: -50, PriceCode: "b"})
+			So(report.Rows[0].Errors, ShouldResemble, []string{"rule 6 prices the part at -4.70"})
+		})
+
+		Convey("rules are validated", func() {
+			rule := PricingRule{Base: "list", Rounding: ".95"}
+			So(rule.Validate(), ShouldBeNil)
+			So(rule.Base, ShouldEqual, "List")
+
+			So((&PricingRule{Base: "Retail"}).Validate(), ShouldNotBeNil)
+			So((&PricingRule{Base: "Map", Percent: -100}).Validate(), ShouldNotBeNil)
+			So((&PricingRule{Base: "Map", Priority: -1}).Validate(), ShouldNotBeNil)
+			So((&PricingRule{Base: "Map", Rounding: "99"}).Validate(), ShouldNotBeNil)
+			So((&PricingRule{Base: "Map", Rounding: ".9"}).Validate(), ShouldNotBeNil)
+		})
+
+		Convey("categories match their descendants", func() {
+			So(inCategory(11, 10, parents), ShouldBeTrue)
+			So(inCategory(10, 11, parents), ShouldBeFalse)
+			So(inCategory(1, 2, map[int]int{1: 2, 2: 1}), ShouldBeTrue)
+			So(inCategory(1, 3, map[int]int{1: 2, 2: 1}), ShouldBeFalse)
+		})
+	})
+}
+
+func TestPriceHistory(t *testing.T) {
+	Convey("Testing price history", t, func() {
+		Convey("global prices every part from its base price", func() {
+			lk := testLookups()
+			lk.prices[1] = CustomerPrice{ID: 10, CustID: 1, PartID: 1, Price: 100}
+			lk.prices[3] = CustomerPrice{ID: 11, CustID: 1, PartID: 3, Price: 30}
+			bases := map[int]float64{1: 150, 2: 120, 3: 33.33, 4: 20}
+
+			report := priceAll(1, lk, bases, "List", 90)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Summary, ShouldResemble, UploadSummary{Rows: 6, Created: 2, Updated: 1, Unchanged: 3, Warnings: 3})
+			So(report.Rows[0].Action, ShouldEqual, ActionUpdate)
+			So(report.Rows[0].Price.Price, ShouldEqual, 135)
+			So(report.Rows[0].Previous.Price, ShouldEqual, 100)
+			So(report.Rows[1].Price.Price, ShouldEqual, 108)
+			// 29.997 rounds to the current 30.00
+			So(report.Rows[2].Action, ShouldEqual, ActionUnchanged)
+			So(report.Rows[3].Warnings, ShouldResemble, []string{"price 18.00 is below the MAP price of 100.00, which isn't enforced"})
+			So(report.Rows[4].Warnings, ShouldResemble, []string{"no List price; left unchanged"})
+			So(report.Prices(), ShouldHaveLength, 4)
+
+			report = priceAll(1, lk, bases, "List", 50)
+			So(report.Valid(), ShouldBeFalse)
+			So(report.Rows[0].Errors, ShouldResemble, []string{"price 75.00 is below the MAP price of 100.00"})
+			So(report.Rows[0].Price, ShouldBeNil)
+		})
+
+		Convey("reset to MAP leaves parts without MAP alone", func() {
+			lk := testLookups()
+			report := priceAll(1, lk, lk.mapPrices, "MAP", 100)
+			So(report.Valid(), ShouldBeTrue)
+			So(report.Summary.Created, ShouldEqual, 3)
+			So(report.Rows[2].Warnings, ShouldResemble, []string{"no MAP price; left unchanged"})
+		})
+
+		Convey("changes are current until the price changes again", func() {
+			start := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+			change := PriceChange{Action: ActionUpdate, Old: &PriceValue{Price: 10}, New: &PriceValue{Price: 12, IsSale: 1, SaleStart: &start}}
+			current := &CustomerPrice{Price: 12.001, IsSale: 1, SaleStart: &start}
+			So(change.isCurrent(current), ShouldBeTrue)
+			current.Price = 11
+			So(change.isCurrent(current), ShouldBeFalse)
+			So(change.isCurrent(nil), ShouldBeFalse)
+
+			deleted := PriceChange{Action: ActionDelete, Old: &PriceValue{Price: 10}}
+			So(deleted.isCurrent(nil), ShouldBeTrue)
+			So(deleted.isCurrent(&CustomerPrice{Price: 10}), ShouldBeFalse)
+		})
+
+		Convey("a price written more than once is reverted to where the batch found it", func() {
+			changes := []PriceChange{
+				{ID: 4, CustPriceID: 11, Action: ActionUpdate, Old: &PriceValue{Price: 12}, New: &PriceValue{Price: 13}},
+				{ID: 3, CustPriceID: 12, Action: ActionCreate, New: &PriceValue{Price: 5}},
+				{ID: 2, CustPriceID: 11, Action: ActionUpdate, Old: &PriceValue{Price: 11}, New: &PriceValue{Price: 12}},
+				{ID: 1, CustPriceID: 11, Action: ActionUpdate, Old: &PriceValue{Price: 10}, New: &PriceValue{Price: 11}},
+			}
+			collapsed := collapse(changes)
+			So(collapsed, ShouldHaveLength, 2)
+			So(collapsed[0].ID, ShouldEqual, 4)
+			So(collapsed[0].Old, ShouldResemble, &PriceValue{Price: 10})
+			So(collapsed[0].New, ShouldResemble, &PriceValue{Price: 13})
+			So(collapsed[1].ID, ShouldEqual, 3)
+			So(collapsed[1].Old, ShouldBeNil)
+			So(changes[0].Old, ShouldResemble, &PriceValue{Price: 12})
+		})
+	})
+}
+
+func TestWebhooks(t *testing.T) {
+	Convey("Testing webhooks", t, func() {
+		secret := "0123456789abcdef"
+		sent := time.Unix(1600000000, 0)
+		body, _ := json.Marshal(WebhookPayload{DeliveryID: "d1", WebhookID: 3, Created: sent, Events: []WebhookEvent{
+			{Type: EventCustomerPrice, Action: ActionUpdate, Source: SourceUpload, PartID: 1, PartNumber: "11000", Old: &PriceValue{Price: 10}, New: &PriceValue{Price: 12}, Date: sent},
+		}})
+
+		Convey("deliveries are signed and sent to the stand-in", func() {
+			var got *http.Request
+			var gotBody []byte
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got = r
+				gotBody, _ = ioutil.ReadAll(r.Body)
+			}))
+			defer srv.Close()
+
+			code, err := sendWebhook(context.Background(), srv.Client(), 3, "d1", srv.URL, secret, body, sent)
+			So(err, ShouldBeNil)
+			So(code, ShouldEqual, http.StatusOK)
+			So(gotBody, ShouldResemble, body)
+			So(got.Header.Get("X-Curt-Delivery"), ShouldEqual, "d1")
+			So(got.Header.Get("X-Curt-Webhook"), ShouldEqual, "3")
+			So(got.Header.Get("X-Curt-Timestamp"), ShouldEqual, "1600000000")
+			So(got.Header.Get("X-Curt-Signature"), ShouldEqual, SignWebhook(secret, sent, gotBody))
+			So(got.Header.Get("X-Curt-Signature"), ShouldNotEqual, SignWebhook("fedcba9876543210", sent, gotBody))
+			So(got.Header.Get("X-Curt-Signature"), ShouldNotEqual, SignWebhook(secret, sent.Add(time.Second), gotBody))
+
+			var payload WebhookPayload
+			So(json.Unmarshal(gotBody, &payload), ShouldBeNil)
+			So(payload.Events[0].New.Price, ShouldEqual, 12)
+		})
+
+		Convey("anything but a 2xx is a failure", func() {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				http.Error(w, "down for maintenance", http.StatusServiceUnavailable)
+			}))
+			defer srv.Close()
+
+			code, err := sendWebhook(context.Background(), srv.Client(), 3, "d1", srv.URL, secret, body, sent)
+			So(code, ShouldEqual, http.StatusServiceUnavailable)
+			So(err.Error(), ShouldEqual, "503 Service Unavailable: down for maintenance")
+
+			srv.Close()
+			_, err = sendWebhook(context.Background(), srv.Client(), 3, "d1", srv.URL, secret, body, sent)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("slow receivers time out", func() {
+			release := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				<-release
+			}))
+			defer srv.Close()
+			defer close(release)
+
+			client := &http.Client{Timeout: 50 * time.Millisecond}
+			_, err := sendWebhook(context.Background(), client, 3, "d1", srv.URL, secret, body, sent)
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("failures back off until the delivery is dead", func() {
+			d := WebhookDelivery{Status: DeliveryPending}
+			now := time.Now()
+			var waits []time.Duration
+			for d.Status == DeliveryPending {
+				d.Attempts++
+				d.attempted(500, errors.New("500 Internal Server Error"), now)
+				if d.NextAttempt != nil {
+					waits = append(waits, d.NextAttempt.Sub(now))
+				}
+			}
+			So(d.Status, ShouldEqual, DeliveryDead)
+			So(d.Attempts, ShouldEqual, webhookMaxAttempts)
+			So(d.NextAttempt, ShouldBeNil)
+			So(d.LastError, ShouldEqual, "500 Internal Server Error")
+			So(waits, ShouldHaveLength, webhookMaxAttempts-1)
+			So(waits[0], ShouldEqual, 30*time.Second)
+			So(waits[1], ShouldEqual, time.Minute)
+			So(waits[6], ShouldEqual, 32*time.Minute)
+			So(webhookBackoff(20), ShouldEqual, 6*time.Hour)
+
+			d.Attempts = 1
+			d.attempted(204, nil, now)
+			So(d.Status, ShouldEqual, DeliveryDelivered)
+			So(d.LastError, ShouldEqual, "")
+			So(*d.DateDelivered, ShouldEqual, now)
+		})
+
+		Convey("events are ordered by date", func() {
+			t0 := time.Now()
+			history := []WebhookEvent{{Type: EventCustomerPrice, Date: t0}, {Type: EventCustomerPrice, Date: t0.Add(2 * time.Second)}}
+			prices := []WebhookEvent{{Type: EventMAP, Date: t0.Add(time.Second)}}
+			events := mergeEvents(history, prices)
+			So(events, ShouldHaveLength, 3)
+			So(events[1].Type, ShouldEqual, EventMAP)
+		})
+
+		lookupIPAddr = func(ctx context.Context, host string) ([]net.IPAddr, error) {
+			switch host {
+			case "dealer.example.com":
+				return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}}, nil
+			case "internal.example.com":
+				return []net.IPAddr{{IP: net.ParseIP("93.184.216.34")}, {IP: net.ParseIP("10.0.0.5")}}, nil
+			}
+			return nil, errors.New("no such host")
+		}
+		defer func() { lookupIPAddr = net.DefaultResolver.LookupIPAddr }()
+
+		Convey("webhooks need an http URL and get a secret", func() {
+			w := Webhook{URL: "https://dealer.example.com/hooks/prices"}
+			So(w.Validate(), ShouldBeNil)
+			So(w.Secret, ShouldHaveLength, 64)
+			So((&Webhook{URL: "ftp://dealer.example.com"}).Validate(), ShouldNotBeNil)
+			So((&Webhook{URL: "/hooks"}).Validate(), ShouldNotBeNil)
+			So((&Webhook{URL: "https://dealer.example.com", Secret: "short"}).Validate(), ShouldNotBeNil)
+		})
+
+		Convey("webhooks can't be aimed at the API's own network", func() {
+			for _, u := range []string{
+				"http://127.0.0.1/hooks",
+				"http://[::1]:8080/hooks",
+				"http://10.1.2.3/hooks",
+				"http://192.168.0.10/hooks",
+				"http://169.254.169.254/latest/meta-data",
+				"http://0.0.0.0/hooks",
+				"https://internal.example.com/hooks",
+				"https://unknown.example.com/hooks",
+			} {
+				So((&Webhook{URL: u}).Validate(), ShouldNotBeNil)
+			}
+			So((&Webhook{URL: "https://93.184.216.34/hooks"}).Validate(), ShouldBeNil)
+
+			// and deliveries are refused as they connect, whatever the
+			// host resolves to by then
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+			defer srv.Close()
+			_, err := sendWebhook(context.Background(), webhookClient(), 3, "d1", srv.URL, "secret", []byte("{}"), time.Now())
+			So(err, ShouldNotBeNil)
+			So(err.Error(), ShouldContainSubstring, "is not public")
+		})
+	})
+}
+
+func TestCustomerCurrency(t *testing.T) {
+	Convey("Testing prices in other currencies", t, func() {
+		cad := currency.NewConverter(currency.ExchangeRate{Currency: "CAD", Rate: 1.25, Increment: 0.01})
+
+		Convey("part prices use native price types where there are any", func() {
+			ps := []Price{
+				{PartID: 1, Type: "List", Price: 100},
+				{PartID: 1, Type: "List CAD", Price: 129.99},
+				{PartID: 1, Type: "Map", Price: 80},
+				{PartID: 2, Type: "List", Price: 10},
+				{PartID: 2, Type: "Map EUR", Price: 9},
+			}
+			converted := PricesInCurrency(ps, cad)
+			So(converted, ShouldResemble, []Price{
+				{PartID: 1, Type: "List", Price: 129.99, Currency: "CAD"},
+				{PartID: 1, Type: "Map", Price: 100, Currency: "CAD"},
+				{PartID: 2, Type: "List", Price: 12.5, Currency: "CAD"},
+			})
+			So(PricesInCurrency(ps, nil), ShouldResemble, ps)
+		})
+
+		Convey("customer prices convert along with their list price", func() {
+			cp := CustomerPrice{PartID: 1, Price: 90, ListPrice: Price{PartID: 1, Type: "List", Price: 100}}
+			convertCustomerPrice(&cp, cad, map[int]float64{1: 129.99})
+			So(cp.Price, ShouldEqual, 112.5)
+			So(cp.Currency, ShouldEqual, "CAD")
+			So(cp.ListPrice.Price, ShouldEqual, 129.99)
+
+			cp = CustomerPrice{PartID: 2, Price: 90, ListPrice: Price{PartID: 2, Type: "List", Price: 100}}
+			convertCustomerPrice(&cp, cad, nil)
+			So(cp.ListPrice.Price, ShouldEqual, 125)
+			So(cp.ListPrice.Currency, ShouldEqual, "CAD")
+		})
+	})
+}
+
+func testLookups() uploadLookups {
+	return uploadLookups{
+		parts:        map[string]int{"11000": 1, "11001": 2, "11002": 3, "11003": 4, "11004": 5, "11005": 6},
+		prices:       map[int]CustomerPrice{},
+		sales:        map[int][]CustomerPrice{},
+		integrations: map[int]int{},
+		mapPrices:    map[int]float64{1: 100, 2: 100, 4: 100},
+		enforced:     map[int]bool{1: true, 2: true},
+		overrides:    map[int]MAPOverride{},
+	}
+}
+
 func newfileUploadRequest() (multipart.File, error) {
 	file, err := os.Create("test.csv")
 	if err != nil {
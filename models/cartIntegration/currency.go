@@ -0,0 +1,114 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/models/currency"
+
+	"strings"
+)
+
+// Prices are kept in USD. Responses in another currency are converted at
+// the rate in effect, except where a part has a price type kept natively in
+// the currency, such as "List CAD", which is used in place of converting
+// the price type it stands in for.
+
+var (
+	getNativePrices = `select pr.partID, pr.price from Price as pr
+		join Part as p on p.partID = pr.partID
+		where p.brandID = ? && pr.priceType = ?`
+)
+
+// PricesInCurrency converts part prices with c. Native prices replace the
+// conversions of their price types, and are only listed in USD responses.
+func PricesInCurrency(ps []Price, c *currency.Converter) []Price {
+	if c == nil {
+		return ps
+	}
+	type key struct {
+		partID    int
+		priceType string
+	}
+	natives := make(map[key]float64)
+	for _, p := range ps {
+		if base, cur, ok := currency.NativeType(p.Type); ok && cur == c.Currency {
+			natives[key{p.PartID, strings.ToLower(base)}] = p.Price
+		}
+	}
+	converted := make([]Price, 0, len(ps))
+	for _, p := range ps {
+		if _, _, ok := currency.NativeType(p.Type); ok {
+			continue
+		}
+		if native, ok := natives[key{p.PartID, strings.ToLower(p.Type)}]; ok {
+			p.Price = native
+		} else {
+			p.Price = c.Convert(p.Price)
+		}
+		p.Currency = c.Currency
+		converted = append(converted, p)
+	}
+	return converted
+}
+
+// CustomerPricesInCurrency converts lists of the customer's prices, and the
+// List prices alongside them, with c. A part's native List price in c's
+// currency is used in place of converting its List price.
+func CustomerPricesInCurrency(dtx *apicontext.DataContext, c *currency.Converter, lists ...[]CustomerPrice) error {
+	if c == nil {
+		return nil
+	}
+	natives, err := nativeListPrices(dtx, c.Currency)
+	if err != nil {
+		return err
+	}
+	for _, cps := range lists {
+		for i := range cps {
+			convertCustomerPrice(&cps[i], c, natives)
+		}
+	}
+	return nil
+}
+
+func convertCustomerPrice(cp *CustomerPrice, c *currency.Converter, nativeList map[int]float64) {
+	cp.Price = c.Convert(cp.Price)
+	cp.Currency = c.Currency
+	if cp.ListPrice.Type == "" {
+		return
+	}
+	if native, ok := nativeList[cp.PartID]; ok {
+		cp.ListPrice.Price = native
+	} else {
+		cp.ListPrice.Price = c.Convert(cp.ListPrice.Price)
+	}
+	cp.ListPrice.Currency = c.Currency
+}
+
+// nativeListPrices returns the brand's List prices kept natively in cur, by
+// part.
+func nativeListPrices(dtx *apicontext.DataContext, cur string) (map[int]float64, error) {
+	natives := make(map[int]float64)
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return natives, err
+	}
+	err = database.Init()
+	if err != nil {
+		return natives, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getNativePrices, brandID, "List "+cur)
+	if err != nil {
+		return natives, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var partID int
+		var price float64
+		if err = rows.Scan(&partID, &price); err != nil {
+			return natives, err
+		}
+		natives[partID] = price
+	}
+	return natives, rows.Err()
+}
@@ -1,10 +1,13 @@
 package cartIntegration
 
 import (
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
 	_ "github.com/go-sql-driver/mysql"
 
+	"context"
 	"database/sql"
+	"errors"
 	"time"
 )
 
@@ -25,6 +28,7 @@ type CustomerPrice struct {
 	SaleEnd        *time.Time `json:"saleEnd,omitempty" xml:"saleEnd,omitempty"`
 	ListPrice      Price      `json:"listPrice,omitempty" xml:"listPrice,omitempty"`
 	ReferenceID    int        `json:"referenceId,omitempty" xml:"referenceId,omitempty"`
+	Currency       string     `json:"currency,omitempty" xml:"currency,omitempty"`
 }
 
 type Price struct {
@@ -32,6 +36,7 @@ type Price struct {
 	PartNumber string  `json:"partNumber,omitempty" xml:"partNumber,omitempty"`
 	Type       string  `json:"type,omitempty" xml:"type,omitempty"`
 	Price      float64 `json:"price,omitempty" xml:"price,omitempty"`
+	Currency   string  `json:"currency,omitempty" xml:"currency,omitempty"`
 }
 
 var (
@@ -66,15 +71,13 @@ var (
 		JOIN Part as p ON pr.partID = p.partID
 		WHERE p.status != 999 && p.brandID = ? && pr.priceType = 'Map'
 		ORDER by p.oldPartNumber, pr.priceType`
-	updateCustomerPrice = `UPDATE CustomerPricing SET price = ?, isSale = ?, sale_start = ?, sale_end = ? WHERE cust_id = ? AND partID = ?`
+	updateCustomerPrice = `UPDATE CustomerPricing SET price = ?, isSale = ?, sale_start = ?, sale_end = ? WHERE cust_price_id = ? AND cust_id = ?`
 	insertCustomerPrice = `INSERT INTO CustomerPricing(cust_id, partID, price, isSale, sale_start, sale_end) VALUES(?, ?, ?, ?, ?, ?)`
-	deleteCustomerPrice = `delete from CustomerPricing where cust_price_id = ?`
+	deleteCustomerPrice = `delete from CustomerPricing where cust_price_id = ? and cust_id = ?`
 	// Cart Integrations
 	getCustomerCartIntegrations = `select c.referenceID, c.partID, p.oldPartNumber, c.custPartID, c.custID from CartIntegration as c
-		join CustomerUser as cu on cu.cust_id = c.custID
-		join ApiKey as a on a.user_id = cu.id
 		join Part as p on p.partID = c.partID
-		where a.api_key = ?
+		where c.custID = ?
 		and p.brandID = ?
 		order by p.oldPartNumber`
 	insertCartIntegration = `INSERT INTO CartIntegration(partID, custPartID, custID) VALUES (?, ?, ?)`
@@ -85,17 +88,44 @@ var (
 	getPartIDfromPartNumber = `select p.partID from Part as p where p.oldPartNumber = ?`
 )
 
+// preparer is satisfied by both *sql.DB and *sql.Tx, so that the same
+// writes can run on their own or as part of an upload's transaction.
+type preparer interface {
+	PrepareContext(ctx context.Context, query string) (*sql.Stmt, error)
+}
+
 var (
-	Brand_ID    int
-	Customer_ID int
+	ErrNoCustomer = errors.New("no customer is associated with this API key")
+	ErrNoBrand    = errors.New("a brandID is required")
 )
 
+// tenant returns the customer and brand that a request is scoped to. Every
+// query in this package is filtered by both, so a DataContext without them
+// is an error rather than a query across every customer.
+func tenant(dtx *apicontext.DataContext) (custID int, brandID int, err error) {
+	if dtx == nil || dtx.CustomerID < 1 {
+		return 0, 0, ErrNoCustomer
+	}
+	brandID = dtx.BrandID
+	if brandID < 1 && len(dtx.BrandArray) == 1 {
+		brandID = dtx.BrandArray[0]
+	}
+	if brandID < 1 {
+		return 0, 0, ErrNoBrand
+	}
+	return dtx.CustomerID, brandID, nil
+}
+
 //Get all of a single customer's prices
-func GetCustomerPrices(page int, count int) (CustomerPriceResp, error) {
+func GetCustomerPrices(dtx *apicontext.DataContext, page int, count int) (CustomerPriceResp, error) {
 	var customerJson CustomerPriceResp
 	var cps []CustomerPrice
 
-	err := database.Init()
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return customerJson, err
+	}
+	err = database.Init()
 	if err != nil {
 		return customerJson, err
 	}
@@ -103,22 +133,23 @@ func GetCustomerPrices(page int, count int) (CustomerPriceResp, error) {
 	var res *sql.Rows
 
 	if page == 0 && count == 0 {
-		res, err = database.DB.Query(getPricing, Customer_ID, Customer_ID, Brand_ID)
+		res, err = database.DB.QueryContext(dtx.Context(), getPricing, custID, custID, brandID)
 		if err != nil {
 			return customerJson, err
 		}
 	} else {
-		countRow := database.DB.QueryRow(getPricingCount, Customer_ID, Customer_ID, Brand_ID)
+		countRow := database.DB.QueryRowContext(dtx.Context(), getPricingCount, custID, custID, brandID)
 		var rowCount int
 
 		countRow.Scan(&rowCount)
 		customerJson.Total = rowCount
 
-		res, err = database.DB.Query(getPricingPaged, Customer_ID, Customer_ID, Brand_ID, (page-1)*count, count)
+		res, err = database.DB.QueryContext(dtx.Context(), getPricingPaged, custID, custID, brandID, (page-1)*count, count)
 		if err != nil {
 			return customerJson, err
 		}
 	}
+	defer res.Close()
 
 	for res.Next() {
 		c, err := Scan(res)
@@ -132,22 +163,27 @@ func GetCustomerPrices(page int, count int) (CustomerPriceResp, error) {
 }
 
 //Get a customers prices - paged/limited
-func GetPricingPaged(page int, count int) ([]CustomerPrice, error) {
+func GetPricingPaged(dtx *apicontext.DataContext, page int, count int) ([]CustomerPrice, error) {
 	var cps []CustomerPrice
-	err := database.Init()
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return cps, err
+	}
+	err = database.Init()
 	if err != nil {
 		return cps, err
 	}
 
-	stmt, err := database.DB.Prepare(getPricingPaged)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getPricingPaged)
 	if err != nil {
 		return cps, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(Customer_ID, Customer_ID, Brand_ID, (page-1)*count, count)
+	res, err := stmt.QueryContext(dtx.Context(), custID, custID, brandID, (page-1)*count, count)
 	if err != nil {
 		return cps, err
 	}
+	defer res.Close()
 
 	for res.Next() {
 		c, err := Scan(res)
@@ -160,20 +196,24 @@ func GetPricingPaged(page int, count int) ([]CustomerPrice, error) {
 }
 
 //Returns the number of prices that a customer has
-func GetPricingCount() (int, error) {
+func GetPricingCount(dtx *apicontext.DataContext) (int, error) {
 	var count int
-	err := database.Init()
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return count, err
+	}
+	err = database.Init()
 	if err != nil {
 		return count, err
 	}
 
-	stmt, err := database.DB.Prepare(getPricingCount)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getPricingCount)
 	if err != nil {
 		return count, err
 	}
 
 	defer stmt.Close()
-	err = stmt.QueryRow(Customer_ID, Customer_ID, Brand_ID).Scan(&count)
+	err = stmt.QueryRowContext(dtx.Context(), custID, custID, brandID).Scan(&count)
 	if err != nil {
 		return count, err
 	}
@@ -181,22 +221,27 @@ func GetPricingCount() (int, error) {
 }
 
 //Returns Price for a part
-func GetPartPricesByPartID(partNumber string) ([]Price, error) {
+func GetPartPricesByPartID(dtx *apicontext.DataContext, partNumber string) ([]Price, error) {
 	var ps []Price
-	err := database.Init()
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return ps, err
+	}
+	err = database.Init()
 	if err != nil {
 		return ps, err
 	}
 
-	stmt, err := database.DB.Prepare(getPricingByPart)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getPricingByPart)
 	if err != nil {
 		return ps, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(Brand_ID, partNumber)
+	res, err := stmt.QueryContext(dtx.Context(), brandID, partNumber)
 	if err != nil {
 		return ps, err
 	}
+	defer res.Close()
 	for res.Next() {
 		p, err := ScanPrice(res)
 		if err != nil {
@@ -208,22 +253,27 @@ func GetPartPricesByPartID(partNumber string) ([]Price, error) {
 }
 
 //Returns all Prices
-func GetPartPrices() ([]Price, error) {
+func GetPartPrices(dtx *apicontext.DataContext) ([]Price, error) {
 	var ps []Price
-	err := database.Init()
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return ps, err
+	}
+	err = database.Init()
 	if err != nil {
 		return ps, err
 	}
 
-	stmt, err := database.DB.Prepare(getAllPricing)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getAllPricing)
 	if err != nil {
 		return ps, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(Brand_ID)
+	res, err := stmt.QueryContext(dtx.Context(), brandID)
 	if err != nil {
 		return ps, err
 	}
+	defer res.Close()
 	for res.Next() {
 		p, err := ScanPrice(res)
 		if err != nil {
@@ -235,22 +285,27 @@ func GetPartPrices() ([]Price, error) {
 }
 
 //Returns Map Price for every part
-func GetMAPPartPrices() ([]Price, error) {
+func GetMAPPartPrices(dtx *apicontext.DataContext) ([]Price, error) {
 	var ps []Price
-	err := database.Init()
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return ps, err
+	}
+	err = database.Init()
 	if err != nil {
 		return ps, err
 	}
 
-	stmt, err := database.DB.Prepare(getAllMAPPricing)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getAllMAPPricing)
 	if err != nil {
 		return ps, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(Brand_ID)
+	res, err := stmt.QueryContext(dtx.Context(), brandID)
 	if err != nil {
 		return ps, err
 	}
+	defer res.Close()
 	for res.Next() {
 		p, err := ScanPrice(res)
 		if err != nil {
@@ -262,45 +317,96 @@ func GetMAPPartPrices() ([]Price, error) {
 }
 
 //CRUD
-func (c *CustomerPrice) Update() error {
-	err := database.Init()
+func (c *CustomerPrice) Update(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
 	if err != nil {
 		return err
 	}
+	c.CustID = custID
 
-	stmt, err := database.DB.Prepare(updateCustomerPrice)
+	err = database.Init()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 	if c.PartID == 0 {
-		c.PartID, err = GetPartIDfromOldPartNumber(c.PartNumber)
+		c.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), c.PartNumber)
 		if c.PartID == 0 || err != nil {
 			return err
 		}
 	}
-	_, err = stmt.Exec(c.Price, c.IsSale, c.SaleStart, c.SaleEnd, c.CustID, c.PartID)
+	a, err := newAudit(dtx, "", SourceManual)
+	if err != nil {
+		return err
+	}
+	return inTx(dtx.Context(), func(tx *sql.Tx) error {
+		existing, err := currentPrice(dtx.Context(), tx, c)
+		if err != nil {
+			return err
+		}
+		if existing == nil {
+			return ErrPriceNotFound
+		}
+		if err := c.CheckMAP(dtx.Context(), tx); err != nil {
+			return err
+		}
+		return c.updateAudited(dtx.Context(), tx, a)
+	})
+}
+
+func (c *CustomerPrice) update(ctx context.Context, db preparer) error {
+	stmt, err := db.PrepareContext(ctx, updateCustomerPrice)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, c.Price, c.IsSale, c.SaleStart, c.SaleEnd, c.ID, c.CustID)
 	return err
 }
 
-func (c *CustomerPrice) Create() error {
-	err := database.Init()
+func (c *CustomerPrice) Create(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
 	if err != nil {
 		return err
 	}
+	c.CustID = custID
 
-	stmt, err := database.DB.Prepare(insertCustomerPrice)
+	err = database.Init()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 	if c.PartID == 0 {
-		c.PartID, err = GetPartIDfromOldPartNumber(c.PartNumber)
+		c.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), c.PartNumber)
 		if c.PartID == 0 || err != nil {
 			return err
 		}
 	}
-	res, err := stmt.Exec(c.CustID, c.PartID, c.Price, c.IsSale, c.SaleStart, c.SaleEnd)
+	a, err := newAudit(dtx, "", SourceManual)
+	if err != nil {
+		return err
+	}
+	c.ID = 0
+	return inTx(dtx.Context(), func(tx *sql.Tx) error {
+		existing, err := currentPrice(dtx.Context(), tx, c)
+		if err != nil {
+			return err
+		}
+		if existing != nil {
+			return ErrPriceExists
+		}
+		if err := c.CheckMAP(dtx.Context(), tx); err != nil {
+			return err
+		}
+		return c.createAudited(dtx.Context(), tx, a)
+	})
+}
+
+func (c *CustomerPrice) create(ctx context.Context, db preparer) error {
+	stmt, err := db.PrepareContext(ctx, insertCustomerPrice)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, c.CustID, c.PartID, c.Price, c.IsSale, c.SaleStart, c.SaleEnd)
 	if err != nil {
 		return err
 	}
@@ -312,38 +418,48 @@ func (c *CustomerPrice) Create() error {
 	return nil
 }
 
-func (c *CustomerPrice) Delete() error {
-	err := database.Init()
+func (c *CustomerPrice) Delete(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
 	if err != nil {
 		return err
 	}
+	c.CustID = custID
 
-	stmt, err := database.DB.Prepare(deleteCustomerPrice)
+	err = database.Init()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
-	_, err = stmt.Exec(c.ID)
-	return err
+	a, err := newAudit(dtx, "", SourceManual)
+	if err != nil {
+		return err
+	}
+	return inTx(dtx.Context(), func(tx *sql.Tx) error {
+		return c.deleteAudited(dtx.Context(), tx, a)
+	})
 }
 
 //CartIntegration
-func GetCustomerCartIntegrations(key string) ([]CustomerPrice, error) {
+func GetCustomerCartIntegrations(dtx *apicontext.DataContext) ([]CustomerPrice, error) {
 	var cps []CustomerPrice
-	err := database.Init()
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return cps, err
+	}
+	err = database.Init()
 	if err != nil {
 		return cps, err
 	}
 
-	stmt, err := database.DB.Prepare(getCustomerCartIntegrations)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getCustomerCartIntegrations)
 	if err != nil {
 		return cps, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query(key, Brand_ID)
+	res, err := stmt.QueryContext(dtx.Context(), custID, brandID)
 	if err != nil {
 		return cps, err
 	}
+	defer res.Close()
 	for res.Next() {
 		c, err := ScanCartIntegration(res)
 		if err != nil {
@@ -354,82 +470,106 @@ func GetCustomerCartIntegrations(key string) ([]CustomerPrice, error) {
 	return cps, err
 }
 
-func (cp *CustomerPrice) UpdateCartIntegration() error {
-	err := database.Init()
+func (cp *CustomerPrice) UpdateCartIntegration(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
 	if err != nil {
 		return err
 	}
+	cp.CustID = custID
 
-	stmt, err := database.DB.Prepare(updateCartIntegration)
+	err = database.Init()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 	if cp.PartID == 0 {
-		cp.PartID, err = GetPartIDfromOldPartNumber(cp.PartNumber)
+		cp.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), cp.PartNumber)
 		if cp.PartID == 0 || err != nil {
 			return err
 		}
 	}
-	_, err = stmt.Exec(cp.CustomerPartID, cp.PartID, cp.CustID)
+	return cp.updateIntegration(dtx.Context(), database.DB)
+}
+
+func (cp *CustomerPrice) updateIntegration(ctx context.Context, db preparer) error {
+	stmt, err := db.PrepareContext(ctx, updateCartIntegration)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, cp.CustomerPartID, cp.PartID, cp.CustID)
 	return err
 }
 
-func (cp *CustomerPrice) InsertCartIntegration() error {
-	err := database.Init()
+func (cp *CustomerPrice) InsertCartIntegration(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
 	if err != nil {
 		return err
 	}
+	cp.CustID = custID
 
-	stmt, err := database.DB.Prepare(insertCartIntegration)
+	err = database.Init()
 	if err != nil {
 		return err
 	}
-	defer stmt.Close()
 	if cp.PartID == 0 {
-		cp.PartID, err = GetPartIDfromOldPartNumber(cp.PartNumber)
+		cp.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), cp.PartNumber)
 		if cp.PartID == 0 || err != nil {
 			return err
 		}
 	}
-	_, err = stmt.Exec(cp.PartID, cp.CustomerPartID, cp.CustID)
+	return cp.insertIntegration(dtx.Context(), database.DB)
+}
+
+func (cp *CustomerPrice) insertIntegration(ctx context.Context, db preparer) error {
+	stmt, err := db.PrepareContext(ctx, insertCartIntegration)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(ctx, cp.PartID, cp.CustomerPartID, cp.CustID)
 	return err
 }
 
-func (cp *CustomerPrice) DeleteCartIntegration() error {
-	err := database.Init()
+func (cp *CustomerPrice) DeleteCartIntegration(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	cp.CustID = custID
+
+	err = database.Init()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(deleteCartIntegration)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), deleteCartIntegration)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 	if cp.PartID == 0 {
-		cp.PartID, err = GetPartIDfromOldPartNumber(cp.PartNumber)
+		cp.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), cp.PartNumber)
 		if cp.PartID == 0 || err != nil {
 			return err
 		}
 	}
-	_, err = stmt.Exec(cp.PartID, cp.CustomerPartID, cp.CustID)
+	_, err = stmt.ExecContext(dtx.Context(), cp.PartID, cp.CustomerPartID, cp.CustID)
 	return err
 }
 
-func GetAllPriceTypes() ([]string, error) {
+func GetAllPriceTypes(ctx context.Context) ([]string, error) {
 	var types []string
 	err := database.Init()
 	if err != nil {
 		return types, err
 	}
 
-	stmt, err := database.DB.Prepare(getAllPriceTypes)
+	stmt, err := database.DB.PrepareContext(ctx, getAllPriceTypes)
 	if err != nil {
 		return types, err
 	}
 	defer stmt.Close()
-	res, err := stmt.Query()
+	res, err := stmt.QueryContext(ctx)
 	if err != nil {
 		return types, err
 	}
@@ -530,20 +670,20 @@ func ScanCartIntegration(rows database.Scanner) (CustomerPrice, error) {
 	return c, err
 }
 
-func GetPartIDfromOldPartNumber(oldPartNumber string) (int, error) {
+func GetPartIDfromOldPartNumber(ctx context.Context, oldPartNumber string) (int, error) {
 	var partID int
 	err := database.Init()
 	if err != nil {
 		return partID, err
 	}
 
-	stmt, err := database.DB.Prepare(getPartIDfromPartNumber)
+	stmt, err := database.DB.PrepareContext(ctx, getPartIDfromPartNumber)
 	if err != nil {
 		return partID, err
 	}
 	defer stmt.Close()
 	var id *int
-	err = stmt.QueryRow(oldPartNumber).Scan(&id)
+	err = stmt.QueryRowContext(ctx, oldPartNumber).Scan(&id)
 	if err != nil {
 		return partID, err
 	}
@@ -0,0 +1,465 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Sources of price changes, as recorded in their history.
+const (
+	SourceManual     = "manual"
+	SourceUpload     = "upload"
+	SourceGlobal     = "global"
+	SourceResetToMap = "resetToMap"
+	SourceRules      = "rules"
+	SourceRevert     = "revert"
+	SourceExpired    = "expired"
+
+	ActionDelete = "delete"
+)
+
+var (
+	ErrBatchNotFound = errors.New("no price changes with that batch ID")
+	ErrSaleMismatch  = errors.New("a sale and a regular price are separate prices; one can't be changed into the other")
+	ErrPriceExists   = errors.New("the customer already has this price; update it instead")
+	ErrPriceNotFound = errors.New("the customer has no such price; create it instead")
+)
+
+// PriceChange records a single write to a customer price. Every write made
+// by one request, such as an upload, shares a BatchID so that it can be
+// reverted as a whole. Old is nil for creates and New for deletes.
+type PriceChange struct {
+	ID          int         `json:"id" xml:"id,attr"`
+	BatchID     string      `json:"batchId" xml:"batchId,attr"`
+	CustID      int         `json:"custId" xml:"custId,attr"`
+	PartID      int         `json:"partId" xml:"partId,attr"`
+	PartNumber  string      `json:"partNumber,omitempty" xml:"partNumber,attr,omitempty"`
+	CustPriceID int         `json:"custPriceId,omitempty" xml:"custPriceId,attr,omitempty"`
+	Action      string      `json:"action" xml:"action,attr"`
+	Source      string      `json:"source" xml:"source,attr"`
+	Old         *PriceValue `json:"old,omitempty" xml:"old,omitempty"`
+	New         *PriceValue `json:"new,omitempty" xml:"new,omitempty"`
+	APIKey      string      `json:"apiKey,omitempty" xml:"apiKey,omitempty"`
+	UserID      string      `json:"userId,omitempty" xml:"userId,omitempty"`
+	Date        time.Time   `json:"date" xml:"date"`
+}
+
+// PriceValue is the part of a customer price that its history tracks.
+type PriceValue struct {
+	Price     float64    `json:"price" xml:"price"`
+	IsSale    int        `json:"isSale,omitempty" xml:"isSale,omitempty"`
+	SaleStart *time.Time `json:"saleStart,omitempty" xml:"saleStart,omitempty"`
+	SaleEnd   *time.Time `json:"saleEnd,omitempty" xml:"saleEnd,omitempty"`
+}
+
+type PriceHistoryResp struct {
+	Items []PriceChange `json:"items" xml:"items>change"`
+	Total int           `json:"total" xml:"total"`
+}
+
+// HistoryQuery filters a customer's price history. Zero values match
+// everything.
+type HistoryQuery struct {
+	PartNumber string
+	BatchID    string
+	Source     string
+	Since      time.Time
+	Until      time.Time
+	Page       int
+	Count      int
+}
+
+// RevertReport lists the changes a revert undid, and those it skipped
+// because the price has changed again since.
+type RevertReport struct {
+	BatchID   string        `json:"batchId" xml:"batchId,attr"`
+	Reverted  []PriceChange `json:"reverted" xml:"reverted>change"`
+	Conflicts []PriceChange `json:"conflicts" xml:"conflicts>change"`
+}
+
+var (
+	// CustomerPriceHistory has a row per PriceChange, with Old and New
+	// flattened into old_ and new_ columns.
+	insertPriceChange = `insert into CustomerPriceHistory(batchID, cust_id, partID, cust_price_id, action, source,
+		old_price, old_isSale, old_sale_start, old_sale_end, new_price, new_isSale, new_sale_start, new_sale_end,
+		apiKey, userID, dateAdded)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	priceHistoryFilter = `from CustomerPriceHistory as h
+		join Part as p on p.partID = h.partID
+		where h.cust_id = ? && p.brandID = ?
+		&& (? = '' || p.oldPartNumber = ?)
+		&& (? = '' || h.batchID = ?)
+		&& (? = '' || h.source = ?)
+		&& h.dateAdded >= ? && h.dateAdded < ?`
+	getPriceHistoryCount = `select count(*) ` + priceHistoryFilter
+	getPriceHistory      = `select h.historyID, h.batchID, h.cust_id, h.partID, p.oldPartNumber, h.cust_price_id, h.action, h.source,
+		h.old_price, h.old_isSale, h.old_sale_start, h.old_sale_end, h.new_price, h.new_isSale, h.new_sale_start, h.new_sale_end,
+		h.apiKey, h.userID, h.dateAdded ` + priceHistoryFilter + `
+		order by h.historyID desc
+		limit ?, ?`
+	getBatchChanges = `select h.historyID, h.batchID, h.cust_id, h.partID, p.oldPartNumber, h.cust_price_id, h.action, h.source,
+		h.old_price, h.old_isSale, h.old_sale_start, h.old_sale_end, h.new_price, h.new_isSale, h.new_sale_start, h.new_sale_end,
+		h.apiKey, h.userID, h.dateAdded
+		from CustomerPriceHistory as h
+		join Part as p on p.partID = h.partID
+		where h.cust_id = ? && h.batchID = ?
+		order by h.historyID desc`
+	// A customer has at most one regular price per part, and any number of
+	// sales alongside it, each told apart by the day it starts.
+	getRegularPrice = `select cust_price_id, partID, price, isSale, sale_start, sale_end from CustomerPricing
+		where cust_id = ? and partID = ? and coalesce(isSale, 0) = 0
+		order by cust_price_id
+		limit 1
+		for update`
+	getSalePrice = `select cust_price_id, partID, price, isSale, sale_start, sale_end from CustomerPricing
+		where cust_id = ? and partID = ? and isSale = 1 and date(sale_start) = date(?)
+		order by cust_price_id
+		limit 1
+		for update`
+	getPriceByID = `select cust_price_id, partID, price, isSale, sale_start, sale_end from CustomerPricing
+		where cust_id = ? and cust_price_id = ?
+		for update`
+	archiveExpiredHistory = `insert into CustomerPriceHistory(batchID, cust_id, partID, cust_price_id, action, source,
+		old_price, old_isSale, old_sale_start, old_sale_end, apiKey, userID, dateAdded)
+		select ?, cust_id, partID, cust_price_id, 'delete', 'expired', price, isSale, sale_start, sale_end, '', '', ?
+		from CustomerPricing
+		where isSale = 1 && sale_end < ?`
+)
+
+// audit records the writes of a single batch.
+type audit struct {
+	batchID string
+	source  string
+	apiKey  string
+	userID  string
+}
+
+func newAudit(dtx *apicontext.DataContext, batchID, source string) (audit, error) {
+	var err error
+	if batchID == "" {
+		batchID, err = newBatchID()
+	}
+	return audit{batchID: batchID, source: source, apiKey: dtx.APIKey, userID: dtx.UserID}, err
+}
+
+func (a audit) record(ctx context.Context, db preparer, action string, custID, partID, custPriceID int, old, new *PriceValue) error {
+	stmt, err := db.PrepareContext(ctx, insertPriceChange)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var o, n PriceValue
+	var oldPrice, newPrice *float64
+	var oldSale, newSale *int
+	if old != nil {
+		o, oldPrice, oldSale = *old, &old.Price, &old.IsSale
+	}
+	if new != nil {
+		n, newPrice, newSale = *new, &new.Price, &new.IsSale
+	}
+	_, err = stmt.ExecContext(ctx, a.batchID, custID, partID, custPriceID, action, a.source,
+		oldPrice, oldSale, o.SaleStart, o.SaleEnd, newPrice, newSale, n.SaleStart, n.SaleEnd,
+		a.apiKey, a.userID, time.Now())
+	return err
+}
+
+func (c *CustomerPrice) value() *PriceValue {
+	return &PriceValue{Price: c.Price, IsSale: c.IsSale, SaleStart: c.SaleStart, SaleEnd: c.SaleEnd}
+}
+
+// currentPrice locks and returns the row that a write of c applies to, or
+// nil if there isn't one: the row with c's ID if it has one, or else the
+// customer's sale on the part that starts the same day as c, for sales, or
+// their regular price for it.
+func currentPrice(ctx context.Context, db preparer, c *CustomerPrice) (*CustomerPrice, error) {
+	switch {
+	case c.ID > 0:
+		return lockPrice(ctx, db, getPriceByID, c.CustID, c.ID)
+	case c.IsSale == 1:
+		return lockPrice(ctx, db, getSalePrice, c.CustID, c.PartID, c.SaleStart)
+	default:
+		return lockPrice(ctx, db, getRegularPrice, c.CustID, c.PartID)
+	}
+}
+
+func lockPrice(ctx context.Context, db preparer, query string, custID int, args ...interface{}) (*CustomerPrice, error) {
+	stmt, err := db.PrepareContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer stmt.Close()
+
+	cp := CustomerPrice{CustID: custID}
+	var isSale *int
+	err = stmt.QueryRowContext(ctx, append([]interface{}{custID}, args...)...).Scan(&cp.ID, &cp.PartID, &cp.Price, &isSale, &cp.SaleStart, &cp.SaleEnd)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isSale != nil {
+		cp.IsSale = *isSale
+	}
+	return &cp, nil
+}
+
+func (c *CustomerPrice) createAudited(ctx context.Context, db preparer, a audit) error {
+	if err := c.create(ctx, db); err != nil {
+		return err
+	}
+	return a.record(ctx, db, ActionCreate, c.CustID, c.PartID, c.ID, nil, c.value())
+}
+
+func (c *CustomerPrice) updateAudited(ctx context.Context, db preparer, a audit) error {
+	old, err := currentPrice(ctx, db, c)
+	if err != nil {
+		return err
+	}
+	if old == nil {
+		return ErrPriceNotFound
+	}
+	if old.IsSale != c.IsSale {
+		return ErrSaleMismatch
+	}
+	c.ID, c.PartID = old.ID, old.PartID
+	if err = c.update(ctx, db); err != nil {
+		return err
+	}
+	return a.record(ctx, db, ActionUpdate, c.CustID, c.PartID, c.ID, old.value(), c.value())
+}
+
+func (c *CustomerPrice) deleteAudited(ctx context.Context, db preparer, a audit) error {
+	old, err := lockPrice(ctx, db, getPriceByID, c.CustID, c.ID)
+	if err != nil || old == nil {
+		// nothing to delete
+		return err
+	}
+
+	del, err := db.PrepareContext(ctx, deleteCustomerPrice)
+	if err != nil {
+		return err
+	}
+	defer del.Close()
+	if _, err = del.ExecContext(ctx, c.ID, c.CustID); err != nil {
+		return err
+	}
+	c.PartID = old.PartID
+	return a.record(ctx, db, ActionDelete, c.CustID, c.PartID, c.ID, old.value(), nil)
+}
+
+// inTx runs fn in a transaction, committing if it returns nil.
+func inTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err = fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// GetPriceHistory returns the customer's price changes for the brand, most
+// recent first.
+func GetPriceHistory(dtx *apicontext.DataContext, q HistoryQuery) (PriceHistoryResp, error) {
+	resp := PriceHistoryResp{Items: []PriceChange{}}
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return resp, err
+	}
+	err = database.Init()
+	if err != nil {
+		return resp, err
+	}
+
+	if q.Until.IsZero() {
+		q.Until = time.Now().Add(time.Minute)
+	}
+	if q.Page < 1 {
+		q.Page = 1
+	}
+	if q.Count < 1 {
+		q.Count = 100
+	}
+	args := []interface{}{custID, brandID, q.PartNumber, q.PartNumber, q.BatchID, q.BatchID, q.Source, q.Source, q.Since, q.Until}
+
+	err = database.DB.QueryRowContext(dtx.Context(), getPriceHistoryCount, args...).Scan(&resp.Total)
+	if err != nil {
+		return resp, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getPriceHistory, append(args, (q.Page-1)*q.Count, q.Count)...)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		c, err := scanPriceChange(rows)
+		if err != nil {
+			return resp, err
+		}
+		resp.Items = append(resp.Items, c)
+	}
+	return resp, rows.Err()
+}
+
+// RevertBatch undoes a batch as a new batch of its own, restoring each
+// price it changed to what it was before the batch. Prices that have
+// changed again since are left alone and reported as conflicts, unless
+// force is set.
+func RevertBatch(dtx *apicontext.DataContext, batchID string, force bool) (*RevertReport, error) {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	err = database.Init()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getBatchChanges, custID, batchID)
+	if err != nil {
+		return nil, err
+	}
+	var changes []PriceChange
+	for rows.Next() {
+		c, err := scanPriceChange(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		changes = append(changes, c)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	if len(changes) == 0 {
+		return nil, ErrBatchNotFound
+	}
+
+	a, err := newAudit(dtx, "", SourceRevert)
+	if err != nil {
+		return nil, err
+	}
+	report := &RevertReport{BatchID: a.batchID, Reverted: []PriceChange{}, Conflicts: []PriceChange{}}
+	ctx := dtx.Context()
+	err = inTx(ctx, func(tx *sql.Tx) error {
+		for _, c := range collapse(changes) {
+			current, err := lockPrice(ctx, tx, getPriceByID, c.CustID, c.CustPriceID)
+			if err != nil {
+				return err
+			}
+			if !force && !c.isCurrent(current) {
+				report.Conflicts = append(report.Conflicts, c)
+				continue
+			}
+			if err = c.revert(ctx, tx, a, current); err != nil {
+				return fmt.Errorf("change %d: %v", c.ID, err)
+			}
+			report.Reverted = append(report.Reverted, c)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// collapse merges changes, newest first, into one per price, from the
+// earliest change's Old to the latest one's New, so that a price the batch
+// wrote more than once is reverted to where the batch found it.
+func collapse(changes []PriceChange) []PriceChange {
+	index := make(map[int]int)
+	var collapsed []PriceChange
+	for _, c := range changes {
+		if i, ok := index[c.CustPriceID]; ok {
+			collapsed[i].Old = c.Old
+			continue
+		}
+		index[c.CustPriceID] = len(collapsed)
+		collapsed = append(collapsed, c)
+	}
+	return collapsed
+}
+
+// isCurrent reports whether current is still what c left behind.
+func (c *PriceChange) isCurrent(current *CustomerPrice) bool {
+	if c.New == nil || current == nil {
+		return c.New == nil && current == nil
+	}
+	return cents(c.New.Price) == cents(current.Price) && c.New.IsSale == current.IsSale &&
+		sameDate(c.New.SaleStart, current.SaleStart) && sameDate(c.New.SaleEnd, current.SaleEnd)
+}
+
+func (c *PriceChange) revert(ctx context.Context, tx *sql.Tx, a audit, current *CustomerPrice) error {
+	cp := CustomerPrice{CustID: c.CustID, PartID: c.PartID, PartNumber: c.PartNumber}
+	if c.Old != nil {
+		cp.Price, cp.IsSale, cp.SaleStart, cp.SaleEnd = c.Old.Price, c.Old.IsSale, c.Old.SaleStart, c.Old.SaleEnd
+	}
+
+	switch {
+	case c.Old == nil && current != nil:
+		cp.ID = c.CustPriceID
+		return cp.deleteAudited(ctx, tx, a)
+	case c.Old == nil:
+		return nil
+	case current == nil:
+		return cp.createAudited(ctx, tx, a)
+	default:
+		cp.ID = current.ID
+		return cp.updateAudited(ctx, tx, a)
+	}
+}
+
+func scanPriceChange(row database.Scanner) (PriceChange, error) {
+	var c PriceChange
+	var partNumber, apiKey, userID *string
+	var custPriceID *int
+	var oldPrice, newPrice *float64
+	var oldSale, newSale *int
+	var o, n PriceValue
+	err := row.Scan(&c.ID, &c.BatchID, &c.CustID, &c.PartID, &partNumber, &custPriceID, &c.Action, &c.Source,
+		&oldPrice, &oldSale, &o.SaleStart, &o.SaleEnd, &newPrice, &newSale, &n.SaleStart, &n.SaleEnd,
+		&apiKey, &userID, &c.Date)
+	if err != nil {
+		return c, err
+	}
+	if partNumber != nil {
+		c.PartNumber = *partNumber
+	}
+	if custPriceID != nil {
+		c.CustPriceID = *custPriceID
+	}
+	if oldPrice != nil {
+		o.Price = *oldPrice
+		if oldSale != nil {
+			o.IsSale = *oldSale
+		}
+		c.Old = &o
+	}
+	if newPrice != nil {
+		n.Price = *newPrice
+		if newSale != nil {
+			n.IsSale = *newSale
+		}
+		c.New = &n
+	}
+	if apiKey != nil {
+		c.APIKey = *apiKey
+	}
+	if userID != nil {
+		c.UserID = *userID
+	}
+	return c, nil
+}
@@ -0,0 +1,405 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// MAPViolation is a customer price below the part's MAP price. Prices below
+// an enforced MAP are refused unless the customer has a MAPOverride for the
+// part that allows them; those below an unenforced MAP are only flagged.
+type MAPViolation struct {
+	CustID       int          `json:"custId" xml:"custId,attr"`
+	CustomerName string       `json:"customerName,omitempty" xml:"customerName,omitempty"`
+	PartID       int          `json:"partId" xml:"partId,attr"`
+	PartNumber   string       `json:"partNumber,omitempty" xml:"partNumber,attr,omitempty"`
+	BrandID      int          `json:"brandId,omitempty" xml:"brandId,attr,omitempty"`
+	Price        float64      `json:"price" xml:"price"`
+	MAP          float64      `json:"map" xml:"map"`
+	Enforced     bool         `json:"enforced" xml:"enforced,attr"`
+	Override     *MAPOverride `json:"override,omitempty" xml:"override,omitempty"`
+}
+
+func (v *MAPViolation) Error() string {
+	return fmt.Sprintf("price %.2f is below the MAP price of %.2f", v.Price, v.MAP)
+}
+
+// Allowed reports whether the price may be written anyway, because MAP
+// isn't enforced for the part or an override allows it.
+func (v *MAPViolation) Allowed() bool {
+	return !v.Enforced || v.Override.allows(v.Price)
+}
+
+// MAPOverride lets a customer price a part below its enforced MAP, down to
+// Price, until it expires. Overrides are granted with internal keys.
+type MAPOverride struct {
+	ID         int        `json:"id,omitempty" xml:"id,attr,omitempty"`
+	CustID     int        `json:"custId" xml:"custId,attr"`
+	PartID     int        `json:"partId" xml:"partId,attr"`
+	PartNumber string     `json:"partNumber,omitempty" xml:"partNumber,attr,omitempty"`
+	Price      float64    `json:"price" xml:"price"`
+	Reason     string     `json:"reason" xml:"reason"`
+	ApprovedBy string     `json:"approvedBy,omitempty" xml:"approvedBy,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty" xml:"expires,omitempty"`
+	DateAdded  time.Time  `json:"dateAdded,omitempty" xml:"dateAdded,omitempty"`
+}
+
+// MAPReport lists the customer prices that are below MAP as of Generated.
+type MAPReport struct {
+	Generated  time.Time      `json:"generated" xml:"generated,attr"`
+	Total      int            `json:"total" xml:"total,attr"`
+	Refused    int            `json:"refused" xml:"refused,attr"`
+	Violations []MAPViolation `json:"violations" xml:"violations>violation"`
+}
+
+var (
+	ErrOverrideNotFound = errors.New("no MAP override with that ID")
+)
+
+var (
+	getPartMAP = `select pr.price, pr.enforced from Price as pr
+		where pr.partID = ? && pr.priceType = 'Map'
+		limit 1`
+	getBrandMAP = `select pr.partID, pr.price, pr.enforced from Price as pr
+		join Part as p on pr.partID = p.partID
+		where p.status != 999 && p.brandID = ? && pr.priceType = 'Map'`
+
+	// CustomerMapOverride(overrideID, cust_id, partID, price, reason,
+	// approvedBy, expires, dateAdded)
+	mapOverrideColumns   = `o.overrideID, o.cust_id, o.partID, p.oldPartNumber, o.price, o.reason, o.approvedBy, o.expires, o.dateAdded`
+	getActiveMAPOverride = `select ` + mapOverrideColumns + ` from CustomerMapOverride as o
+		join Part as p on p.partID = o.partID
+		where o.cust_id = ? && o.partID = ? && (o.expires is null || o.expires > now())
+		order by o.price
+		limit 1`
+	getActiveMAPOverrides = `select ` + mapOverrideColumns + ` from CustomerMapOverride as o
+		join Part as p on p.partID = o.partID
+		where o.cust_id = ? && (o.expires is null || o.expires > now())
+		order by p.oldPartNumber, o.price`
+	getMAPOverrides = `select ` + mapOverrideColumns + ` from CustomerMapOverride as o
+		join Part as p on p.partID = o.partID
+		where (? = 0 || o.cust_id = ?)
+		order by o.cust_id, p.oldPartNumber, o.dateAdded`
+	insertMAPOverride = `insert into CustomerMapOverride(cust_id, partID, price, reason, approvedBy, expires, dateAdded) values (?, ?, ?, ?, ?, ?, ?)`
+	deleteMAPOverride = `delete from CustomerMapOverride where overrideID = ?`
+
+	// every customer price below MAP, with the lowest override that applies
+	getMAPViolations = `select cp.cust_id, c.name, p.partID, p.oldPartNumber, p.brandID, cp.price, pr.price, pr.enforced,
+		o.overrideID, o.price, o.reason, o.approvedBy, o.expires, o.dateAdded
+		from CustomerPricing as cp
+		join Part as p on p.partID = cp.partID
+		join Price as pr on pr.partID = cp.partID && pr.priceType = 'Map'
+		left join Customer as c on c.cust_id = cp.cust_id
+		left join CustomerMapOverride as o on o.overrideID = (
+			select o2.overrideID from CustomerMapOverride as o2
+			where o2.cust_id = cp.cust_id && o2.partID = cp.partID && (o2.expires is null || o2.expires > now())
+			order by o2.price
+			limit 1
+		)
+		where p.status != 999 && round(cp.price, 2) < round(pr.price, 2)
+		&& (? || pr.enforced = 1)
+		&& (? = 0 || p.brandID = ?)
+		&& (? = 0 || cp.cust_id = ?)
+		order by cp.cust_id, p.oldPartNumber`
+)
+
+func (o *MAPOverride) allows(price float64) bool {
+	return o != nil && cents(price) >= cents(o.Price)
+}
+
+// checkMAP returns the violation if price is below mapPrice, or nil.
+func checkMAP(price, mapPrice float64, enforced bool, override *MAPOverride) *MAPViolation {
+	if mapPrice <= 0 || cents(price) >= cents(mapPrice) {
+		return nil
+	}
+	return &MAPViolation{Price: price, MAP: mapPrice, Enforced: enforced, Override: override}
+}
+
+// CheckMAP returns a *MAPViolation if c would be written below an enforced
+// MAP without an override that allows it. Prices that aren't changing are
+// let through, so that a price already below MAP can still have its sale
+// dates edited. It's run on the write's own transaction, so that the price
+// it compares against is the row being replaced, locked until the write is
+// done.
+func (c *CustomerPrice) CheckMAP(ctx context.Context, db preparer) error {
+	stmt, err := db.PrepareContext(ctx, getPartMAP)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	var mapPrice float64
+	var enforced bool
+	err = stmt.QueryRowContext(ctx, c.PartID).Scan(&mapPrice, &enforced)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	current, err := currentPrice(ctx, db, c)
+	if err != nil {
+		return err
+	}
+	if current != nil && cents(current.Price) == cents(c.Price) {
+		return nil
+	}
+
+	v := checkMAP(c.Price, mapPrice, enforced, nil)
+	if v == nil || !v.Enforced {
+		return nil
+	}
+	v.CustID, v.PartID, v.PartNumber = c.CustID, c.PartID, c.PartNumber
+
+	overrideStmt, err := db.PrepareContext(ctx, getActiveMAPOverride)
+	if err != nil {
+		return err
+	}
+	defer overrideStmt.Close()
+
+	override, err := scanMAPOverride(overrideStmt.QueryRowContext(ctx, c.CustID, c.PartID))
+	if err != nil && err != sql.ErrNoRows {
+		return err
+	}
+	if err == nil {
+		v.Override = &override
+	}
+	if v.Allowed() {
+		return nil
+	}
+	return v
+}
+
+// GetMAPViolations lists every customer price below enforced MAP, or below
+// any MAP with all, optionally limited to a brand and/or customer. It reads
+// current prices each time, so it can be run again whenever MAP changes.
+func GetMAPViolations(ctx context.Context, brandID, custID int, all bool) (MAPReport, error) {
+	report := MAPReport{Generated: time.Now(), Violations: []MAPViolation{}}
+	err := database.Init()
+	if err != nil {
+		return report, err
+	}
+
+	rows, err := database.DB.QueryContext(ctx, getMAPViolations, all, brandID, brandID, custID, custID)
+	if err != nil {
+		return report, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var v MAPViolation
+		var name, reason, approvedBy *string
+		var overrideID *int
+		var overridePrice *float64
+		var expires, added *time.Time
+		err = rows.Scan(&v.CustID, &name, &v.PartID, &v.PartNumber, &v.BrandID, &v.Price, &v.MAP, &v.Enforced,
+			&overrideID, &overridePrice, &reason, &approvedBy, &expires, &added)
+		if err != nil {
+			return report, err
+		}
+		if name != nil {
+			v.CustomerName = *name
+		}
+		if overrideID != nil {
+			o := MAPOverride{ID: *overrideID, CustID: v.CustID, PartID: v.PartID, PartNumber: v.PartNumber, Expires: expires}
+			if overridePrice != nil {
+				o.Price = *overridePrice
+			}
+			if reason != nil {
+				o.Reason = *reason
+			}
+			if approvedBy != nil {
+				o.ApprovedBy = *approvedBy
+			}
+			if added != nil {
+				o.DateAdded = *added
+			}
+			v.Override = &o
+		}
+		report.add(v)
+	}
+	return report, rows.Err()
+}
+
+func (r *MAPReport) add(v MAPViolation) {
+	r.Total++
+	if !v.Allowed() {
+		r.Refused++
+	}
+	r.Violations = append(r.Violations, v)
+}
+
+// GetMAPOverrides lists the MAP overrides of a customer, or of every
+// customer if custID is 0, including expired ones.
+func GetMAPOverrides(ctx context.Context, custID int) ([]MAPOverride, error) {
+	overrides := []MAPOverride{}
+	err := database.Init()
+	if err != nil {
+		return overrides, err
+	}
+
+	rows, err := database.DB.QueryContext(ctx, getMAPOverrides, custID, custID)
+	if err != nil {
+		return overrides, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		o, err := scanMAPOverride(rows)
+		if err != nil {
+			return overrides, err
+		}
+		overrides = append(overrides, o)
+	}
+	return overrides, rows.Err()
+}
+
+// Validate checks the override before it's granted.
+func (o *MAPOverride) Validate() error {
+	if o.CustID < 1 {
+		return errors.New("custId is required")
+	}
+	if o.Reason == "" {
+		return errors.New("a reason is required")
+	}
+	if o.Price < 0 {
+		return errors.New("price cannot be negative")
+	}
+	if o.Expires != nil && o.Expires.Before(time.Now()) {
+		return errors.New("expires cannot be in the past")
+	}
+	if o.PartID < 1 && o.PartNumber == "" {
+		return errors.New("partId or partNumber is required")
+	}
+	return nil
+}
+
+// Create grants the override, recording the user or key that approved it.
+func (o *MAPOverride) Create(dtx *apicontext.DataContext) error {
+	if err := o.Validate(); err != nil {
+		return err
+	}
+	err := database.Init()
+	if err != nil {
+		return err
+	}
+	if o.PartID == 0 {
+		o.PartID, err = GetPartIDfromOldPartNumber(dtx.Context(), o.PartNumber)
+		if err == sql.ErrNoRows || (err == nil && o.PartID == 0) {
+			return fmt.Errorf("%s is not a part number", o.PartNumber)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	o.ApprovedBy = dtx.UserID
+	if o.ApprovedBy == "" {
+		o.ApprovedBy = dtx.APIKey
+	}
+	o.DateAdded = time.Now()
+
+	res, err := database.DB.ExecContext(dtx.Context(), insertMAPOverride, o.CustID, o.PartID, o.Price, o.Reason, o.ApprovedBy, o.Expires, o.DateAdded)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	o.ID = int(id)
+	return nil
+}
+
+// Delete revokes the override.
+func (o *MAPOverride) Delete(ctx context.Context) error {
+	err := database.Init()
+	if err != nil {
+		return err
+	}
+	res, err := database.DB.ExecContext(ctx, deleteMAPOverride, o.ID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrOverrideNotFound
+	}
+	return nil
+}
+
+// loadMAPPolicy adds the brand's MAP prices, and the customer's active
+// overrides, to lk.
+func loadMAPPolicy(dtx *apicontext.DataContext, lk *uploadLookups) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getBrandMAP, brandID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var partID int
+		var price float64
+		var enforced bool
+		if err = rows.Scan(&partID, &price, &enforced); err != nil {
+			return err
+		}
+		lk.mapPrices[partID] = price
+		lk.enforced[partID] = enforced
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	overrides, err := database.DB.QueryContext(dtx.Context(), getActiveMAPOverrides, custID)
+	if err != nil {
+		return err
+	}
+	defer overrides.Close()
+	for overrides.Next() {
+		o, err := scanMAPOverride(overrides)
+		if err != nil {
+			return err
+		}
+		// the lowest active override for a part is the one that applies
+		if _, ok := lk.overrides[o.PartID]; !ok {
+			lk.overrides[o.PartID] = o
+		}
+	}
+	return overrides.Err()
+}
+
+func scanMAPOverride(row database.Scanner) (MAPOverride, error) {
+	var o MAPOverride
+	var partNumber, reason, approvedBy *string
+	var added *time.Time
+	err := row.Scan(&o.ID, &o.CustID, &o.PartID, &partNumber, &o.Price, &reason, &approvedBy, &o.Expires, &added)
+	if err != nil {
+		return o, err
+	}
+	if partNumber != nil {
+		o.PartNumber = *partNumber
+	}
+	if reason != nil {
+		o.Reason = *reason
+	}
+	if approvedBy != nil {
+		o.ApprovedBy = *approvedBy
+	}
+	if added != nil {
+		o.DateAdded = *added
+	}
+	return o, nil
+}
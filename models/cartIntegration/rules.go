@@ -0,0 +1,548 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// PricingRule prices every part it matches as a percentage and/or amount
+// off one of the part's manufacturer prices. A customer's rules are tried
+// in priority order, lowest first, and the first that matches a part is the
+// only one applied to it. Empty match fields match every part.
+type PricingRule struct {
+	ID         int     `json:"id,omitempty" xml:"id,attr,omitempty"`
+	CustID     int     `json:"custId,omitempty" xml:"custId,attr,omitempty"`
+	Priority   int     `json:"priority" xml:"priority,attr"`
+	Name       string  `json:"name,omitempty" xml:"name,omitempty"`
+	BrandID    int     `json:"brandId,omitempty" xml:"brandId,omitempty"`
+	CategoryID int     `json:"categoryId,omitempty" xml:"categoryId,omitempty"`
+	Class      string  `json:"class,omitempty" xml:"class,omitempty"`
+	PriceCode  string  `json:"priceCode,omitempty" xml:"priceCode,omitempty"`
+	Base       string  `json:"base" xml:"base"`
+	Percent    float64 `json:"percent,omitempty" xml:"percent,omitempty"`
+	Amount     float64 `json:"amount,omitempty" xml:"amount,omitempty"`
+	Rounding   string  `json:"rounding,omitempty" xml:"rounding,omitempty"`
+	FloorMAP   bool    `json:"floorMap,omitempty" xml:"floorMap,omitempty"`
+}
+
+var (
+	// BaseTypes are the manufacturer prices a rule can be based on.
+	BaseTypes = []string{"Map", "List", "Jobber"}
+
+	ErrRuleNotFound = errors.New("no pricing rule with that ID")
+)
+
+var (
+	// CustomerPricingRule(ruleID, cust_id, priority, name, brandID, catID,
+	// class, priceCode, basePrice, percent, amount, rounding, floorMap)
+	getPricingRules = `select ruleID, cust_id, priority, name, brandID, catID, class, priceCode, basePrice, percent, amount, rounding, floorMap
+		from CustomerPricingRule
+		where cust_id = ?
+		order by priority, ruleID`
+	getPricingRule = `select ruleID, cust_id, priority, name, brandID, catID, class, priceCode, basePrice, percent, amount, rounding, floorMap
+		from CustomerPricingRule
+		where ruleID = ? and cust_id = ?`
+	insertPricingRule = `insert into CustomerPricingRule(cust_id, priority, name, brandID, catID, class, priceCode, basePrice, percent, amount, rounding, floorMap)
+		values (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	updatePricingRule = `update CustomerPricingRule set priority = ?, name = ?, brandID = ?, catID = ?, class = ?, priceCode = ?, basePrice = ?, percent = ?, amount = ?, rounding = ?, floorMap = ?
+		where ruleID = ? and cust_id = ?`
+	deletePricingRule = `delete from CustomerPricingRule where ruleID = ? and cust_id = ?`
+
+	getRuleSubjects = `select p.partID, p.oldPartNumber, p.brandID, p.priceCode, pc.class, group_concat(cp.catID)
+		from Part as p
+		left join Class as pc on p.classID = pc.classID
+		left join CatPart as cp on cp.partID = p.partID
+		where p.status in (700, 800, 810, 815, 850, 870, 888, 900, 910, 950) && p.brandID = ?
+		group by p.partID
+		order by p.oldPartNumber`
+	getCategoryParents = `select catID, parentID from Categories`
+)
+
+// ruleSubject is what rules are matched against for a single part.
+type ruleSubject struct {
+	partID     int
+	partNumber string
+	brandID    int
+	categories []int
+	class      string
+	priceCode  string
+	prices     map[string]float64 // lower case price type to price
+}
+
+// Validate checks that the rule can be evaluated.
+func (r *PricingRule) Validate() error {
+	if r.Priority < 0 {
+		return errors.New("priority cannot be negative")
+	}
+	base := ""
+	for _, t := range BaseTypes {
+		if strings.EqualFold(t, r.Base) {
+			base = t
+		}
+	}
+	if base == "" {
+		return fmt.Errorf("base must be one of %s", strings.Join(BaseTypes, ", "))
+	}
+	r.Base = base
+	if math.IsNaN(r.Percent) || math.IsInf(r.Percent, 0) || r.Percent <= -100 {
+		return errors.New("percent must be greater than -100")
+	}
+	if math.IsNaN(r.Amount) || math.IsInf(r.Amount, 0) {
+		return errors.New("amount is not a number")
+	}
+	if _, ok := roundingCents(r.Rounding); !ok {
+		return fmt.Errorf("rounding %q must be a price ending such as .99", r.Rounding)
+	}
+	return nil
+}
+
+// GetPricingRules returns the customer's rules in the order they're tried.
+func GetPricingRules(dtx *apicontext.DataContext) ([]PricingRule, error) {
+	var rules []PricingRule
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return rules, err
+	}
+	err = database.Init()
+	if err != nil {
+		return rules, err
+	}
+
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getPricingRules)
+	if err != nil {
+		return rules, err
+	}
+	defer stmt.Close()
+	res, err := stmt.QueryContext(dtx.Context(), custID)
+	if err != nil {
+		return rules, err
+	}
+	defer res.Close()
+	for res.Next() {
+		r, err := scanRule(res)
+		if err != nil {
+			return rules, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, res.Err()
+}
+
+// Get loads the rule by ID, if the customer owns it.
+func (r *PricingRule) Get(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := database.DB.PrepareContext(dtx.Context(), getPricingRule)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	rule, err := scanRule(stmt.QueryRowContext(dtx.Context(), r.ID, custID))
+	if err == sql.ErrNoRows {
+		return ErrRuleNotFound
+	}
+	if err != nil {
+		return err
+	}
+	*r = rule
+	return nil
+}
+
+func (r *PricingRule) Create(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	r.CustID = custID
+	if err = r.Validate(); err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := database.DB.PrepareContext(dtx.Context(), insertPricingRule)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(dtx.Context(), r.CustID, r.Priority, r.Name, r.BrandID, r.CategoryID, r.Class, r.PriceCode, r.Base, r.Percent, r.Amount, r.Rounding, r.FloorMAP)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ID = int(id)
+	return nil
+}
+
+func (r *PricingRule) Update(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	r.CustID = custID
+	if err = r.Validate(); err != nil {
+		return err
+	}
+	// MySQL reports no affected rows for an update that changes nothing, so
+	// ownership is checked up front
+	existing := PricingRule{ID: r.ID}
+	if err = existing.Get(dtx); err != nil {
+		return err
+	}
+
+	stmt, err := database.DB.PrepareContext(dtx.Context(), updatePricingRule)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	_, err = stmt.ExecContext(dtx.Context(), r.Priority, r.Name, r.BrandID, r.CategoryID, r.Class, r.PriceCode, r.Base, r.Percent, r.Amount, r.Rounding, r.FloorMAP, r.ID, r.CustID)
+	return err
+}
+
+func (r *PricingRule) Delete(dtx *apicontext.DataContext) error {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	r.CustID = custID
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	stmt, err := database.DB.PrepareContext(dtx.Context(), deletePricingRule)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(dtx.Context(), r.ID, r.CustID)
+	if err != nil {
+		return err
+	}
+	return requireRow(res)
+}
+
+// requireRow turns a delete that matched nothing into ErrRuleNotFound,
+// rather than reporting success for another customer's rule.
+func requireRow(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrRuleNotFound
+	}
+	return nil
+}
+
+// PreviewRules evaluates the customer's rules against every active part in
+// the brand and reports the prices they would set, without writing
+// anything. Parts no rule matches are left out.
+func PreviewRules(dtx *apicontext.DataContext) (*UploadReport, error) {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := GetPricingRules(dtx)
+	if err != nil {
+		return nil, err
+	}
+	subjects, err := loadRuleSubjects(dtx)
+	if err != nil {
+		return nil, err
+	}
+	parents, err := loadCategoryParents(dtx)
+	if err != nil {
+		return nil, err
+	}
+	lk, err := loadUploadLookups(dtx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := evaluateRules(custID, rules, subjects, parents, lk)
+	report.BatchID, err = newBatchID()
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = true
+	return report, nil
+}
+
+// ApplyRules evaluates the customer's rules and writes the resulting
+// prices in a single transaction. If any part can't be priced, the report
+// is returned with ErrInvalidUpload and nothing is written.
+func ApplyRules(dtx *apicontext.DataContext) (*UploadReport, error) {
+	report, err := PreviewRules(dtx)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = false
+	return report, applyReport(dtx, report, SourceRules)
+}
+
+// evaluateRules prices each subject by the first matching rule and diffs
+// the result against the customer's regular prices. Sales and cart
+// integrations are left as they are.
+func evaluateRules(custID int, rules []PricingRule, subjects []ruleSubject, parents map[int]int, lk uploadLookups) *UploadReport {
+	rules = append([]PricingRule(nil), rules...)
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+
+	report := &UploadReport{}
+	for _, s := range subjects {
+		for _, rule := range rules {
+			if !rule.matches(s, parents) {
+				continue
+			}
+			row := UploadRow{
+				Line:            len(report.Rows) + 1,
+				PartNumber:      s.partNumber,
+				RuleID:          rule.ID,
+				keepIntegration: true,
+			}
+			price, ok := rule.price(&row, s, lk.mapPrices[s.partID])
+			if ok {
+				row.Price = &CustomerPrice{CustID: custID, PartID: s.partID, PartNumber: s.partNumber, Price: price}
+				row.diff(lk)
+			} else if len(row.Errors) == 0 {
+				row.Action = ActionUnchanged
+			}
+			if len(row.Errors) > 0 {
+				row.Price = nil
+				row.Previous = nil
+				row.Action = ""
+			}
+			report.add(row)
+			break
+		}
+	}
+	return report
+}
+
+func (r PricingRule) matches(s ruleSubject, parents map[int]int) bool {
+	if r.BrandID > 0 && r.BrandID != s.brandID {
+		return false
+	}
+	if r.Class != "" && !strings.EqualFold(r.Class, s.class) {
+		return false
+	}
+	if r.PriceCode != "" && !strings.EqualFold(r.PriceCode, s.priceCode) {
+		return false
+	}
+	if r.CategoryID > 0 {
+		for _, cat := range s.categories {
+			if inCategory(cat, r.CategoryID, parents) {
+				return true
+			}
+		}
+		return false
+	}
+	return true
+}
+
+// inCategory reports whether cat is category or one of its descendants.
+func inCategory(cat, category int, parents map[int]int) bool {
+	for seen := 0; cat > 0 && seen <= len(parents); seen++ {
+		if cat == category {
+			return true
+		}
+		cat = parents[cat]
+	}
+	return false
+}
+
+// price works out the rule's price for s. Rounding is down to the nearest
+// price with the rule's ending; if that, or the adjustment itself, takes a
+// "never below MAP" price under MAP, it goes up to the next price with the
+// ending at or above MAP instead. It returns false, with a warning, if the
+// part has no base price, or with an error if the result isn't a price.
+func (r PricingRule) price(row *UploadRow, s ruleSubject, mapPrice float64) (float64, bool) {
+	base := s.prices[strings.ToLower(r.Base)]
+	if base <= 0 {
+		row.Warnings = append(row.Warnings, fmt.Sprintf("no %s price; left unchanged", r.Base))
+		return 0, false
+	}
+
+	c := cents(base*(1+r.Percent/100) + r.Amount)
+	ending, _ := roundingCents(r.Rounding)
+	if ending >= 0 {
+		c -= mod(c-ending, 100)
+	}
+	if floor := cents(mapPrice); r.FloorMAP && floor > 0 && c < floor {
+		c = floor
+		if ending >= 0 {
+			c += mod(ending-c, 100)
+		}
+	}
+	if c <= 0 {
+		row.Errors = append(row.Errors, fmt.Sprintf("rule %d prices the part at %.2f", r.ID, float64(c)/100))
+		return 0, false
+	}
+	return float64(c) / 100, true
+}
+
+// roundingCents parses a price ending such as ".99", returning -1 for no
+// rounding.
+func roundingCents(rounding string) (int64, bool) {
+	if rounding == "" {
+		return -1, true
+	}
+	if len(rounding) != 3 || rounding[0] != '.' {
+		return 0, false
+	}
+	n, err := strconv.Atoi(rounding[1:])
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return int64(n), true
+}
+
+func mod(a, b int64) int64 {
+	return ((a % b) + b) % b
+}
+
+func loadRuleSubjects(dtx *apicontext.DataContext) ([]ruleSubject, error) {
+	var subjects []ruleSubject
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return subjects, err
+	}
+	err = database.Init()
+	if err != nil {
+		return subjects, err
+	}
+
+	prices, err := GetPartPrices(dtx)
+	if err != nil {
+		return subjects, err
+	}
+	priceMap := make(map[int]map[string]float64)
+	for _, p := range prices {
+		if priceMap[p.PartID] == nil {
+			priceMap[p.PartID] = make(map[string]float64)
+		}
+		priceMap[p.PartID][strings.ToLower(p.Type)] = p.Price
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getRuleSubjects, brandID)
+	if err != nil {
+		return subjects, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s ruleSubject
+		var partNumber, priceCode, class, cats *string
+		if err = rows.Scan(&s.partID, &partNumber, &s.brandID, &priceCode, &class, &cats); err != nil {
+			return subjects, err
+		}
+		if partNumber != nil {
+			s.partNumber = *partNumber
+		}
+		if priceCode != nil {
+			s.priceCode = *priceCode
+		}
+		if class != nil {
+			s.class = *class
+		}
+		if cats != nil {
+			for _, c := range strings.Split(*cats, ",") {
+				if id, err := strconv.Atoi(c); err == nil {
+					s.categories = append(s.categories, id)
+				}
+			}
+		}
+		s.prices = priceMap[s.partID]
+		subjects = append(subjects, s)
+	}
+	return subjects, rows.Err()
+}
+
+func loadCategoryParents(dtx *apicontext.DataContext) (map[int]int, error) {
+	parents := make(map[int]int)
+	err := database.Init()
+	if err != nil {
+		return parents, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getCategoryParents)
+	if err != nil {
+		return parents, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var parent *int
+		if err = rows.Scan(&id, &parent); err != nil {
+			return parents, err
+		}
+		if parent != nil {
+			parents[id] = *parent
+		}
+	}
+	return parents, rows.Err()
+}
+
+func scanRule(row database.Scanner) (PricingRule, error) {
+	var r PricingRule
+	var name, class, priceCode, rounding *string
+	var brandID, catID *int
+	err := row.Scan(
+		&r.ID,
+		&r.CustID,
+		&r.Priority,
+		&name,
+		&brandID,
+		&catID,
+		&class,
+		&priceCode,
+		&r.Base,
+		&r.Percent,
+		&r.Amount,
+		&rounding,
+		&r.FloorMAP,
+	)
+	if err != nil {
+		return r, err
+	}
+	if name != nil {
+		r.Name = *name
+	}
+	if brandID != nil {
+		r.BrandID = *brandID
+	}
+	if catID != nil {
+		r.CategoryID = *catID
+	}
+	if class != nil {
+		r.Class = *class
+	}
+	if priceCode != nil {
+		r.PriceCode = *priceCode
+	}
+	if rounding != nil {
+		r.Rounding = *rounding
+	}
+	return r, nil
+}
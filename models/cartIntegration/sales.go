@@ -0,0 +1,190 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+
+	"context"
+	"database/sql"
+	"log"
+	"sort"
+	"time"
+)
+
+// A customer's price for a part is a regular price (isSale = 0) and/or any
+// number of sales (isSale = 1), each priced for the days from SaleStart to
+// SaleEnd inclusive. Outside of its window a sale isn't a price at all.
+
+// SalesResp lists a customer's sales that haven't ended.
+type SalesResp struct {
+	Active   []CustomerPrice `json:"active" xml:"active>price"`
+	Upcoming []CustomerPrice `json:"upcoming" xml:"upcoming>price"`
+}
+
+var (
+	getCustomerSales = `select cp.cust_price_id, cp.cust_id, p.partID, p.oldPartNumber, ci.referenceID, ci.custPartID, cp.price, cp.isSale, cp.sale_start, cp.sale_end, pr.priceType, pr.price
+		from CustomerPricing as cp
+		join Part as p on p.partID = cp.partID
+		left join CartIntegration as ci on ci.partID = cp.partID and ci.custID = cp.cust_id
+		left join Price as pr on pr.partID = cp.partID and pr.priceType = 'list'
+		where cp.cust_id = ? && p.brandID = ? && cp.isSale = 1 && cp.sale_end >= ?
+		order by cp.sale_start, p.oldPartNumber`
+
+	// CustomerPricingArchive has the columns of CustomerPricing, keyed by
+	// cust_price_id, and the time the sale was archived.
+	archiveExpiredSales = `insert ignore into CustomerPricingArchive(cust_price_id, cust_id, partID, price, isSale, sale_start, sale_end, archived)
+		select cust_price_id, cust_id, partID, price, isSale, sale_start, sale_end, ?
+		from CustomerPricing
+		where isSale = 1 && sale_end < ?`
+	deleteExpiredSales = `delete from CustomerPricing where isSale = 1 && sale_end < ?`
+)
+
+// EffectivePrice returns the price in effect at now among a customer's
+// prices for a single part: the lowest sale whose window contains now, or
+// else the regular price. It returns false if neither applies.
+func EffectivePrice(prices []CustomerPrice, now time.Time) (CustomerPrice, bool) {
+	var effective CustomerPrice
+	var sale, regular bool
+	for _, cp := range prices {
+		switch {
+		case cp.IsSale == 0:
+			if !sale && !regular {
+				effective, regular = cp, true
+			}
+		case cp.SaleActive(now):
+			if !sale || cp.Price < effective.Price {
+				effective, sale = cp, true
+			}
+		}
+	}
+	return effective, sale || regular
+}
+
+// SaleActive reports whether c is a sale and now is within its window.
+func (c *CustomerPrice) SaleActive(now time.Time) bool {
+	return c.IsSale == 1 && c.SaleStart != nil && c.SaleEnd != nil &&
+		!now.Before(*c.SaleStart) && now.Before(saleEnds(*c.SaleEnd))
+}
+
+// saleEnds returns the time a sale ending on end is over. Sale dates are
+// usually whole days, in which case the sale runs through the end of the
+// day.
+func saleEnds(end time.Time) time.Time {
+	if day := startOfDay(end); end.Equal(day) {
+		y, m, d := day.Date()
+		return time.Date(y, m, d+1, 0, 0, 0, 0, day.Location())
+	}
+	return end
+}
+
+// startOfDay returns midnight of t's day in t's time zone.
+func startOfDay(t time.Time) time.Time {
+	y, m, d := t.Date()
+	return time.Date(y, m, d, 0, 0, 0, 0, t.Location())
+}
+
+// GetSales returns the customer's active and upcoming sales for the brand.
+func GetSales(dtx *apicontext.DataContext, now time.Time) (SalesResp, error) {
+	sales := SalesResp{Active: []CustomerPrice{}, Upcoming: []CustomerPrice{}}
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return sales, err
+	}
+	err = database.Init()
+	if err != nil {
+		return sales, err
+	}
+
+	// a day early, so that whole day sales ending today are included
+	rows, err := database.DB.QueryContext(dtx.Context(), getCustomerSales, custID, brandID, now.AddDate(0, 0, -1))
+	if err != nil {
+		return sales, err
+	}
+	defer rows.Close()
+	var prices []CustomerPrice
+	for rows.Next() {
+		cp, err := Scan(rows)
+		if err != nil {
+			return sales, err
+		}
+		prices = append(prices, cp)
+	}
+	if err = rows.Err(); err != nil {
+		return sales, err
+	}
+	return splitSales(prices, now), nil
+}
+
+// splitSales sorts sales into those active at now and those yet to start,
+// each ordered by start date. Sales that have ended are left out.
+func splitSales(prices []CustomerPrice, now time.Time) SalesResp {
+	sales := SalesResp{Active: []CustomerPrice{}, Upcoming: []CustomerPrice{}}
+	for _, cp := range prices {
+		switch {
+		case cp.SaleActive(now):
+			sales.Active = append(sales.Active, cp)
+		case cp.IsSale == 1 && cp.SaleStart != nil && cp.SaleStart.After(now):
+			sales.Upcoming = append(sales.Upcoming, cp)
+		}
+	}
+	byStart := func(s []CustomerPrice) func(i, j int) bool {
+		return func(i, j int) bool { return s[i].SaleStart.Before(*s[j].SaleStart) }
+	}
+	sort.SliceStable(sales.Active, byStart(sales.Active))
+	sort.SliceStable(sales.Upcoming, byStart(sales.Upcoming))
+	return sales
+}
+
+// ArchiveExpiredSales moves every sale that ended before the start of
+// now's day, in the database's time zone, to CustomerPricingArchive,
+// recording the deletes in the price history as a single batch, and
+// returns how many were removed. It's safe to run from several instances
+// at once.
+func ArchiveExpiredSales(ctx context.Context, now time.Time) (int64, error) {
+	err := database.Init()
+	if err != nil {
+		return 0, err
+	}
+	batchID, err := newBatchID()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := startOfDay(now.In(database.Location))
+
+	var n int64
+	err = inTx(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, archiveExpiredHistory, batchID, now, cutoff); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, archiveExpiredSales, now, cutoff); err != nil {
+			return err
+		}
+		res, err := tx.ExecContext(ctx, deleteExpiredSales, cutoff)
+		if err != nil {
+			return err
+		}
+		n, err = res.RowsAffected()
+		return err
+	})
+	return n, err
+}
+
+// ArchiveSalesEvery runs ArchiveExpiredSales every interval until ctx is
+// done.
+func ArchiveSalesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := ArchiveExpiredSales(ctx, now)
+			if err != nil {
+				log.Printf("Error archiving expired sales: %s\n", err)
+			} else if n > 0 {
+				log.Printf("Archived %d expired sales\n", n)
+			}
+		}
+	}
+}
@@ -0,0 +1,326 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/tealeg/xlsx"
+
+	"encoding/csv"
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	XLSX_CONTENT_TYPE = "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+)
+
+var (
+	// ExportHeader is the header row of a downloaded price sheet. The
+	// first five columns can be edited and uploaded again; the rest are
+	// for reference and are ignored on upload.
+	ExportHeader = []string{
+		"CURT Part Number",
+		"Customer Part ID",
+		"Sale Price",
+		"Sale Start Date",
+		"Sale End Date",
+		"Map Price",
+		"List Price",
+		"Description",
+		"Category",
+	}
+
+	getPartDetails = `select p.partID, p.shortDesc, (
+			select c.catTitle from CatPart as cp
+			join Categories as c on c.catID = cp.catID
+			where cp.partID = p.partID
+			order by c.catID
+			limit 1
+		) as category
+		from Part as p
+		where p.status in (700, 800, 810, 815, 850, 870, 888, 900, 910, 950) && p.brandID = ?`
+)
+
+// columns are the positions of the fields of a price sheet, -1 for any the
+// sheet doesn't have.
+type columns struct {
+	part, custPart, price, saleStart, saleEnd int
+}
+
+// positional is the layout of sheets without a header row, which is the
+// order of ExportHeader.
+var positional = columns{part: 0, custPart: 1, price: 2, saleStart: 3, saleEnd: 4}
+
+// columnNames maps normalized header names to the field they hold.
+var columnNames = map[string]string{
+	"curtpartnumber":     "part",
+	"curtpartid":         "part",
+	"partnumber":         "part",
+	"part":               "part",
+	"customerpartid":     "custPart",
+	"customerpartnumber": "custPart",
+	"custpartid":         "custPart",
+	"saleprice":          "price",
+	"price":              "price",
+	"customerprice":      "price",
+	"salestartdate":      "saleStart",
+	"salestart":          "saleStart",
+	"startdate":          "saleStart",
+	"saleenddate":        "saleEnd",
+	"saleend":            "saleEnd",
+	"enddate":            "saleEnd",
+}
+
+// uploadColumns maps the fields of a price sheet by its header row, so
+// that columns can be in any order and extra columns are ignored. It
+// returns false if line isn't a header.
+func uploadColumns(line []string) (columns, bool) {
+	cols := columns{part: -1, custPart: -1, price: -1, saleStart: -1, saleEnd: -1}
+	found := false
+	for i, name := range line {
+		var idx *int
+		switch columnNames[normalizeColumn(name)] {
+		case "part":
+			idx = &cols.part
+		case "custPart":
+			idx = &cols.custPart
+		case "price":
+			idx = &cols.price
+		case "saleStart":
+			idx = &cols.saleStart
+		case "saleEnd":
+			idx = &cols.saleEnd
+		default:
+			continue
+		}
+		if *idx < 0 {
+			*idx = i
+		}
+		found = true
+	}
+	return cols, found
+}
+
+func normalizeColumn(name string) string {
+	return strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return -1
+	}, name)
+}
+
+// field returns the trimmed value at idx, or "" if the line is too short
+// or the sheet doesn't have the column.
+func (c columns) field(line []string, idx int) string {
+	if idx < 0 || idx >= len(line) {
+		return ""
+	}
+	return strings.TrimSpace(line[idx])
+}
+
+func blank(line []string) bool {
+	for _, v := range line {
+		if strings.TrimSpace(v) != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// ReadCSV reads the lines of an uploaded CSV price sheet.
+func ReadCSV(r io.Reader) ([][]string, error) {
+	csvfile := csv.NewReader(r)
+	csvfile.FieldsPerRecord = -1
+	csvfile.TrimLeadingSpace = true
+	return csvfile.ReadAll()
+}
+
+// ReadXLSX reads the lines of the first worksheet of an uploaded XLSX
+// price sheet. Numbers are read as entered rather than as displayed, and
+// dates are formatted as DATE_FORMAT.
+func ReadXLSX(r io.ReaderAt, size int64) ([][]string, error) {
+	f, err := xlsx.OpenReaderAt(r, size)
+	if err != nil {
+		return nil, err
+	}
+	if len(f.Sheets) == 0 {
+		return nil, errors.New("the workbook has no worksheets")
+	}
+
+	var lines [][]string
+	for _, row := range f.Sheets[0].Rows {
+		var line []string
+		if row != nil {
+			line = make([]string, len(row.Cells))
+			for i, cell := range row.Cells {
+				line[i] = cellValue(cell, f.Date1904)
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines, nil
+}
+
+func cellValue(cell *xlsx.Cell, date1904 bool) string {
+	if cell == nil {
+		return ""
+	}
+	if cell.Type() != xlsx.CellTypeNumeric {
+		return cell.String()
+	}
+	if isDateFormat(cell.GetNumberFormat()) {
+		if f, err := cell.Float(); err == nil {
+			return xlsx.TimeFromExcelTime(f, date1904).Format(DATE_FORMAT)
+		}
+	}
+	return cell.Value
+}
+
+// isDateFormat reports whether an Excel number format displays a date,
+// ignoring quoted literals and [colour] or [$-locale] sections.
+func isDateFormat(format string) bool {
+	literal, section := false, false
+	for _, r := range strings.ToLower(format) {
+		switch {
+		case r == '"':
+			literal = !literal
+		case literal:
+		case r == '[':
+			section = true
+		case r == ']':
+			section = false
+		case section:
+		case r == 'd' || r == 'y':
+			return true
+		}
+	}
+	return false
+}
+
+// ExportRows returns the customer's price sheet, headed by ExportHeader,
+// with a row for every active part in the brand. Parts the customer has
+// no price for have a blank price, which is skipped on upload.
+func ExportRows(dtx *apicontext.DataContext) ([][]string, error) {
+	customerPrices, err := GetCustomerPrices(dtx, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	prices, err := GetPartPrices(dtx)
+	if err != nil {
+		return nil, err
+	}
+	priceMap := make(map[string]float64)
+	for _, p := range prices {
+		priceMap[strconv.Itoa(p.PartID)+":"+strings.ToLower(p.Type)] = p.Price
+	}
+
+	details, err := partDetails(dtx)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := [][]string{ExportHeader}
+	for _, price := range customerPrices.Items {
+		var custPart, salePrice, start, end string
+		if price.CustomerPartID != 0 {
+			custPart = strconv.Itoa(price.CustomerPartID)
+		}
+		if price.ID > 0 {
+			salePrice = strconv.FormatFloat(price.Price, 'f', 2, 64)
+		}
+		if price.SaleStart != nil && !price.SaleStart.IsZero() {
+			start = price.SaleStart.Format(DATE_FORMAT)
+		}
+		if price.SaleEnd != nil && !price.SaleEnd.IsZero() {
+			end = price.SaleEnd.Format(DATE_FORMAT)
+		}
+		d := details[price.PartID]
+		lines = append(lines, []string{
+			price.PartNumber,
+			custPart,
+			salePrice,
+			start,
+			end,
+			strconv.FormatFloat(priceMap[strconv.Itoa(price.PartID)+":map"], 'f', 2, 64),
+			strconv.FormatFloat(priceMap[strconv.Itoa(price.PartID)+":list"], 'f', 2, 64),
+			d.description,
+			d.category,
+		})
+	}
+	return lines, nil
+}
+
+// WriteXLSX writes lines, as returned by ExportRows, as a workbook with a
+// single worksheet. Prices are written as numbers so that they can be
+// edited as such.
+func WriteXLSX(w io.Writer, lines [][]string) error {
+	f := xlsx.NewFile()
+	sheet, err := f.AddSheet("Pricing")
+	if err != nil {
+		return err
+	}
+
+	numeric := map[int]bool{2: true, 5: true, 6: true}
+	for i, line := range lines {
+		row := sheet.AddRow()
+		for j, v := range line {
+			cell := row.AddCell()
+			if i == 0 || v == "" {
+				cell.SetString(v)
+				continue
+			}
+			if n, err := strconv.Atoi(v); err == nil && j == 1 {
+				cell.SetInt(n)
+			} else if f, err := strconv.ParseFloat(v, 64); err == nil && numeric[j] {
+				cell.SetFloatWithFormat(f, "0.00")
+			} else {
+				cell.SetString(v)
+			}
+		}
+	}
+	return f.Write(w)
+}
+
+type partDetail struct {
+	description string
+	category    string
+}
+
+func partDetails(dtx *apicontext.DataContext) (map[int]partDetail, error) {
+	details := make(map[int]partDetail)
+	_, brandID, err := tenant(dtx)
+	if err != nil {
+		return details, err
+	}
+	err = database.Init()
+	if err != nil {
+		return details, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getPartDetails, brandID)
+	if err != nil {
+		return details, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var id int
+		var desc, cat *string
+		if err = rows.Scan(&id, &desc, &cat); err != nil {
+			return details, err
+		}
+		var d partDetail
+		if desc != nil {
+			d.description = *desc
+		}
+		if cat != nil {
+			d.category = *cat
+		}
+		details[id] = d
+	}
+	return details, rows.Err()
+}
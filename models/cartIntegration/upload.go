@@ -0,0 +1,510 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/redis"
+
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	DATE_FORMAT = "2006-01-02"
+
+	ActionCreate    = "create"
+	ActionUpdate    = "update"
+	ActionUnchanged = "unchanged"
+
+	// pending dry runs can be confirmed for an hour
+	pendingUploadExpiry = 60 * 60
+)
+
+var (
+	ErrInvalidUpload  = errors.New("the upload has errors and was not applied")
+	ErrUploadNotFound = errors.New("no pending upload with that batch ID; it may have expired or already been applied")
+)
+
+// UploadRow is the outcome of validating a single line of an uploaded CSV,
+// or of pricing a single part by a PricingRule, with the rule's ID.
+// Price is what the line would write and Previous is what it replaces, for
+// updates. Rows with errors, and duplicate rows, have no Action.
+type UploadRow struct {
+	Line       int            `json:"line" xml:"line,attr"`
+	PartNumber string         `json:"partNumber" xml:"partNumber,attr"`
+	RuleID     int            `json:"ruleId,omitempty" xml:"ruleId,attr,omitempty"`
+	Action     string         `json:"action,omitempty" xml:"action,attr,omitempty"`
+	Price      *CustomerPrice `json:"price,omitempty" xml:"price,omitempty"`
+	Previous   *CustomerPrice `json:"previous,omitempty" xml:"previous,omitempty"`
+	Errors     []string       `json:"errors,omitempty" xml:"error,omitempty"`
+	Warnings   []string       `json:"warnings,omitempty" xml:"warning,omitempty"`
+
+	integrationExists  bool
+	integrationChanged bool
+	keepIntegration    bool // the sheet has no customer part ID column
+}
+
+type UploadSummary struct {
+	Rows      int `json:"rows" xml:"rows"`
+	Created   int `json:"created" xml:"created"`
+	Updated   int `json:"updated" xml:"updated"`
+	Unchanged int `json:"unchanged" xml:"unchanged"`
+	Errors    int `json:"errors" xml:"errors"`
+	Warnings  int `json:"warnings" xml:"warnings"`
+}
+
+// UploadReport describes what an upload would change, and whether it was
+// applied. An upload is all or nothing: if any row has an error, nothing
+// is written.
+type UploadReport struct {
+	BatchID string        `json:"batchId" xml:"batchId,attr"`
+	DryRun  bool          `json:"dryRun" xml:"dryRun,attr"`
+	Applied bool          `json:"applied" xml:"applied,attr"`
+	Errors  []string      `json:"errors,omitempty" xml:"error,omitempty"`
+	Summary UploadSummary `json:"summary" xml:"summary"`
+	Rows    []UploadRow   `json:"rows" xml:"rows>row"`
+}
+
+// Valid reports whether the upload can be applied.
+func (u *UploadReport) Valid() bool {
+	return len(u.Errors) == 0 && u.Summary.Errors == 0
+}
+
+// uploadLookups is the state an upload is validated and diffed against.
+type uploadLookups struct {
+	parts        map[string]int          // part number to part ID, for the brand
+	prices       map[int]CustomerPrice   // part ID to the customer's regular price
+	sales        map[int][]CustomerPrice // part ID to the customer's sales
+	integrations map[int]int             // part ID to the customer's part ID
+	mapPrices    map[int]float64         // part ID to MAP price
+	enforced     map[int]bool            // part ID to whether its MAP is enforced
+	overrides    map[int]MAPOverride     // part ID to the customer's MAP override
+}
+
+type pendingUpload struct {
+	BrandID int        `json:"brandId"`
+	Lines   [][]string `json:"lines"`
+}
+
+// ValidateUpload validates every line of a price sheet against the
+// catalog and the customer's current prices, without writing anything.
+// Lines come from ReadCSV or ReadXLSX; see uploadColumns for the columns
+// that are understood.
+func ValidateUpload(dtx *apicontext.DataContext, lines [][]string) (*UploadReport, error) {
+	batchID, err := newBatchID()
+	if err != nil {
+		return nil, err
+	}
+	return validateLines(dtx, batchID, lines)
+}
+
+// UploadFile validates a price sheet and, if every row is valid, applies
+// it. Otherwise the report is returned with ErrInvalidUpload and nothing
+// is written.
+func UploadFile(dtx *apicontext.DataContext, lines [][]string) (*UploadReport, error) {
+	report, err := ValidateUpload(dtx, lines)
+	if err != nil {
+		return nil, err
+	}
+	return report, ApplyUpload(dtx, report)
+}
+
+// DryRun validates a price sheet and, if every row is valid, keeps it for
+// an hour so that it can be applied with ConfirmUpload.
+func DryRun(dtx *apicontext.DataContext, lines [][]string) (*UploadReport, error) {
+	report, err := ValidateUpload(dtx, lines)
+	if err != nil {
+		return nil, err
+	}
+	report.DryRun = true
+	if !report.Valid() {
+		return report, nil
+	}
+
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	err = redis.Setex(pendingUploadKey(custID, report.BatchID), pendingUpload{BrandID: brandID, Lines: lines}, pendingUploadExpiry)
+	return report, err
+}
+
+func validateLines(dtx *apicontext.DataContext, batchID string, lines [][]string) (*UploadReport, error) {
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+	lk, err := loadUploadLookups(dtx)
+	if err != nil {
+		return nil, err
+	}
+
+	report := validateUpload(custID, lines, lk, time.Now())
+	report.BatchID = batchID
+	return report, nil
+}
+
+// ConfirmUpload applies an upload previously validated by DryRun. The rows
+// are validated again first, since prices may have changed in between.
+func ConfirmUpload(dtx *apicontext.DataContext, batchID string) (*UploadReport, error) {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return nil, err
+	}
+
+	key := pendingUploadKey(custID, batchID)
+	data, err := redis.Get(key)
+	if err != nil || len(data) == 0 {
+		return nil, ErrUploadNotFound
+	}
+	var pending pendingUpload
+	if err = json.Unmarshal(data, &pending); err != nil {
+		return nil, err
+	}
+	if pending.BrandID != brandID {
+		return nil, ErrUploadNotFound
+	}
+
+	report, err := validateLines(dtx, batchID, pending.Lines)
+	if err != nil {
+		return nil, err
+	}
+	if err = ApplyUpload(dtx, report); err != nil {
+		return report, err
+	}
+	redis.Delete(key)
+	return report, nil
+}
+
+// ApplyUpload writes every created and updated row of report in a single
+// transaction, recorded in the price history under the report's batch ID.
+// Reports with errors are refused.
+func ApplyUpload(dtx *apicontext.DataContext, report *UploadReport) error {
+	return applyReport(dtx, report, SourceUpload)
+}
+
+func applyReport(dtx *apicontext.DataContext, report *UploadReport, source string) error {
+	if !report.Valid() {
+		return ErrInvalidUpload
+	}
+	custID, _, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	a, err := newAudit(dtx, report.BatchID, source)
+	if err != nil {
+		return err
+	}
+	report.BatchID = a.batchID
+
+	ctx := dtx.Context()
+	tx, err := database.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for i := range report.Rows {
+		row := &report.Rows[i]
+		if row.Price == nil || (row.Action != ActionCreate && row.Action != ActionUpdate) {
+			continue
+		}
+		cp := row.Price
+		cp.CustID = custID
+
+		if row.Action == ActionCreate {
+			err = cp.createAudited(ctx, tx, a)
+		} else {
+			err = cp.updateAudited(ctx, tx, a)
+		}
+		if err == nil && row.integrationChanged {
+			if row.integrationExists {
+				err = cp.updateIntegration(ctx, tx)
+			} else {
+				err = cp.insertIntegration(ctx, tx)
+			}
+		}
+		if err != nil {
+			tx.Rollback()
+			return fmt.Errorf("line %d: %v", row.Line, err)
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return err
+	}
+	report.Applied = true
+	return nil
+}
+
+func loadUploadLookups(dtx *apicontext.DataContext) (uploadLookups, error) {
+	lk := uploadLookups{
+		parts:        make(map[string]int),
+		prices:       make(map[int]CustomerPrice),
+		sales:        make(map[int][]CustomerPrice),
+		integrations: make(map[int]int),
+		mapPrices:    make(map[int]float64),
+		enforced:     make(map[int]bool),
+		overrides:    make(map[int]MAPOverride),
+	}
+
+	// every active part in the brand, with the customer's prices if any
+	current, err := GetCustomerPrices(dtx, 0, 0)
+	if err != nil {
+		return lk, err
+	}
+	for _, cp := range current.Items {
+		lk.parts[cp.PartNumber] = cp.PartID
+		switch {
+		case cp.ID == 0:
+		case cp.IsSale == 1:
+			lk.sales[cp.PartID] = append(lk.sales[cp.PartID], cp)
+		default:
+			lk.prices[cp.PartID] = cp
+		}
+	}
+
+	integrations, err := GetCustomerCartIntegrations(dtx)
+	if err != nil {
+		return lk, err
+	}
+	for _, ci := range integrations {
+		lk.integrations[ci.PartID] = ci.CustomerPartID
+	}
+
+	err = loadMAPPolicy(dtx, &lk)
+	return lk, err
+}
+
+// validateUpload checks every line and diffs it against lk, collecting
+// every problem rather than stopping at the first. A part's regular price
+// and each of its sales are lines of their own, with sales told apart by
+// their start date.
+func validateUpload(custID int, lines [][]string, lk uploadLookups, now time.Time) *UploadReport {
+	report := &UploadReport{}
+	seen := make(map[string]int)
+	today := now.Format(DATE_FORMAT)
+
+	cols, hasHeader := positional, false
+	if len(lines) > 0 {
+		if header, ok := uploadColumns(lines[0]); ok {
+			cols, hasHeader = header, true
+		}
+	}
+	if cols.part < 0 || cols.price < 0 {
+		report.Errors = append(report.Errors, "the header row needs a part number and a price column")
+		return report
+	}
+
+	for i, line := range lines {
+		if (i == 0 && hasHeader) || blank(line) {
+			continue
+		}
+		row := UploadRow{Line: i + 1, PartNumber: cols.field(line, cols.part)}
+		key := row.PartNumber + "\x00" + cols.field(line, cols.saleStart)
+
+		switch {
+		case row.PartNumber == "":
+			row.Errors = append(row.Errors, "part number is required")
+		case seen[key] > 0:
+			row.Warnings = append(row.Warnings, fmt.Sprintf("duplicate of line %d; ignored", seen[key]))
+		case cols.field(line, cols.price) == "":
+			// nothing to change, e.g. a part the customer has no price for
+			// in a downloaded sheet
+			seen[key] = row.Line
+			row.Action = ActionUnchanged
+		default:
+			seen[key] = row.Line
+			row.Price = validateRow(&row, custID, cols, line, lk, today)
+		}
+
+		if len(row.Errors) == 0 && row.Price != nil {
+			row.diff(lk)
+		}
+		if len(row.Errors) > 0 {
+			row.Price = nil
+			row.Previous = nil
+			row.Action = ""
+		}
+		report.add(row)
+	}
+	return report
+}
+
+func validateRow(row *UploadRow, custID int, cols columns, line []string, lk uploadLookups, today string) *CustomerPrice {
+	cp := &CustomerPrice{CustID: custID, PartNumber: row.PartNumber}
+
+	id, ok := lk.parts[row.PartNumber]
+	if !ok {
+		row.Errors = append(row.Errors, fmt.Sprintf("%s is not an active part number for this brand", row.PartNumber))
+	}
+	cp.PartID = id
+
+	row.keepIntegration = cols.custPart < 0
+	if custPart := cols.field(line, cols.custPart); custPart != "" {
+		customerPartID, err := strconv.Atoi(custPart)
+		if err != nil || customerPartID < 0 {
+			row.Errors = append(row.Errors, fmt.Sprintf("customer part ID %q is not a whole number", custPart))
+		}
+		cp.CustomerPartID = customerPartID
+	}
+
+	rawPrice := cols.field(line, cols.price)
+	price, err := strconv.ParseFloat(strings.NewReplacer("$", "", ",", "").Replace(rawPrice), 64)
+	switch {
+	case err != nil || math.IsNaN(price) || math.IsInf(price, 0):
+		row.Errors = append(row.Errors, fmt.Sprintf("price %q is not a number", rawPrice))
+	case price < 0:
+		row.Errors = append(row.Errors, "price cannot be negative")
+	case price == 0:
+		row.Warnings = append(row.Warnings, "price is zero")
+	}
+	cp.Price = price
+
+	start := cols.field(line, cols.saleStart)
+	end := cols.field(line, cols.saleEnd)
+	cp.SaleStart = parseDate(row, "sale start", start)
+	cp.SaleEnd = parseDate(row, "sale end", end)
+	switch {
+	case (start == "") != (end == ""):
+		row.Errors = append(row.Errors, "a sale needs both a start and an end date")
+	case cp.SaleStart != nil && cp.SaleEnd != nil:
+		cp.IsSale = 1
+		if cp.SaleStart.After(*cp.SaleEnd) {
+			row.Errors = append(row.Errors, "sale start date is after the sale end date")
+		} else if end < today {
+			row.Warnings = append(row.Warnings, "sale has already ended")
+		}
+	}
+	return cp
+}
+
+func parseDate(row *UploadRow, name, value string) *time.Time {
+	if value == "" {
+		return nil
+	}
+	t, err := time.Parse(DATE_FORMAT, value)
+	if err != nil {
+		row.Errors = append(row.Errors, fmt.Sprintf("%s date %q is not in the format %s", name, value, DATE_FORMAT))
+		return nil
+	}
+	return &t
+}
+
+// diff decides whether the row creates, updates or leaves alone the
+// customer's current price and cart integration for the part: their sale
+// starting the same day, for sales, or else their regular price. Prices are
+// only held to MAP when they change, so that re-uploading a downloaded
+// sheet never fails on prices that are already in place. Below an enforced
+// MAP is an error unless the customer has an override that allows it;
+// anything else below MAP is a warning.
+func (row *UploadRow) diff(lk uploadLookups) {
+	cp := row.Price
+	custPart, integrated := lk.integrations[cp.PartID]
+	if row.keepIntegration {
+		cp.CustomerPartID = custPart
+	}
+	row.integrationExists = integrated
+	row.integrationChanged = custPart != cp.CustomerPartID
+
+	prev, ok := lk.previous(cp)
+	if !ok || cents(prev.Price) != cents(cp.Price) {
+		var override *MAPOverride
+		if o, ok := lk.overrides[cp.PartID]; ok {
+			override = &o
+		}
+		if v := checkMAP(cp.Price, lk.mapPrices[cp.PartID], lk.enforced[cp.PartID], override); v != nil {
+			switch {
+			case !v.Allowed():
+				row.Errors = append(row.Errors, v.Error())
+				return
+			case v.Enforced:
+				row.Warnings = append(row.Warnings, v.Error()+", allowed by a MAP override")
+			default:
+				row.Warnings = append(row.Warnings, v.Error()+", which isn't enforced")
+			}
+		}
+	}
+	if !ok {
+		row.Action = ActionCreate
+		return
+	}
+
+	cp.ID = prev.ID
+	prev.CustomerPartID = custPart
+	if cents(prev.Price) == cents(cp.Price) && prev.IsSale == cp.IsSale &&
+		sameDate(prev.SaleStart, cp.SaleStart) && sameDate(prev.SaleEnd, cp.SaleEnd) &&
+		!row.integrationChanged {
+		row.Action = ActionUnchanged
+		return
+	}
+	row.Action = ActionUpdate
+	row.Previous = &prev
+}
+
+// previous returns the customer's current price that cp would replace.
+func (lk uploadLookups) previous(cp *CustomerPrice) (CustomerPrice, bool) {
+	if cp.IsSale != 1 {
+		prev, ok := lk.prices[cp.PartID]
+		return prev, ok
+	}
+	for _, sale := range lk.sales[cp.PartID] {
+		if sameDate(sale.SaleStart, cp.SaleStart) {
+			return sale, true
+		}
+	}
+	return CustomerPrice{}, false
+}
+
+func (u *UploadReport) add(row UploadRow) {
+	u.Summary.Rows++
+	switch row.Action {
+	case ActionCreate:
+		u.Summary.Created++
+	case ActionUpdate:
+		u.Summary.Updated++
+	case ActionUnchanged:
+		u.Summary.Unchanged++
+	}
+	if len(row.Errors) > 0 {
+		u.Summary.Errors++
+	}
+	if len(row.Warnings) > 0 {
+		u.Summary.Warnings++
+	}
+	u.Rows = append(u.Rows, row)
+}
+
+func cents(price float64) int64 {
+	return int64(math.Round(price * 100))
+}
+
+func sameDate(a, b *time.Time) bool {
+	if a == nil || a.IsZero() {
+		return b == nil || b.IsZero()
+	}
+	return b != nil && a.Format(DATE_FORMAT) == b.Format(DATE_FORMAT)
+}
+
+func pendingUploadKey(custID int, batchID string) string {
+	return fmt.Sprintf("cartIntegration:upload:%d:%s", custID, batchID)
+}
+
+func newBatchID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,456 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/database"
+
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Webhook event types. Customer price events carry the action and source
+// recorded in the price history; MAP and List events carry the new price.
+const (
+	EventCustomerPrice = "customerPrice"
+	EventMAP           = "map"
+	EventList          = "list"
+)
+
+// WebhookPayload is the JSON body POSTed to a webhook. It's signed with the
+// webhook's secret: the X-Curt-Signature header is "sha256=" followed by the
+// hex HMAC-SHA256 of the X-Curt-Timestamp header, a ".", and the body.
+//
+// A webhook's deliveries are sent one at a time in the order they were
+// made, so a delivery that's being retried holds up those after it until it
+// succeeds or is dead.
+type WebhookPayload struct {
+	DeliveryID string         `json:"deliveryId"`
+	WebhookID  int            `json:"webhookId"`
+	Created    time.Time      `json:"created"`
+	Events     []WebhookEvent `json:"events"`
+}
+
+type WebhookEvent struct {
+	Type       string      `json:"type"`
+	Action     string      `json:"action"`
+	Source     string      `json:"source,omitempty"`
+	BatchID    string      `json:"batchId,omitempty"`
+	PartID     int         `json:"partId"`
+	PartNumber string      `json:"partNumber"`
+	Old        *PriceValue `json:"old,omitempty"`
+	New        *PriceValue `json:"new,omitempty"`
+	Date       time.Time   `json:"date"`
+}
+
+const (
+	webhookBatchSize     = 500
+	webhookMaxAttempts   = 8
+	webhookFirstBackoff  = 30 * time.Second
+	webhookMaxBackoff    = 6 * time.Hour
+	webhookTimeout       = 10 * time.Second
+	webhookLease         = time.Minute
+	webhookBatchesPerRun = 20
+)
+
+// webhookCursor is how far through the price history and Price table a
+// webhook's deliveries have got.
+type webhookCursor struct {
+	historyID     int
+	priceModified time.Time
+	priceID       int
+}
+
+var (
+	getActiveWebhooks = `select webhookID from CustomerWebhook where active = 1`
+	lockWebhook       = `select cust_id, brandID, lastHistoryID, lastPriceModified, lastPriceID from CustomerWebhook
+		where webhookID = ? && active = 1
+		for update`
+	getHistoryEvents = `select h.historyID, h.batchID, h.partID, p.oldPartNumber, h.action, h.source,
+		h.old_price, h.old_isSale, h.old_sale_start, h.old_sale_end, h.new_price, h.new_isSale, h.new_sale_start, h.new_sale_end, h.dateAdded
+		from CustomerPriceHistory as h
+		join Part as p on p.partID = h.partID
+		where h.cust_id = ? && p.brandID = ? && h.historyID > ?
+		order by h.historyID
+		limit ?`
+	getPriceEvents = `select pr.priceID, pr.partID, p.oldPartNumber, pr.priceType, pr.price, pr.dateModified
+		from Price as pr
+		join Part as p on p.partID = pr.partID
+		where p.brandID = ? && pr.priceType in ('Map', 'List')
+		&& (pr.dateModified > ? || (pr.dateModified = ? && pr.priceID > ?))
+		order by pr.dateModified, pr.priceID
+		limit ?`
+	insertDelivery = `insert into CustomerWebhookDelivery(deliveryID, webhookID, status, attempts, events, payload, nextAttempt, dateAdded)
+		values (?, ?, 'pending', 0, ?, ?, ?, ?)`
+	advanceWebhook = `update CustomerWebhook set lastHistoryID = ?, lastPriceModified = ?, lastPriceID = ? where webhookID = ?`
+
+	// the oldest pending delivery of each active webhook
+	getNextDeliveries = `select d.deliveryID, d.webhookID, d.attempts, d.events, d.payload, d.nextAttempt, d.dateAdded, w.url, w.secret
+		from CustomerWebhookDelivery as d
+		join CustomerWebhook as w on w.webhookID = d.webhookID
+		where d.status = 'pending' && w.active = 1 && d.sequence = (
+			select min(o.sequence) from CustomerWebhookDelivery as o
+			where o.webhookID = d.webhookID && o.status = 'pending'
+		)
+		order by d.sequence`
+	claimDelivery = `update CustomerWebhookDelivery set attempts = attempts + 1, nextAttempt = ?
+		where deliveryID = ? && status = 'pending' && attempts = ?`
+	finishDelivery = `update CustomerWebhookDelivery set status = ?, statusCode = ?, lastError = ?, nextAttempt = ?, dateDelivered = ?
+		where deliveryID = ?`
+)
+
+// DispatchWebhooks batches the price changes made since each active
+// webhook's last delivery into new deliveries, then sends every delivery
+// that's due.
+func DispatchWebhooks(ctx context.Context, client *http.Client, now time.Time) error {
+	err := database.Init()
+	if err != nil {
+		return err
+	}
+	if err = queueDeliveries(ctx, now); err != nil {
+		return err
+	}
+	return sendDeliveries(ctx, client, now)
+}
+
+// DispatchWebhooksEvery runs DispatchWebhooks every interval until ctx is
+// done.
+func DispatchWebhooksEvery(ctx context.Context, interval time.Duration) {
+	client := webhookClient()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := DispatchWebhooks(ctx, client, now); err != nil {
+				log.Printf("Error dispatching webhooks: %s\n", err)
+			}
+		}
+	}
+}
+
+func queueDeliveries(ctx context.Context, now time.Time) error {
+	rows, err := database.DB.QueryContext(ctx, getActiveWebhooks)
+	if err != nil {
+		return err
+	}
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		for i := 0; i < webhookBatchesPerRun; i++ {
+			var n int
+			err = inTx(ctx, func(tx *sql.Tx) error {
+				n, err = queueDelivery(ctx, tx, id, now)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("webhook %d: %v", id, err)
+			}
+			if n < webhookBatchSize {
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// queueDelivery locks the webhook, so that only one instance batches its
+// changes at a time, and queues a delivery of up to webhookBatchSize
+// changes. It returns how many it queued.
+func queueDelivery(ctx context.Context, tx *sql.Tx, webhookID int, now time.Time) (int, error) {
+	var custID, brandID int
+	var cur webhookCursor
+	err := tx.QueryRowContext(ctx, lockWebhook, webhookID).Scan(&custID, &brandID, &cur.historyID, &cur.priceModified, &cur.priceID)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	history, next, err := historyEvents(ctx, tx, custID, brandID, cur, webhookBatchSize)
+	if err != nil {
+		return 0, err
+	}
+	prices, next, err := priceEvents(ctx, tx, brandID, next, webhookBatchSize-len(history))
+	if err != nil {
+		return 0, err
+	}
+	events := mergeEvents(history, prices)
+	if len(events) == 0 {
+		return 0, nil
+	}
+
+	id, err := newBatchID()
+	if err != nil {
+		return 0, err
+	}
+	body, err := json.Marshal(WebhookPayload{DeliveryID: id, WebhookID: webhookID, Created: now, Events: events})
+	if err != nil {
+		return 0, err
+	}
+	if _, err = tx.ExecContext(ctx, insertDelivery, id, webhookID, len(events), body, now, now); err != nil {
+		return 0, err
+	}
+	_, err = tx.ExecContext(ctx, advanceWebhook, next.historyID, next.priceModified, next.priceID, webhookID)
+	return len(events), err
+}
+
+func historyEvents(ctx context.Context, tx *sql.Tx, custID, brandID int, cur webhookCursor, limit int) ([]WebhookEvent, webhookCursor, error) {
+	rows, err := tx.QueryContext(ctx, getHistoryEvents, custID, brandID, cur.historyID, limit)
+	if err != nil {
+		return nil, cur, err
+	}
+	defer rows.Close()
+	var events []WebhookEvent
+	for rows.Next() {
+		var e WebhookEvent
+		var batchID, partNumber *string
+		var oldPrice, newPrice *float64
+		var oldSale, newSale *int
+		var o, n PriceValue
+		err = rows.Scan(&cur.historyID, &batchID, &e.PartID, &partNumber, &e.Action, &e.Source,
+			&oldPrice, &oldSale, &o.SaleStart, &o.SaleEnd, &newPrice, &newSale, &n.SaleStart, &n.SaleEnd, &e.Date)
+		if err != nil {
+			return nil, cur, err
+		}
+		e.Type = EventCustomerPrice
+		if batchID != nil {
+			e.BatchID = *batchID
+		}
+		if partNumber != nil {
+			e.PartNumber = *partNumber
+		}
+		e.Old = priceValue(oldPrice, oldSale, o)
+		e.New = priceValue(newPrice, newSale, n)
+		events = append(events, e)
+	}
+	return events, cur, rows.Err()
+}
+
+func priceEvents(ctx context.Context, tx *sql.Tx, brandID int, cur webhookCursor, limit int) ([]WebhookEvent, webhookCursor, error) {
+	if limit < 1 {
+		return nil, cur, nil
+	}
+	rows, err := tx.QueryContext(ctx, getPriceEvents, brandID, cur.priceModified, cur.priceModified, cur.priceID, limit)
+	if err != nil {
+		return nil, cur, err
+	}
+	defer rows.Close()
+	var events []WebhookEvent
+	for rows.Next() {
+		e := WebhookEvent{Action: ActionUpdate, New: &PriceValue{}}
+		var partNumber *string
+		err = rows.Scan(&cur.priceID, &e.PartID, &partNumber, &e.Type, &e.New.Price, &e.Date)
+		if err != nil {
+			return nil, cur, err
+		}
+		cur.priceModified = e.Date
+		e.Type = strings.ToLower(e.Type)
+		if partNumber != nil {
+			e.PartNumber = *partNumber
+		}
+		events = append(events, e)
+	}
+	return events, cur, rows.Err()
+}
+
+func priceValue(price *float64, isSale *int, v PriceValue) *PriceValue {
+	if price == nil {
+		return nil
+	}
+	v.Price = *price
+	if isSale != nil {
+		v.IsSale = *isSale
+	}
+	return &v
+}
+
+// mergeEvents orders customer price and Price table events by date.
+func mergeEvents(history, prices []WebhookEvent) []WebhookEvent {
+	events := append(append([]WebhookEvent{}, history...), prices...)
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Date.Before(events[j].Date)
+	})
+	return events
+}
+
+// pendingDelivery is a delivery along with where it's sent.
+type pendingDelivery struct {
+	WebhookDelivery
+	url    string
+	secret string
+	body   []byte
+}
+
+func sendDeliveries(ctx context.Context, client *http.Client, now time.Time) error {
+	rows, err := database.DB.QueryContext(ctx, getNextDeliveries)
+	if err != nil {
+		return err
+	}
+	var due []pendingDelivery
+	for rows.Next() {
+		var d pendingDelivery
+		d.Status = DeliveryPending
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Attempts, &d.Events, &d.body, &d.NextAttempt, &d.DateAdded, &d.url, &d.secret)
+		if err != nil {
+			rows.Close()
+			return err
+		}
+		if d.NextAttempt == nil || !d.NextAttempt.After(now) {
+			due = append(due, d)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	for _, d := range due {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		// claiming the attempt keeps other instances from sending it
+		// until the lease is up
+		res, err := database.DB.ExecContext(ctx, claimDelivery, now.Add(webhookLease), d.ID, d.Attempts)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			continue
+		}
+		d.Attempts++
+
+		code, sendErr := sendWebhook(ctx, client, d.WebhookID, d.ID, d.url, d.secret, d.body, time.Now())
+		d.attempted(code, sendErr, time.Now())
+		_, err = database.DB.ExecContext(ctx, finishDelivery, d.Status, d.StatusCode, d.LastError, d.NextAttempt, d.DateDelivered, d.ID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attempted records the outcome of the delivery's latest attempt, which
+// Attempts already counts.
+func (d *WebhookDelivery) attempted(statusCode int, err error, now time.Time) {
+	d.StatusCode = statusCode
+	d.NextAttempt = nil
+	if err == nil {
+		d.Status, d.LastError, d.DateDelivered = DeliveryDelivered, "", &now
+		return
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= webhookMaxAttempts {
+		d.Status = DeliveryDead
+		return
+	}
+	next := now.Add(webhookBackoff(d.Attempts))
+	d.Status, d.NextAttempt = DeliveryPending, &next
+}
+
+// webhookBackoff returns how long to wait after the attempt'th failure:
+// webhookFirstBackoff, doubling each time, up to webhookMaxBackoff.
+func webhookBackoff(attempt int) time.Duration {
+	wait := webhookFirstBackoff
+	for i := 1; i < attempt && wait < webhookMaxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > webhookMaxBackoff {
+		wait = webhookMaxBackoff
+	}
+	return wait
+}
+
+// webhookClient returns the client deliveries are sent with. It refuses to
+// connect to addresses that aren't public, whatever a webhook's host
+// resolves to by the time it's delivered to, and through any redirects.
+func webhookClient() *http.Client {
+	dialer := &net.Dialer{Timeout: webhookTimeout, Control: dialPublic}
+	return &http.Client{
+		Timeout: webhookTimeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			TLSHandshakeTimeout: webhookTimeout,
+		},
+	}
+}
+
+func dialPublic(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+		return fmt.Errorf("webhook address %s is not public", host)
+	}
+	return nil
+}
+
+// sendWebhook POSTs a signed delivery and returns the response's status
+// code. Anything other than a 2xx is an error.
+func sendWebhook(ctx context.Context, client *http.Client, webhookID int, deliveryID, url, secret string, body []byte, now time.Time) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CURT-Webhooks/1.0")
+	req.Header.Set("X-Curt-Webhook", strconv.Itoa(webhookID))
+	req.Header.Set("X-Curt-Delivery", deliveryID)
+	req.Header.Set("X-Curt-Timestamp", strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set("X-Curt-Signature", SignWebhook(secret, now, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 512))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	return resp.StatusCode, nil
+}
+
+// SignWebhook returns the X-Curt-Signature of a delivery body sent at
+// timestamp, which receivers compute to verify it.
+func SignWebhook(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10)))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
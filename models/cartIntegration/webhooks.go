@@ -0,0 +1,370 @@
+package cartIntegration
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Webhook is a URL that a customer's cart is told of price changes at.
+// Changes to the customer's prices, and to the MAP and List prices of the
+// brand's parts, are batched into deliveries signed with Secret.
+type Webhook struct {
+	ID        int       `json:"id,omitempty" xml:"id,attr,omitempty"`
+	CustID    int       `json:"custId" xml:"custId,attr"`
+	BrandID   int       `json:"brandId" xml:"brandId,attr"`
+	URL       string    `json:"url" xml:"url"`
+	Secret    string    `json:"secret,omitempty" xml:"secret,omitempty"`
+	Active    bool      `json:"active" xml:"active,attr"`
+	DateAdded time.Time `json:"dateAdded,omitempty" xml:"dateAdded,omitempty"`
+}
+
+// Delivery statuses. Pending deliveries are retried with backoff until they
+// succeed or run out of attempts, after which they're dead until
+// redelivered.
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// WebhookDelivery is a batch of price changes sent to a webhook, and the
+// outcome of its latest attempt.
+type WebhookDelivery struct {
+	ID            string     `json:"id" xml:"id,attr"`
+	WebhookID     int        `json:"webhookId" xml:"webhookId,attr"`
+	Status        string     `json:"status" xml:"status,attr"`
+	Attempts      int        `json:"attempts" xml:"attempts,attr"`
+	Events        int        `json:"events" xml:"events,attr"`
+	StatusCode    int        `json:"statusCode,omitempty" xml:"statusCode,omitempty"`
+	LastError     string     `json:"lastError,omitempty" xml:"lastError,omitempty"`
+	NextAttempt   *time.Time `json:"nextAttempt,omitempty" xml:"nextAttempt,omitempty"`
+	DateAdded     time.Time  `json:"dateAdded" xml:"dateAdded"`
+	DateDelivered *time.Time `json:"dateDelivered,omitempty" xml:"dateDelivered,omitempty"`
+}
+
+type WebhookDeliveryResp struct {
+	Items []WebhookDelivery `json:"items" xml:"items>delivery"`
+	Total int               `json:"total" xml:"total"`
+}
+
+var (
+	ErrWebhookNotFound  = errors.New("no webhook with that ID")
+	ErrDeliveryNotFound = errors.New("no dead delivery with that ID")
+)
+
+var (
+	// CustomerWebhook has a row per Webhook, along with how far through
+	// CustomerPriceHistory (lastHistoryID) and Price (lastPriceModified,
+	// lastPriceID) its deliveries have got.
+	getWebhooks = `select webhookID, cust_id, brandID, url, active, dateAdded from CustomerWebhook
+		where cust_id = ? && brandID = ?
+		order by webhookID`
+	getWebhook = `select webhookID, cust_id, brandID, url, active, dateAdded from CustomerWebhook
+		where webhookID = ? && cust_id = ? && brandID = ?`
+	// new webhooks start from the current end of the history, rather than
+	// being sent every change ever made
+	createWebhook = `insert into CustomerWebhook(cust_id, brandID, url, secret, active, lastHistoryID, lastPriceModified, lastPriceID, dateAdded)
+		select ?, ?, ?, ?, 1, coalesce(max(historyID), 0), ?, 0, ? from CustomerPriceHistory`
+	updateWebhook           = `update CustomerWebhook set url = ?, active = ? where webhookID = ? && cust_id = ? && brandID = ?`
+	deleteWebhook           = `delete from CustomerWebhook where webhookID = ? && cust_id = ? && brandID = ?`
+	deleteWebhookDeliveries = `delete from CustomerWebhookDelivery where webhookID = ?`
+
+	// CustomerWebhookDelivery has a row per WebhookDelivery, with the JSON
+	// payload that's sent and an auto-incremented sequence that orders
+	// them.
+	deliveryFilter = `from CustomerWebhookDelivery as d
+		join CustomerWebhook as w on w.webhookID = d.webhookID
+		where w.cust_id = ? && w.brandID = ?
+		&& (? = 0 || d.webhookID = ?)
+		&& (? = '' || d.status = ?)`
+	getDeliveryCount = `select count(*) ` + deliveryFilter
+	getDeliveries    = `select d.deliveryID, d.webhookID, d.status, d.attempts, d.events, d.statusCode, d.lastError, d.nextAttempt, d.dateAdded, d.dateDelivered ` + deliveryFilter + `
+		order by d.sequence desc
+		limit ?, ?`
+	redeliver = `update CustomerWebhookDelivery as d
+		join CustomerWebhook as w on w.webhookID = d.webhookID
+		set d.status = 'pending', d.attempts = 0, d.nextAttempt = ?
+		where d.deliveryID = ? && d.webhookID = ? && d.status = 'dead' && w.cust_id = ? && w.brandID = ?`
+)
+
+// GetWebhooks returns the customer's webhooks for the brand. Secrets are
+// only returned when a webhook is created.
+func GetWebhooks(dtx *apicontext.DataContext) ([]Webhook, error) {
+	hooks := []Webhook{}
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return hooks, err
+	}
+	err = database.Init()
+	if err != nil {
+		return hooks, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getWebhooks, custID, brandID)
+	if err != nil {
+		return hooks, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return hooks, err
+		}
+		hooks = append(hooks, w)
+	}
+	return hooks, rows.Err()
+}
+
+func (w *Webhook) Get(dtx *apicontext.DataContext) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	row := database.DB.QueryRowContext(dtx.Context(), getWebhook, w.ID, custID, brandID)
+	hook, err := scanWebhook(row)
+	if err == sql.ErrNoRows {
+		return ErrWebhookNotFound
+	}
+	if err != nil {
+		return err
+	}
+	*w = hook
+	return nil
+}
+
+// Validate requires an absolute http(s) URL whose host is public. A secret
+// is generated if one isn't given.
+func (w *Webhook) Validate() error {
+	u, err := url.Parse(w.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return errors.New("url must be an absolute http or https URL")
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+	if err = checkPublicHost(ctx, u.Hostname()); err != nil {
+		return err
+	}
+	if w.Secret == "" {
+		b := make([]byte, 32)
+		if _, err := rand.Read(b); err != nil {
+			return err
+		}
+		w.Secret = hex.EncodeToString(b)
+	}
+	if len(w.Secret) < 16 {
+		return errors.New("secret must be at least 16 characters")
+	}
+	return nil
+}
+
+// lookupIPAddr resolves webhook hosts.
+var lookupIPAddr = net.DefaultResolver.LookupIPAddr
+
+// checkPublicHost requires every address host resolves to to be public, so
+// that webhooks can't be aimed at the API's own network. Deliveries are
+// checked again as they connect, since what a host resolves to can change.
+func checkPublicHost(ctx context.Context, host string) error {
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		addrs, err := lookupIPAddr(ctx, host)
+		if err != nil || len(addrs) == 0 {
+			return fmt.Errorf("url host %s can't be resolved", host)
+		}
+		ips = ips[:0]
+		for _, addr := range addrs {
+			ips = append(ips, addr.IP)
+		}
+	}
+	for _, ip := range ips {
+		if !publicIP(ip) {
+			return fmt.Errorf("url host %s is not a public address", host)
+		}
+	}
+	return nil
+}
+
+// publicIP reports whether ip is one a webhook may be delivered to: not
+// loopback, private, link-local, multicast or unspecified.
+func publicIP(ip net.IP) bool {
+	return !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() && !ip.IsLinkLocalMulticast() &&
+		!ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+// Create registers the webhook for the customer and brand. Only changes
+// made after it's created are delivered.
+func (w *Webhook) Create(dtx *apicontext.DataContext) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	w.CustID, w.BrandID, w.Active, w.DateAdded = custID, brandID, true, time.Now()
+	stmt, err := database.DB.PrepareContext(dtx.Context(), createWebhook)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(dtx.Context(), w.CustID, w.BrandID, w.URL, w.Secret, w.DateAdded, w.DateAdded)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	w.ID = int(id)
+	return err
+}
+
+// Update changes the webhook's URL and whether it's active. Its secret
+// can't be changed; create a new webhook to rotate it.
+func (w *Webhook) Update(dtx *apicontext.DataContext) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	current := Webhook{ID: w.ID}
+	if err = current.Get(dtx); err != nil {
+		return err
+	}
+	stmt, err := database.DB.PrepareContext(dtx.Context(), updateWebhook)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	if _, err = stmt.ExecContext(dtx.Context(), w.URL, w.Active, w.ID, custID, brandID); err != nil {
+		return err
+	}
+	w.CustID, w.BrandID, w.Secret, w.DateAdded = current.CustID, current.BrandID, "", current.DateAdded
+	return nil
+}
+
+// Delete removes the webhook and its deliveries.
+func (w *Webhook) Delete(dtx *apicontext.DataContext) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	ctx := dtx.Context()
+	return inTx(ctx, func(tx *sql.Tx) error {
+		res, err := tx.ExecContext(ctx, deleteWebhook, w.ID, custID, brandID)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err != nil {
+			return err
+		} else if n == 0 {
+			return ErrWebhookNotFound
+		}
+		_, err = tx.ExecContext(ctx, deleteWebhookDeliveries, w.ID)
+		return err
+	})
+}
+
+// GetDeliveries returns the delivery log of the customer's webhooks, most
+// recent first. webhookID and status narrow it when set; status "dead" is
+// the dead-letter list.
+func GetDeliveries(dtx *apicontext.DataContext, webhookID int, status string, page, count int) (WebhookDeliveryResp, error) {
+	resp := WebhookDeliveryResp{Items: []WebhookDelivery{}}
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return resp, err
+	}
+	err = database.Init()
+	if err != nil {
+		return resp, err
+	}
+
+	if page < 1 {
+		page = 1
+	}
+	if count < 1 {
+		count = 100
+	}
+	args := []interface{}{custID, brandID, webhookID, webhookID, status, status}
+	err = database.DB.QueryRowContext(dtx.Context(), getDeliveryCount, args...).Scan(&resp.Total)
+	if err != nil {
+		return resp, err
+	}
+
+	rows, err := database.DB.QueryContext(dtx.Context(), getDeliveries, append(args, (page-1)*count, count)...)
+	if err != nil {
+		return resp, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d WebhookDelivery
+		var statusCode *int
+		var lastError *string
+		err = rows.Scan(&d.ID, &d.WebhookID, &d.Status, &d.Attempts, &d.Events, &statusCode, &lastError, &d.NextAttempt, &d.DateAdded, &d.DateDelivered)
+		if err != nil {
+			return resp, err
+		}
+		if statusCode != nil {
+			d.StatusCode = *statusCode
+		}
+		if lastError != nil {
+			d.LastError = *lastError
+		}
+		resp.Items = append(resp.Items, d)
+	}
+	return resp, rows.Err()
+}
+
+// Redeliver queues a dead delivery to be sent again, with a fresh set of
+// attempts.
+func Redeliver(dtx *apicontext.DataContext, webhookID int, deliveryID string) error {
+	custID, brandID, err := tenant(dtx)
+	if err != nil {
+		return err
+	}
+	err = database.Init()
+	if err != nil {
+		return err
+	}
+
+	res, err := database.DB.ExecContext(dtx.Context(), redeliver, time.Now(), deliveryID, webhookID, custID, brandID)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrDeliveryNotFound
+	}
+	return nil
+}
+
+func scanWebhook(row database.Scanner) (Webhook, error) {
+	var w Webhook
+	err := row.Scan(&w.ID, &w.CustID, &w.BrandID, &w.URL, &w.Active, &w.DateAdded)
+	return w, err
+}
@@ -0,0 +1,348 @@
+package currency
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/redis"
+
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"log"
+	"math"
+	"strings"
+	"time"
+)
+
+// Base is the currency that prices are kept in. Other currencies are
+// converted from it at the exchange rate in effect, except for price types
+// kept natively in a currency, such as "List CAD".
+const Base = "USD"
+
+// Rounding rules, applied to a converted price at the rate's Increment.
+const (
+	RoundNearest = "nearest"
+	RoundUp      = "up"
+	RoundDown    = "down"
+)
+
+// ExchangeRate is the number of units of Currency that a US dollar buys from
+// Effective on, until a later rate for the currency takes effect.
+type ExchangeRate struct {
+	ID        int       `json:"id,omitempty" xml:"id,attr,omitempty"`
+	Currency  string    `json:"currency" xml:"currency,attr"`
+	Rate      float64   `json:"rate" xml:"rate,attr"`
+	Rounding  string    `json:"rounding" xml:"rounding,attr"`
+	Increment float64   `json:"increment" xml:"increment,attr"`
+	Effective time.Time `json:"effective" xml:"effective"`
+	DateAdded time.Time `json:"dateAdded,omitempty" xml:"dateAdded,omitempty"`
+}
+
+var (
+	ErrUnknownCurrency = errors.New("no exchange rate is in effect for that currency")
+	ErrRateNotFound    = errors.New("no exchange rate with that ID")
+)
+
+var (
+	// ExchangeRate has a row per ExchangeRate; CustomerCurrency has the
+	// currency of each customer that doesn't use USD.
+	getRates = `select rateID, currency, rate, rounding, increment, effective, dateAdded from ExchangeRate
+		where (? = '' || currency = ?)
+		order by currency, effective desc, rateID desc`
+	createRate             = `insert into ExchangeRate(currency, rate, rounding, increment, effective, dateAdded) values(?, ?, ?, ?, ?, ?)`
+	getRateCurrency        = `select currency from ExchangeRate where rateID = ?`
+	deleteRate             = `delete from ExchangeRate where rateID = ?`
+	getCustomerCurrency    = `select currency from CustomerCurrency where cust_id = ?`
+	setCustomerCurrency    = `replace into CustomerCurrency(cust_id, currency) values(?, ?)`
+	deleteCustomerCurrency = `delete from CustomerCurrency where cust_id = ?`
+)
+
+const ratesRedisKey = "currency:rates:"
+
+// GetRates returns the exchange rates of a currency, or of every currency
+// if it's empty, most recent first.
+func GetRates(ctx context.Context, currency string) ([]ExchangeRate, error) {
+	rates := []ExchangeRate{}
+	err := database.Init()
+	if err != nil {
+		return rates, err
+	}
+
+	rows, err := database.DB.QueryContext(ctx, getRates, currency, currency)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var x ExchangeRate
+		err = rows.Scan(&x.ID, &x.Currency, &x.Rate, &x.Rounding, &x.Increment, &x.Effective, &x.DateAdded)
+		if err != nil {
+			return rates, err
+		}
+		rates = append(rates, x)
+	}
+	return rates, rows.Err()
+}
+
+// Validate normalizes the currency code and fills in the rounding rules,
+// which default to the nearest cent, and the effective date, which defaults
+// to now.
+func (x *ExchangeRate) Validate() error {
+	x.Currency = strings.ToUpper(strings.TrimSpace(x.Currency))
+	if !IsCode(x.Currency) {
+		return errors.New("currency must be a three letter ISO 4217 code")
+	}
+	if x.Currency == Base {
+		return errors.New("prices are kept in " + Base + " and it has no exchange rate")
+	}
+	if x.Rate <= 0 {
+		return errors.New("rate must be greater than zero")
+	}
+	switch x.Rounding {
+	case "":
+		x.Rounding = RoundNearest
+	case RoundNearest, RoundUp, RoundDown:
+	default:
+		return errors.New("rounding must be nearest, up or down")
+	}
+	if x.Increment < 0 {
+		return errors.New("increment can't be negative")
+	}
+	if x.Increment == 0 {
+		x.Increment = 0.01
+	}
+	if x.Effective.IsZero() {
+		x.Effective = time.Now()
+	}
+	return nil
+}
+
+// Create adds the rate. Rates aren't changed once added; a correction is a
+// new rate with the same effective date, which takes precedence.
+func (x *ExchangeRate) Create(ctx context.Context) error {
+	err := database.Init()
+	if err != nil {
+		return err
+	}
+
+	x.DateAdded = time.Now()
+	stmt, err := database.DB.PrepareContext(ctx, createRate)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+	res, err := stmt.ExecContext(ctx, x.Currency, x.Rate, x.Rounding, x.Increment, x.Effective, x.DateAdded)
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	x.ID = int(id)
+	redis.Delete(ratesRedisKey + x.Currency)
+	return err
+}
+
+// Delete removes the rate, so that whichever rate preceded it is in effect
+// again.
+func (x *ExchangeRate) Delete(ctx context.Context) error {
+	err := database.Init()
+	if err != nil {
+		return err
+	}
+
+	err = database.DB.QueryRowContext(ctx, getRateCurrency, x.ID).Scan(&x.Currency)
+	if err == sql.ErrNoRows {
+		return ErrRateNotFound
+	}
+	if err != nil {
+		return err
+	}
+	if _, err = database.DB.ExecContext(ctx, deleteRate, x.ID); err != nil {
+		return err
+	}
+	redis.Delete(ratesRedisKey + x.Currency)
+	return nil
+}
+
+// RateAt returns the rate for currency in effect at at.
+func RateAt(ctx context.Context, currency string, at time.Time) (ExchangeRate, error) {
+	var rates []ExchangeRate
+	data, err := redis.Get(ratesRedisKey + currency)
+	if err != nil || len(data) == 0 || json.Unmarshal(data, &rates) != nil {
+		rates, err = GetRates(ctx, currency)
+		if err != nil {
+			return ExchangeRate{}, err
+		}
+		redis.SetexAsync(ratesRedisKey+currency, rates, 3600)
+	}
+	x, ok := effectiveRate(rates, at)
+	if !ok {
+		return x, ErrUnknownCurrency
+	}
+	return x, nil
+}
+
+// effectiveRate returns the rate with the latest effective date that isn't
+// after at, preferring the most recently added of rates that take effect
+// together.
+func effectiveRate(rates []ExchangeRate, at time.Time) (ExchangeRate, bool) {
+	var best ExchangeRate
+	var found bool
+	for _, x := range rates {
+		if x.Effective.After(at) {
+			continue
+		}
+		if !found || x.Effective.After(best.Effective) || (x.Effective.Equal(best.Effective) && x.ID > best.ID) {
+			best, found = x, true
+		}
+	}
+	return best, found
+}
+
+// Convert converts a USD amount to the rate's currency and rounds it.
+func (x ExchangeRate) Convert(usd float64) float64 {
+	return x.Round(usd * x.Rate)
+}
+
+// Round rounds v to a multiple of the rate's Increment, in the direction of
+// its Rounding rule.
+func (x ExchangeRate) Round(v float64) float64 {
+	inc := x.Increment
+	if inc <= 0 {
+		inc = 0.01
+	}
+	// the tolerance keeps amounts that are already a multiple of inc, but
+	// not exactly so in floating point, from being pushed up or down
+	n := v / inc
+	switch x.Rounding {
+	case RoundUp:
+		n = math.Ceil(n - 1e-9)
+	case RoundDown:
+		n = math.Floor(n + 1e-9)
+	default:
+		n = math.Round(n)
+	}
+	return math.Round(n*inc*1e6) / 1e6
+}
+
+// IsCode reports whether s looks like an ISO 4217 currency code.
+func IsCode(s string) bool {
+	if len(s) != 3 {
+		return false
+	}
+	for _, r := range s {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// NativeType splits a price type that's kept natively in a currency, such as
+// "List CAD", into the price type that it stands in for and the currency.
+func NativeType(priceType string) (base string, currency string, ok bool) {
+	i := strings.LastIndex(priceType, " ")
+	if i < 1 || !IsCode(priceType[i+1:]) {
+		return priceType, "", false
+	}
+	return priceType[:i], priceType[i+1:], true
+}
+
+// GetCustomerCurrency returns the currency that the customer's prices are
+// shown in by default.
+func GetCustomerCurrency(ctx context.Context, custID int) (string, error) {
+	if custID < 1 {
+		return Base, nil
+	}
+	err := database.Init()
+	if err != nil {
+		return Base, err
+	}
+
+	var currency string
+	err = database.DB.QueryRowContext(ctx, getCustomerCurrency, custID).Scan(&currency)
+	if err == sql.ErrNoRows {
+		return Base, nil
+	}
+	if err != nil {
+		return Base, err
+	}
+	return currency, nil
+}
+
+// SetCustomerCurrency changes the customer's default currency, which must
+// have a rate in effect.
+func SetCustomerCurrency(ctx context.Context, custID int, currency string) (string, error) {
+	currency = strings.ToUpper(strings.TrimSpace(currency))
+	if currency == "" {
+		currency = Base
+	}
+	if currency != Base {
+		if _, err := RateAt(ctx, currency, time.Now()); err != nil {
+			return currency, err
+		}
+	}
+	err := database.Init()
+	if err != nil {
+		return currency, err
+	}
+
+	if currency == Base {
+		_, err = database.DB.ExecContext(ctx, deleteCustomerCurrency, custID)
+	} else {
+		_, err = database.DB.ExecContext(ctx, setCustomerCurrency, custID, currency)
+	}
+	return currency, err
+}
+
+// Converter converts a response's prices to a currency. A nil Converter
+// leaves them in USD.
+type Converter struct {
+	Currency string
+	rate     ExchangeRate
+}
+
+// NewConverter returns a Converter for the rate's currency.
+func NewConverter(x ExchangeRate) *Converter {
+	return &Converter{Currency: x.Currency, rate: x}
+}
+
+// Resolve returns the Converter for a request: to the requested currency,
+// or else to the customer's default. A request for a currency without a
+// rate in effect is ErrUnknownCurrency, but a default that's lost its rate
+// falls back to USD rather than failing every request.
+func Resolve(dtx *apicontext.DataContext, requested string) (*Converter, error) {
+	ctx := context.Background()
+	var custID int
+	if dtx != nil {
+		ctx, custID = dtx.Context(), dtx.CustomerID
+	}
+
+	currency := strings.ToUpper(strings.TrimSpace(requested))
+	if currency == "" {
+		var err error
+		if currency, err = GetCustomerCurrency(ctx, custID); err != nil {
+			return nil, err
+		}
+	}
+	if currency == Base {
+		return nil, nil
+	}
+	x, err := RateAt(ctx, currency, time.Now())
+	if err == ErrUnknownCurrency && requested == "" {
+		log.Printf("customer %d defaults to %s, which has no exchange rate\n", custID, currency)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return NewConverter(x), nil
+}
+
+// Convert converts a USD amount.
+func (c *Converter) Convert(usd float64) float64 {
+	if c == nil {
+		return usd
+	}
+	return c.rate.Convert(usd)
+}
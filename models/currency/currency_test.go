@@ -0,0 +1,89 @@
+package currency
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCurrency(t *testing.T) {
+	Convey("Testing exchange rates", t, func() {
+		day := func(d int) time.Time {
+			return time.Date(2026, time.October, d, 0, 0, 0, 0, time.UTC)
+		}
+
+		Convey("the latest rate that's taken effect applies", func() {
+			rates := []ExchangeRate{
+				{ID: 1, Currency: "CAD", Rate: 1.30, Effective: day(1)},
+				{ID: 2, Currency: "CAD", Rate: 1.35, Effective: day(10)},
+				{ID: 3, Currency: "CAD", Rate: 1.36, Effective: day(10)},
+				{ID: 4, Currency: "CAD", Rate: 1.40, Effective: day(20)},
+			}
+			x, ok := effectiveRate(rates, day(15))
+			So(ok, ShouldBeTrue)
+			So(x.ID, ShouldEqual, 3)
+
+			x, ok = effectiveRate(rates, day(1))
+			So(ok, ShouldBeTrue)
+			So(x.ID, ShouldEqual, 1)
+
+			_, ok = effectiveRate(rates, day(1).Add(-time.Second))
+			So(ok, ShouldBeFalse)
+		})
+
+		Convey("converted prices are rounded by the rate's rules", func() {
+			x := ExchangeRate{Currency: "CAD", Rate: 1.3333, Rounding: RoundNearest, Increment: 0.01}
+			So(x.Convert(100), ShouldEqual, 133.33)
+			x.Rounding = RoundUp
+			So(x.Convert(100), ShouldEqual, 133.33)
+			So(x.Convert(100.01), ShouldEqual, 133.35)
+			x.Rounding = RoundDown
+			So(x.Convert(100.01), ShouldEqual, 133.34)
+
+			x.Increment = 0.05
+			x.Rounding = RoundUp
+			So(x.Convert(100), ShouldEqual, 133.35)
+			x.Increment = 1
+			x.Rounding = RoundNearest
+			So(x.Convert(100), ShouldEqual, 133)
+
+			// already a multiple of the increment
+			x = ExchangeRate{Rate: 1.1, Rounding: RoundUp, Increment: 0.01}
+			So(x.Convert(10), ShouldEqual, 11)
+		})
+
+		Convey("rates are validated", func() {
+			x := ExchangeRate{Currency: " cad", Rate: 1.35}
+			So(x.Validate(), ShouldBeNil)
+			So(x.Currency, ShouldEqual, "CAD")
+			So(x.Rounding, ShouldEqual, RoundNearest)
+			So(x.Increment, ShouldEqual, 0.01)
+			So(x.Effective.IsZero(), ShouldBeFalse)
+
+			So((&ExchangeRate{Currency: "USD", Rate: 1}).Validate(), ShouldNotBeNil)
+			So((&ExchangeRate{Currency: "CAD"}).Validate(), ShouldNotBeNil)
+			So((&ExchangeRate{Currency: "CA", Rate: 1}).Validate(), ShouldNotBeNil)
+			So((&ExchangeRate{Currency: "CAD", Rate: 1, Rounding: "sideways"}).Validate(), ShouldNotBeNil)
+		})
+
+		Convey("native price types name their currency", func() {
+			base, cur, ok := NativeType("List CAD")
+			So(ok, ShouldBeTrue)
+			So(base, ShouldEqual, "List")
+			So(cur, ShouldEqual, "CAD")
+
+			for _, t := range []string{"List", "Map", "Jobber Price", "CAD", "List cad"} {
+				_, _, ok = NativeType(t)
+				So(ok, ShouldBeFalse)
+			}
+		})
+
+		Convey("a nil converter leaves prices in USD", func() {
+			var c *Converter
+			So(c.Convert(99.99), ShouldEqual, 99.99)
+			c = NewConverter(ExchangeRate{Currency: "CAD", Rate: 1.25, Increment: 0.01})
+			So(c.Convert(99.99), ShouldEqual, 124.99)
+		})
+	})
+}
@@ -8,6 +8,7 @@ import (
 	"github.com/curt-labs/API/helpers/redis"
 	"github.com/curt-labs/API/helpers/sortutil"
 	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/cartIntegration"
 	"github.com/curt-labs/API/models/geography"
 	_ "github.com/go-sql-driver/mysql"
 
@@ -18,6 +19,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 )
 
 //TODO: Clean up these monstrosities of scan functions. Some of these are like this
@@ -251,7 +253,7 @@ var (
 						join Customer as c on cu.cust_ID = c.cust_id
 						where c.cust_id = ?
 						order by cu.name`
-	customerPrice = `select distinct cp.price from
+	customerPrice = `select cp.price, cp.isSale, cp.sale_start, cp.sale_end from
 						 CustomerPricing cp
 						 where cp.cust_ID =  ?
 						 and cp.partID = ?`
@@ -798,8 +800,8 @@ func (c *Customer) Update() (err error) {
 	for _, brandID := range c.BrandIDs {
 		err = c.CreateCustomerBrand(brandID)
 	}
-	go redis.Set(custPrefix+strconv.Itoa(c.Id), c)
-	go redis.Delete("customerLocations:" + strconv.Itoa(c.Id))
+	redis.SetAsync(custPrefix+strconv.Itoa(c.Id), c)
+	redis.DeleteAsync("customerLocations:" + strconv.Itoa(c.Id))
 	return nil
 }
 
@@ -822,8 +824,8 @@ func (c *Customer) Delete() (err error) {
 	if err != nil {
 		return err
 	}
-	go redis.Delete(custPrefix + strconv.Itoa(c.Id))
-	go redis.Delete("customerLocations:" + strconv.Itoa(c.Id))
+	redis.DeleteAsync(custPrefix + strconv.Itoa(c.Id))
+	redis.DeleteAsync("customerLocations:" + strconv.Itoa(c.Id))
 	return nil
 }
 
@@ -913,20 +915,46 @@ func (c *Customer) JoinUser(u CustomerUser) error {
 	return err
 }
 
+// GetCustomerPrice returns the customer's price for the part in effect
+// now, taking sales into account, or sql.ErrNoRows if there isn't one.
 func GetCustomerPrice(dtx *apicontext.DataContext, part_id int) (price float64, err error) {
 	err = database.Init()
 	if err != nil {
 		return price, err
 	}
 
-	stmt, err := database.DB.Prepare(customerPrice)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), customerPrice)
 	if err != nil {
 		return price, err
 	}
 	defer stmt.Close()
 
-	err = stmt.QueryRow(dtx.CustomerID, part_id).Scan(&price)
-	return price, err
+	rows, err := stmt.QueryContext(dtx.Context(), dtx.CustomerID, part_id)
+	if err != nil {
+		return price, err
+	}
+	defer rows.Close()
+	var prices []cartIntegration.CustomerPrice
+	for rows.Next() {
+		var cp cartIntegration.CustomerPrice
+		var isSale *int
+		if err = rows.Scan(&cp.Price, &isSale, &cp.SaleStart, &cp.SaleEnd); err != nil {
+			return price, err
+		}
+		if isSale != nil {
+			cp.IsSale = *isSale
+		}
+		prices = append(prices, cp)
+	}
+	if err = rows.Err(); err != nil {
+		return price, err
+	}
+
+	cp, ok := cartIntegration.EffectivePrice(prices, time.Now())
+	if !ok {
+		return price, sql.ErrNoRows
+	}
+	return cp.Price, nil
 }
 
 func GetCustomerCartReference(api_key string, part_id int) (ref int, err error) {
@@ -1148,7 +1176,7 @@ func GetLocalRegions() (regions []StateRegion, err error) {
 		regions = append(regions, reg)
 	}
 	defer res.Close()
-	go redis.Set(redis_key, regions)
+	redis.SetAsync(redis_key, regions)
 	return
 }
 
@@ -1183,7 +1211,7 @@ func GetLocalDealerTiers(dtx *apicontext.DataContext) (tiers []DealerTier, err e
 		tiers = append(tiers, t)
 	}
 	defer res.Close()
-	go redis.Setex(redis_key, tiers, 86400)
+	redis.SetexAsync(redis_key, tiers, 86400)
 	return
 }
 
@@ -1232,7 +1260,7 @@ func GetLocalDealerTypes(dtx *apicontext.DataContext) (graphics []MapGraphics, e
 		graphics = append(graphics, g)
 	}
 	defer res.Close()
-	go redis.Setex(redis_key, graphics, 86400)
+	redis.SetexAsync(redis_key, graphics, 86400)
 	return
 }
 
@@ -1269,7 +1297,7 @@ func GetWhereToBuyDealers(dtx *apicontext.DataContext) (customers []Customer, er
 		}
 		customers = append(customers, cust)
 	}
-	go redis.Setex(redis_key, customers, 86400)
+	redis.SetexAsync(redis_key, customers, 86400)
 
 	return customers, err
 }
@@ -1429,8 +1457,8 @@ func (d *DealerType) Delete(dtx *apicontext.DataContext) error {
 	}
 	defer stmt.Close()
 	_, err = stmt.Exec(d.Id)
-	go redis.Delete("local:types:" + dtx.BrandString)
-	go redis.Delete("dealers:etailer:" + dtx.BrandString)
+	redis.DeleteAsync("local:types:" + dtx.BrandString)
+	redis.DeleteAsync("dealers:etailer:" + dtx.BrandString)
 	return err
 }
 
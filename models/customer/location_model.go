@@ -133,7 +133,7 @@ func GetAllLocations(dtx *apicontext.DataContext) (CustomerLocations, error) {
 		ls = append(ls, l)
 	}
 	defer res.Close()
-	go redis.Setex(redis_key, ls, 86400)
+	redis.SetexAsync(redis_key, ls, 86400)
 	return ls, err
 }
 
@@ -179,8 +179,8 @@ func (l *CustomerLocation) Create(dtx *apicontext.DataContext) error {
 		return err
 	}
 	err = tx.Commit()
-	go redis.Delete("customers:locations:" + dtx.BrandString)
-	go redis.Delete("customerLocations:" + strconv.Itoa(l.CustomerId))
+	redis.DeleteAsync("customers:locations:" + dtx.BrandString)
+	redis.DeleteAsync("customerLocations:" + strconv.Itoa(l.CustomerId))
 	return err
 }
 
@@ -221,8 +221,8 @@ func (l *CustomerLocation) Update(dtx *apicontext.DataContext) error {
 		return err
 	}
 	err = tx.Commit()
-	go redis.Delete("customers:locations:" + dtx.BrandString)
-	go redis.Delete("customerLocations:" + strconv.Itoa(l.CustomerId))
+	redis.DeleteAsync("customers:locations:" + dtx.BrandString)
+	redis.DeleteAsync("customerLocations:" + strconv.Itoa(l.CustomerId))
 	return err
 }
 
@@ -250,7 +250,7 @@ func (l *CustomerLocation) Delete(dtx *apicontext.DataContext) error {
 	}
 
 	err = tx.Commit()
-	go redis.Delete("customers:locations:" + dtx.BrandString)
-	go redis.Delete("customerLocations:" + strconv.Itoa(l.CustomerId))
+	redis.DeleteAsync("customers:locations:" + dtx.BrandString)
+	redis.DeleteAsync("customerLocations:" + strconv.Itoa(l.CustomerId))
 	return err
 }
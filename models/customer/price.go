@@ -67,7 +67,7 @@ func (p *Price) Get() error {
 		return err
 	}
 
-	go redis.Setex(redis_key, p, 86400)
+	redis.SetexAsync(redis_key, p, 86400)
 	return nil
 }
 
@@ -97,7 +97,7 @@ func GetAllPrices() (Prices, error) {
 		ps = append(ps, p)
 	}
 	defer res.Close()
-	go redis.Setex(allPricesRedisKey, ps, 86400)
+	redis.SetexAsync(allPricesRedisKey, ps, 86400)
 	return ps, nil
 }
 
@@ -132,8 +132,8 @@ func (p *Price) Create() error {
 	if err != nil {
 		return err
 	}
-	go redis.Delete(allPricesRedisKey)
-	go redis.Setex("price:"+strconv.Itoa(p.ID), p, 86400)
+	redis.DeleteAsync(allPricesRedisKey)
+	redis.SetexAsync("price:"+strconv.Itoa(p.ID), p, 86400)
 	return nil
 }
 
@@ -165,9 +165,9 @@ func (p *Price) Update() error {
 		return err
 	}
 
-	go redis.Setex("price:"+strconv.Itoa(p.ID), p, 86400)
-	go redis.Delete(fmt.Sprintf("prices:part:%d", strconv.Itoa(p.PartID)))
-	go redis.Delete(fmt.Sprintf("customers:prices:%d", strconv.Itoa(p.CustID)))
+	redis.SetexAsync("price:"+strconv.Itoa(p.ID), p, 86400)
+	redis.DeleteAsync(fmt.Sprintf("prices:part:%d", strconv.Itoa(p.PartID)))
+	redis.DeleteAsync(fmt.Sprintf("customers:prices:%d", strconv.Itoa(p.CustID)))
 	return nil
 }
 
@@ -199,9 +199,9 @@ func (p *Price) Delete() error {
 		return err
 	}
 
-	go redis.Delete("price:" + strconv.Itoa(p.ID))
-	go redis.Delete(fmt.Sprintf("prices:part:%d", strconv.Itoa(p.PartID)))
-	go redis.Delete(fmt.Sprintf("customers:prices:%d", strconv.Itoa(p.CustID)))
+	redis.DeleteAsync("price:" + strconv.Itoa(p.ID))
+	redis.DeleteAsync(fmt.Sprintf("prices:part:%d", strconv.Itoa(p.PartID)))
+	redis.DeleteAsync(fmt.Sprintf("customers:prices:%d", strconv.Itoa(p.CustID)))
 	return nil
 }
 
@@ -233,7 +233,7 @@ func (c *Customer) GetPricesByCustomer() (CustomerPrices, error) {
 		cps.Prices = append(cps.Prices, p)
 	}
 
-	go redis.Setex(redis_key, cps, 86400)
+	redis.SetexAsync(redis_key, cps, 86400)
 	return cps, err
 }
 
@@ -265,7 +265,7 @@ func GetPricesByPart(partID int) (Prices, error) {
 		ps = append(ps, p)
 	}
 
-	go redis.Setex(redis_key, ps, 86400)
+	redis.SetexAsync(redis_key, ps, 86400)
 	return ps, nil
 }
 
@@ -172,7 +172,7 @@ func GetAll(dtx *apicontext.DataContext) (ls Lifestyles, err error) {
 		ls = append(ls, l)
 	}
 	defer res.Close()
-	go redis.Setex(redis_key, ls, 86400)
+	redis.SetexAsync(redis_key, ls, 86400)
 	return ls, err
 }
 
@@ -207,7 +207,7 @@ func (l *Lifestyle) Get(dtx *apicontext.DataContext) (err error) {
 	if err != nil {
 		return err
 	}
-	go redis.Setex(redis_key, l, 86400)
+	redis.SetexAsync(redis_key, l, 86400)
 	return nil
 }
 
@@ -434,7 +434,7 @@ func (l *Lifestyle) Update(dtx *apicontext.DataContext) (err error) {
 	}
 
 	redis_key := "lifestyle:get:" + strconv.Itoa(l.ID) + ":" + dtx.BrandString
-	go redis.Setex(redis_key, l, redis.CacheTimeout)
+	redis.SetexAsync(redis_key, l, redis.CacheTimeout)
 	return err
 }
 
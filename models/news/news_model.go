@@ -84,7 +84,7 @@ func (n *News) Get(dtx *apicontext.DataContext) error {
 
 	n.copy(item)
 
-	go redis.Setex(redis_key, n, 86400)
+	redis.SetexAsync(redis_key, n, 86400)
 
 	return nil
 }
@@ -119,7 +119,7 @@ func GetAll(dtx *apicontext.DataContext) (Newses, error) {
 	}
 	defer res.Close()
 
-	go redis.Setex(redis_key, fs, 86400)
+	redis.SetexAsync(redis_key, fs, 86400)
 	return fs, nil
 }
 
@@ -155,7 +155,7 @@ func GetTitles(pageStr, resultsStr string, dtx *apicontext.DataContext) (paginat
 	defer res.Close()
 	l = pagination.Paginate(pageStr, resultsStr, fs)
 
-	go redis.Setex(redis_key, l, 86400)
+	redis.SetexAsync(redis_key, l, 86400)
 	return l, err
 }
 
@@ -190,7 +190,7 @@ func GetLeads(pageStr, resultsStr string, dtx *apicontext.DataContext) (paginati
 	defer res.Close()
 	l = pagination.Paginate(pageStr, resultsStr, fs)
 
-	go redis.Setex(redis_key, l, 86400)
+	redis.SetexAsync(redis_key, l, 86400)
 	return l, err
 }
 
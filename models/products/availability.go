@@ -0,0 +1,278 @@
+package products
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/curt-labs/API/helpers/api"
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/geocoding"
+	"github.com/curt-labs/API/helpers/redis"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PartAvailability is a part's inventory, for batch availability lookups.
+type PartAvailability struct {
+	PartID     int           `json:"part_id" xml:"part_id,attr"`
+	PartNumber string        `json:"part_number" xml:"part_number,attr"`
+	Inventory  PartInventory `json:"inventory" xml:"inventory"`
+}
+
+// Origin is the point that warehouses are ranked by distance from.
+type Origin struct {
+	Latitude  float64
+	Longitude float64
+}
+
+var (
+	ErrUnknownPostalCode = errors.New("that postal code couldn't be located")
+	ErrPartNotFound      = errors.New("no part with that ID is available to your key")
+)
+
+var (
+	getWarehouses = `select w.id, w.name, w.code, w.address, w.city, w.postalCode, w.tollFreePhone, w.fax, w.localPhone, w.manager, w.latitude, w.longitude,
+		s.stateID, s.state, s.abbr, c.countryID, c.name, c.abbr
+		from Warehouses as w
+		left join States as s on s.stateID = w.stateID
+		left join Country as c on c.countryID = s.countryID
+		order by w.code`
+	// the customer's default warehouse is kept in step with the DefWH of
+	// their ShippingInfo on their accounts
+	getDefaultWarehouse = `select w.code from Accounts as ac
+		join Warehouses as w on w.id = ac.defaultWarehouseId
+		where ac.cust_id = ?
+		order by ac.id
+		limit 1`
+)
+
+const (
+	warehousesRedisKey = "warehouses"
+	postalRedisKey     = "geocode:postal:"
+)
+
+// inventoryFields is all of a part that availability needs from Mongo.
+var inventoryFields = bson.M{"id": 1, "part_number": 1, "inventory": 1}
+
+// GetAvailability returns the part's availability, total and by warehouse.
+// Warehouses are ranked by distance from origin when there is one, with the
+// customer's default warehouse first.
+func (p *Part) GetAvailability(dtx *apicontext.DataContext, origin *Origin) (PartInventory, error) {
+	if err := database.Init(); err != nil {
+		return PartInventory{}, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+
+	query := bson.M{"id": p.ID, "brand.id": bson.M{"$in": getBrandsFromDTX(dtx)}}
+	err := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(query).Select(inventoryFields).One(&p)
+	if err == mgo.ErrNotFound {
+		return PartInventory{}, ErrPartNotFound
+	}
+	if err != nil {
+		return PartInventory{}, err
+	}
+
+	avail, err := GetManyAvailability(dtx, []Part{*p}, origin)
+	if err != nil {
+		return PartInventory{}, err
+	}
+	return avail[0].Inventory, nil
+}
+
+// GetAvailabilityByPartNumbers returns the availability of each of the
+// parts, in the order they're found.
+func GetAvailabilityByPartNumbers(dtx *apicontext.DataContext, partNumbers []string, origin *Origin) ([]PartAvailability, error) {
+	if err := database.Init(); err != nil {
+		return nil, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+
+	query := bson.M{"part_number": bson.M{"$in": partNumbers}, "brand.id": bson.M{"$in": getBrandsFromDTX(dtx)}}
+	var parts []Part
+	err := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(query).Select(inventoryFields).Sort("part_number").All(&parts)
+	if err != nil {
+		return nil, err
+	}
+	return GetManyAvailability(dtx, parts, origin)
+}
+
+// GetManyAvailability fills in the warehouses of the parts' inventory and
+// ranks them.
+func GetManyAvailability(dtx *apicontext.DataContext, parts []Part, origin *Origin) ([]PartAvailability, error) {
+	avail := make([]PartAvailability, 0, len(parts))
+	warehouses, err := GetWarehouses(dtx.Context())
+	if err != nil {
+		return avail, err
+	}
+	defaultCode, err := defaultWarehouse(dtx.Context(), dtx.CustomerID)
+	if err != nil {
+		return avail, err
+	}
+	for _, p := range parts {
+		avail = append(avail, PartAvailability{
+			PartID:     p.ID,
+			PartNumber: p.PartNumber,
+			Inventory:  rankInventory(p.Inventory, warehouses, defaultCode, origin),
+		})
+	}
+	return avail, nil
+}
+
+// rankInventory resolves inventory's warehouses, which may only have been
+// stored by code, totals it and orders it: the default warehouse, then by
+// distance from origin, or by code without one.
+func rankInventory(inv PartInventory, warehouses []Warehouse, defaultCode string, origin *Origin) PartInventory {
+	byCode := make(map[string]Warehouse, len(warehouses))
+	for _, w := range warehouses {
+		byCode[strings.ToUpper(w.Code)] = w
+	}
+
+	ranked := PartInventory{Warehouses: make([]Inventory, 0, len(inv.Warehouses))}
+	for _, i := range inv.Warehouses {
+		if w, ok := byCode[strings.ToUpper(i.Warehouse.Code)]; ok {
+			i.Warehouse = w
+		}
+		i.Default = defaultCode != "" && strings.EqualFold(i.Warehouse.Code, defaultCode)
+		i.Distance = nil
+		if origin != nil && hasLocation(i.Warehouse) {
+			d := math.Round(distance(*origin, i.Warehouse)*10) / 10
+			i.Distance = &d
+		}
+		if i.Quantity > 0 {
+			ranked.TotalAvailability += i.Quantity
+		}
+		ranked.Warehouses = append(ranked.Warehouses, i)
+	}
+
+	sort.SliceStable(ranked.Warehouses, func(a, b int) bool {
+		x, y := ranked.Warehouses[a], ranked.Warehouses[b]
+		if x.Default != y.Default {
+			return x.Default
+		}
+		if (x.Distance == nil) != (y.Distance == nil) {
+			return x.Distance != nil
+		}
+		if x.Distance != nil && *x.Distance != *y.Distance {
+			return *x.Distance < *y.Distance
+		}
+		return x.Warehouse.Code < y.Warehouse.Code
+	})
+	return ranked
+}
+
+func hasLocation(w Warehouse) bool {
+	return w.Latitude != 0 || w.Longitude != 0
+}
+
+// distance returns the great circle distance from o to w in miles.
+func distance(o Origin, w Warehouse) float64 {
+	rad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	lat1, lat2 := rad(o.Latitude), rad(w.Latitude)
+	dLat, dLng := lat2-lat1, rad(w.Longitude-o.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * api_helpers.EARTH * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// GetWarehouses returns every warehouse.
+func GetWarehouses(ctx context.Context) ([]Warehouse, error) {
+	var warehouses []Warehouse
+	data, err := redis.Get(warehousesRedisKey)
+	if err == nil && len(data) > 0 && json.Unmarshal(data, &warehouses) == nil {
+		return warehouses, nil
+	}
+
+	err = database.Init()
+	if err != nil {
+		return warehouses, err
+	}
+	rows, err := database.DB.QueryContext(ctx, getWarehouses)
+	if err != nil {
+		return warehouses, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var w Warehouse
+		var name, code, address, city, postal, tollFree, fax, local, manager *string
+		var lat, lng *float64
+		var stateID, countryID *int
+		var state, stateAbbr, country, countryAbbr *string
+		err = rows.Scan(&w.ID, &name, &code, &address, &city, &postal, &tollFree, &fax, &local, &manager, &lat, &lng,
+			&stateID, &state, &stateAbbr, &countryID, &country, &countryAbbr)
+		if err != nil {
+			return warehouses, err
+		}
+		w.Name, w.Code, w.Address, w.City = deref(name), deref(code), deref(address), deref(city)
+		w.PostalCode, w.TollFreePhone, w.Fax, w.LocalPhone, w.Manager = deref(postal), deref(tollFree), deref(fax), deref(local), deref(manager)
+		if lat != nil && lng != nil {
+			w.Latitude, w.Longitude = *lat, *lng
+		}
+		if stateID != nil {
+			w.State = State{ID: *stateID, State: deref(state), Abbreviation: deref(stateAbbr)}
+		}
+		if countryID != nil {
+			w.State.Country = Country{ID: *countryID, Name: deref(country), Abbreviation: deref(countryAbbr)}
+		}
+		warehouses = append(warehouses, w)
+	}
+	if err = rows.Err(); err != nil {
+		return warehouses, err
+	}
+	redis.SetexAsync(warehousesRedisKey, warehouses, 86400)
+	return warehouses, nil
+}
+
+func deref(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+// defaultWarehouse returns the code of the customer's default warehouse, or
+// "" if they haven't got one.
+func defaultWarehouse(ctx context.Context, custID int) (string, error) {
+	if custID < 1 {
+		return "", nil
+	}
+	err := database.Init()
+	if err != nil {
+		return "", err
+	}
+	var code string
+	err = database.DB.QueryRowContext(ctx, getDefaultWarehouse, custID).Scan(&code)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+	return code, err
+}
+
+// LocatePostalCode geocodes a postal code.
+func LocatePostalCode(postal string) (*Origin, error) {
+	postal = strings.ToUpper(strings.TrimSpace(postal))
+	var o Origin
+	data, err := redis.Get(postalRedisKey + postal)
+	if err == nil && len(data) > 0 && json.Unmarshal(data, &o) == nil {
+		return &o, nil
+	}
+
+	l := geocoding.Lookup{Address: postal}
+	resp, err := l.Search()
+	if err != nil {
+		return nil, err
+	}
+	if len(resp.Results) == 0 {
+		return nil, ErrUnknownPostalCode
+	}
+	o.Latitude = resp.Results[0].Geometry.Location.Latitude
+	o.Longitude = resp.Results[0].Geometry.Location.Longitude
+	redis.SetexAsync(postalRedisKey+postal, o, 86400*30)
+	return &o, nil
+}
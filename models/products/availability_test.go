@@ -0,0 +1,58 @@
+package products
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestAvailability(t *testing.T) {
+	Convey("Testing part availability", t, func() {
+		warehouses := []Warehouse{
+			{ID: 1, Code: "HQ", Name: "Eau Claire", Latitude: 44.8113, Longitude: -91.4985},
+			{ID: 2, Code: "RN", Name: "Reno", Latitude: 39.5296, Longitude: -119.8138},
+			{ID: 3, Code: "PA", Name: "Allentown", Latitude: 40.6084, Longitude: -75.4902},
+		}
+		inv := PartInventory{
+			TotalAvailability: 1,
+			Warehouses: []Inventory{
+				{Warehouse: Warehouse{Code: "hq"}, Quantity: 10},
+				{Warehouse: Warehouse{Code: "RN"}, Quantity: 4},
+				{Warehouse: Warehouse{Code: "PA"}, Quantity: -2},
+				{Warehouse: Warehouse{Code: "XX", Name: "Unknown"}, Quantity: 1},
+			},
+		}
+		codes := func(inv PartInventory) []string {
+			var c []string
+			for _, i := range inv.Warehouses {
+				c = append(c, i.Warehouse.Code)
+			}
+			return c
+		}
+
+		Convey("warehouses are filled in and totalled", func() {
+			ranked := rankInventory(inv, warehouses, "", nil)
+			So(ranked.TotalAvailability, ShouldEqual, 15)
+			So(codes(ranked), ShouldResemble, []string{"HQ", "PA", "RN", "XX"})
+			So(ranked.Warehouses[0].Warehouse.Name, ShouldEqual, "Eau Claire")
+			So(ranked.Warehouses[0].Distance, ShouldBeNil)
+			So(ranked.Warehouses[3].Warehouse.Name, ShouldEqual, "Unknown")
+		})
+
+		Convey("warehouses are ranked by distance", func() {
+			sf := &Origin{Latitude: 37.7749, Longitude: -122.4194}
+			ranked := rankInventory(inv, warehouses, "", sf)
+			So(codes(ranked), ShouldResemble, []string{"RN", "HQ", "PA", "XX"})
+			So(*ranked.Warehouses[0].Distance, ShouldAlmostEqual, 186, 5)
+			So(ranked.Warehouses[3].Distance, ShouldBeNil)
+		})
+
+		Convey("the default warehouse comes first", func() {
+			sf := &Origin{Latitude: 37.7749, Longitude: -122.4194}
+			ranked := rankInventory(inv, warehouses, "PA", sf)
+			So(codes(ranked), ShouldResemble, []string{"PA", "RN", "HQ", "XX"})
+			So(ranked.Warehouses[0].Default, ShouldBeTrue)
+			So(ranked.Warehouses[1].Default, ShouldBeFalse)
+		})
+	})
+}
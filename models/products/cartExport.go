@@ -0,0 +1,464 @@
+package products
+
+import (
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"gopkg.in/mgo.v2/bson"
+
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An ExportProfile is a cart platform's product import CSV. Each part is
+// written as one or more rows under Header.
+type ExportProfile struct {
+	Name   string
+	Header []string
+	rows   func(p *Part) [][]string
+}
+
+// ExportFilter narrows a catalog export. Zero values match everything the
+// key can see.
+type ExportFilter struct {
+	BrandID    int
+	CategoryID int
+}
+
+var (
+	ErrUnknownProfile = errors.New("unknown export profile; use shopify, bigcommerce or woocommerce")
+
+	// ExportProfiles are keyed by the name used in the export route.
+	ExportProfiles = map[string]ExportProfile{
+		"shopify":     {Name: "Shopify", Header: shopifyHeader, rows: shopifyRows},
+		"bigcommerce": {Name: "BigCommerce", Header: bigCommerceHeader, rows: bigCommerceRows},
+		"woocommerce": {Name: "WooCommerce", Header: wooHeader(), rows: wooRows},
+	}
+)
+
+const (
+	// parts are read from Mongo, priced and written this many at a time
+	exportChunkSize = 200
+
+	bigCommerceImages  = 10
+	wooAttributeFields = 20
+)
+
+type flusher interface {
+	Flush()
+}
+
+// ExportCatalog writes every active part the key can see, matching f, as
+// profile's import CSV. Parts are streamed in chunks priced at the
+// customer's effective price, and w is flushed after each if it can be.
+func ExportCatalog(dtx *apicontext.DataContext, w io.Writer, profile string, f ExportFilter) error {
+	prof, ok := ExportProfiles[strings.ToLower(profile)]
+	if !ok {
+		return ErrUnknownProfile
+	}
+	if err := database.Init(); err != nil {
+		return err
+	}
+
+	brands := getBrandsFromDTX(dtx)
+	if f.BrandID > 0 {
+		brands = intersect(brands, []int{f.BrandID})
+	}
+	query := bson.M{
+		"brand.id": bson.M{"$in": brands},
+		"status":   bson.M{"$in": []int{700, 800, 810, 815, 850, 870, 888, 900, 910, 950}},
+	}
+	if f.CategoryID > 0 {
+		query["$or"] = []bson.M{{"categories.id": f.CategoryID}, {"categories.parent_id": f.CategoryID}}
+	}
+
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	iter := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(query).Sort("id").Batch(exportChunkSize).Iter()
+	defer iter.Close()
+
+	cw := csv.NewWriter(w)
+	if err := cw.Write(prof.Header); err != nil {
+		return err
+	}
+
+	chunk := make([]Part, 0, exportChunkSize)
+	writeChunk := func() error {
+		parts, err := BindCustomerToSeveralParts(chunk, dtx)
+		if err != nil {
+			return err
+		}
+		for i := range parts {
+			for _, row := range prof.rows(&parts[i]) {
+				if err = cw.Write(row); err != nil {
+					return err
+				}
+			}
+		}
+		cw.Flush()
+		if err = cw.Error(); err != nil {
+			return err
+		}
+		if fl, ok := w.(flusher); ok {
+			fl.Flush()
+		}
+		chunk = chunk[:0]
+		return dtx.Context().Err()
+	}
+
+	var p Part
+	for iter.Next(&p) {
+		chunk = append(chunk, p)
+		p = Part{}
+		if len(chunk) == exportChunkSize {
+			if err := writeChunk(); err != nil {
+				return err
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if len(chunk) > 0 {
+		if err := writeChunk(); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+var shopifyHeader = []string{
+	"Handle", "Title", "Body (HTML)", "Vendor", "Type", "Tags", "Published",
+	"Variant SKU", "Variant Inventory Policy", "Variant Fulfillment Service", "Variant Price", "Variant Compare At Price",
+	"Variant Requires Shipping", "Variant Taxable", "Variant Barcode",
+	"Image Src", "Image Position", "Image Alt Text", "Status",
+	"Fitment (product.metafields.curt.fitment)", "Attributes (product.metafields.curt.attributes)",
+}
+
+// shopifyRows writes the product on its first row and each further image
+// on a row of its own, as Shopify expects.
+func shopifyRows(p *Part) [][]string {
+	handle := exportHandle(p)
+	images := exportImages(p)
+	price, compareAt := exportPrices(p)
+	var image string
+	if len(images) > 0 {
+		image = images[0]
+	}
+	attrs, _ := json.Marshal(exportAttributes(p))
+
+	rows := [][]string{{
+		handle, exportTitle(p), exportHTML(p), p.Brand.Name, exportType(p), strings.Join(exportTags(p), ", "), "TRUE",
+		p.PartNumber, "deny", "manual", price, compareAt,
+		"TRUE", "TRUE", p.UPC,
+		image, imagePosition(image, 1), exportTitle(p), "active",
+		FitmentText(p), string(attrs),
+	}}
+	for i := 1; i < len(images); i++ {
+		row := make([]string, len(shopifyHeader))
+		row[0], row[15], row[16] = handle, images[i], strconv.Itoa(i+1)
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+var bigCommerceHeader = func() []string {
+	h := []string{
+		"Item Type", "Product Name", "Product Type", "Product Code/SKU", "Brand Name", "Product Description",
+		"Price", "Retail Price", "Category", "Product UPC/EAN", "Product Visible?", "Search Keywords",
+		"Product Custom Fields",
+	}
+	for i := 1; i <= bigCommerceImages; i++ {
+		h = append(h, fmt.Sprintf("Product Image URL - %d", i))
+	}
+	return h
+}()
+
+// bigCommerceRows writes the product's attributes and fitment as custom
+// fields, formatted name=value;name=value.
+func bigCommerceRows(p *Part) [][]string {
+	price, retail := exportPrices(p)
+	if retail == "" {
+		retail = price
+	}
+	fields := make([]string, 0, len(p.Attributes)+1)
+	for _, a := range exportAttributes(p) {
+		fields = append(fields, customField(a.Key)+"="+customField(a.Value))
+	}
+	if fit := FitmentText(p); fit != "" {
+		fields = append(fields, "Fitment="+customField(fit))
+	}
+
+	row := []string{
+		"Product", exportTitle(p), "P", p.PartNumber, p.Brand.Name, exportHTML(p),
+		price, retail, strings.Join(exportCategories(p), ";"), p.UPC, "Y", strings.Join(exportTags(p), ","),
+		strings.Join(fields, ";"),
+	}
+	images := exportImages(p)
+	for i := 0; i < bigCommerceImages; i++ {
+		var src string
+		if i < len(images) {
+			src = images[i]
+		}
+		row = append(row, src)
+	}
+	return [][]string{row}
+}
+
+func wooHeader() []string {
+	h := []string{
+		"SKU", "Type", "Name", "Published", "Visibility in catalog", "Short description", "Description",
+		"Regular price", "Categories", "Tags", "Images", "Brands", "Meta: _curt_fitment",
+	}
+	for i := 1; i <= wooAttributeFields; i++ {
+		h = append(h,
+			fmt.Sprintf("Attribute %d name", i), fmt.Sprintf("Attribute %d value(s)", i),
+			fmt.Sprintf("Attribute %d visible", i), fmt.Sprintf("Attribute %d global", i))
+	}
+	return h
+}
+
+// wooRows writes the product's attributes as WooCommerce product
+// attributes, up to wooAttributeFields of them in sort order.
+func wooRows(p *Part) [][]string {
+	price, _ := exportPrices(p)
+	row := []string{
+		p.PartNumber, "simple", exportTitle(p), "1", "visible", html.EscapeString(p.ShortDesc), exportHTML(p),
+		price, strings.Join(exportCategories(p), ", "), strings.Join(exportTags(p), ", "),
+		strings.Join(exportImages(p), ", "), p.Brand.Name, FitmentText(p),
+	}
+	attrs := exportAttributes(p)
+	for i := 0; i < wooAttributeFields; i++ {
+		if i < len(attrs) {
+			row = append(row, attrs[i].Key, attrs[i].Value, "1", "0")
+		} else {
+			row = append(row, "", "", "", "")
+		}
+	}
+	return [][]string{row}
+}
+
+var handleStrip = regexp.MustCompile(`[^a-z0-9]+`)
+
+func exportHandle(p *Part) string {
+	return strings.Trim(handleStrip.ReplaceAllString(strings.ToLower(p.Brand.Name+" "+p.PartNumber), "-"), "-")
+}
+
+func exportTitle(p *Part) string {
+	title := strings.TrimSpace(p.ShortDesc)
+	if title == "" {
+		return p.PartNumber
+	}
+	return title + " #" + p.PartNumber
+}
+
+// exportPrices returns the dealer's price, falling back to List, and List
+// when the dealer's price is below it.
+func exportPrices(p *Part) (price, list string) {
+	var listPrice float64
+	for _, pr := range p.Pricing {
+		if strings.EqualFold(pr.Type, "List") {
+			listPrice = pr.Price
+		}
+	}
+	dealer := p.Customer.Price
+	if dealer <= 0 {
+		dealer = listPrice
+	}
+	if dealer > 0 {
+		price = fmt.Sprintf("%.2f", dealer)
+	}
+	if listPrice > dealer {
+		list = fmt.Sprintf("%.2f", listPrice)
+	}
+	return price, list
+}
+
+// exportHTML builds the product description from its marketing content and
+// bullets, escaping content that doesn't allow HTML.
+func exportHTML(p *Part) string {
+	content := append([]Content(nil), p.Content...)
+	sort.SliceStable(content, func(i, j int) bool { return content[i].Sort < content[j].Sort })
+
+	var desc, bullets []string
+	for _, c := range content {
+		text := strings.TrimSpace(c.Text)
+		if text == "" {
+			continue
+		}
+		if !c.ContentType.AllowsHTML {
+			text = html.EscapeString(text)
+		}
+		kind := strings.ToLower(c.ContentType.Type)
+		kind = kind[strings.LastIndex(kind, ":")+1:]
+		switch kind {
+		case "bullet":
+			bullets = append(bullets, "<li>"+text+"</li>")
+		case "listdescription", "marketing", "longdescription", "description":
+			desc = append(desc, "<p>"+text+"</p>")
+		}
+	}
+	if len(desc) == 0 && p.ShortDesc != "" {
+		desc = append(desc, "<p>"+html.EscapeString(p.ShortDesc)+"</p>")
+	}
+	if len(bullets) > 0 {
+		desc = append(desc, "<ul>"+strings.Join(bullets, "")+"</ul>")
+	}
+	return strings.Join(desc, "")
+}
+
+// exportImages returns the URL of the largest size of each of the part's
+// images, in their sort order.
+func exportImages(p *Part) []string {
+	largest := make(map[string]Image)
+	for _, img := range p.Images {
+		if img.Path == nil {
+			continue
+		}
+		if cur, ok := largest[img.Sort]; !ok || img.Width*img.Height > cur.Width*cur.Height {
+			largest[img.Sort] = img
+		}
+	}
+	sorts := make([]string, 0, len(largest))
+	for s := range largest {
+		sorts = append(sorts, s)
+	}
+	sort.Strings(sorts)
+	urls := make([]string, 0, len(sorts))
+	for _, s := range sorts {
+		urls = append(urls, largest[s].Path.String())
+	}
+	return urls
+}
+
+func imagePosition(src string, pos int) string {
+	if src == "" {
+		return ""
+	}
+	return strconv.Itoa(pos)
+}
+
+func exportAttributes(p *Part) []Attribute {
+	attrs := make([]Attribute, 0, len(p.Attributes))
+	for _, a := range p.Attributes {
+		if strings.TrimSpace(a.Key) != "" && strings.TrimSpace(a.Value) != "" {
+			attrs = append(attrs, a)
+		}
+	}
+	sort.SliceStable(attrs, func(i, j int) bool { return attrs[i].Sort < attrs[j].Sort })
+	return attrs
+}
+
+func exportCategories(p *Part) []string {
+	var titles []string
+	for _, c := range p.Categories {
+		if c.Title != "" {
+			titles = append(titles, c.Title)
+		}
+	}
+	return titles
+}
+
+func exportType(p *Part) string {
+	if cats := exportCategories(p); len(cats) > 0 {
+		return cats[0]
+	}
+	return ""
+}
+
+func exportTags(p *Part) []string {
+	tags := exportCategories(p)
+	if p.Class.Name != "" {
+		tags = append(tags, p.Class.Name)
+	}
+	return tags
+}
+
+// customField escapes the separators of BigCommerce's custom fields column,
+// which also can't span lines.
+func customField(s string) string {
+	return strings.NewReplacer(";", ",", "=", "-", "\n", ", ").Replace(strings.TrimSpace(s))
+}
+
+// FitmentText describes the vehicles a part fits, one vehicle per line
+// with consecutive years collapsed into ranges, e.g. "2015-2018 Ford F-150".
+func FitmentText(p *Part) string {
+	years := make(map[string][]int)
+	add := func(year int, vehicle ...string) {
+		var parts []string
+		for _, v := range vehicle {
+			if v = strings.TrimSpace(v); v != "" {
+				parts = append(parts, v)
+			}
+		}
+		if year > 0 && len(parts) > 0 {
+			key := strings.Join(parts, " ")
+			years[key] = append(years[key], year)
+		}
+	}
+	for _, v := range p.AcesVehicles {
+		add(v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel)
+	}
+	for _, v := range p.Vehicles {
+		year, _ := strconv.Atoi(v.Year)
+		add(year, v.Make, v.Model, v.Style)
+	}
+	for _, v := range p.LuverneVehicles {
+		year, _ := strconv.Atoi(v.Year)
+		add(year, v.Make, v.Model, v.Body)
+	}
+
+	vehicles := make([]string, 0, len(years))
+	for v := range years {
+		vehicles = append(vehicles, v)
+	}
+	sort.Strings(vehicles)
+	var lines []string
+	for _, v := range vehicles {
+		for _, r := range yearRanges(years[v]) {
+			lines = append(lines, r+" "+v)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// yearRanges collapses years into runs, e.g. 2010, 2011, 2012, 2015 into
+// "2010-2012" and "2015".
+func yearRanges(years []int) []string {
+	sort.Ints(years)
+	var ranges []string
+	for i := 0; i < len(years); {
+		j := i
+		for j+1 < len(years) && years[j+1] <= years[j]+1 {
+			j++
+		}
+		if years[i] == years[j] {
+			ranges = append(ranges, strconv.Itoa(years[i]))
+		} else {
+			ranges = append(ranges, fmt.Sprintf("%d-%d", years[i], years[j]))
+		}
+		i = j + 1
+	}
+	return ranges
+}
+
+func intersect(a, b []int) []int {
+	var out []int
+	for _, x := range a {
+		for _, y := range b {
+			if x == y {
+				out = append(out, x)
+				break
+			}
+		}
+	}
+	return out
+}
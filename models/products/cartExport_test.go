@@ -0,0 +1,104 @@
+package products
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/curt-labs/API/models/brand"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestCartExport(t *testing.T) {
+	Convey("Testing cart platform exports", t, func() {
+		img := func(sort, size string, w int) Image {
+			u, _ := url.Parse("https://images.curtmfg.com/11000_" + sort + "_" + size + ".jpg")
+			return Image{Sort: sort, Size: size, Width: w, Height: w, Path: u}
+		}
+		p := Part{
+			ID:         1,
+			PartNumber: "11000",
+			Brand:      brand.Brand{ID: 1, Name: "CURT"},
+			ShortDesc:  "Class 3 Trailer Hitch",
+			UPC:        "016709110006",
+			Class:      Class{Name: "Class III"},
+			Categories: []Category{{CategoryID: 11, Title: "Trailer Hitches"}},
+			Attributes: []Attribute{
+				{Key: "Finish", Value: "Carbide Black", Sort: 2},
+				{Key: "GTW", Value: "6,000 lbs.", Sort: 1},
+				{Key: "Blank", Value: ""},
+			},
+			Content: []Content{
+				{Text: "Heavy & strong", ContentType: ContentType{Type: "Bullet"}, Sort: 2},
+				{Text: "<b>Built to last</b>", ContentType: ContentType{Type: "Marketing", AllowsHTML: true}, Sort: 1},
+			},
+			Pricing:  []Price{{Type: "List", Price: 250}, {Type: "Map", Price: 200}},
+			Customer: CustomerPart{Price: 210},
+			Images:   []Image{img("b", "Tall", 300), img("a", "Venti", 1000), img("a", "Grande", 500)},
+			AcesVehicles: []AcesVehicle{
+				{Base: BaseVehicle{Year: 2016, Make: "Ford", Model: "F-150"}, Submodel: "XL"},
+				{Base: BaseVehicle{Year: 2015, Make: "Ford", Model: "F-150"}, Submodel: "XL"},
+				{Base: BaseVehicle{Year: 2018, Make: "Ford", Model: "F-150"}, Submodel: "XL"},
+			},
+			Vehicles: []VehicleApplication{{Year: "2010", Make: "Chevrolet", Model: "Silverado"}},
+		}
+
+		Convey("every profile writes rows as wide as its header", func() {
+			for _, prof := range ExportProfiles {
+				for _, row := range prof.rows(&p) {
+					So(row, ShouldHaveLength, len(prof.Header))
+				}
+			}
+		})
+
+		Convey("shopify puts further images on rows of their own", func() {
+			rows := shopifyRows(&p)
+			So(rows, ShouldHaveLength, 2)
+			So(rows[0][0], ShouldEqual, "curt-11000")
+			So(rows[0][1], ShouldEqual, "Class 3 Trailer Hitch #11000")
+			So(rows[0][2], ShouldEqual, "<p><b>Built to last</b></p><ul><li>Heavy &amp; strong</li></ul>")
+			So(rows[0][10], ShouldEqual, "210.00")
+			So(rows[0][11], ShouldEqual, "250.00")
+			So(rows[0][15], ShouldEqual, "https://images.curtmfg.com/11000_a_Venti.jpg")
+			So(rows[0][20], ShouldEqual, `[{"name":"GTW","value":"6,000 lbs.","sort":1},{"name":"Finish","value":"Carbide Black","sort":2}]`)
+			So(rows[1][0], ShouldEqual, "curt-11000")
+			So(rows[1][15], ShouldEqual, "https://images.curtmfg.com/11000_b_Tall.jpg")
+			So(rows[1][16], ShouldEqual, "2")
+			So(rows[1][1], ShouldEqual, "")
+		})
+
+		Convey("bigcommerce writes attributes as custom fields", func() {
+			row := bigCommerceRows(&p)[0]
+			So(row[12], ShouldEqual, "GTW=6,000 lbs.;Finish=Carbide Black;Fitment=2010 Chevrolet Silverado, 2015-2016 Ford F-150 XL, 2018 Ford F-150 XL")
+			So(row[6], ShouldEqual, "210.00")
+			So(row[7], ShouldEqual, "250.00")
+			So(row[13], ShouldEqual, "https://images.curtmfg.com/11000_a_Venti.jpg")
+			So(row[15], ShouldEqual, "")
+		})
+
+		Convey("woocommerce writes attributes in sort order", func() {
+			row := wooRows(&p)[0]
+			So(row[7], ShouldEqual, "210.00")
+			So(row[10], ShouldEqual, "https://images.curtmfg.com/11000_a_Venti.jpg, https://images.curtmfg.com/11000_b_Tall.jpg")
+			So(row[13:17], ShouldResemble, []string{"GTW", "6,000 lbs.", "1", "0"})
+			So(row[17:21], ShouldResemble, []string{"Finish", "Carbide Black", "1", "0"})
+			So(row[21], ShouldEqual, "")
+		})
+
+		Convey("parts without a dealer price are sold at List", func() {
+			p.Customer.Price = 0
+			price, compareAt := exportPrices(&p)
+			So(price, ShouldEqual, "250.00")
+			So(compareAt, ShouldEqual, "")
+		})
+
+		Convey("fitment collapses years into ranges", func() {
+			So(FitmentText(&p), ShouldEqual, "2010 Chevrolet Silverado\n2015-2016 Ford F-150 XL\n2018 Ford F-150 XL")
+			So(yearRanges([]int{2012, 2010, 2011, 2011, 2015}), ShouldResemble, []string{"2010-2012", "2015"})
+			So(FitmentText(&Part{}), ShouldEqual, "")
+		})
+
+		Convey("handles are safe for URLs", func() {
+			So(exportHandle(&Part{PartNumber: "C-11000/B", Brand: brand.Brand{Name: "ARIES Automotive"}}), ShouldEqual, "aries-automotive-c-11000-b")
+		})
+	})
+}
@@ -0,0 +1,516 @@
+package products
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Parts are written to Mongo by the catalog sync, not through the API, so
+// the change feed is made by scanning the catalog: each part's sections are
+// fingerprinted, and compared with their fingerprints from the last scan.
+// Every difference is recorded as a PartChange, numbered in the order it
+// was found, and a part that's gone from the catalog leaves a deleted
+// change behind as its tombstone.
+
+// Kinds of part change.
+const (
+	PartCreated       = "created"
+	PartUpdated       = "updated"
+	PartStatusChanged = "status-changed"
+	PartDeleted       = "deleted"
+)
+
+// Sections of a part whose changes are told apart. Content is everything
+// not in another section, except inventory, which changes too often to be
+// part of the catalog.
+const (
+	SectionStatus     = "status"
+	SectionContent    = "content"
+	SectionPricing    = "pricing"
+	SectionCategories = "categories"
+	SectionFitment    = "fitment"
+)
+
+// PartChange is an entry in the change feed.
+type PartChange struct {
+	Sequence       int64     `bson:"_id" json:"-" xml:"-"`
+	Type           string    `bson:"type" json:"type" xml:"type,attr"`
+	PartID         int       `bson:"part_id" json:"part_id" xml:"part_id,attr"`
+	PartNumber     string    `bson:"part_number" json:"part_number" xml:"part_number,attr"`
+	BrandID        int       `bson:"brand_id" json:"brand_id" xml:"brand_id,attr"`
+	Status         int       `bson:"status" json:"status" xml:"status,attr"`
+	PreviousStatus int       `bson:"previous_status,omitempty" json:"previous_status,omitempty" xml:"previous_status,attr,omitempty"`
+	Sections       []string  `bson:"sections" json:"sections" xml:"sections>section"`
+	Date           time.Time `bson:"date" json:"date" xml:"date,attr"`
+}
+
+// ChangeFeed is a page of the change feed. Cursor resumes the feed after
+// its last change; More says whether there are changes after it already.
+type ChangeFeed struct {
+	Changes []PartChange `json:"changes" xml:"changes>change"`
+	Cursor  string       `json:"cursor" xml:"cursor,attr"`
+	More    bool         `json:"more" xml:"more,attr"`
+}
+
+// partFingerprint is what a part looked like at the last scan.
+type partFingerprint struct {
+	PartID     int    `bson:"_id"`
+	PartNumber string `bson:"part_number"`
+	BrandID    int    `bson:"brand_id"`
+	Status     int    `bson:"status"`
+	Content    string `bson:"content"`
+	Pricing    string `bson:"pricing"`
+	Categories string `bson:"categories"`
+	Fitment    string `bson:"fitment"`
+}
+
+var (
+	ErrInvalidCursor = errors.New("that change feed cursor isn't valid")
+	ErrLeaseLost     = errors.New("the lease expired and was taken by another server")
+)
+
+const (
+	changeCollection      = "part_changes"
+	fingerprintCollection = "part_fingerprints"
+	changeStateCollection = "part_change_state"
+	changeStateID         = "catalog"
+	cursorPrefix          = "pc1:"
+)
+
+// sectionFields are the part's fields in each section other than content.
+var sectionFields = map[string]string{
+	"status":               SectionStatus,
+	"pricing":              SectionPricing,
+	"categories":           SectionCategories,
+	"vehicle_applications": SectionFitment,
+	"aces_vehicles":        SectionFitment,
+	"luverne_applications": SectionFitment,
+	"_id":                  "",
+	"inventory":            "",
+}
+
+// GetChanges returns the changes to the customer's brands' parts after the
+// cursor, oldest first. An empty cursor starts from the beginning.
+func GetChanges(dtx *apicontext.DataContext, cursor string, count int) (ChangeFeed, error) {
+	feed := ChangeFeed{Changes: []PartChange{}, Cursor: cursor}
+	since, err := decodeCursor(cursor)
+	if err != nil {
+		return feed, err
+	}
+	if count < 1 || count > 1000 {
+		count = 100
+	}
+	if err = database.Init(); err != nil {
+		return feed, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+
+	db := session.DB(database.ProductDatabase)
+	var state changeState
+	if err = db.C(changeStateCollection).FindId(changeStateID).One(&state); err == mgo.ErrNotFound {
+		return feed, nil
+	} else if err != nil {
+		return feed, err
+	}
+	query := changesQuery(since, state.committed(), getBrandsFromDTX(dtx))
+	err = db.C(changeCollection).Find(query).Sort("_id").Limit(count + 1).All(&feed.Changes)
+	if err != nil {
+		return feed, err
+	}
+	if len(feed.Changes) > count {
+		feed.Changes, feed.More = feed.Changes[:count], true
+	}
+	if len(feed.Changes) > 0 {
+		feed.Cursor = encodeCursor(feed.Changes[len(feed.Changes)-1].Sequence)
+	}
+	return feed, nil
+}
+
+// changesQuery finds the brands' changes after since, up to the last one
+// committed. Sequences are reserved before their changes are recorded, so
+// without the limit a cursor could pass changes still being recorded and
+// never see them.
+func changesQuery(since, committed int64, brands []int) bson.M {
+	return bson.M{"_id": bson.M{"$gt": since, "$lte": committed}, "brand_id": bson.M{"$in": brands}}
+}
+
+func encodeCursor(seq int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(cursorPrefix + strconv.FormatInt(seq, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	if cursor == "" {
+		return 0, nil
+	}
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil || !strings.HasPrefix(string(b), cursorPrefix) {
+		return 0, ErrInvalidCursor
+	}
+	seq, err := strconv.ParseInt(strings.TrimPrefix(string(b), cursorPrefix), 10, 64)
+	if err != nil || seq < 0 {
+		return 0, ErrInvalidCursor
+	}
+	return seq, nil
+}
+
+// ScanChangesEvery scans the catalog for changes every interval until ctx
+// is done.
+func ScanChangesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := ScanChanges(ctx, now, interval); err != nil {
+				log.Printf("Error scanning the catalog for changes: %s\n", err)
+			}
+		}
+	}
+}
+
+// ScanChanges records the changes to the catalog since it was last
+// scanned. Only one server scans at a time: the scan is leased for
+// lease, renewed as it goes, and skipped if another server holds it.
+func ScanChanges(ctx context.Context, now time.Time, lease time.Duration) error {
+	if err := database.Init(); err != nil {
+		return err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	db := session.DB(database.ProductDatabase)
+	state := db.C(changeStateCollection)
+
+	owner := bson.NewObjectId().Hex()
+	ok, err := acquireLease(state, changeStateID, owner, now, lease)
+	if err != nil || !ok {
+		return err
+	}
+	defer releaseLease(state, changeStateID, owner)
+	renewed := time.Now()
+	renew := func() error {
+		if time.Since(renewed) < lease/3 {
+			return nil
+		}
+		renewed = time.Now()
+		return renewLease(state, changeStateID, owner, renewed, lease)
+	}
+
+	previous := make(map[int]partFingerprint)
+	var fp partFingerprint
+	iter := db.C(fingerprintCollection).Find(nil).Iter()
+	for iter.Next(&fp) {
+		previous[fp.PartID] = fp
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+
+	var changes []PartChange
+	var updated []partFingerprint
+	seen := make(map[int]bool, len(previous))
+	// a part that can't be read can't be told apart from one that's been
+	// deleted, so no tombstones are left by a scan that couldn't read them
+	// all
+	complete := true
+	var doc bson.RawD
+	iter = db.C(database.ProductCollectionName).Find(nil).Iter()
+	for iter.Next(&doc) {
+		if err = ctx.Err(); err == nil {
+			err = renew()
+		}
+		if err != nil {
+			iter.Close()
+			return err
+		}
+		cur, err := fingerprint(doc)
+		doc = nil
+		if err != nil {
+			log.Printf("Error fingerprinting a part: %s\n", err)
+			complete = false
+			continue
+		}
+		seen[cur.PartID] = true
+		old, existed := previous[cur.PartID]
+		var prev *partFingerprint
+		if existed {
+			prev = &old
+		}
+		if found := partChanges(prev, &cur); len(found) > 0 {
+			changes = append(changes, found...)
+			updated = append(updated, cur)
+		}
+	}
+	if err = iter.Close(); err != nil {
+		return err
+	}
+	var deleted []int
+	for id, old := range previous {
+		if complete && !seen[id] {
+			change, _ := diffFingerprints(&old, nil)
+			changes = append(changes, change)
+			deleted = append(deleted, id)
+		}
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	sort.SliceStable(changes, func(a, b int) bool { return changes[a].PartID < changes[b].PartID })
+	if err = renewLease(state, changeStateID, owner, time.Now(), lease); err != nil {
+		return err
+	}
+	last, err := reserveSequences(state, len(changes))
+	if err != nil {
+		return err
+	}
+	// changes are recorded and committed before fingerprints, so that a
+	// scan that fails part way finds the same changes again rather than
+	// losing them. Changes that were recorded but not committed are
+	// removed, as the next scan records them again.
+	first := last - int64(len(changes)-1)
+	bulk := db.C(changeCollection).Bulk()
+	for i := range changes {
+		changes[i].Sequence = first + int64(i)
+		changes[i].Date = now
+		bulk.Insert(changes[i])
+	}
+	if _, err = bulk.Run(); err == nil {
+		err = commitSequences(state, owner, last)
+	}
+	if err != nil {
+		db.C(changeCollection).RemoveAll(bson.M{"_id": bson.M{"$gte": first, "$lte": last}})
+		return err
+	}
+	fingerprints := db.C(fingerprintCollection)
+	for _, fp := range updated {
+		if _, err = fingerprints.UpsertId(fp.PartID, fp); err != nil {
+			return err
+		}
+	}
+	if len(deleted) > 0 {
+		_, err = fingerprints.RemoveAll(bson.M{"_id": bson.M{"$in": deleted}})
+	}
+	return err
+}
+
+// acquireLease takes the lease kept in c's document id for owner, unless
+// another server holds it.
+func acquireLease(c *mgo.Collection, id, owner string, now time.Time, lease time.Duration) (bool, error) {
+	query := bson.M{"_id": id, "$or": []bson.M{
+		{"locked_until": bson.M{"$exists": false}},
+		{"locked_until": bson.M{"$lt": now}},
+	}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"locked_until": now.Add(lease), "owner": owner}}, Upsert: true}
+	_, err := c.Find(query).Apply(change, &bson.M{})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// renewLease extends owner's lease, returning ErrLeaseLost if it has
+// expired and been taken by another server.
+func renewLease(c *mgo.Collection, id, owner string, now time.Time, lease time.Duration) error {
+	err := c.Update(bson.M{"_id": id, "owner": owner}, bson.M{"$set": bson.M{"locked_until": now.Add(lease)}})
+	if err == mgo.ErrNotFound {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// releaseLease gives up owner's lease, if it still holds it.
+func releaseLease(c *mgo.Collection, id, owner string) error {
+	err := c.Update(bson.M{"_id": id, "owner": owner}, bson.M{"$unset": bson.M{"locked_until": 1, "owner": 1}})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// changeState is the change feed's sequence numbers. Committed is the last
+// change readers may see; feeds recorded before it was kept have only
+// Sequence.
+type changeState struct {
+	Sequence  int64  `bson:"sequence"`
+	Committed *int64 `bson:"committed"`
+}
+
+func (s changeState) committed() int64 {
+	if s.Committed == nil {
+		return s.Sequence
+	}
+	return *s.Committed
+}
+
+// reserveSequences reserves n change sequence numbers, returning the last.
+func reserveSequences(c *mgo.Collection, n int) (int64, error) {
+	var state changeState
+	change := mgo.Change{Update: bson.M{"$inc": bson.M{"sequence": n}}, Upsert: true, ReturnNew: true}
+	_, err := c.FindId(changeStateID).Apply(change, &state)
+	return state.Sequence, err
+}
+
+// commitSequences lets readers see the changes up to last, so long as
+// owner still holds the scan lease.
+func commitSequences(c *mgo.Collection, owner string, last int64) error {
+	err := c.Update(bson.M{"_id": changeStateID, "owner": owner}, bson.M{"$max": bson.M{"committed": last}})
+	if err == mgo.ErrNotFound {
+		return ErrLeaseLost
+	}
+	return err
+}
+
+// fingerprint hashes each section of a part's document. Fields are hashed
+// in a canonical form, with the keys of every document sorted, so that the
+// same part written with its fields in another order has the same
+// fingerprint.
+func fingerprint(doc bson.RawD) (partFingerprint, error) {
+	var fp partFingerprint
+	hashes := map[string]hash.Hash{
+		SectionContent:    sha1.New(),
+		SectionPricing:    sha1.New(),
+		SectionCategories: sha1.New(),
+		SectionFitment:    sha1.New(),
+	}
+	sorted := make(bson.RawD, len(doc))
+	copy(sorted, doc)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	foundID := false
+	for _, e := range sorted {
+		var err error
+		switch e.Name {
+		case "id":
+			err = e.Value.Unmarshal(&fp.PartID)
+			foundID = err == nil
+		case "part_number":
+			err = e.Value.Unmarshal(&fp.PartNumber)
+		case "status":
+			err = e.Value.Unmarshal(&fp.Status)
+		case "brand":
+			var b struct {
+				ID int `bson:"id"`
+			}
+			err = e.Value.Unmarshal(&b)
+			fp.BrandID = b.ID
+		}
+		if err != nil {
+			return fp, err
+		}
+
+		section, ok := sectionFields[e.Name]
+		if !ok {
+			section = SectionContent
+		}
+		if h, ok := hashes[section]; ok {
+			data, err := canonical(e)
+			if err != nil {
+				return fp, err
+			}
+			h.Write(data)
+		}
+	}
+	if !foundID {
+		return fp, errors.New("part has no id")
+	}
+	sum := func(section string) string {
+		return hex.EncodeToString(hashes[section].Sum(nil))
+	}
+	fp.Content, fp.Pricing = sum(SectionContent), sum(SectionPricing)
+	fp.Categories, fp.Fitment = sum(SectionCategories), sum(SectionFitment)
+	return fp, nil
+}
+
+// canonical encodes a field with the keys of its documents sorted. Arrays
+// keep their order.
+func canonical(e bson.RawDocElem) ([]byte, error) {
+	var v interface{}
+	if err := e.Value.Unmarshal(&v); err != nil {
+		return nil, err
+	}
+	return bson.Marshal(bson.D{{Name: e.Name, Value: sortKeys(v)}})
+}
+
+func sortKeys(v interface{}) interface{} {
+	switch v := v.(type) {
+	case bson.M:
+		d := make(bson.D, 0, len(v))
+		for k, val := range v {
+			d = append(d, bson.DocElem{Name: k, Value: sortKeys(val)})
+		}
+		sort.Slice(d, func(i, j int) bool { return d[i].Name < d[j].Name })
+		return d
+	case []interface{}:
+		s := make([]interface{}, len(v))
+		for i := range v {
+			s[i] = sortKeys(v[i])
+		}
+		return s
+	}
+	return v
+}
+
+// partChanges returns the changes from old to cur, either of which is nil
+// if the part didn't, or doesn't, exist. A part that moved to another
+// brand is deleted from its old brand's feed and created in its new one's.
+func partChanges(old, cur *partFingerprint) []PartChange {
+	if old != nil && cur != nil && old.BrandID != cur.BrandID {
+		removed, _ := diffFingerprints(old, nil)
+		created, _ := diffFingerprints(nil, cur)
+		return []PartChange{removed, created}
+	}
+	if change, ok := diffFingerprints(old, cur); ok {
+		return []PartChange{change}
+	}
+	return nil
+}
+
+// diffFingerprints returns the change from old to cur, either of which is
+// nil if the part didn't, or doesn't, exist, and whether there is one.
+func diffFingerprints(old, cur *partFingerprint) (PartChange, bool) {
+	switch {
+	case old == nil && cur == nil:
+		return PartChange{}, false
+	case old == nil:
+		return PartChange{Type: PartCreated, PartID: cur.PartID, PartNumber: cur.PartNumber, BrandID: cur.BrandID, Status: cur.Status, Sections: []string{}}, true
+	case cur == nil:
+		return PartChange{Type: PartDeleted, PartID: old.PartID, PartNumber: old.PartNumber, BrandID: old.BrandID, Status: old.Status, Sections: []string{}}, true
+	}
+
+	change := PartChange{Type: PartUpdated, PartID: cur.PartID, PartNumber: cur.PartNumber, BrandID: cur.BrandID, Status: cur.Status, Sections: []string{}}
+	if old.Status != cur.Status {
+		change.Type = PartStatusChanged
+		change.PreviousStatus = old.Status
+		change.Sections = append(change.Sections, SectionStatus)
+	}
+	// a new part number is a change to its content
+	if old.Content != cur.Content || old.PartNumber != cur.PartNumber {
+		change.Sections = append(change.Sections, SectionContent)
+	}
+	if old.Pricing != cur.Pricing {
+		change.Sections = append(change.Sections, SectionPricing)
+	}
+	if old.Categories != cur.Categories {
+		change.Sections = append(change.Sections, SectionCategories)
+	}
+	if old.Fitment != cur.Fitment {
+		change.Sections = append(change.Sections, SectionFitment)
+	}
+	return change, len(change.Sections) > 0
+}
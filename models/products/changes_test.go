@@ -0,0 +1,137 @@
+package products
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestChanges(t *testing.T) {
+	Convey("Testing the part change feed", t, func() {
+		raw := func(doc bson.D) bson.RawD {
+			data, err := bson.Marshal(doc)
+			So(err, ShouldBeNil)
+			var rd bson.RawD
+			So(bson.Unmarshal(data, &rd), ShouldBeNil)
+			return rd
+		}
+		part := bson.D{
+			{Name: "id", Value: 11000},
+			{Name: "part_number", Value: "11000"},
+			{Name: "brand", Value: bson.M{"id": 1}},
+			{Name: "status", Value: 800},
+			{Name: "short_description", Value: "Class 1 Hitch"},
+			{Name: "pricing", Value: []bson.D{{{Name: "type", Value: "List"}, {Name: "price", Value: 250.0}}}},
+			{Name: "categories", Value: []bson.M{{"id": 3}}},
+			{Name: "vehicle_applications", Value: []bson.D{{{Name: "year", Value: "2015"}, {Name: "make", Value: "Ford"}}}},
+			{Name: "inventory", Value: bson.M{"totalavailability": 4}},
+		}
+		with := func(name string, v interface{}) bson.D {
+			d := make(bson.D, len(part))
+			copy(d, part)
+			for i := range d {
+				if d[i].Name == name {
+					d[i].Value = v
+				}
+			}
+			return d
+		}
+
+		Convey("parts are fingerprinted by section", func() {
+			old, err := fingerprint(raw(part))
+			So(err, ShouldBeNil)
+			So(old.PartID, ShouldEqual, 11000)
+			So(old.BrandID, ShouldEqual, 1)
+			So(old.Status, ShouldEqual, 800)
+
+			same, _ := fingerprint(raw(with("inventory", bson.M{"totalavailability": 0})))
+			_, changed := diffFingerprints(&old, &same)
+			So(changed, ShouldBeFalse)
+
+			cur, _ := fingerprint(raw(with("pricing", []bson.D{{{Name: "type", Value: "List"}, {Name: "price", Value: 260.0}}})))
+			change, changed := diffFingerprints(&old, &cur)
+			So(changed, ShouldBeTrue)
+			So(change.Type, ShouldEqual, PartUpdated)
+			So(change.Sections, ShouldResemble, []string{SectionPricing})
+
+			cur, _ = fingerprint(raw(with("vehicle_applications", []bson.M{})))
+			change, _ = diffFingerprints(&old, &cur)
+			So(change.Sections, ShouldResemble, []string{SectionFitment})
+
+			cur, _ = fingerprint(raw(with("status", 999)))
+			change, _ = diffFingerprints(&old, &cur)
+			So(change.Type, ShouldEqual, PartStatusChanged)
+			So(change.PreviousStatus, ShouldEqual, 800)
+			So(change.Sections, ShouldResemble, []string{SectionStatus})
+
+			cur, _ = fingerprint(raw(with("short_description", "Class 2 Hitch")))
+			change, _ = diffFingerprints(&old, &cur)
+			So(change.Sections, ShouldResemble, []string{SectionContent})
+		})
+
+		Convey("fingerprints don't depend on the order of fields", func() {
+			old, _ := fingerprint(raw(part))
+			reordered := bson.D{
+				{Name: "vehicle_applications", Value: []bson.D{{{Name: "make", Value: "Ford"}, {Name: "year", Value: "2015"}}}},
+				{Name: "status", Value: 800},
+				{Name: "pricing", Value: []bson.D{{{Name: "price", Value: 250.0}, {Name: "type", Value: "List"}}}},
+				{Name: "brand", Value: bson.M{"id": 1}},
+				{Name: "short_description", Value: "Class 1 Hitch"},
+				{Name: "categories", Value: []bson.M{{"id": 3}}},
+				{Name: "part_number", Value: "11000"},
+				{Name: "id", Value: 11000},
+			}
+			cur, err := fingerprint(raw(reordered))
+			So(err, ShouldBeNil)
+			So(cur, ShouldResemble, old)
+			So(partChanges(&old, &cur), ShouldBeEmpty)
+		})
+
+		Convey("parts moved to another brand leave their old brand's feed", func() {
+			old, _ := fingerprint(raw(part))
+			cur, _ := fingerprint(raw(with("brand", bson.M{"id": 3})))
+			changes := partChanges(&old, &cur)
+			So(len(changes), ShouldEqual, 2)
+			So(changes[0].Type, ShouldEqual, PartDeleted)
+			So(changes[0].BrandID, ShouldEqual, 1)
+			So(changes[1].Type, ShouldEqual, PartCreated)
+			So(changes[1].BrandID, ShouldEqual, 3)
+		})
+
+		Convey("new and removed parts are created and deleted", func() {
+			fp, _ := fingerprint(raw(part))
+			change, _ := diffFingerprints(nil, &fp)
+			So(change.Type, ShouldEqual, PartCreated)
+			change, _ = diffFingerprints(&fp, nil)
+			So(change.Type, ShouldEqual, PartDeleted)
+			So(change.PartNumber, ShouldEqual, "11000")
+		})
+
+		Convey("parts without an id aren't fingerprinted", func() {
+			_, err := fingerprint(raw(bson.D{{Name: "part_number", Value: "X"}}))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("readers stop at the last committed change", func() {
+			committed := int64(40)
+			So(changeState{Sequence: 50, Committed: &committed}.committed(), ShouldEqual, 40)
+			So(changeState{Sequence: 50}.committed(), ShouldEqual, 50)
+			So(changesQuery(30, 40, []int{1}), ShouldResemble, bson.M{
+				"_id":      bson.M{"$gt": int64(30), "$lte": int64(40)},
+				"brand_id": bson.M{"$in": []int{1}},
+			})
+		})
+
+		Convey("cursors are opaque sequence numbers", func() {
+			seq, err := decodeCursor(encodeCursor(42))
+			So(err, ShouldBeNil)
+			So(seq, ShouldEqual, 42)
+			seq, err = decodeCursor("")
+			So(err, ShouldBeNil)
+			So(seq, ShouldEqual, 0)
+			_, err = decodeCursor("42")
+			So(err, ShouldEqual, ErrInvalidCursor)
+		})
+	})
+}
@@ -1,10 +1,12 @@
 package products
 
 import (
+	"context"
 	"database/sql"
 	"strconv"
 	"strings"
 
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
 	_ "github.com/go-sql-driver/mysql"
 )
@@ -297,7 +299,7 @@ type DefinedConfiguration struct {
 	ConfigID int
 }
 
-func (l *Lookup) GetConfigurations() error {
+func (l *Lookup) GetConfigurations(dtx *apicontext.DataContext) error {
 	stmtBeginning := `select distinct cat.name, cat.AcesTypeID from vcdb_Vehicle as v
 		join VehicleConfigAttribute as vca on v.ConfigID = vca.VehicleConfigID
 		join ConfigAttribute as ca on vca.AttributeID = ca.ID
@@ -325,13 +327,13 @@ func (l *Lookup) GetConfigurations() error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(wholeStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), wholeStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model, l.Vehicle.Submodel)
+	res, err := stmt.QueryContext(dtx.Context(), l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model, l.Vehicle.Submodel)
 	if err != nil {
 		return err
 	}
@@ -344,7 +346,7 @@ func (l *Lookup) GetConfigurations() error {
 		var acesType int
 		err = res.Scan(&conf.Key, &acesType)
 		if err == nil {
-			go conf.allOptions(l, acesType, ch)
+			go conf.allOptions(dtx.Context(), l, acesType, ch)
 			count++
 		}
 	}
@@ -365,7 +367,7 @@ func (l *Lookup) GetConfigurations() error {
 	return nil
 }
 
-func (v Vehicle) getDefinedConfigurations(apiKey string) (*map[int][]DefinedConfiguration, error) {
+func (v Vehicle) getDefinedConfigurations(ctx context.Context, apiKey string) (*map[int][]DefinedConfiguration, error) {
 	configs := make(map[int][]DefinedConfiguration, 0)
 
 	err := database.Init()
@@ -373,13 +375,13 @@ func (v Vehicle) getDefinedConfigurations(apiKey string) (*map[int][]DefinedConf
 		return nil, err
 	}
 
-	stmt, err := database.DB.Prepare(getDefinedConfigurationsForVehicleStmt)
+	stmt, err := database.DB.PrepareContext(ctx, getDefinedConfigurationsForVehicleStmt)
 	if err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	rows, err := stmt.Query(apiKey, v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel)
+	rows, err := stmt.QueryContext(ctx, apiKey, v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel)
 	if err != nil || rows == nil {
 		return nil, err
 	}
@@ -418,18 +420,18 @@ func (v Vehicle) getDefinedConfigurations(apiKey string) (*map[int][]DefinedConf
 // from preferrable the VCDB, or CurtDev if it's a custom configuration
 // type that match the current vehicle.
 // This is meant to be run in a goroutine, hence the channel.
-func (c Configuration) allOptions(l *Lookup, acesType int, ch chan error) {
+func (c Configuration) allOptions(ctx context.Context, l *Lookup, acesType int, ch chan error) {
 	var opts []string
 	var err error
 
 	if acesType == 0 { // Custom configuraton (implicit)
-		opts, err = c.getCurtOptions(l.Vehicle)
+		opts, err = c.getCurtOptions(ctx, l.Vehicle)
 		if err != nil {
 			ch <- err
 			return
 		}
 	} else { // VCDB configuration (explicit)
-		opts, err = c.getVcdbOptions(l.Vehicle)
+		opts, err = c.getVcdbOptions(ctx, l.Vehicle)
 		if err != nil {
 			ch <- err
 			return
@@ -459,11 +461,11 @@ func (c Configuration) allOptions(l *Lookup, acesType int, ch chan error) {
 
 // getVcdbOptions will return the configuration options
 // that fit the provided vehicel and the provided type from the VCDB.
-func (c *Configuration) getVcdbOptions(v Vehicle) ([]string, error) {
+func (c *Configuration) getVcdbOptions(ctx context.Context, v Vehicle) ([]string, error) {
 	var opts []string
 	var err error
 
-	id, err := v.GetVcdbID()
+	id, err := v.GetVcdbID(ctx)
 	if err != nil || id == 0 {
 		return opts, err
 	}
@@ -476,69 +478,69 @@ func (c *Configuration) getVcdbOptions(v Vehicle) ([]string, error) {
 	var stmt *sql.Stmt
 	switch strings.ToLower(strings.Replace(c.Key, " ", "", -1)) {
 	case "aspiration":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetAspirationForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetAspirationForVehicle)
 	case "bedlength":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBedLengthForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBedLengthForVehicle)
 	case "bedtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBedTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBedTypeForVehicle)
 	case "bodytype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBodyTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBodyTypeForVehicle)
 	case "brakeabs":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBrakeABSForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBrakeABSForVehicle)
 	case "brakesystem":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBrakeSystemForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBrakeSystemForVehicle)
 	case "frontbraketype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFrontBrakeTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFrontBrakeTypeForVehicle)
 	case "rearbraketype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetRearBrakeTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetRearBrakeTypeForVehicle)
 	case "cylinderheadtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetCylinderHeadTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetCylinderHeadTypeForVehicle)
 	case "drivetype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetDriveTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetDriveTypeForVehicle)
 	case "enginedesignation":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetEngineDesignationForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetEngineDesignationForVehicle)
 	case "engineversion":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetEngineVersionForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetEngineVersionForVehicle)
 	case "enginevin":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetEngineVINForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetEngineVINForVehicle)
 	case "fueldeliverysubtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFuelDeliverySubTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFuelDeliverySubTypeForVehicle)
 	case "fueldeliverytype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFuelDeliveryTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFuelDeliveryTypeForVehicle)
 	case "fuelsystemcontroltype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFuelSystemControlTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFuelSystemControlTypeForVehicle)
 	case "fuelsystemdesign":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFuelSystemDesignForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFuelSystemDesignForVehicle)
 	case "fueltype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFuelTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFuelTypeForVehicle)
 	case "ignitionsystem":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetIgnitionSystemForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetIgnitionSystemForVehicle)
 	case "mfrbodycode":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetMfrBodyCodeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetMfrBodyCodeForVehicle)
 	case "numberofdoors":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetBodyNumDoorsForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetBodyNumDoorsForVehicle)
 	case "frontspringtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetFrontSpringTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetFrontSpringTypeForVehicle)
 	case "rearspringtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetRearSpringTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetRearSpringTypeForVehicle)
 	case "steeringsystem":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetSteeringSystemForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetSteeringSystemForVehicle)
 	case "steeringtype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetSteeringTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetSteeringTypeForVehicle)
 	case "transmissioncontroltype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetTransmissionControlTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetTransmissionControlTypeForVehicle)
 	case "transmissionelectroniccontrolled":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetElecControlledForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetElecControlledForVehicle)
 	case "transmissionmanufacturercode":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetTransmissionMfrCodeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetTransmissionMfrCodeForVehicle)
 	case "transmissionnumspeeds":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetTransmissionNumSpeedsForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetTransmissionNumSpeedsForVehicle)
 	case "transmissiontype":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetTransmissionTypeForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetTransmissionTypeForVehicle)
 	case "valves":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetValvesForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetValvesForVehicle)
 	case "wheelbase":
-		stmt, err = database.VcdbDB.Prepare(vcdb_GetWheelBaseForVehicle)
+		stmt, err = database.VcdbDB.PrepareContext(ctx, vcdb_GetWheelBaseForVehicle)
 	default:
 	}
 	if err != nil || stmt == nil {
@@ -546,7 +548,7 @@ func (c *Configuration) getVcdbOptions(v Vehicle) ([]string, error) {
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(id)
+	res, err := stmt.QueryContext(ctx, id)
 	if err != nil || res == nil {
 		return opts, err
 	}
@@ -565,7 +567,7 @@ func (c *Configuration) getVcdbOptions(v Vehicle) ([]string, error) {
 
 // getCurtOptions will return the configuration options
 // that fit the provided vehicle and the provided type from CurtDev.
-func (c *Configuration) getCurtOptions(v Vehicle) ([]string, error) {
+func (c *Configuration) getCurtOptions(ctx context.Context, v Vehicle) ([]string, error) {
 	var opts []string
 	var err error
 
@@ -574,13 +576,13 @@ func (c *Configuration) getCurtOptions(v Vehicle) ([]string, error) {
 		return opts, err
 	}
 
-	stmt, err := database.DB.Prepare(getAllOptionsForType)
+	stmt, err := database.DB.PrepareContext(ctx, getAllOptionsForType)
 	if err != nil {
 		return opts, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel, c.Key)
+	res, err := stmt.QueryContext(ctx, v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel, c.Key)
 	if err != nil || res == nil {
 		return opts, err
 	}
@@ -19,7 +19,7 @@ func TestGetConfigurations(t *testing.T) {
 	Convey("Testing GetConfigurations()", t, func() {
 
 		Convey("without year/make/model", func() {
-			err := l.GetConfigurations()
+			err := l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			So(len(l.Configurations), ShouldEqual, 0)
@@ -33,7 +33,7 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Base.Year = l.Years[api_helpers.RandGenerator(len(l.Years)-1)]
 
-			err = l.GetConfigurations()
+			err = l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			So(len(l.Configurations), ShouldEqual, 0)
@@ -53,7 +53,7 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetConfigurations()
+			err = l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			So(len(l.Configurations), ShouldEqual, 0)
@@ -75,14 +75,14 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetConfigurations()
+			err = l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			if (len(l.Configurations)) > 0 {
@@ -116,14 +116,14 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			if (len(l.Submodels)) == 0 {
@@ -131,7 +131,7 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Submodel = l.Submodels[api_helpers.RandGenerator(len(l.Submodels)-1)]
 
-			err = l.GetConfigurations()
+			err = l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			//TODO fix - runtime error
@@ -157,7 +157,7 @@ func TestGetConfigurations(t *testing.T) {
 			l.Vehicle.Base.Make = "KD"
 			l.Vehicle.Base.Model = "123"
 			l.Vehicle.Submodel = "LKJ"
-			err := l.GetConfigurations()
+			err := l.GetConfigurations(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Configurations, ShouldNotEqual, nil)
 			So(len(l.Configurations), ShouldEqual, 0)
@@ -166,7 +166,7 @@ func TestGetConfigurations(t *testing.T) {
 
 	Convey("Test getDefinedConfigurations()", t, func() {
 
-		configs, err := l.Vehicle.getDefinedConfigurations(MockedDTX.APIKey)
+		configs, err := l.Vehicle.getDefinedConfigurations(MockedDTX.Context(), MockedDTX.APIKey)
 		So(err, ShouldEqual, nil)
 		So(configs, ShouldNotEqual, nil)
 
@@ -186,14 +186,14 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			if (len(l.Submodels)) == 0 {
@@ -201,7 +201,7 @@ func TestGetConfigurations(t *testing.T) {
 			}
 			l.Vehicle.Submodel = l.Submodels[api_helpers.RandGenerator(len(l.Submodels)-1)]
 
-			configs, err := l.Vehicle.getDefinedConfigurations(MockedDTX.APIKey)
+			configs, err := l.Vehicle.getDefinedConfigurations(MockedDTX.Context(), MockedDTX.APIKey)
 			So(err, ShouldEqual, nil)
 			So(configs, ShouldNotEqual, nil)
 		})
@@ -30,6 +30,10 @@ type Inventory struct {
 	Warehouse   Warehouse `json:"warehouse" xml:"warehouse"`
 	Quantity    int       `json:"quantity" xml:"quantity,attr"`
 	DateUpdated time.Time `json:"date_updated" xml:"date_update,attr"`
+	// Distance, in miles, and Default are only set on availability
+	// responses.
+	Distance *float64 `json:"distance,omitempty" xml:"distance,attr,omitempty" bson:"-"`
+	Default  bool     `json:"default,omitempty" xml:"default,attr,omitempty" bson:"-"`
 }
 
 type State struct {
@@ -45,9 +49,12 @@ type Country struct {
 	Abbreviation string `json:"abbreviation" xml:"abbreviation,attr"`
 }
 
+// FeedRecord is a line of a warehouse inventory feed. Its part is given by
+// PartNumber, or else by ID in Part.
 type FeedRecord struct {
 	Warehouse  Warehouse `json:"warehouse" xml:"warehouse"`
 	Part       int       `json:"part" xml:"part,attr"`
+	PartNumber string    `json:"part_number" xml:"part_number,attr"`
 	Quantity   int       `json:"quantity" xml:"quantity,attr"`
-	DateUpdate time.Time `json:"date_updated" xml:"date_updated`
+	DateUpdate time.Time `json:"date_updated" xml:"date_updated,attr"`
 }
@@ -0,0 +1,535 @@
+package products
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/helpers/rabbitmq"
+	"github.com/streadway/amqp"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A warehouse inventory feed is a list of FeedRecords, each the quantity of
+// a part on hand at a warehouse as of DateUpdate. Feeds are applied to the
+// inventory of the parts in Mongo. A record only replaces a warehouse's
+// quantity if it's newer than the one there, so feeds can be redelivered,
+// or arrive out of order, without going backwards.
+
+// Feed formats.
+const (
+	FeedCSV  = "csv"
+	FeedJSON = "json"
+)
+
+// FeedReport is the outcome of ingesting a feed. Records that are no newer
+// than the inventory they'd replace are Stale; Rejected records have an
+// unknown warehouse or part, or couldn't be read.
+type FeedReport struct {
+	ID       bson.ObjectId `bson:"_id" json:"id" xml:"id,attr"`
+	Source   string        `bson:"source" json:"source" xml:"source,attr"`
+	Format   string        `bson:"format" json:"format" xml:"format,attr"`
+	Received time.Time     `bson:"received" json:"received" xml:"received,attr"`
+	Records  int           `bson:"records" json:"records" xml:"records,attr"`
+	Applied  int           `bson:"applied" json:"applied" xml:"applied,attr"`
+	Stale    int           `bson:"stale" json:"stale" xml:"stale,attr"`
+	Rejected int           `bson:"rejected" json:"rejected" xml:"rejected,attr"`
+	Errors   []FeedError   `bson:"errors" json:"errors" xml:"errors>error"`
+}
+
+// FeedError is a record that was rejected. Record is its line in a CSV
+// feed, or its index in a JSON one.
+type FeedError struct {
+	Record    int    `bson:"record" json:"record" xml:"record,attr"`
+	Warehouse string `bson:"warehouse,omitempty" json:"warehouse,omitempty" xml:"warehouse,attr,omitempty"`
+	Part      string `bson:"part,omitempty" json:"part,omitempty" xml:"part,attr,omitempty"`
+	Message   string `bson:"message" json:"message" xml:",chardata"`
+}
+
+// InventoryChange is published for every record that changes a part's
+// inventory.
+type InventoryChange struct {
+	FeedID      string    `json:"feedId"`
+	PartID      int       `json:"partId"`
+	PartNumber  string    `json:"partNumber"`
+	Warehouse   string    `json:"warehouse"`
+	Quantity    int       `json:"quantity"`
+	DateUpdated time.Time `json:"dateUpdated"`
+}
+
+// Publisher sends messages to a queue; *rabbitmq.Producer is one.
+type Publisher interface {
+	SendMessage([]byte) error
+}
+
+var (
+	// InventoryEvents, when set, is sent a JSON array of the
+	// InventoryChanges made by each feed.
+	InventoryEvents Publisher
+	eventsMu        sync.Mutex
+
+	// MaxFeedSize is the most, in bytes, that a feed sent over HTTP may be.
+	MaxFeedSize int64 = 64 << 20
+
+	ErrUnknownFeedFormat = errors.New("feeds must be csv or json")
+	ErrFeedNotFound      = errors.New("no inventory feed with that ID")
+)
+
+// InvalidFeedError is returned for a feed that can't be read at all, as
+// opposed to one with records that are rejected.
+type InvalidFeedError struct {
+	Err error
+}
+
+func (e InvalidFeedError) Error() string {
+	return e.Err.Error()
+}
+
+func (e InvalidFeedError) Unwrap() error {
+	return e.Err
+}
+
+const feedCollection = "inventory_feeds"
+
+// Feeds are consumed from, and changes published to, the inventory
+// exchange.
+var (
+	FeedExchange   = rabbitmq.Exchange{Name: "inventory", Type: "direct", RoutingKey: "inventory.feed"}
+	ChangeExchange = rabbitmq.Exchange{Name: "inventory", Type: "direct", RoutingKey: "inventory.change"}
+)
+
+const feedReconnectDelay = 30 * time.Second
+
+// IngestFeed reads a feed in format and applies it, and records and returns
+// its report. source says where the feed came from, such as "api".
+func IngestFeed(ctx context.Context, r io.Reader, format, source string) (*FeedReport, error) {
+	report := &FeedReport{
+		ID:       bson.NewObjectId(),
+		Source:   source,
+		Format:   format,
+		Received: time.Now(),
+		Errors:   []FeedError{},
+	}
+	var records []FeedRecord
+	var lines []int
+	var err error
+	switch format {
+	case FeedCSV:
+		records, lines, err = readCSVFeed(r, report)
+	case FeedJSON:
+		records, lines, err = readJSONFeed(r)
+	default:
+		return nil, ErrUnknownFeedFormat
+	}
+	if err != nil {
+		return nil, InvalidFeedError{err}
+	}
+	report.Records = len(records) + report.Rejected
+
+	if err = database.Init(); err != nil {
+		return nil, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	parts := session.DB(database.ProductDatabase).C(database.ProductCollectionName)
+
+	valid, err := validateFeed(ctx, parts, records, lines, report)
+	if err != nil {
+		return nil, err
+	}
+	// changes that were made are published even if the feed wasn't
+	// finished; redelivering it applies the rest
+	changes, err := applyFeed(ctx, parts, valid, report)
+	publishChanges(changes)
+	if err != nil {
+		return nil, err
+	}
+	err = session.DB(database.ProductDatabase).C(feedCollection).Insert(report)
+	return report, err
+}
+
+// GetFeedReports returns the most recent feed reports.
+func GetFeedReports(ctx context.Context, count int) ([]FeedReport, error) {
+	reports := []FeedReport{}
+	if count < 1 || count > 500 {
+		count = 50
+	}
+	if err := database.Init(); err != nil {
+		return reports, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	err := session.DB(database.ProductDatabase).C(feedCollection).Find(nil).Sort("-received").Limit(count).All(&reports)
+	return reports, err
+}
+
+// GetFeedReport returns the report of a feed.
+func GetFeedReport(ctx context.Context, id string) (FeedReport, error) {
+	var report FeedReport
+	if !bson.IsObjectIdHex(id) {
+		return report, ErrFeedNotFound
+	}
+	if err := database.Init(); err != nil {
+		return report, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	err := session.DB(database.ProductDatabase).C(feedCollection).FindId(bson.ObjectIdHex(id)).One(&report)
+	if err == mgo.ErrNotFound {
+		return report, ErrFeedNotFound
+	}
+	return report, err
+}
+
+// readCSVFeed reads a CSV feed with a header row naming its warehouse,
+// part (a part number), quantity and date_updated columns. Rows that can't
+// be read are rejected on the report.
+func readCSVFeed(r io.Reader, report *FeedReport) ([]FeedRecord, []int, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading feed header: %w", err)
+	}
+	cols := map[string]int{}
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(strings.TrimPrefix(h, "\ufeff")))
+		cols[strings.Replace(h, " ", "_", -1)] = i
+	}
+	if _, ok := cols["part"]; !ok {
+		if i, ok := cols["part_number"]; ok {
+			cols["part"] = i
+		}
+	}
+	for _, c := range []string{"warehouse", "part", "quantity", "date_updated"} {
+		if _, ok := cols[c]; !ok {
+			return nil, nil, fmt.Errorf("feed header has no %s column", c)
+		}
+	}
+
+	var records []FeedRecord
+	var lines []int
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			if pe, ok := err.(*csv.ParseError); ok {
+				report.reject(pe.StartLine, "", "", err.Error())
+				continue
+			}
+			return nil, nil, err
+		}
+		line, _ := cr.FieldPos(0)
+		field := func(c string) string {
+			if i := cols[c]; i < len(row) {
+				return strings.TrimSpace(row[i])
+			}
+			return ""
+		}
+		if strings.Join(row, "") == "" {
+			continue
+		}
+		rec := FeedRecord{Warehouse: Warehouse{Code: field("warehouse")}, PartNumber: field("part")}
+		if rec.Quantity, err = strconv.Atoi(field("quantity")); err != nil {
+			report.reject(line, rec.Warehouse.Code, rec.PartNumber, "quantity must be a whole number")
+			continue
+		}
+		if rec.DateUpdate, err = parseFeedTime(field("date_updated")); err != nil {
+			report.reject(line, rec.Warehouse.Code, rec.PartNumber, "date_updated must be RFC 3339 or YYYY-MM-DD")
+			continue
+		}
+		records = append(records, rec)
+		lines = append(lines, line)
+	}
+	return records, lines, nil
+}
+
+// readJSONFeed reads a JSON array of FeedRecords.
+func readJSONFeed(r io.Reader) ([]FeedRecord, []int, error) {
+	var records []FeedRecord
+	if err := json.NewDecoder(r).Decode(&records); err != nil {
+		return nil, nil, fmt.Errorf("reading feed: %w", err)
+	}
+	lines := make([]int, len(records))
+	for i := range records {
+		lines[i] = i
+	}
+	return records, lines, nil
+}
+
+func parseFeedTime(s string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func (r *FeedReport) reject(record int, warehouse, part, msg string) {
+	r.Rejected++
+	r.Errors = append(r.Errors, FeedError{Record: record, Warehouse: warehouse, Part: part, Message: msg})
+}
+
+// validateFeed resolves the records' warehouses and parts, rejecting those
+// that aren't known, and returns the rest oldest first.
+func validateFeed(ctx context.Context, parts *mgo.Collection, records []FeedRecord, lines []int, report *FeedReport) ([]FeedRecord, error) {
+	warehouses, err := GetWarehouses(ctx)
+	if err != nil {
+		return nil, err
+	}
+	byCode := make(map[string]Warehouse, len(warehouses))
+	for _, w := range warehouses {
+		byCode[strings.ToUpper(w.Code)] = w
+	}
+
+	numbers := []string{}
+	ids := []int{}
+	for _, rec := range records {
+		if rec.PartNumber != "" {
+			numbers = append(numbers, rec.PartNumber)
+		} else {
+			ids = append(ids, rec.Part)
+		}
+	}
+	var found []Part
+	if len(records) == 0 {
+		return []FeedRecord{}, nil
+	}
+	query := bson.M{"$or": []bson.M{{"part_number": bson.M{"$in": numbers}}, {"id": bson.M{"$in": ids}}}}
+	if err = parts.Find(query).Select(bson.M{"id": 1, "part_number": 1}).All(&found); err != nil {
+		return nil, err
+	}
+	byNumber := make(map[string]Part, len(found))
+	byID := make(map[int]Part, len(found))
+	for _, p := range found {
+		byNumber[strings.ToUpper(p.PartNumber)] = p
+		byID[p.ID] = p
+	}
+
+	valid := make([]FeedRecord, 0, len(records))
+	for i, rec := range records {
+		p, ok := byID[rec.Part]
+		if rec.PartNumber != "" {
+			p, ok = byNumber[strings.ToUpper(rec.PartNumber)]
+		}
+		w, known := byCode[strings.ToUpper(rec.Warehouse.Code)]
+		switch {
+		case !known:
+			report.reject(lines[i], rec.Warehouse.Code, rec.PartNumber, "unknown warehouse")
+		case !ok:
+			report.reject(lines[i], rec.Warehouse.Code, rec.PartNumber, "unknown part")
+		case rec.DateUpdate.IsZero():
+			report.reject(lines[i], rec.Warehouse.Code, p.PartNumber, "date_updated is required")
+		default:
+			rec.Part, rec.PartNumber, rec.Warehouse = p.ID, p.PartNumber, w
+			valid = append(valid, rec)
+		}
+	}
+	// so that when a feed has several records for a part at a warehouse,
+	// the latest is the one left standing
+	sort.SliceStable(valid, func(a, b int) bool {
+		return valid[a].DateUpdate.Before(valid[b].DateUpdate)
+	})
+	return valid, nil
+}
+
+// applyFeed applies each record that's newer than the warehouse's current
+// inventory of the part, then updates the parts' total availability.
+func applyFeed(ctx context.Context, parts *mgo.Collection, records []FeedRecord, report *FeedReport) ([]InventoryChange, error) {
+	var changes []InventoryChange
+	touched := map[int]bool{}
+	for _, rec := range records {
+		if err := ctx.Err(); err != nil {
+			return changes, err
+		}
+		applied, err := applyRecord(parts, rec)
+		if err != nil {
+			return changes, err
+		}
+		if !applied {
+			report.Stale++
+			continue
+		}
+		report.Applied++
+		touched[rec.Part] = true
+		changes = append(changes, InventoryChange{
+			FeedID:      report.ID.Hex(),
+			PartID:      rec.Part,
+			PartNumber:  rec.PartNumber,
+			Warehouse:   rec.Warehouse.Code,
+			Quantity:    rec.Quantity,
+			DateUpdated: rec.DateUpdate,
+		})
+	}
+	for id := range touched {
+		if err := updateTotalAvailability(parts, id); err != nil {
+			return changes, err
+		}
+	}
+	return changes, nil
+}
+
+// applyRecord replaces the warehouse's inventory of the part if the record
+// is newer, or adds it if the warehouse has none. Each is a single
+// conditional update, so concurrent feeds can't overwrite newer records.
+func applyRecord(parts *mgo.Collection, rec FeedRecord) (bool, error) {
+	inv := Inventory{Part: rec.Part, Warehouse: rec.Warehouse, Quantity: rec.Quantity, DateUpdated: rec.DateUpdate}
+	err := parts.Update(bson.M{
+		"id": rec.Part,
+		"inventory.warehouses": bson.M{"$elemMatch": bson.M{
+			"warehouse.code": rec.Warehouse.Code,
+			"dateupdated":    bson.M{"$lt": rec.DateUpdate},
+		}},
+	}, bson.M{"$set": bson.M{"inventory.warehouses.$": inv}})
+	if err == nil {
+		return true, nil
+	}
+	if err != mgo.ErrNotFound {
+		return false, err
+	}
+
+	err = parts.Update(bson.M{
+		"id":                                  rec.Part,
+		"inventory.warehouses.warehouse.code": bson.M{"$ne": rec.Warehouse.Code},
+	}, bson.M{"$push": bson.M{"inventory.warehouses": inv}})
+	if err == mgo.ErrNotFound {
+		// the warehouse has a record at least as new
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// updateTotalAvailability sets the part's total to the sum of what its
+// warehouses have on hand. The total is only written if the warehouses
+// haven't changed since they were read, and is otherwise worked out again.
+func updateTotalAvailability(parts *mgo.Collection, id int) error {
+	for attempt := 0; attempt < 5; attempt++ {
+		var p Part
+		if err := parts.Find(bson.M{"id": id}).Select(bson.M{"inventory": 1}).One(&p); err != nil {
+			return err
+		}
+		total := 0
+		unchanged := []bson.M{{"id": id}, {"inventory.warehouses": bson.M{"$size": len(p.Inventory.Warehouses)}}}
+		for _, i := range p.Inventory.Warehouses {
+			if i.Quantity > 0 {
+				total += i.Quantity
+			}
+			unchanged = append(unchanged, bson.M{"inventory.warehouses": bson.M{"$elemMatch": bson.M{
+				"warehouse.code": i.Warehouse.Code,
+				"quantity":       i.Quantity,
+				"dateupdated":    i.DateUpdated,
+			}}})
+		}
+		err := parts.Update(bson.M{"$and": unchanged}, bson.M{"$set": bson.M{"inventory.totalavailability": total}})
+		if err != mgo.ErrNotFound {
+			return err
+		}
+	}
+	return fmt.Errorf("part %d's inventory kept changing while it was totalled", id)
+}
+
+func publishChanges(changes []InventoryChange) {
+	if InventoryEvents == nil || len(changes) == 0 {
+		return
+	}
+	body, err := json.Marshal(changes)
+	if err != nil {
+		log.Printf("Error encoding inventory changes: %s\n", err)
+		return
+	}
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	if err = InventoryEvents.SendMessage(body); err != nil {
+		log.Printf("Error publishing inventory changes: %s\n", err)
+	}
+}
+
+// ConsumeInventoryFeeds ingests the feeds delivered to queue until ctx is
+// done, reconnecting if the connection is lost. A message's content type
+// gives its format, which is otherwise guessed from the body.
+func ConsumeInventoryFeeds(ctx context.Context, queue string) {
+	for {
+		err := consumeFeeds(ctx, queue)
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Error consuming inventory feeds: %s\n", err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(feedReconnectDelay):
+		}
+	}
+}
+
+func consumeFeeds(ctx context.Context, queue string) error {
+	consumer, err := rabbitmq.NewConsumer("inventory-feeds", queue, FeedExchange, nil)
+	if err != nil {
+		return err
+	}
+	consumer.DoneChan = make(chan error, 1)
+	consumer.AddHandler(rabbitmq.HandlerFunc(func(m *amqp.Delivery) error {
+		format := FeedFormat(m.ContentType)
+		if format == "" {
+			format = sniffFeedFormat(m.Body)
+		}
+		// a feed that's been started is finished, and shutdown waits
+		// for it
+		report, err := IngestFeed(context.Background(), bytes.NewReader(m.Body), format, "amqp")
+		if err != nil {
+			log.Printf("Error ingesting inventory feed: %s\n", err)
+			return err
+		}
+		if report.Rejected > 0 {
+			log.Printf("Inventory feed %s rejected %d of %d records\n", report.ID.Hex(), report.Rejected, report.Records)
+		}
+		return nil
+	}))
+
+	select {
+	case <-ctx.Done():
+		if err = consumer.Close(); err == nil {
+			<-consumer.DoneChan
+		}
+		return err
+	case <-consumer.DoneChan:
+		consumer.Close()
+		return errors.New("inventory feed deliveries stopped")
+	}
+}
+
+// FeedFormat returns the feed format of a content type, or "" if it isn't
+// one.
+func FeedFormat(contentType string) string {
+	ct := strings.ToLower(contentType)
+	switch {
+	case strings.Contains(ct, "csv"):
+		return FeedCSV
+	case strings.Contains(ct, "json"):
+		return FeedJSON
+	}
+	return ""
+}
+
+// sniffFeedFormat guesses the format of a feed that didn't say.
+func sniffFeedFormat(body []byte) string {
+	b := bytes.TrimSpace(body)
+	if len(b) > 0 && b[0] == '[' {
+		return FeedJSON
+	}
+	return FeedCSV
+}
@@ -0,0 +1,81 @@
+package products
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestInventoryFeed(t *testing.T) {
+	Convey("Testing inventory feeds", t, func() {
+		Convey("CSV feeds are read by their header", func() {
+			report := &FeedReport{}
+			feed := "\ufeffPart Number,Warehouse,Date Updated,Quantity\n" +
+				"C5120,HQ,2026-10-01T08:00:00Z,12\n" +
+				"\n" +
+				"C5121,RN,2026-10-02,-1\n" +
+				"C5122,RN,yesterday,3\n" +
+				"C5123,PA,2026-10-02,lots\n"
+			records, lines, err := readCSVFeed(strings.NewReader(feed), report)
+			So(err, ShouldBeNil)
+			So(len(records), ShouldEqual, 2)
+			So(lines, ShouldResemble, []int{2, 4})
+			So(records[0].PartNumber, ShouldEqual, "C5120")
+			So(records[0].Warehouse.Code, ShouldEqual, "HQ")
+			So(records[0].Quantity, ShouldEqual, 12)
+			So(records[0].DateUpdate.Equal(time.Date(2026, 10, 1, 8, 0, 0, 0, time.UTC)), ShouldBeTrue)
+			So(records[1].Quantity, ShouldEqual, -1)
+
+			So(report.Rejected, ShouldEqual, 2)
+			So(report.Errors[0].Record, ShouldEqual, 5)
+			So(report.Errors[0].Part, ShouldEqual, "C5122")
+			So(report.Errors[1].Record, ShouldEqual, 6)
+		})
+
+		Convey("CSV feeds need every column", func() {
+			_, _, err := readCSVFeed(strings.NewReader("part,warehouse,quantity\nC5120,HQ,1\n"), &FeedReport{})
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("JSON feeds are arrays of records", func() {
+			feed := `[{"warehouse":{"code":"HQ"},"part":11000,"quantity":5,"date_updated":"2026-10-01T08:00:00Z"}]`
+			records, lines, err := readJSONFeed(strings.NewReader(feed))
+			So(err, ShouldBeNil)
+			So(lines, ShouldResemble, []int{0})
+			So(records[0].Part, ShouldEqual, 11000)
+			So(records[0].Warehouse.Code, ShouldEqual, "HQ")
+
+			_, _, err = readJSONFeed(strings.NewReader(`{"part":11000}`))
+			So(err, ShouldNotBeNil)
+		})
+
+		Convey("feeds over the size limit are refused before anything is applied", func() {
+			feed := "part,warehouse,quantity,date_updated\n" + strings.Repeat("C5120,HQ,1,2026-10-01\n", 100)
+			body := http.MaxBytesReader(httptest.NewRecorder(), io.NopCloser(strings.NewReader(feed)), 64)
+			_, err := IngestFeed(context.Background(), body, FeedCSV, "api")
+			var tooLarge *http.MaxBytesError
+			So(errors.As(err, &tooLarge), ShouldBeTrue)
+			So(tooLarge.Limit, ShouldEqual, 64)
+		})
+
+		Convey("formats come from the content type or the body", func() {
+			So(FeedFormat("text/csv; charset=utf-8"), ShouldEqual, FeedCSV)
+			So(FeedFormat("application/json"), ShouldEqual, FeedJSON)
+			So(FeedFormat("application/octet-stream"), ShouldEqual, "")
+			So(sniffFeedFormat([]byte("  [{}]")), ShouldEqual, FeedJSON)
+			So(sniffFeedFormat([]byte("part,warehouse")), ShouldEqual, FeedCSV)
+		})
+
+		Convey("unknown formats are refused", func() {
+			_, err := IngestFeed(context.Background(), strings.NewReader(""), "xml", "api")
+			So(err, ShouldEqual, ErrUnknownFeedFormat)
+		})
+	})
+}
@@ -0,0 +1,190 @@
+package products
+
+import (
+	"math"
+	"strings"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Kit is a complex part's components, resolved, with the kit's price and
+// availability worked out from them.
+type Kit struct {
+	Components []KitComponent `json:"components" xml:"components>component"`
+	// Pricing is the total of each price type that every component has.
+	Pricing []Price `json:"pricing" xml:"pricing>price"`
+	// CustomerPrice is the total of the components' customer prices, or
+	// 0 unless every component has one.
+	CustomerPrice float64 `json:"customer_price" xml:"customer_price,attr"`
+	Currency      string  `json:"currency,omitempty" xml:"currency,attr,omitempty"`
+	// Buildable is how many kits the components on hand make.
+	Buildable int `json:"buildable" xml:"buildable,attr"`
+}
+
+// KitComponent is a component of a kit, Count of which go into each kit.
+// Part is nil when the component's SKU isn't a part the customer can get.
+type KitComponent struct {
+	Sku          string `json:"sku" xml:"sku,attr"`
+	Count        int    `json:"count" xml:"count,attr"`
+	Part         *Part  `json:"part,omitempty" xml:"part,omitempty"`
+	Missing      bool   `json:"missing,omitempty" xml:"missing,attr,omitempty"`
+	Discontinued bool   `json:"discontinued,omitempty" xml:"discontinued,attr,omitempty"`
+	// ReplacedBy is the ID of the part that supersedes the component.
+	ReplacedBy int `json:"replaced_by,omitempty" xml:"replaced_by,attr,omitempty"`
+}
+
+const discontinuedStatus = 999
+
+// kitPriceTypes are the price types totalled for kits.
+var kitPriceTypes = []string{"List", "Map"}
+
+// ExpandKit embeds the part's components in its Kit, if it's a complex
+// part.
+func (p *Part) ExpandKit(dtx *apicontext.DataContext) error {
+	parts := []Part{*p}
+	if err := ExpandKits(dtx, parts); err != nil {
+		return err
+	}
+	*p = parts[0]
+	return nil
+}
+
+// ExpandKits embeds the components of each of the complex parts in their
+// Kits. Components are expanded a single level; a component that is itself
+// a kit isn't expanded.
+func ExpandKits(dtx *apicontext.DataContext, parts []Part) error {
+	skus := []string{}
+	for _, p := range parts {
+		if p.ComplexPart == nil {
+			continue
+		}
+		for _, sc := range p.ComplexPart.SkuCount {
+			if sc != nil && sc.Sku != "" {
+				skus = append(skus, sc.Sku)
+			}
+		}
+	}
+	if len(skus) == 0 {
+		return nil
+	}
+
+	if err := database.Init(); err != nil {
+		return err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+
+	query := bson.M{"part_number": bson.M{"$in": skus}, "brand.id": bson.M{"$in": getBrandsFromDTX(dtx)}}
+	var components []Part
+	err := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(query).All(&components)
+	if err != nil {
+		return err
+	}
+	if components, err = BindCustomerToSeveralParts(components, dtx); err != nil {
+		return err
+	}
+
+	bySku := make(map[string]Part, len(components))
+	for _, c := range components {
+		bySku[strings.ToUpper(c.PartNumber)] = c
+	}
+	for i := range parts {
+		if parts[i].ComplexPart != nil {
+			parts[i].Kit = newKit(parts[i].ComplexPart, bySku)
+		}
+	}
+	return nil
+}
+
+// newKit resolves a complex part's components from the parts by SKU, and
+// totals them.
+func newKit(cp *ComplexPart, bySku map[string]Part) *Kit {
+	k := &Kit{Components: make([]KitComponent, 0, len(cp.SkuCount))}
+	for _, sc := range cp.SkuCount {
+		if sc == nil || sc.Sku == "" {
+			continue
+		}
+		c := KitComponent{Sku: sc.Sku, Count: int(sc.Count)}
+		if c.Count < 1 {
+			c.Count = 1
+		}
+		if p, ok := bySku[strings.ToUpper(sc.Sku)]; ok {
+			p := p
+			c.Part = &p
+			c.Discontinued = p.Status == discontinuedStatus
+			c.ReplacedBy = p.ReplacedBy
+		} else {
+			c.Missing = true
+		}
+		k.Components = append(k.Components, c)
+	}
+	k.total()
+	return k
+}
+
+// total works out the kit's prices and buildable count from its
+// components.
+func (k *Kit) total() {
+	k.Pricing = []Price{}
+	k.CustomerPrice = 0
+	k.Buildable = 0
+	if len(k.Components) == 0 {
+		return
+	}
+
+	for _, t := range kitPriceTypes {
+		total, complete := 0.0, true
+		for _, c := range k.Components {
+			pr, ok := c.price(t)
+			if !ok {
+				complete = false
+				break
+			}
+			total += pr * float64(c.Count)
+		}
+		if complete {
+			k.Pricing = append(k.Pricing, Price{Type: t, Price: roundCents(total), Currency: k.Currency})
+		}
+	}
+
+	customer := 0.0
+	for _, c := range k.Components {
+		if c.Part == nil || c.Part.Customer.Price <= 0 {
+			customer = 0
+			break
+		}
+		customer += c.Part.Customer.Price * float64(c.Count)
+	}
+	k.CustomerPrice = roundCents(customer)
+
+	buildable := -1
+	for _, c := range k.Components {
+		n := 0
+		if c.Part != nil && c.Part.Inventory.TotalAvailability > 0 {
+			n = c.Part.Inventory.TotalAvailability / c.Count
+		}
+		if buildable < 0 || n < buildable {
+			buildable = n
+		}
+	}
+	k.Buildable = buildable
+}
+
+// price returns the component's price of type t.
+func (c KitComponent) price(t string) (float64, bool) {
+	if c.Part == nil {
+		return 0, false
+	}
+	for _, pr := range c.Part.Pricing {
+		if strings.EqualFold(pr.Type, t) {
+			return pr.Price, true
+		}
+	}
+	return 0, false
+}
+
+func roundCents(f float64) float64 {
+	return math.Round(f*100) / 100
+}
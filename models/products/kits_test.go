@@ -0,0 +1,58 @@
+package products
+
+import (
+	"testing"
+
+	"github.com/curt-labs/API/models/currency"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestKits(t *testing.T) {
+	Convey("Testing kits", t, func() {
+		bySku := map[string]Part{
+			"C5120": {
+				ID: 1, PartNumber: "C5120", Status: 800,
+				Pricing:   []Price{{Type: "List", Price: 100}, {Type: "Map", Price: 80}},
+				Customer:  CustomerPart{Price: 70},
+				Inventory: PartInventory{TotalAvailability: 9},
+			},
+			"C5121": {
+				ID: 2, PartNumber: "C5121", Status: 999, ReplacedBy: 3,
+				Pricing:   []Price{{Type: "List", Price: 12.5}},
+				Customer:  CustomerPart{Price: 10},
+				Inventory: PartInventory{TotalAvailability: 5},
+			},
+		}
+		cp := &ComplexPart{SkuCount: []*SkuCount{{Sku: "c5120", Count: 1}, {Sku: "C5121", Count: 2}}}
+
+		Convey("components are resolved and totalled", func() {
+			k := newKit(cp, bySku)
+			So(len(k.Components), ShouldEqual, 2)
+			So(k.Components[0].Part.ID, ShouldEqual, 1)
+			So(k.Components[1].Discontinued, ShouldBeTrue)
+			So(k.Components[1].ReplacedBy, ShouldEqual, 3)
+			So(k.Pricing, ShouldResemble, []Price{{Type: "List", Price: 125}})
+			So(k.CustomerPrice, ShouldEqual, 90)
+			So(k.Buildable, ShouldEqual, 2)
+		})
+
+		Convey("missing components leave the kit incomplete", func() {
+			missing := &ComplexPart{SkuCount: append(cp.SkuCount, &SkuCount{Sku: "NOPE", Count: 1})}
+			k := newKit(missing, bySku)
+			So(k.Components[2].Missing, ShouldBeTrue)
+			So(k.Components[2].Part, ShouldBeNil)
+			So(k.Pricing, ShouldBeEmpty)
+			So(k.CustomerPrice, ShouldEqual, 0)
+			So(k.Buildable, ShouldEqual, 0)
+		})
+
+		Convey("kits are totalled again in other currencies", func() {
+			p := Part{ComplexPart: cp, Kit: newKit(cp, bySku)}
+			p.InCurrency(currency.NewConverter(currency.ExchangeRate{Currency: "CAD", Rate: 1.5, Rounding: currency.RoundNearest, Increment: 0.01}))
+			So(p.Kit.Currency, ShouldEqual, "CAD")
+			So(p.Kit.Pricing, ShouldResemble, []Price{{Type: "List", Price: 187.5, Currency: "CAD"}})
+			So(p.Kit.CustomerPrice, ShouldEqual, 135)
+			So(p.Kit.Components[0].Part.Customer.Currency, ShouldEqual, "CAD")
+		})
+	})
+}
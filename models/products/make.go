@@ -42,13 +42,13 @@ func (l *Lookup) GetMakes(dtx *apicontext.DataContext) error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(wholeStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), wholeStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(l.Vehicle.Base.Year)
+	res, err := stmt.QueryContext(dtx.Context(), l.Vehicle.Base.Year)
 	if err != nil {
 		return err
 	}
@@ -4,11 +4,12 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func (l *Lookup) GetModels() error {
+func (l *Lookup) GetModels(dtx *apicontext.DataContext) error {
 	stmtBeginning := `select distinct mo.ModelName from vcdb_Model as mo
 		join BaseVehicle as bv on mo.ID = bv.ModelID
 		join vcdb_Make as ma on bv.MakeID = ma.ID
@@ -31,13 +32,13 @@ func (l *Lookup) GetModels() error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(wholeStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), wholeStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(l.Vehicle.Base.Year, l.Vehicle.Base.Make)
+	res, err := stmt.QueryContext(dtx.Context(), l.Vehicle.Base.Year, l.Vehicle.Base.Make)
 	if err != nil {
 		return err
 	}
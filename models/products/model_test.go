@@ -1,6 +1,7 @@
 package products
 
 import (
+	"github.com/curt-labs/API/helpers/apicontextmock"
 	. "github.com/smartystreets/goconvey/convey"
 	"testing"
 )
@@ -8,8 +9,13 @@ import (
 func TestGetModels(t *testing.T) {
 	var l Lookup
 	l.Brands = append(l.Brands, 1)
+
+	MockedDTX, err := apicontextmock.Mock()
+	if err != nil {
+		return
+	}
 	Convey("Testing GetModels() without year/make", t, func() {
-		err := l.GetModels()
+		err := l.GetModels(MockedDTX)
 		So(err, ShouldEqual, nil)
 		So(l.Models, ShouldNotEqual, nil)
 		So(len(l.Models), ShouldEqual, 0)
@@ -18,7 +24,7 @@ func TestGetModels(t *testing.T) {
 	Convey("Testing GetModels() with bogus data", t, func() {
 		l.Vehicle.Base.Year = 1
 		l.Vehicle.Base.Make = "KD"
-		err := l.GetModels()
+		err := l.GetModels(MockedDTX)
 		So(err, ShouldEqual, nil)
 		So(l.Models, ShouldNotEqual, nil)
 		So(len(l.Models), ShouldEqual, 0)
@@ -26,7 +32,7 @@ func TestGetModels(t *testing.T) {
 
 	Convey("Testing GetModels() with year", t, func() {
 		l.Vehicle.Base.Year = 2010
-		err := l.GetModels()
+		err := l.GetModels(MockedDTX)
 		So(err, ShouldEqual, nil)
 		So(l.Models, ShouldNotEqual, nil)
 		So(len(l.Models), ShouldEqual, 0)
@@ -35,11 +41,12 @@ func TestGetModels(t *testing.T) {
 	Convey("Testing GetModels()", t, func() {
 		l.Vehicle.Base.Year = 2010
 		l.Vehicle.Base.Make = "Ford"
-		err := l.GetModels()
+		err := l.GetModels(MockedDTX)
 		So(err, ShouldEqual, nil)
 		So(l.Models, ShouldNotEqual, nil)
 		So(l.Models, ShouldHaveSameTypeAs, []string{})
 		So(l.Vehicle.Base.Year, ShouldEqual, 2010)
 		So(l.Vehicle.Base.Make, ShouldEqual, "Ford")
 	})
+	_ = apicontextmock.DeMock(MockedDTX)
 }
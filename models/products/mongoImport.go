@@ -1,16 +1,48 @@
 package products
 
 import (
+	"context"
+	"database/sql"
 	"encoding/csv"
+	"errors"
 	"fmt"
-	"mime/multipart"
+	"io"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/curt-labs/API/helpers/database"
 	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// An import is loaded into a staging collection beside the one it
+// replaces, and only swapped in if few enough of its rows had errors. The
+// collection it replaced is kept as a copy, so the import can be rolled
+// back. An import that isn't swapped in is dropped. Neither copy is
+// listed, or searched, as an application collection. Imports and
+// rollbacks of a collection take turns, holding a lease on it in the lock
+// collection while they run.
+const (
+	importStagingSuffix  = "__import"
+	importPreviousSuffix = "__previous"
+	importLockCollection = "application_import_locks"
+	importLease          = time.Hour
+
+	// DefaultImportErrorRate is the share of an import's rows that may
+	// fail before it's rejected.
+	DefaultImportErrorRate = 0.05
+)
+
+var (
+	ErrInvalidCollection = errors.New("invalid application collection")
+	ErrNoPreviousImport  = errors.New("there's no previous import to roll back to")
+	ErrImportInProgress  = errors.New("the collection is already being imported or rolled back")
 )
 
 type Input struct {
+	Row   int
 	Year  string
 	Make  string
 	Model string
@@ -19,102 +51,310 @@ type Input struct {
 }
 
 type Application struct {
-	Year  string `bson:"year"`
-	Make  string `bson:"make"`
-	Model string `bson:"model"`
-	Style string `bson:"style"`
-	Parts []int  `bson:"parts"`
+	Year  string `bson:"year" json:"year"`
+	Make  string `bson:"make" json:"make"`
+	Model string `bson:"model" json:"model"`
+	Style string `bson:"style" json:"style"`
+	Parts []int  `bson:"parts" json:"parts"`
 }
 
-var (
-	VehicleApplications map[string]Application
-	PartConversion      map[string]int
-	Session             *mgo.Session
-	inf                 = database.AriesMongoConnectionString()
-)
+// ImportRowError is a row of an import that couldn't be converted, or an
+// application that couldn't be inserted. Insert errors list every row of
+// the application.
+type ImportRowError struct {
+	Rows  []int  `json:"rows"`
+	Error string `json:"error"`
+}
+
+// ImportReport describes an application import, and whether it replaced
+// the collection.
+type ImportReport struct {
+	Collection       string           `json:"collection"`
+	Rows             int              `json:"rows"`
+	Applications     int              `json:"applications"`
+	ConversionErrors []ImportRowError `json:"conversion_errors"`
+	InsertErrors     []ImportRowError `json:"insert_errors"`
+	ErrorRate        float64          `json:"error_rate"`
+	MaxErrorRate     float64          `json:"max_error_rate"`
+	Diff             ApplicationDiff  `json:"diff"`
+	Swapped          bool             `json:"swapped"`
+}
+
+// ApplicationDiff compares an import with the collection it replaces.
+// Changed applications are listed as imported.
+type ApplicationDiff struct {
+	Added     []Application `json:"added"`
+	Removed   []Application `json:"removed"`
+	Changed   []Application `json:"changed"`
+	Unchanged int           `json:"unchanged"`
+}
+
+// applicationImport converts a CSV's rows to applications, one per
+// vehicle, with the parts that fit it.
+type applicationImport struct {
+	apps  map[string]Application
+	rows  map[string][]int
+	order []string
+	// partID looks up a part by its old part number.
+	partID func(string) (int, error)
+	parts  map[string]int
+}
 
-func Import(f multipart.File, collectionName string) ([]error, []error, error) {
-	var err error
-	var conversionErrs []error
-	var insertErrs []error
-	VehicleApplications = make(map[string]Application)
-	PartConversion = make(map[string]int)
-	Session, err = mgo.DialWithInfo(inf)
+// Import replaces the ARIES application collection with the applications
+// of a CSV of make, model, style, part and year. The rows are checked and
+// staged in a collection of their own, which is compared with the current
+// one and swapped in if at most maxErrorRate of the rows had errors, and
+// dryRun isn't set. A negative maxErrorRate uses DefaultImportErrorRate.
+// The replaced collection is kept until the next import for
+// RollbackImport. An import that's cancelled by ctx leaves the collection
+// as it was.
+func Import(ctx context.Context, f io.Reader, collectionName string, maxErrorRate float64, dryRun bool) (*ImportReport, error) {
+	if !validApplicationCollection(collectionName) {
+		return nil, ErrInvalidCollection
+	}
+	if maxErrorRate < 0 {
+		maxErrorRate = DefaultImportErrorRate
+	}
 	es, err := CaptureCsv(f)
 	if err != nil {
-		return conversionErrs, insertErrs, err
+		return nil, err
+	}
+
+	if err = database.Init(); err != nil {
+		return nil, err
+	}
+	stmt, err := database.DB.PrepareContext(ctx, "select partID from Part where oldPartNumber = ?")
+	if err != nil {
+		return nil, err
 	}
+	defer stmt.Close()
 
+	imp := newApplicationImport(func(part string) (int, error) {
+		var partID int
+		err := stmt.QueryRowContext(ctx, part).Scan(&partID)
+		return partID, err
+	})
+	report := &ImportReport{
+		Collection:       collectionName,
+		Rows:             len(es),
+		ConversionErrors: []ImportRowError{},
+		InsertErrors:     []ImportRowError{},
+		MaxErrorRate:     maxErrorRate,
+	}
 	for _, e := range es {
-		if cerr := ConvertToApplication(e); cerr != nil {
-			conversionErrs = append(conversionErrs, cerr)
-			continue
+		if cerr := imp.convert(e); cerr != nil {
+			report.ConversionErrors = append(report.ConversionErrors, ImportRowError{Rows: []int{e.Row}, Error: cerr.Error()})
 		}
 	}
+	report.Applications = len(imp.apps)
 
-	_ = ClearCollection(collectionName)
+	dialed, err := mgo.DialWithInfo(database.AriesMongoConnectionString())
+	if err != nil {
+		return nil, err
+	}
+	defer dialed.Close()
+	session := database.CopySession(ctx, dialed)
+	defer session.Close()
+	db := session.DB(AriesDb)
+	unlock, err := lockImport(db, collectionName)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
 
-	for _, app := range VehicleApplications {
-		if ierr := IntoDB(app, collectionName); ierr != nil {
-			insertErrs = append(insertErrs, ierr)
-			continue
+	staging := db.C(collectionName + importStagingSuffix)
+	if err = staging.DropCollection(); err != nil && !isNamespaceNotFound(err) {
+		return nil, err
+	}
+	// once swapped in, there's no staging collection left to drop
+	defer staging.DropCollection()
+	failed := 0
+	for _, key := range imp.order {
+		if err = ctx.Err(); err != nil {
+			return nil, err
+		}
+		if ierr := staging.Insert(imp.apps[key]); ierr != nil {
+			report.InsertErrors = append(report.InsertErrors, ImportRowError{Rows: imp.rows[key], Error: ierr.Error()})
+			failed += len(imp.rows[key])
+		}
+	}
+	if report.Rows > 0 {
+		report.ErrorRate = float64(len(report.ConversionErrors)+failed) / float64(report.Rows)
+	}
+
+	var current []Application
+	if err = db.C(collectionName).Find(nil).All(&current); err != nil {
+		return report, err
+	}
+	report.Diff = diffApplications(current, imp.applications())
+
+	if dryRun || report.Applications == 0 || report.ErrorRate > maxErrorRate {
+		return report, nil
+	}
+	if err = swapApplicationCollection(session, collectionName); err != nil {
+		return report, err
+	}
+	report.Swapped = true
+	return report, nil
+}
+
+// RollbackImport puts back the collection the last import replaced.
+func RollbackImport(ctx context.Context, collectionName string) error {
+	if !validApplicationCollection(collectionName) {
+		return ErrInvalidCollection
+	}
+	dialed, err := mgo.DialWithInfo(database.AriesMongoConnectionString())
+	if err != nil {
+		return err
+	}
+	defer dialed.Close()
+	session := database.CopySession(ctx, dialed)
+	defer session.Close()
+	unlock, err := lockImport(session.DB(AriesDb), collectionName)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	names, err := session.DB(AriesDb).CollectionNames()
+	if err != nil {
+		return err
+	}
+	previous := collectionName + importPreviousSuffix
+	for _, name := range names {
+		if name == previous {
+			return renameCollection(session, previous, collectionName)
 		}
 	}
+	return ErrNoPreviousImport
+}
+
+// lockImport takes the lease on importing to the collection, returning
+// ErrImportInProgress if another import or rollback holds it, and a func
+// that gives it up.
+func lockImport(db *mgo.Database, collectionName string) (func(), error) {
+	locks := db.C(importLockCollection)
+	owner := bson.NewObjectId().Hex()
+	ok, err := acquireLease(locks, collectionName, owner, time.Now(), importLease)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrImportInProgress
+	}
+	return func() {
+		releaseLease(locks, collectionName, owner)
+	}, nil
+}
+
+// swapApplicationCollection copies the collection aside, and renames the
+// staged import over it. Both steps replace their target in one
+// operation, so readers see either the old applications or the new ones.
+func swapApplicationCollection(session *mgo.Session, collectionName string) error {
+	db := session.DB(AriesDb)
+	previous := collectionName + importPreviousSuffix
+	if err := db.C(previous).DropCollection(); err != nil && !isNamespaceNotFound(err) {
+		return err
+	}
+	var out []bson.M
+	if err := db.C(collectionName).Pipe([]bson.M{{"$out": previous}}).All(&out); err != nil {
+		return err
+	}
+	return renameCollection(session, collectionName+importStagingSuffix, collectionName)
+}
+
+func renameCollection(session *mgo.Session, from, to string) error {
+	return session.Run(bson.D{
+		{"renameCollection", AriesDb + "." + from},
+		{"to", AriesDb + "." + to},
+		{"dropTarget", true},
+	}, nil)
+}
+
+func isNamespaceNotFound(err error) bool {
+	return err != nil && strings.Contains(err.Error(), "ns not found")
+}
+
+// validApplicationCollection reports whether name can be imported to. The
+// staging and previous copies of imports, and their locks, can't.
+func validApplicationCollection(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "$.\x00") && !isImportCollection(name)
+}
+
+// IsApplicationCollection reports whether the ARIES collection name holds
+// vehicle applications, rather than being a system collection or part of
+// an import.
+func IsApplicationCollection(name string) bool {
+	return !strings.Contains(name, "system") && !isImportCollection(name)
+}
 
-	return conversionErrs, insertErrs, err
+func isImportCollection(name string) bool {
+	return strings.HasSuffix(name, importStagingSuffix) || strings.HasSuffix(name, importPreviousSuffix) || name == importLockCollection
 }
 
 //Csv to Struct
-func CaptureCsv(f multipart.File) ([]Input, error) {
+func CaptureCsv(f io.Reader) ([]Input, error) {
 	var e Input
 	var es []Input
 
 	reader := csv.NewReader(f)
+	reader.FieldsPerRecord = -1
 
 	lines, err := reader.ReadAll()
 	if err != nil {
 		return es, err
 	}
 
-	for _, line := range lines {
+	for i, line := range lines {
 		if len(line) < 5 {
 			continue
 		}
 		e = Input{
+			Row:   i + 1,
 			Make:  strings.ToLower(strings.TrimSpace(line[0])),
 			Model: strings.ToLower(strings.TrimSpace(line[1])),
 			Style: strings.ToLower(strings.TrimSpace(line[2])),
 			Part:  strings.TrimSpace(line[3]),
 			Year:  strings.ToLower(strings.TrimSpace(line[4])),
 		}
+		if i == 0 && e.Year == "year" {
+			continue
+		}
 
 		es = append(es, e)
 	}
 	return es, nil
 }
 
-//Convert Input ot Applications array
-func ConvertToApplication(e Input) error {
-	var partID int
-
-	if partID = PartConversion[e.Part]; partID == 0 {
+func newApplicationImport(partID func(string) (int, error)) *applicationImport {
+	return &applicationImport{
+		apps:   make(map[string]Application),
+		rows:   make(map[string][]int),
+		partID: partID,
+		parts:  make(map[string]int),
+	}
+}
 
-		err := database.Init()
-		if err != nil {
-			return err
-		}
+//Convert Input to Applications array
+func (imp *applicationImport) convert(e Input) error {
+	if e.Make == "" || e.Model == "" {
+		return errors.New("make and model are required")
+	}
+	year, err := strconv.Atoi(e.Year)
+	if err != nil || year < 1900 || year > time.Now().Year()+2 {
+		return fmt.Errorf("invalid year: %s", e.Year)
+	}
 
-		stmt, err := database.DB.Prepare("select partID from Part where oldPartNumber = ?")
-		if err != nil {
+	partID, ok := imp.parts[e.Part]
+	if !ok {
+		partID, err = imp.partID(e.Part)
+		if err != nil && err != sql.ErrNoRows {
 			return err
 		}
-		defer stmt.Close()
-
-		if err := stmt.QueryRow(e.Part).Scan(&partID); err != nil || partID == 0 {
-			return fmt.Errorf("invalid part: %s", e.Part)
-		}
-
-		PartConversion[e.Part] = partID
+		imp.parts[e.Part] = partID
+	}
+	if partID == 0 {
+		return fmt.Errorf("invalid part: %s", e.Part)
 	}
 
 	tmp := Application{
@@ -125,29 +365,84 @@ func ConvertToApplication(e Input) error {
 		Style: e.Style,
 	}
 
-	idx := VehicleApplications[tmp.string()]
-	if idx.Year == "" {
-		VehicleApplications[tmp.string()] = tmp
+	key := tmp.string()
+	imp.rows[key] = append(imp.rows[key], e.Row)
+	app, ok := imp.apps[key]
+	if !ok {
+		imp.apps[key] = tmp
+		imp.order = append(imp.order, key)
 		return nil
 	}
-
-	idx.Parts = append(idx.Parts, partID)
-	VehicleApplications[tmp.string()] = idx
+	for _, id := range app.Parts {
+		if id == partID {
+			return nil
+		}
+	}
+	app.Parts = append(app.Parts, partID)
+	imp.apps[key] = app
 
 	return nil
 }
 
-//Dump into mongo
-func IntoDB(app Application, collectionName string) error {
-	return Session.DB(inf.Database).C(collectionName).Insert(app)
+func (imp *applicationImport) applications() []Application {
+	apps := make([]Application, 0, len(imp.order))
+	for _, key := range imp.order {
+		apps = append(apps, imp.apps[key])
+	}
+	return apps
 }
 
-//Drop collection specified
-func ClearCollection(name string) error {
-	return Session.DB(inf.Database).C(name).DropCollection()
+// diffApplications compares the applications of a collection with an
+// import's. Applications whose parts differ only in order are unchanged.
+func diffApplications(current, imported []Application) ApplicationDiff {
+	diff := ApplicationDiff{
+		Added:   []Application{},
+		Removed: []Application{},
+		Changed: []Application{},
+	}
+	have := make(map[string]Application, len(current))
+	for _, app := range current {
+		have[app.string()] = app
+	}
+	seen := make(map[string]bool, len(imported))
+	for _, app := range imported {
+		key := app.string()
+		seen[key] = true
+		old, ok := have[key]
+		switch {
+		case !ok:
+			diff.Added = append(diff.Added, app)
+		case sameParts(old.Parts, app.Parts):
+			diff.Unchanged++
+		default:
+			diff.Changed = append(diff.Changed, app)
+		}
+	}
+	for _, app := range current {
+		if !seen[app.string()] {
+			diff.Removed = append(diff.Removed, app)
+		}
+	}
+	return diff
+}
+
+func sameParts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a = append([]int(nil), a...)
+	b = append([]int(nil), b...)
+	sort.Ints(a)
+	sort.Ints(b)
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
 }
 
 //ToString
 func (a *Application) string() string {
-	return fmt.Sprintf("%s%s%s%s", a.Year, a.Make, a.Model, a.Style)
+	return fmt.Sprintf("%s|%s|%s|%s", a.Year, a.Make, a.Model, a.Style)
 }
@@ -0,0 +1,85 @@
+package products
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestApplicationImport(t *testing.T) {
+	Convey("Testing application imports", t, func() {
+		csv := "Make,Model,Style,Part,Year\n" +
+			"Ford, F-150 ,XLT,C123,2012\n" +
+			"ford,f-150,xlt,C124,2012\n" +
+			"ford,f-150,xlt,C123,2012\n" +
+			"ford,f-150,xlt,C999,2012\n" +
+			"ford,f-150,xlt,C123,12\n" +
+			"short,row\n" +
+			"ford,ranger,,C124,2013\n"
+		partIDs := map[string]int{"C123": 123, "C124": 124}
+		lookups := 0
+		imp := newApplicationImport(func(part string) (int, error) {
+			lookups++
+			if id, ok := partIDs[part]; ok {
+				return id, nil
+			}
+			return 0, sql.ErrNoRows
+		})
+
+		Convey("rows are converted with their errors", func() {
+			es, err := CaptureCsv(strings.NewReader(csv))
+			So(err, ShouldBeNil)
+			So(len(es), ShouldEqual, 6)
+			So(es[0], ShouldResemble, Input{Row: 2, Make: "ford", Model: "f-150", Style: "xlt", Part: "C123", Year: "2012"})
+			So(es[5].Row, ShouldEqual, 8)
+
+			var errs []string
+			for _, e := range es {
+				if err := imp.convert(e); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+			So(errs, ShouldResemble, []string{"invalid part: C999", "invalid year: 12"})
+			So(lookups, ShouldEqual, 3)
+
+			apps := imp.applications()
+			So(len(apps), ShouldEqual, 2)
+			So(apps[0].Parts, ShouldResemble, []int{123, 124})
+			So(imp.rows[apps[0].string()], ShouldResemble, []int{2, 3, 4})
+			So(apps[1].Model, ShouldEqual, "ranger")
+		})
+
+		Convey("imports are compared with the collection", func() {
+			current := []Application{
+				{Year: "2012", Make: "ford", Model: "f-150", Style: "xlt", Parts: []int{124, 123}},
+				{Year: "2013", Make: "ford", Model: "ranger", Parts: []int{123}},
+				{Year: "2014", Make: "ford", Model: "ranger", Parts: []int{123}},
+			}
+			imported := []Application{
+				{Year: "2012", Make: "ford", Model: "f-150", Style: "xlt", Parts: []int{123, 124}},
+				{Year: "2013", Make: "ford", Model: "ranger", Parts: []int{124}},
+				{Year: "2015", Make: "ford", Model: "ranger", Parts: []int{123}},
+			}
+			diff := diffApplications(current, imported)
+			So(diff.Unchanged, ShouldEqual, 1)
+			So(diff.Changed, ShouldResemble, imported[1:2])
+			So(diff.Added, ShouldResemble, imported[2:])
+			So(diff.Removed, ShouldResemble, current[2:])
+		})
+
+		Convey("import copies can't be imported to", func() {
+			So(validApplicationCollection("interior"), ShouldBeTrue)
+			So(validApplicationCollection("interior"+importStagingSuffix), ShouldBeFalse)
+			So(validApplicationCollection("interior"+importPreviousSuffix), ShouldBeFalse)
+			So(validApplicationCollection(importLockCollection), ShouldBeFalse)
+			So(validApplicationCollection("system.indexes"), ShouldBeFalse)
+			So(validApplicationCollection(""), ShouldBeFalse)
+			So(IsApplicationCollection("interior"), ShouldBeTrue)
+			So(IsApplicationCollection("interior"+importPreviousSuffix), ShouldBeFalse)
+			So(IsApplicationCollection(importLockCollection), ShouldBeFalse)
+			So(IsApplicationCollection("system.indexes"), ShouldBeFalse)
+		})
+	})
+}
@@ -118,7 +118,7 @@ func GetAriesVehicleCollections(session *mgo.Session) ([]string, error) {
 
 	validCols := make([]string, 0)
 	for _, col := range cols {
-		if !strings.Contains(col, "system") {
+		if IsApplicationCollection(col) {
 			validCols = append(validCols, col)
 		}
 	}
@@ -358,6 +358,9 @@ func FindApplications(collection string, skip, limit int) (Result, error) {
 	return res, err
 }
 
+// buildPartMap fills partMap once for every caller, so it isn't bound by
+// the context of the request that happens to trigger it; cancelling that
+// request would leave the map empty for good.
 func buildPartMap() error {
 	err := database.Init()
 	if err != nil {
@@ -505,6 +508,10 @@ func FindVehiclesFromAllCategories(v NoSqlVehicle, dtx *apicontext.DataContext,
 
 	//from each category
 	for _, col := range cols {
+		// stop walking collections once the request has been abandoned
+		if err := dtx.Context().Err(); err != nil {
+			return lookupMap, err
+		}
 
 		c := sess.DB(AriesDb).C(col)
 		queryMap := make(map[string]interface{})
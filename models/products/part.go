@@ -8,6 +8,7 @@ import (
 	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
 	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/cartIntegration"
 	"github.com/curt-labs/API/models/customer"
 	"github.com/curt-labs/API/models/customer/content"
 	"github.com/curt-labs/API/models/video"
@@ -61,6 +62,8 @@ type Part struct {
 	ShowForLoggedIn   bool                 `json:"showForLoggedIn" xml:"showForLoggedIn" bson:"showForLoggedIn"`
 	Tariff            string               `json:"tariff" xml:"tariff" bson:"tariff"`
 	ComplexPart       *ComplexPart         `bson:"complex_part" json:"complex_part,omitempty" xml:"complex_part,omitempty"`
+	Kit               *Kit                 `bson:"-" json:"kit,omitempty" xml:"kit,omitempty"`
+	Supersession      *Supersession        `bson:"-" json:"supersession,omitempty" xml:"supersession,omitempty"`
 }
 
 type SkuCount struct {
@@ -76,6 +79,7 @@ type ComplexPart struct {
 type CustomerPart struct {
 	Price         float64 `json:"price" xml:"price,attr"`
 	CartReference int     `json:"cart_reference" xml:"cart_reference,attr"`
+	Currency      string  `json:"currency,omitempty" xml:"currency,attr,omitempty" bson:"-"`
 }
 
 type PaginatedProductListing struct {
@@ -144,7 +148,12 @@ func (p *Part) Get(dtx *apicontext.DataContext) error {
 	if len(parts) > 0 {
 		*p = parts[0]
 	}
-	if err := p.FromDatabase(brands); err != nil {
+	if err := database.Init(); err != nil {
+		return err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	if err := p.FromMongoDatabase(brands, session); err != nil {
 		return err
 	}
 
@@ -424,7 +433,7 @@ func BindCustomerToSeveralParts(parts []Part, dtx *apicontext.DataContext) ([]Pa
 		return parts, err
 	}
 
-	statement := fmt.Sprintf(`select distinct ci.custPartID, cp.price, cp.partID from ApiKey as ak
+	statement := fmt.Sprintf(`select distinct ci.custPartID, cp.price, cp.isSale, cp.sale_start, cp.sale_end, cp.partID from ApiKey as ak
 						join CustomerUser cu on ak.user_id = cu.id
 						join Customer c on cu.cust_ID = c.cust_id
 						left join CustomerPricing cp on cp.cust_ID = cu.cust_ID
@@ -432,25 +441,30 @@ func BindCustomerToSeveralParts(parts []Part, dtx *apicontext.DataContext) ([]Pa
 						where ak.api_key = '%s'
 						and cp.partID in (%s)`, dtx.APIKey, partIDs)
 
-	stmt, err := database.DB.Prepare(statement)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), statement)
 	if err != nil {
 		return parts, err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query()
+	res, err := stmt.QueryContext(dtx.Context())
 	if err != nil {
 		return parts, err
 	}
-	var custPartID, partID *int
+	defer res.Close()
+	var custPartID, partID, isSale *int
 	var price *float64
+	var saleStart, saleEnd *time.Time
 	custPartMap := make(map[int]int)
-	custPriceMap := make(map[int]float64)
+	custPricesMap := make(map[int][]cartIntegration.CustomerPrice)
 
 	for res.Next() {
 		err = res.Scan(
 			&custPartID,
 			&price,
+			&isSale,
+			&saleStart,
+			&saleEnd,
 			&partID,
 		)
 		if err != nil {
@@ -460,7 +474,20 @@ func BindCustomerToSeveralParts(parts []Part, dtx *apicontext.DataContext) ([]Pa
 			custPartMap[*partID] = *custPartID
 		}
 		if price != nil && partID != nil {
-			custPriceMap[*partID] = *price
+			cp := cartIntegration.CustomerPrice{Price: *price, SaleStart: saleStart, SaleEnd: saleEnd}
+			if isSale != nil {
+				cp.IsSale = *isSale
+			}
+			custPricesMap[*partID] = append(custPricesMap[*partID], cp)
+		}
+	}
+
+	// a part's regular price and sales resolve to the one in effect now
+	now := time.Now()
+	custPriceMap := make(map[int]float64)
+	for id, prices := range custPricesMap {
+		if cp, ok := cartIntegration.EffectivePrice(prices, now); ok {
+			custPriceMap[id] = cp.Price
 		}
 	}
 
@@ -0,0 +1,579 @@
+package products
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/models/brand"
+	"github.com/curt-labs/API/models/currency"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// PIES is the Auto Care Association's product information standard, the
+// counterpart of ACES fitment. A PIES file is a header, the price sheets
+// its prices are listed on, an item for each part and a trailer.
+
+var (
+	// PIESVersions are the PIES versions files are generated for, with
+	// their namespaces.
+	PIESVersions = map[string]string{
+		"6.5": "http://www.aftermarket.org",
+		"7.1": "http://www.autocare.org",
+	}
+
+	ErrUnknownPIESVersion = errors.New("PIES files are version 6.5 or 7.1")
+	ErrBrandNotAvailable  = errors.New("that brand isn't available to your key")
+)
+
+const (
+	piesLanguage = "EN"
+	// piesDescriptionLength is the longest a DES description may be.
+	piesDescriptionLength = 80
+)
+
+// piesPriceTypes maps price types to PIES price type codes. Other types
+// aren't exported.
+var piesPriceTypes = map[string]string{
+	"list":   "LST",
+	"jobber": "JBR",
+	"map":    "RMP",
+}
+
+// piesDescriptionCodes maps content types to PIES description codes.
+// Other content isn't exported.
+var piesDescriptionCodes = map[string]string{
+	"bullet":          "FAB",
+	"marketing":       "MKT",
+	"description":     "EXT",
+	"listdescription": "EXT",
+	"longdescription": "EXT",
+	"keywords":        "KEY",
+}
+
+type piesHeader struct {
+	PIESVersion          string
+	SubmissionType       string
+	BlanketEffectiveDate string
+	CurrencyCode         string
+	LanguageCode         string
+}
+
+type piesPriceSheet struct {
+	MaintenanceType  string `xml:",attr"`
+	PriceSheetNumber string
+	CurrencyCode     string
+	EffectiveDate    string
+}
+
+type piesItem struct {
+	MaintenanceType        string `xml:",attr"`
+	HazardousMaterialCode  string
+	ItemLevelGTIN          *piesGTIN `xml:",omitempty"`
+	PartNumber             string
+	BrandAAIAID            string `xml:",omitempty"`
+	BrandLabel             string `xml:",omitempty"`
+	ItemQuantitySize       piesQuantity
+	QuantityPerApplication piesQuantity
+	MinimumOrderQuantity   piesQuantity
+	PartTerminologyID      int `xml:",omitempty"`
+	// Segments are nil when they'd be empty, as PIES doesn't allow empty
+	// segments.
+	Descriptions        *piesDescriptions        `xml:",omitempty"`
+	Prices              *piesPrices              `xml:",omitempty"`
+	ExtendedInformation *piesExtendedInformation `xml:",omitempty"`
+	ProductAttributes   *piesAttributes          `xml:",omitempty"`
+	Packages            *piesPackages            `xml:",omitempty"`
+	DigitalAssets       *piesAssets              `xml:",omitempty"`
+}
+
+type piesDescriptions struct {
+	Description []piesDescription
+}
+
+type piesPrices struct {
+	Pricing []piesPricing
+}
+
+type piesExtendedInformation struct {
+	ExtendedProductInformation []piesExtendedInfo
+}
+
+type piesAttributes struct {
+	ProductAttribute []piesAttribute
+}
+
+type piesPackages struct {
+	Package []piesPackage
+}
+
+type piesAssets struct {
+	DigitalFileInformation []piesAsset
+}
+
+type piesGTIN struct {
+	GTINQualifier string `xml:",attr"`
+	Value         string `xml:",chardata"`
+}
+
+type piesQuantity struct {
+	UOM   string `xml:",attr"`
+	Value int    `xml:",chardata"`
+}
+
+type piesDescription struct {
+	MaintenanceType string `xml:",attr"`
+	DescriptionCode string `xml:",attr"`
+	LanguageCode    string `xml:",attr"`
+	Sequence        int    `xml:",attr,omitempty"`
+	Value           string `xml:",chardata"`
+}
+
+type piesPricing struct {
+	MaintenanceType  string `xml:",attr"`
+	PriceType        string `xml:",attr"`
+	PriceSheetNumber string
+	CurrencyCode     string
+	Price            struct {
+		UOM   string `xml:",attr"`
+		Value string `xml:",chardata"`
+	}
+}
+
+type piesExtendedInfo struct {
+	MaintenanceType string `xml:",attr"`
+	EXPICode        string `xml:",attr"`
+	LanguageCode    string `xml:",attr"`
+	Value           string `xml:",chardata"`
+}
+
+type piesAttribute struct {
+	MaintenanceType string `xml:",attr"`
+	AttributeID     string `xml:",attr"`
+	PADBAttribute   string `xml:",attr"`
+	LanguageCode    string `xml:",attr"`
+	Value           string `xml:",chardata"`
+}
+
+type piesPackage struct {
+	MaintenanceType  string `xml:",attr"`
+	PackageUOM       string
+	QuantityofEaches int
+	Dimensions       *piesDimensions  `xml:",omitempty"`
+	Weights          *piesPackWeights `xml:",omitempty"`
+}
+
+type piesDimensions struct {
+	UOM                 string `xml:",attr"`
+	MerchandisingHeight string
+	MerchandisingWidth  string
+	MerchandisingLength string
+}
+
+type piesPackWeights struct {
+	UOM    string `xml:",attr"`
+	Weight string
+}
+
+type piesAsset struct {
+	MaintenanceType string `xml:",attr"`
+	LanguageCode    string `xml:",attr"`
+	FileName        string
+	AssetType       string
+	FileType        string
+	Representation  string
+	AssetDimensions *piesAssetDimensions `xml:",omitempty"`
+	URI             string
+}
+
+type piesAssetDimensions struct {
+	UOM         string `xml:",attr"`
+	AssetHeight int
+	AssetWidth  int
+}
+
+type piesTrailer struct {
+	ItemCount       int
+	TransactionDate string
+}
+
+// ExportPIES writes a PIES file of b's active parts in version. Items carry
+// the parts' descriptions, attributes, packages, prices, images, UPC and
+// tariff code, and are streamed in chunks, flushing w after each if it can
+// be.
+func ExportPIES(dtx *apicontext.DataContext, w io.Writer, b brand.Brand, version string) error {
+	if _, ok := PIESVersions[version]; !ok {
+		return ErrUnknownPIESVersion
+	}
+	if len(intersect(getBrandsFromDTX(dtx), []int{b.ID})) == 0 {
+		return ErrBrandNotAvailable
+	}
+	if err := database.Init(); err != nil {
+		return err
+	}
+
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	c := session.DB(database.ProductDatabase).C(database.ProductCollectionName)
+	query := bson.M{"brand.id": b.ID, "status": bson.M{"$in": statuses}}
+
+	var types []string
+	if err := c.Find(query).Distinct("pricing.type", &types); err != nil {
+		return err
+	}
+	now := time.Now()
+	pw, err := newPIESWriter(w, version, b, piesPriceSheets(types, now), now)
+	if err != nil {
+		return err
+	}
+
+	iter := c.Find(query).Sort("id").Batch(exportChunkSize).Iter()
+	defer iter.Close()
+	var p Part
+	for iter.Next(&p) {
+		if err = pw.item(&p); err != nil {
+			return err
+		}
+		if pw.count%exportChunkSize == 0 {
+			pw.flush()
+			if err = dtx.Context().Err(); err != nil {
+				return err
+			}
+		}
+		p = Part{}
+	}
+	if err = iter.Err(); err != nil {
+		return err
+	}
+	return pw.close()
+}
+
+// piesWriter writes a PIES file a part at a time.
+type piesWriter struct {
+	w     io.Writer
+	enc   *xml.Encoder
+	root  xml.Name
+	brand brand.Brand
+	now   time.Time
+	count int
+}
+
+// newPIESWriter writes the file up to its first item.
+func newPIESWriter(w io.Writer, version string, b brand.Brand, sheets []piesPriceSheet, now time.Time) (*piesWriter, error) {
+	pw := &piesWriter{
+		w:     w,
+		enc:   xml.NewEncoder(w),
+		root:  xml.Name{Space: PIESVersions[version], Local: "PIES"},
+		brand: b,
+		now:   now,
+	}
+	pw.enc.Indent("", "  ")
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return nil, err
+	}
+	if err := pw.enc.EncodeToken(xml.StartElement{Name: pw.root}); err != nil {
+		return nil, err
+	}
+	header := piesHeader{
+		PIESVersion:          version,
+		SubmissionType:       "FULL",
+		BlanketEffectiveDate: now.Format("2006-01-02"),
+		CurrencyCode:         "USD",
+		LanguageCode:         piesLanguage,
+	}
+	if err := pw.enc.EncodeElement(header, xml.StartElement{Name: xml.Name{Local: "Header"}}); err != nil {
+		return nil, err
+	}
+	if len(sheets) > 0 {
+		wrapped := struct {
+			Sheets []piesPriceSheet `xml:"PriceSheet"`
+		}{sheets}
+		if err := pw.enc.EncodeElement(wrapped, xml.StartElement{Name: xml.Name{Local: "PriceSheets"}}); err != nil {
+			return nil, err
+		}
+	}
+	if err := pw.enc.EncodeToken(xml.StartElement{Name: xml.Name{Local: "Items"}}); err != nil {
+		return nil, err
+	}
+	return pw, nil
+}
+
+func (pw *piesWriter) item(p *Part) error {
+	pw.count++
+	return pw.enc.EncodeElement(newPIESItem(p, pw.brand), xml.StartElement{Name: xml.Name{Local: "Item"}})
+}
+
+func (pw *piesWriter) flush() {
+	pw.enc.Flush()
+	if fl, ok := pw.w.(flusher); ok {
+		fl.Flush()
+	}
+}
+
+// close writes the trailer and ends the file.
+func (pw *piesWriter) close() error {
+	if err := pw.enc.EncodeToken(xml.EndElement{Name: xml.Name{Local: "Items"}}); err != nil {
+		return err
+	}
+	trailer := piesTrailer{ItemCount: pw.count, TransactionDate: pw.now.Format("2006-01-02")}
+	if err := pw.enc.EncodeElement(trailer, xml.StartElement{Name: xml.Name{Local: "Trailer"}}); err != nil {
+		return err
+	}
+	if err := pw.enc.EncodeToken(xml.EndElement{Name: pw.root}); err != nil {
+		return err
+	}
+	pw.flush()
+	return nil
+}
+
+// piesPriceSheets returns a price sheet for each price type and currency
+// in types.
+func piesPriceSheets(types []string, now time.Time) []piesPriceSheet {
+	seen := map[string]bool{}
+	var sheets []piesPriceSheet
+	for _, t := range types {
+		code, cur, ok := piesPriceType(t)
+		if !ok || seen[code+cur] {
+			continue
+		}
+		seen[code+cur] = true
+		sheets = append(sheets, piesPriceSheet{
+			MaintenanceType:  "A",
+			PriceSheetNumber: piesSheetNumber(code, cur),
+			CurrencyCode:     cur,
+			EffectiveDate:    now.Format("2006-01-02"),
+		})
+	}
+	sort.Slice(sheets, func(i, j int) bool { return sheets[i].PriceSheetNumber < sheets[j].PriceSheetNumber })
+	return sheets
+}
+
+// piesPriceType returns the PIES code and currency of a price type, such
+// as "List" or "List CAD".
+func piesPriceType(t string) (code, cur string, ok bool) {
+	cur = "USD"
+	if base, native, isNative := currency.NativeType(t); isNative {
+		t, cur = base, native
+	}
+	code, ok = piesPriceTypes[strings.ToLower(t)]
+	return code, cur, ok
+}
+
+func piesSheetNumber(code, cur string) string {
+	return code + "-" + cur
+}
+
+func newPIESItem(p *Part, b brand.Brand) piesItem {
+	item := piesItem{
+		MaintenanceType:        "A",
+		HazardousMaterialCode:  "N",
+		PartNumber:             p.PartNumber,
+		BrandAAIAID:            b.AutocareID,
+		BrandLabel:             b.Name,
+		ItemQuantitySize:       piesQuantity{UOM: "EA", Value: 1},
+		QuantityPerApplication: piesQuantity{UOM: "EA", Value: 1},
+		MinimumOrderQuantity:   piesQuantity{UOM: "EA", Value: 1},
+		PartTerminologyID:      p.AcesPartTypeID,
+	}
+	if p.UPC != "" {
+		item.ItemLevelGTIN = &piesGTIN{GTINQualifier: "UP", Value: p.UPC}
+	}
+	if descs := piesDescriptionsOf(p); len(descs) > 0 {
+		item.Descriptions = &piesDescriptions{descs}
+	}
+
+	var prices []piesPricing
+	for _, pr := range p.Pricing {
+		code, cur, ok := piesPriceType(pr.Type)
+		if !ok || pr.Price <= 0 {
+			continue
+		}
+		pricing := piesPricing{MaintenanceType: "A", PriceType: code, PriceSheetNumber: piesSheetNumber(code, cur), CurrencyCode: cur}
+		pricing.Price.UOM = "PE"
+		pricing.Price.Value = strconv.FormatFloat(pr.Price, 'f', 2, 64)
+		prices = append(prices, pricing)
+	}
+	if len(prices) > 0 {
+		item.Prices = &piesPrices{prices}
+	}
+
+	if p.Tariff != "" {
+		item.ExtendedInformation = &piesExtendedInformation{[]piesExtendedInfo{{
+			MaintenanceType: "A", EXPICode: "HTS", LanguageCode: piesLanguage, Value: p.Tariff,
+		}}}
+	}
+
+	var attrs []piesAttribute
+	for _, a := range exportAttributes(p) {
+		attrs = append(attrs, piesAttribute{
+			MaintenanceType: "A", AttributeID: a.Key, PADBAttribute: "N", LanguageCode: piesLanguage, Value: a.Value,
+		})
+	}
+	if len(attrs) > 0 {
+		item.ProductAttributes = &piesAttributes{attrs}
+	}
+
+	if len(p.Packages) > 0 {
+		item.Packages = &piesPackages{}
+		for _, pkg := range p.Packages {
+			item.Packages.Package = append(item.Packages.Package, newPIESPackage(pkg))
+		}
+	}
+
+	if assets := piesAssetsOf(p); len(assets) > 0 {
+		item.DigitalAssets = &piesAssets{assets}
+	}
+	return item
+}
+
+// piesDescriptionsOf returns the short description as the DES description,
+// and the part's content that has a description code, in its sort order.
+// Bullets are numbered.
+func piesDescriptionsOf(p *Part) []piesDescription {
+	var descs []piesDescription
+	if short := strings.TrimSpace(p.ShortDesc); short != "" {
+		for utf8.RuneCountInString(short) > piesDescriptionLength {
+			_, size := utf8.DecodeLastRuneInString(short)
+			short = short[:len(short)-size]
+		}
+		descs = append(descs, piesDescription{MaintenanceType: "A", DescriptionCode: "DES", LanguageCode: piesLanguage, Value: short})
+	}
+
+	content := append([]Content(nil), p.Content...)
+	sort.SliceStable(content, func(i, j int) bool { return content[i].Sort < content[j].Sort })
+	sequences := map[string]int{}
+	for _, c := range content {
+		text := strings.TrimSpace(c.Text)
+		kind := strings.ToLower(c.ContentType.Type)
+		kind = kind[strings.LastIndex(kind, ":")+1:]
+		code, ok := piesDescriptionCodes[kind]
+		if !ok || text == "" {
+			continue
+		}
+		d := piesDescription{MaintenanceType: "A", DescriptionCode: code, LanguageCode: piesLanguage, Value: text}
+		if code == "FAB" {
+			sequences[code]++
+			d.Sequence = sequences[code]
+		}
+		descs = append(descs, d)
+	}
+	return descs
+}
+
+func newPIESPackage(pkg Package) piesPackage {
+	pp := piesPackage{
+		MaintenanceType:  "A",
+		PackageUOM:       strings.ToUpper(pkg.PackageUnit),
+		QuantityofEaches: pkg.Quantity,
+	}
+	if pp.PackageUOM == "" {
+		pp.PackageUOM = "EA"
+	}
+	if pp.QuantityofEaches < 1 {
+		pp.QuantityofEaches = 1
+	}
+	if pkg.Height > 0 || pkg.Width > 0 || pkg.Length > 0 {
+		pp.Dimensions = &piesDimensions{
+			UOM:                 piesDimensionUOM(pkg.DimensionUnit),
+			MerchandisingHeight: piesDecimal(pkg.Height),
+			MerchandisingWidth:  piesDecimal(pkg.Width),
+			MerchandisingLength: piesDecimal(pkg.Length),
+		}
+	}
+	if pkg.Weight > 0 {
+		pp.Weights = &piesPackWeights{UOM: piesWeightUOM(pkg.WeightUnit), Weight: piesDecimal(pkg.Weight)}
+	}
+	return pp
+}
+
+func piesDimensionUOM(unit string) string {
+	switch strings.ToUpper(unit) {
+	case "", "IN", "INCH", "INCHES":
+		return "IN"
+	case "CM", "CENTIMETER", "CENTIMETERS":
+		return "CM"
+	}
+	return strings.ToUpper(unit)
+}
+
+func piesWeightUOM(unit string) string {
+	switch strings.ToUpper(unit) {
+	case "", "LB", "LBS", "POUND", "POUNDS", "PG":
+		return "PG"
+	case "KG", "KILOGRAM", "KILOGRAMS":
+		return "KG"
+	}
+	return strings.ToUpper(unit)
+}
+
+func piesDecimal(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+// piesAssetsOf returns the largest size of each of the part's images, the
+// first as its primary photo, and its install sheet.
+func piesAssetsOf(p *Part) []piesAsset {
+	largest := make(map[string]Image)
+	for _, img := range p.Images {
+		if img.Path == nil {
+			continue
+		}
+		if cur, ok := largest[img.Sort]; !ok || img.Width*img.Height > cur.Width*cur.Height {
+			largest[img.Sort] = img
+		}
+	}
+	sorts := make([]string, 0, len(largest))
+	for s := range largest {
+		sorts = append(sorts, s)
+	}
+	sort.Strings(sorts)
+
+	var assets []piesAsset
+	for i, s := range sorts {
+		img := largest[s]
+		asset := piesAsset{
+			MaintenanceType: "A",
+			LanguageCode:    piesLanguage,
+			FileName:        path.Base(img.Path.Path),
+			AssetType:       "P01",
+			FileType:        piesFileType(img.Path.Path),
+			Representation:  "A",
+			URI:             img.Path.String(),
+		}
+		if i == 0 {
+			asset.AssetType = "P04"
+		}
+		if img.Height > 0 && img.Width > 0 {
+			asset.AssetDimensions = &piesAssetDimensions{UOM: "PX", AssetHeight: img.Height, AssetWidth: img.Width}
+		}
+		assets = append(assets, asset)
+	}
+	if p.InstallSheet != nil && p.InstallSheet.String() != "" {
+		assets = append(assets, piesAsset{
+			MaintenanceType: "A",
+			LanguageCode:    piesLanguage,
+			FileName:        path.Base(p.InstallSheet.Path),
+			AssetType:       "INS",
+			FileType:        piesFileType(p.InstallSheet.Path),
+			Representation:  "A",
+			URI:             p.InstallSheet.String(),
+		})
+	}
+	return assets
+}
+
+func piesFileType(name string) string {
+	ext := strings.ToUpper(strings.TrimPrefix(path.Ext(name), "."))
+	if ext == "JPEG" {
+		return "JPG"
+	}
+	return ext
+}
@@ -0,0 +1,163 @@
+package products
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/models/brand"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPIES(t *testing.T) {
+	Convey("Testing PIES export", t, func() {
+		img, _ := url.Parse("https://images.curtmfg.com/masterlibrary/11000/images/11000_1024x768_a.JPEG")
+		small, _ := url.Parse("https://images.curtmfg.com/masterlibrary/11000/images/11000_300x225_a.jpg")
+		side, _ := url.Parse("https://images.curtmfg.com/masterlibrary/11000/images/11000_1024x768_b.png")
+		sheet, _ := url.Parse("https://www.curtmfg.com/masterlibrary/11000/installsheet/CM_11000_INS.pdf")
+		b := brand.Brand{ID: 1, Name: "CURT", AutocareID: "BKDK"}
+		p := &Part{
+			ID: 11000, PartNumber: "11000", Status: 800, UPC: "038613110007", Tariff: "8716.90.5060",
+			AcesPartTypeID: 5316, InstallSheet: sheet,
+			ShortDesc: strings.Repeat("Class 1 Trailer Hitch, 1-1/4\" Receiver, Select Ford Focus ", 2),
+			Content: []Content{
+				{Text: "Custom-fit", ContentType: ContentType{Type: "Part:Bullet"}, Sort: 2},
+				{Text: "Rated to 2,000 lbs. & tested", ContentType: ContentType{Type: "Part:Bullet"}, Sort: 1},
+				{Text: "Concealed design", ContentType: ContentType{Type: "Marketing"}, Sort: 3},
+				{Text: "internal", ContentType: ContentType{Type: "Note"}},
+			},
+			Attributes: []Attribute{{Key: "Finish", Value: "Black", Sort: 2}, {Key: "Class", Value: "1", Sort: 1}, {Key: "Empty"}},
+			Pricing: []Price{
+				{Type: "List", Price: 129.5}, {Type: "Map", Price: 99.99}, {Type: "List CAD", Price: 170},
+				{Type: "Distributor", Price: 40}, {Type: "Jobber", Price: 0},
+			},
+			Packages: []Package{
+				{Height: 8, Width: 10.5, Length: 48, Weight: 22.4, DimensionUnit: "in", WeightUnit: "lb", PackageUnit: "bx", Quantity: 1},
+				{},
+			},
+			Images: []Image{
+				{Sort: "a", Height: 225, Width: 300, Path: small},
+				{Sort: "a", Height: 768, Width: 1024, Path: img},
+				{Sort: "b", Path: side},
+			},
+		}
+
+		Convey("items map the part's data to PIES segments", func() {
+			item := newPIESItem(p, b)
+			So(item.ItemLevelGTIN.Value, ShouldEqual, "038613110007")
+			So(item.BrandAAIAID, ShouldEqual, "BKDK")
+			So(item.PartTerminologyID, ShouldEqual, 5316)
+
+			So(len(item.Descriptions.Description), ShouldEqual, 4)
+			So(item.Descriptions.Description[0].DescriptionCode, ShouldEqual, "DES")
+			So(len(item.Descriptions.Description[0].Value), ShouldEqual, piesDescriptionLength)
+			So(item.Descriptions.Description[1].Value, ShouldEqual, "Rated to 2,000 lbs. & tested")
+			So(item.Descriptions.Description[1].Sequence, ShouldEqual, 1)
+			So(item.Descriptions.Description[2].Sequence, ShouldEqual, 2)
+			So(item.Descriptions.Description[3].DescriptionCode, ShouldEqual, "MKT")
+
+			So(len(item.Prices.Pricing), ShouldEqual, 3)
+			So(item.Prices.Pricing[0].PriceType, ShouldEqual, "LST")
+			So(item.Prices.Pricing[0].Price.Value, ShouldEqual, "129.50")
+			So(item.Prices.Pricing[1].PriceType, ShouldEqual, "RMP")
+			So(item.Prices.Pricing[2].PriceSheetNumber, ShouldEqual, "LST-CAD")
+
+			So(item.ExtendedInformation.ExtendedProductInformation, ShouldResemble, []piesExtendedInfo{{MaintenanceType: "A", EXPICode: "HTS", LanguageCode: "EN", Value: "8716.90.5060"}})
+			So(len(item.ProductAttributes.ProductAttribute), ShouldEqual, 2)
+			So(item.ProductAttributes.ProductAttribute[0].AttributeID, ShouldEqual, "Class")
+
+			So(item.Packages.Package[0].PackageUOM, ShouldEqual, "BX")
+			So(item.Packages.Package[0].Dimensions.MerchandisingWidth, ShouldEqual, "10.5")
+			So(item.Packages.Package[0].Weights.UOM, ShouldEqual, "PG")
+			So(item.Packages.Package[1].QuantityofEaches, ShouldEqual, 1)
+			So(item.Packages.Package[1].Dimensions, ShouldBeNil)
+
+			So(len(item.DigitalAssets.DigitalFileInformation), ShouldEqual, 3)
+			So(item.DigitalAssets.DigitalFileInformation[0].URI, ShouldEqual, img.String())
+			So(item.DigitalAssets.DigitalFileInformation[0].AssetType, ShouldEqual, "P04")
+			So(item.DigitalAssets.DigitalFileInformation[0].FileType, ShouldEqual, "JPG")
+			So(item.DigitalAssets.DigitalFileInformation[1].AssetDimensions, ShouldBeNil)
+			So(item.DigitalAssets.DigitalFileInformation[2].AssetType, ShouldEqual, "INS")
+		})
+
+		Convey("empty segments are left out", func() {
+			item := newPIESItem(&Part{PartNumber: "11001"}, b)
+			So(item.Descriptions, ShouldBeNil)
+			So(item.Prices, ShouldBeNil)
+			So(item.Packages, ShouldBeNil)
+			So(item.DigitalAssets, ShouldBeNil)
+		})
+
+		Convey("price sheets are listed once per type and currency", func() {
+			sheets := piesPriceSheets([]string{"List", "Map", "List", "List CAD", "Distributor"}, time.Now())
+			var numbers []string
+			for _, s := range sheets {
+				numbers = append(numbers, s.PriceSheetNumber)
+			}
+			So(numbers, ShouldResemble, []string{"LST-CAD", "LST-USD", "RMP-USD"})
+		})
+
+		Convey("files are in each version's namespace and count their items", func() {
+			for version, ns := range PIESVersions {
+				var buf bytes.Buffer
+				now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+				pw, err := newPIESWriter(&buf, version, b, nil, now)
+				So(err, ShouldBeNil)
+				So(pw.item(&Part{PartNumber: "11001"}), ShouldBeNil)
+				So(pw.close(), ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, `<PIES xmlns="`+ns+`">`)
+				So(buf.String(), ShouldContainSubstring, "<PIESVersion>"+version+"</PIESVersion>")
+				So(buf.String(), ShouldContainSubstring, "<ItemCount>1</ItemCount>")
+			}
+		})
+
+		// The PIES schemas are only published to Auto Care Association
+		// members, so they aren't kept in the repo. Files are always checked
+		// against testdata/pies_structure_<version>.xsd, our own schema of the
+		// segments we write, and against the official schemas too when
+		// PIES_XSD_DIR is a directory holding them as pies_6.5.xsd and
+		// pies_7.1.xsd, as it is in CI.
+		Convey("files validate against the PIES schema", func() {
+			xmllint, err := exec.LookPath("xmllint")
+			if err != nil {
+				if os.Getenv("CI") != "" {
+					t.Fatal("xmllint isn't installed")
+				}
+				t.Skip("xmllint isn't installed")
+			}
+			dir, err := ioutil.TempDir("", "pies")
+			So(err, ShouldBeNil)
+			defer os.RemoveAll(dir)
+
+			for version := range PIESVersions {
+				var buf bytes.Buffer
+				now := time.Date(2016, 3, 1, 12, 0, 0, 0, time.UTC)
+				pw, err := newPIESWriter(&buf, version, b, piesPriceSheets([]string{"List", "Map", "List CAD"}, now), now)
+				So(err, ShouldBeNil)
+				So(pw.item(p), ShouldBeNil)
+				So(pw.item(&Part{PartNumber: "11001"}), ShouldBeNil)
+				So(pw.close(), ShouldBeNil)
+				So(buf.String(), ShouldContainSubstring, "<ItemCount>2</ItemCount>")
+
+				file := filepath.Join(dir, "pies_"+version+".xml")
+				So(ioutil.WriteFile(file, buf.Bytes(), 0644), ShouldBeNil)
+
+				schemas := []string{filepath.Join("testdata", "pies_structure_"+version+".xsd")}
+				if official := os.Getenv("PIES_XSD_DIR"); official != "" {
+					schemas = append(schemas, filepath.Join(official, "pies_"+version+".xsd"))
+				}
+				for _, schema := range schemas {
+					out, err := exec.Command(xmllint, "--noout", "--schema", schema, file).CombinedOutput()
+					So(string(out), ShouldContainSubstring, "validates")
+					So(err, ShouldBeNil)
+				}
+			}
+		})
+	})
+}
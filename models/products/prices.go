@@ -1,7 +1,10 @@
 package products
 
 import (
+	"strings"
 	"time"
+
+	"github.com/curt-labs/API/models/currency"
 )
 
 type Price struct {
@@ -11,4 +14,54 @@ type Price struct {
 	Price        float64   `json:"price" xml:"price"`
 	Enforced     bool      `json:"enforced,omitempty", xml:"enforced, omitempty"`
 	DateModified time.Time `json:"dateModified,omitempty" xml:"dateModified,omitempty"`
+	Currency     string    `json:"currency,omitempty" xml:"currency,omitempty" bson:"-"`
+}
+
+// InCurrency converts the part's prices from USD with c, using the prices
+// the part keeps natively in c's currency, such as "List CAD", in place of
+// conversions where it has them. Native prices are only listed in USD
+// responses; a nil c leaves the part as it is. An expanded kit is totalled
+// again from its converted components.
+func (p *Part) InCurrency(c *currency.Converter) {
+	if c == nil {
+		return
+	}
+	natives := make(map[string]float64)
+	for _, pr := range p.Pricing {
+		if base, cur, ok := currency.NativeType(pr.Type); ok && cur == c.Currency {
+			natives[strings.ToLower(base)] = pr.Price
+		}
+	}
+	prices := make([]Price, 0, len(p.Pricing))
+	for _, pr := range p.Pricing {
+		if _, _, ok := currency.NativeType(pr.Type); ok {
+			continue
+		}
+		if native, ok := natives[strings.ToLower(pr.Type)]; ok {
+			pr.Price = native
+		} else {
+			pr.Price = c.Convert(pr.Price)
+		}
+		pr.Currency = c.Currency
+		prices = append(prices, pr)
+	}
+	p.Pricing = prices
+	p.Customer.Price = c.Convert(p.Customer.Price)
+	p.Customer.Currency = c.Currency
+	if p.Kit != nil {
+		for _, comp := range p.Kit.Components {
+			if comp.Part != nil {
+				comp.Part.InCurrency(c)
+			}
+		}
+		p.Kit.Currency = c.Currency
+		p.Kit.total()
+	}
+}
+
+// PartsInCurrency converts the prices of each of parts with c.
+func PartsInCurrency(parts []Part, c *currency.Converter) {
+	for i := range parts {
+		parts[i].InCurrency(c)
+	}
 }
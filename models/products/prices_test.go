@@ -0,0 +1,38 @@
+package products
+
+import (
+	"testing"
+
+	"github.com/curt-labs/API/models/currency"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestPartCurrency(t *testing.T) {
+	Convey("Testing parts in other currencies", t, func() {
+		cad := currency.NewConverter(currency.ExchangeRate{Currency: "CAD", Rate: 1.3, Rounding: currency.RoundUp, Increment: 0.05})
+		p := Part{
+			Pricing: []Price{
+				{Type: "List", Price: 250},
+				{Type: "List CAD", Price: 329.99},
+				{Type: "Map", Price: 199.99},
+			},
+			Customer: CustomerPart{Price: 210},
+		}
+
+		Convey("a nil converter leaves USD prices alone", func() {
+			p.InCurrency(nil)
+			So(p.Pricing, ShouldHaveLength, 3)
+			So(p.Customer.Currency, ShouldEqual, "")
+		})
+
+		Convey("native prices stand in for conversions", func() {
+			p.InCurrency(cad)
+			So(p.Pricing, ShouldResemble, []Price{
+				{Type: "List", Price: 329.99, Currency: "CAD"},
+				{Type: "Map", Price: 260, Currency: "CAD"},
+			})
+			So(p.Customer.Price, ShouldEqual, 273)
+			So(p.Customer.Currency, ShouldEqual, "CAD")
+		})
+	})
+}
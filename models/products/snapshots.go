@@ -0,0 +1,506 @@
+package products
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/blobstore"
+	"github.com/curt-labs/API/helpers/database"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A catalog snapshot is a brand's whole catalog in one gzipped file, so that
+// partners can rebuild it without paging through /part. Snapshots are made
+// in the background, on a schedule or when asked for, by whichever server
+// claims them first, and kept in SnapshotStore.
+
+// Snapshot formats.
+const (
+	SnapshotNDJSON = "ndjson"
+	SnapshotCSV    = "csv"
+)
+
+// Snapshot statuses.
+const (
+	SnapshotQueued   = "queued"
+	SnapshotRunning  = "running"
+	SnapshotComplete = "complete"
+	SnapshotFailed   = "failed"
+)
+
+// Snapshot is a catalog export of a brand's parts. Checksum is the SHA-256
+// of the gzipped file, as downloaded.
+type Snapshot struct {
+	ID        bson.ObjectId `bson:"_id" json:"id" xml:"id,attr"`
+	BrandID   int           `bson:"brand_id" json:"brand_id" xml:"brand_id,attr"`
+	Format    string        `bson:"format" json:"format" xml:"format,attr"`
+	Status    string        `bson:"status" json:"status" xml:"status,attr"`
+	Trigger   string        `bson:"trigger" json:"trigger" xml:"trigger,attr"`
+	Error     string        `bson:"error,omitempty" json:"error,omitempty" xml:"error,omitempty"`
+	Parts     int           `bson:"parts" json:"parts" xml:"parts,attr"`
+	Size      int64         `bson:"size" json:"size" xml:"size,attr"`
+	Checksum  string        `bson:"checksum,omitempty" json:"checksum,omitempty" xml:"checksum,attr,omitempty"`
+	File      string        `bson:"file" json:"-" xml:"-"`
+	Requested time.Time     `bson:"requested" json:"requested" xml:"requested,attr"`
+	Started   *time.Time    `bson:"started,omitempty" json:"started,omitempty" xml:"started,attr,omitempty"`
+	Finished  *time.Time    `bson:"finished,omitempty" json:"finished,omitempty" xml:"finished,attr,omitempty"`
+}
+
+// SnapshotPart is a part as it's written to snapshots.
+type SnapshotPart struct {
+	ID           int                  `json:"id"`
+	PartNumber   string               `json:"part_number"`
+	BrandID      int                  `json:"brand_id"`
+	Status       int                  `json:"status"`
+	ShortDesc    string               `json:"short_description"`
+	UPC          string               `json:"upc,omitempty"`
+	DateModified time.Time            `json:"date_modified"`
+	ReplacedBy   int                  `json:"replaced_by,omitempty"`
+	Attributes   []Attribute          `json:"attributes"`
+	Pricing      []Price              `json:"pricing"`
+	Images       []SnapshotImage      `json:"images"`
+	Categories   []SnapshotCategory   `json:"categories"`
+	Fitment      []VehicleApplication `json:"fitment"`
+	AcesFitment  []AcesVehicle        `json:"aces_fitment,omitempty"`
+}
+
+type SnapshotImage struct {
+	Size string `json:"size"`
+	Sort string `json:"sort"`
+	URL  string `json:"url"`
+}
+
+type SnapshotCategory struct {
+	ID    int    `json:"id"`
+	Title string `json:"title"`
+}
+
+var (
+	// SnapshotStore is where snapshot files are kept.
+	SnapshotStore blobstore.Store = blobstore.NewLocal("exports")
+
+	ErrSnapshotNotFound    = errors.New("no catalog export with that ID")
+	ErrSnapshotIncomplete  = errors.New("that catalog export isn't complete")
+	ErrUnknownSnapshotType = errors.New("catalog exports are ndjson or csv")
+
+	SnapshotFormats = []string{SnapshotNDJSON, SnapshotCSV}
+
+	// wakeSnapshots has the worker look for queued snapshots at once.
+	wakeSnapshots = make(chan struct{}, 1)
+)
+
+const (
+	snapshotCollection      = "catalog_exports"
+	snapshotStateCollection = "catalog_export_state"
+	// snapshotsKept is how many complete snapshots of each brand and
+	// format are kept.
+	snapshotsKept = 3
+	// snapshotPoll is how often the worker looks for queued snapshots
+	// that another server asked for.
+	snapshotPoll = time.Minute
+	// snapshotTimeout is how long a snapshot may run before it's taken to
+	// have been abandoned, and is made again.
+	snapshotTimeout = 6 * time.Hour
+)
+
+var snapshotHeader = []string{
+	"id", "part_number", "brand_id", "status", "short_description", "upc", "date_modified", "replaced_by",
+	"attributes", "pricing", "images", "categories", "fitment",
+}
+
+// GetSnapshots returns the snapshots of the customer's brands, newest
+// first.
+func GetSnapshots(dtx *apicontext.DataContext, count int) ([]Snapshot, error) {
+	snapshots := []Snapshot{}
+	if count < 1 || count > 500 {
+		count = 50
+	}
+	if err := database.Init(); err != nil {
+		return snapshots, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	err := session.DB(database.ProductDatabase).C(snapshotCollection).Find(bson.M{"brand_id": bson.M{"$in": getBrandsFromDTX(dtx)}}).Sort("-requested").Limit(count).All(&snapshots)
+	return snapshots, err
+}
+
+// GetSnapshot returns one of the snapshots of the customer's brands.
+func GetSnapshot(dtx *apicontext.DataContext, id string) (Snapshot, error) {
+	var s Snapshot
+	if !bson.IsObjectIdHex(id) {
+		return s, ErrSnapshotNotFound
+	}
+	if err := database.Init(); err != nil {
+		return s, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	query := bson.M{"_id": bson.ObjectIdHex(id), "brand_id": bson.M{"$in": getBrandsFromDTX(dtx)}}
+	err := session.DB(database.ProductDatabase).C(snapshotCollection).Find(query).One(&s)
+	if err == mgo.ErrNotFound {
+		return s, ErrSnapshotNotFound
+	}
+	return s, err
+}
+
+// Open opens the snapshot's file.
+func (s *Snapshot) Open() (io.ReadCloser, error) {
+	if s.Status != SnapshotComplete {
+		return nil, ErrSnapshotIncomplete
+	}
+	return SnapshotStore.Open(s.File)
+}
+
+// FileName is the name the snapshot is downloaded as.
+func (s *Snapshot) FileName() string {
+	return fmt.Sprintf("catalog-%d-%s.%s.gz", s.BrandID, s.Requested.UTC().Format("20060102-150405"), s.Format)
+}
+
+// QueueSnapshots asks for snapshots of the brands, in the formats, which
+// are made in the background.
+func QueueSnapshots(ctx context.Context, brands []int, formats []string, trigger string) ([]Snapshot, error) {
+	queued := []Snapshot{}
+	for _, f := range formats {
+		if f != SnapshotNDJSON && f != SnapshotCSV {
+			return queued, ErrUnknownSnapshotType
+		}
+	}
+	if err := database.Init(); err != nil {
+		return queued, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	c := session.DB(database.ProductDatabase).C(snapshotCollection)
+
+	now := time.Now().UTC()
+	for _, b := range brands {
+		for _, f := range formats {
+			s := Snapshot{ID: bson.NewObjectId(), BrandID: b, Format: f, Status: SnapshotQueued, Trigger: trigger, Requested: now}
+			s.File = fmt.Sprintf("catalog/%d/%s.%s.gz", b, s.ID.Hex(), f)
+			if err := c.Insert(s); err != nil {
+				return queued, err
+			}
+			queued = append(queued, s)
+		}
+	}
+	select {
+	case wakeSnapshots <- struct{}{}:
+	default:
+	}
+	return queued, nil
+}
+
+// SnapshotBrands returns the brands that have parts.
+func SnapshotBrands(ctx context.Context) ([]int, error) {
+	var brands []int
+	if err := database.Init(); err != nil {
+		return brands, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	err := session.DB(database.ProductDatabase).C(database.ProductCollectionName).Find(nil).Distinct("brand.id", &brands)
+	return brands, err
+}
+
+// RunSnapshots makes queued snapshots until ctx is done, and queues every
+// brand's snapshots each interval, if interval isn't 0.
+func RunSnapshots(ctx context.Context, interval time.Duration) {
+	poll := time.NewTicker(snapshotPoll)
+	defer poll.Stop()
+	for {
+		if interval > 0 {
+			if err := scheduleSnapshots(ctx, time.Now(), interval); err != nil {
+				log.Printf("Error scheduling catalog exports: %s\n", err)
+			}
+		}
+		for {
+			s, err := claimSnapshot(ctx, time.Now())
+			if err != nil {
+				log.Printf("Error claiming a catalog export: %s\n", err)
+				break
+			}
+			if s == nil {
+				break
+			}
+			if err = makeSnapshot(ctx, s); err != nil {
+				log.Printf("Error exporting brand %d's catalog as %s: %s\n", s.BrandID, s.Format, err)
+			}
+			if ctx.Err() != nil {
+				return
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-poll.C:
+		case <-wakeSnapshots:
+		}
+	}
+}
+
+// scheduleSnapshots queues every brand's snapshots if they're due. The
+// schedule is shared, so that only one server queues them.
+func scheduleSnapshots(ctx context.Context, now time.Time, interval time.Duration) error {
+	if err := database.Init(); err != nil {
+		return err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+
+	query := bson.M{"_id": "schedule", "$or": []bson.M{
+		{"next_run": bson.M{"$exists": false}},
+		{"next_run": bson.M{"$lte": now}},
+	}}
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"next_run": now.Add(interval)}}, Upsert: true}
+	_, err := session.DB(database.ProductDatabase).C(snapshotStateCollection).Find(query).Apply(change, &bson.M{})
+	if mgo.IsDup(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	brands, err := SnapshotBrands(ctx)
+	if err != nil {
+		return err
+	}
+	_, err = QueueSnapshots(ctx, brands, SnapshotFormats, "schedule")
+	return err
+}
+
+// claimSnapshot marks the oldest queued, or abandoned, snapshot running,
+// and returns it, or nil if there isn't one.
+func claimSnapshot(ctx context.Context, now time.Time) (*Snapshot, error) {
+	if err := database.Init(); err != nil {
+		return nil, err
+	}
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+
+	var s Snapshot
+	change := mgo.Change{Update: bson.M{"$set": bson.M{"status": SnapshotRunning, "started": now}}, ReturnNew: true}
+	_, err := session.DB(database.ProductDatabase).C(snapshotCollection).Find(bson.M{"$or": []bson.M{
+		{"status": SnapshotQueued},
+		{"status": SnapshotRunning, "started": bson.M{"$lt": now.Add(-snapshotTimeout)}},
+	}}).Sort("requested").Apply(change, &s)
+	if err == mgo.ErrNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// makeSnapshot writes the snapshot's file, and records how it went. A
+// snapshot that's interrupted by shutdown is queued again.
+func makeSnapshot(ctx context.Context, s *Snapshot) error {
+	session := database.CopySession(ctx, database.ProductMongoSession)
+	defer session.Close()
+	db := session.DB(database.ProductDatabase)
+
+	err := writeSnapshot(ctx, db.C(database.ProductCollectionName), s)
+	now := time.Now().UTC()
+	s.Finished = &now
+	s.Status = SnapshotComplete
+	switch {
+	case err != nil && ctx.Err() != nil:
+		s.Status, s.Started, s.Finished, s.Parts = SnapshotQueued, nil, nil, 0
+	case err != nil:
+		s.Status, s.Error = SnapshotFailed, err.Error()
+	}
+	if err != nil {
+		SnapshotStore.Remove(s.File)
+	}
+	if uerr := db.C(snapshotCollection).UpdateId(s.ID, s); uerr != nil {
+		return uerr
+	}
+	if err != nil {
+		return err
+	}
+	return pruneSnapshots(db.C(snapshotCollection), s.BrandID, s.Format)
+}
+
+// writeSnapshot streams the brand's parts, gzipped, into the store,
+// counting and checksumming them on the way.
+func writeSnapshot(ctx context.Context, parts *mgo.Collection, s *Snapshot) error {
+	pr, pw := io.Pipe()
+	stored := make(chan error, 1)
+	go func() {
+		err := SnapshotStore.Put(s.File, pr)
+		pr.CloseWithError(err)
+		stored <- err
+	}()
+
+	sum := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(pw, sum)}
+	zw := gzip.NewWriter(counter)
+	s.Parts = 0
+	err := writeSnapshotParts(ctx, parts, s.BrandID, s.Format, zw, func() { s.Parts++ })
+	if err == nil {
+		err = zw.Close()
+	}
+	pw.CloseWithError(err)
+	if serr := <-stored; err == nil {
+		err = serr
+	}
+	if err != nil {
+		return err
+	}
+	s.Size = counter.n
+	s.Checksum = hex.EncodeToString(sum.Sum(nil))
+	return nil
+}
+
+func writeSnapshotParts(ctx context.Context, parts *mgo.Collection, brandID int, format string, w io.Writer, wrote func()) error {
+	var cw *csv.Writer
+	enc := json.NewEncoder(w)
+	if format == SnapshotCSV {
+		cw = csv.NewWriter(w)
+		if err := cw.Write(snapshotHeader); err != nil {
+			return err
+		}
+	}
+
+	query := bson.M{"brand.id": brandID, "status": bson.M{"$in": statuses}}
+	iter := parts.Find(query).Sort("id").Batch(exportChunkSize).Iter()
+	defer iter.Close()
+	var p Part
+	for iter.Next(&p) {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		sp := newSnapshotPart(&p)
+		var err error
+		if cw != nil {
+			err = cw.Write(sp.row())
+		} else {
+			err = enc.Encode(sp)
+		}
+		if err != nil {
+			return err
+		}
+		wrote()
+		p = Part{}
+	}
+	if err := iter.Err(); err != nil {
+		return err
+	}
+	if cw != nil {
+		cw.Flush()
+		return cw.Error()
+	}
+	return nil
+}
+
+// pruneSnapshots removes all but the newest complete snapshots of the
+// brand in the format, and their files.
+func pruneSnapshots(c *mgo.Collection, brandID int, format string) error {
+	var old []Snapshot
+	query := bson.M{"brand_id": brandID, "format": format, "status": SnapshotComplete}
+	if err := c.Find(query).Sort("-requested").Skip(snapshotsKept).All(&old); err != nil {
+		return err
+	}
+	for _, s := range old {
+		if err := SnapshotStore.Remove(s.File); err != nil && err != blobstore.ErrNotFound {
+			return err
+		}
+		if err := c.RemoveId(s.ID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newSnapshotPart(p *Part) SnapshotPart {
+	sp := SnapshotPart{
+		ID:           p.ID,
+		PartNumber:   p.PartNumber,
+		BrandID:      p.Brand.ID,
+		Status:       p.Status,
+		ShortDesc:    p.ShortDesc,
+		UPC:          p.UPC,
+		DateModified: p.DateModified,
+		ReplacedBy:   p.ReplacedBy,
+		Attributes:   p.Attributes,
+		Pricing:      p.Pricing,
+		Images:       make([]SnapshotImage, 0, len(p.Images)),
+		Categories:   make([]SnapshotCategory, 0, len(p.Categories)),
+		Fitment:      p.Vehicles,
+		AcesFitment:  p.AcesVehicles,
+	}
+	if sp.Attributes == nil {
+		sp.Attributes = []Attribute{}
+	}
+	if sp.Pricing == nil {
+		sp.Pricing = []Price{}
+	}
+	if sp.Fitment == nil {
+		sp.Fitment = []VehicleApplication{}
+	}
+	for _, img := range p.Images {
+		if img.Path != nil {
+			sp.Images = append(sp.Images, SnapshotImage{Size: img.Size, Sort: img.Sort, URL: img.Path.String()})
+		}
+	}
+	for _, c := range p.Categories {
+		sp.Categories = append(sp.Categories, SnapshotCategory{ID: c.CategoryID, Title: c.Title})
+	}
+	return sp
+}
+
+// row is the part as a CSV row. Lists are separated by "|", and the
+// fields of their entries by ";".
+func (sp SnapshotPart) row() []string {
+	var attrs, prices, images, cats, fitment []string
+	for _, a := range sp.Attributes {
+		attrs = append(attrs, a.Key+": "+a.Value)
+	}
+	for _, pr := range sp.Pricing {
+		prices = append(prices, pr.Type+": "+strconv.FormatFloat(pr.Price, 'f', 2, 64))
+	}
+	for _, img := range sp.Images {
+		images = append(images, img.URL)
+	}
+	for _, c := range sp.Categories {
+		cats = append(cats, strconv.Itoa(c.ID)+";"+c.Title)
+	}
+	for _, v := range sp.Fitment {
+		fitment = append(fitment, strings.Join([]string{v.Year, v.Make, v.Model, v.Style}, ";"))
+	}
+	for _, v := range sp.AcesFitment {
+		fitment = append(fitment, strings.Join([]string{strconv.Itoa(v.Base.Year), v.Base.Make, v.Base.Model, v.Submodel}, ";"))
+	}
+	replacedBy := ""
+	if sp.ReplacedBy != 0 {
+		replacedBy = strconv.Itoa(sp.ReplacedBy)
+	}
+	return []string{
+		strconv.Itoa(sp.ID), sp.PartNumber, strconv.Itoa(sp.BrandID), strconv.Itoa(sp.Status), sp.ShortDesc, sp.UPC,
+		sp.DateModified.UTC().Format(time.RFC3339), replacedBy,
+		strings.Join(attrs, "|"), strings.Join(prices, "|"), strings.Join(images, "|"), strings.Join(cats, "|"), strings.Join(fitment, "|"),
+	}
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
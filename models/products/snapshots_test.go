@@ -0,0 +1,72 @@
+package products
+
+import (
+	"bytes"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/curt-labs/API/models/brand"
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSnapshots(t *testing.T) {
+	Convey("Testing snapshots", t, func() {
+		img, _ := url.Parse("https://images.curtmfg.com/masterlibrary/11000/images/11000_300x225_a.jpg")
+		p := &Part{
+			ID: 11000, PartNumber: "11000", Brand: brand.Brand{ID: 1}, Status: 800,
+			ShortDesc: "Class 1 Trailer Hitch", UPC: "038613110007", ReplacedBy: 11001,
+			DateModified: time.Date(2016, 3, 1, 12, 0, 0, 0, time.FixedZone("CST", -6*60*60)),
+			Attributes:   []Attribute{{Key: "Finish", Value: "Black"}},
+			Pricing:      []Price{{Type: "List", Price: 129.5}},
+			Images:       []Image{{Size: "Tall", Sort: "a", Path: img}, {Size: "Grande", Sort: "b"}},
+			Categories:   []Category{{CategoryID: 7, Title: "Hitches"}},
+			Vehicles:     []VehicleApplication{{Year: "2012", Make: "Ford", Model: "F-150", Style: "All"}},
+		}
+
+		Convey("parts are trimmed to what's exported", func() {
+			sp := newSnapshotPart(p)
+			So(sp.BrandID, ShouldEqual, 1)
+			So(sp.Images, ShouldResemble, []SnapshotImage{{Size: "Tall", Sort: "a", URL: img.String()}})
+			So(sp.Categories, ShouldResemble, []SnapshotCategory{{ID: 7, Title: "Hitches"}})
+		})
+
+		Convey("empty lists are exported as empty", func() {
+			sp := newSnapshotPart(&Part{ID: 1})
+			So(sp.Attributes, ShouldNotBeNil)
+			So(sp.Pricing, ShouldNotBeNil)
+			So(sp.Images, ShouldNotBeNil)
+			So(sp.Fitment, ShouldNotBeNil)
+		})
+
+		Convey("rows match the header", func() {
+			row := newSnapshotPart(p).row()
+			So(len(row), ShouldEqual, len(snapshotHeader))
+			So(row[6], ShouldEqual, "2016-03-01T18:00:00Z")
+			So(row[7], ShouldEqual, "11001")
+			So(row[8], ShouldEqual, "Finish: Black")
+			So(row[9], ShouldEqual, "List: 129.50")
+			So(row[11], ShouldEqual, "7;Hitches")
+			So(row[12], ShouldEqual, "2012;Ford;F-150;All")
+		})
+
+		Convey("file names are by brand, time and format", func() {
+			s := Snapshot{BrandID: 3, Format: SnapshotCSV, Requested: time.Date(2016, 3, 1, 6, 5, 4, 0, time.UTC)}
+			So(s.FileName(), ShouldEqual, "catalog-3-20160301-060504.csv.gz")
+		})
+
+		Convey("incomplete snapshots can't be opened", func() {
+			s := Snapshot{Status: SnapshotRunning}
+			_, err := s.Open()
+			So(err, ShouldEqual, ErrSnapshotIncomplete)
+		})
+
+		Convey("written bytes are counted", func() {
+			var buf bytes.Buffer
+			cw := &countingWriter{w: &buf}
+			cw.Write([]byte("abc"))
+			cw.Write([]byte("de"))
+			So(cw.n, ShouldEqual, 5)
+		})
+	})
+}
@@ -4,11 +4,12 @@ import (
 	"strconv"
 	"strings"
 
+	"github.com/curt-labs/API/helpers/apicontext"
 	"github.com/curt-labs/API/helpers/database"
 	_ "github.com/go-sql-driver/mysql"
 )
 
-func (l *Lookup) GetSubmodels() error {
+func (l *Lookup) GetSubmodels(dtx *apicontext.DataContext) error {
 	stmtBeginning := `
 		select distinct s.SubmodelName from vcdb_Vehicle as v
 		join Submodel as s on v.SubModelID = s.ID
@@ -32,13 +33,13 @@ func (l *Lookup) GetSubmodels() error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(wholeStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), wholeStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query(l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model)
+	res, err := stmt.QueryContext(dtx.Context(), l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model)
 	if err != nil {
 		return err
 	}
@@ -18,7 +18,7 @@ func TestGetSubmodels(t *testing.T) {
 	Convey("Testing GetSubmodels()", t, func() {
 
 		Convey("without year/make/model", func() {
-			err := l.GetSubmodels()
+			err := l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			So(len(l.Submodels), ShouldEqual, 0)
@@ -28,7 +28,7 @@ func TestGetSubmodels(t *testing.T) {
 			l.Vehicle.Base.Year = 1
 			l.Vehicle.Base.Make = "KD"
 			l.Vehicle.Base.Model = "123"
-			err := l.GetSubmodels()
+			err := l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			So(len(l.Submodels), ShouldEqual, 0)
@@ -42,7 +42,7 @@ func TestGetSubmodels(t *testing.T) {
 			}
 			l.Vehicle.Base.Year = l.Years[api_helpers.RandGenerator(len(l.Years))]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			So(len(l.Submodels), ShouldEqual, 0)
@@ -62,7 +62,7 @@ func TestGetSubmodels(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes))]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			So(len(l.Submodels), ShouldEqual, 0)
@@ -84,14 +84,14 @@ func TestGetSubmodels(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			if (len(l.Submodels)) > 0 {
@@ -0,0 +1,239 @@
+package products
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/curt-labs/API/helpers/apicontext"
+	"github.com/curt-labs/API/helpers/database"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A part that's been superseded names its replacement in ReplacedBy, which
+// may itself have been superseded. Following the replacements leads to the
+// part that's current.
+
+// Supersession is the chain of replacements from a requested part to the
+// one that's current. Chain runs from the requested part to Current, and
+// is only the requested part when it hasn't been superseded.
+type Supersession struct {
+	Requested string             `json:"requested" xml:"requested,attr"`
+	Current   *SupersessionLink  `json:"current,omitempty" xml:"current,omitempty"`
+	Chain     []SupersessionLink `json:"chain" xml:"chain>part"`
+	// NotFound is set when the requested part doesn't exist, Unresolved
+	// when a replacement in the chain doesn't, or isn't one of the
+	// customer's brands, and Cycle when the chain leads back to a part
+	// already in it. Current is the last part that could be followed.
+	// Discontinued is set when the chain ends on a discontinued part;
+	// Current is then the last part before it that isn't discontinued, or
+	// the requested part, and Chain still runs to the end.
+	NotFound     bool `json:"not_found,omitempty" xml:"not_found,attr,omitempty"`
+	Unresolved   bool `json:"unresolved,omitempty" xml:"unresolved,attr,omitempty"`
+	Cycle        bool `json:"cycle,omitempty" xml:"cycle,attr,omitempty"`
+	Discontinued bool `json:"discontinued,omitempty" xml:"discontinued,attr,omitempty"`
+}
+
+// SupersessionLink is a part in a supersession chain.
+type SupersessionLink struct {
+	ID         int    `json:"id" xml:"id,attr"`
+	PartNumber string `json:"part_number" xml:"part_number,attr"`
+	Status     int    `json:"status" xml:"status,attr"`
+}
+
+// maxSupersessions bounds how far a chain is followed.
+const maxSupersessions = 25
+
+var (
+	ErrSupersessionCycle = errors.New("the part's supersessions lead back to a part already replaced")
+)
+
+var supersessionFields = bson.M{"id": 1, "part_number": 1, "status": 1, "replaced_by": 1}
+
+// FollowSupersession replaces the part with the one that's current, if it's
+// been superseded, and sets its Supersession. A chain that cycles is
+// ErrSupersessionCycle.
+func (p *Part) FollowSupersession(dtx *apicontext.DataContext) error {
+	if p.ReplacedBy == 0 || p.ReplacedBy == p.ID {
+		return nil
+	}
+	ss, err := Supersessions(dtx, []Part{*p})
+	if err != nil {
+		return err
+	}
+	s := ss[p.ID]
+	if s.Cycle {
+		return ErrSupersessionCycle
+	}
+	if s.Current.ID != p.ID {
+		current := Part{PartNumber: s.Current.PartNumber}
+		if err = current.GetPartByPartNumber(dtx); err != nil {
+			return err
+		}
+		*p = current
+	}
+	p.Supersession = s
+	return nil
+}
+
+// ReportSubstitutions sets the Supersession of each of the parts that has
+// been superseded, leaving the parts as they are.
+func ReportSubstitutions(dtx *apicontext.DataContext, parts []Part) error {
+	ss, err := Supersessions(dtx, parts)
+	if err != nil {
+		return err
+	}
+	for i := range parts {
+		if s, ok := ss[parts[i].ID]; ok {
+			parts[i].Supersession = s
+		}
+	}
+	return nil
+}
+
+// Supersessions returns the supersession chains of the parts that have been
+// superseded, by part ID.
+func Supersessions(dtx *apicontext.DataContext, parts []Part) (map[int]*Supersession, error) {
+	superseded := false
+	for _, p := range parts {
+		superseded = superseded || (p.ReplacedBy != 0 && p.ReplacedBy != p.ID)
+	}
+	if !superseded {
+		return map[int]*Supersession{}, nil
+	}
+
+	if err := database.Init(); err != nil {
+		return nil, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	c := session.DB(database.ProductDatabase).C(database.ProductCollectionName)
+	brands := getBrandsFromDTX(dtx)
+
+	return resolveSupersessions(parts, replacementsIn(c, brands))
+}
+
+// CurrentPartNumbers maps part numbers to the parts that are current, for
+// cleaning up catalogs of old part numbers. Each part number has a
+// Supersession, in order.
+func CurrentPartNumbers(dtx *apicontext.DataContext, partNumbers []string) ([]Supersession, error) {
+	if err := database.Init(); err != nil {
+		return nil, err
+	}
+	session := database.CopySession(dtx.Context(), database.ProductMongoSession)
+	defer session.Close()
+	c := session.DB(database.ProductDatabase).C(database.ProductCollectionName)
+	brands := getBrandsFromDTX(dtx)
+
+	var parts []Part
+	query := bson.M{"part_number": bson.M{"$in": partNumbers}, "brand.id": bson.M{"$in": brands}}
+	if err := c.Find(query).Select(supersessionFields).All(&parts); err != nil {
+		return nil, err
+	}
+	ss, err := resolveSupersessions(parts, replacementsIn(c, brands))
+	if err != nil {
+		return nil, err
+	}
+
+	byNumber := make(map[string]Part, len(parts))
+	for _, p := range parts {
+		byNumber[strings.ToUpper(p.PartNumber)] = p
+	}
+	current := make([]Supersession, 0, len(partNumbers))
+	for _, pn := range partNumbers {
+		p, ok := byNumber[strings.ToUpper(pn)]
+		switch {
+		case !ok:
+			current = append(current, Supersession{Requested: pn, Chain: []SupersessionLink{}, NotFound: true})
+		case ss[p.ID] != nil:
+			s := *ss[p.ID]
+			s.Requested = pn
+			current = append(current, s)
+		default:
+			link := linkOf(p)
+			current = append(current, Supersession{Requested: pn, Current: &link, Chain: []SupersessionLink{link}})
+		}
+	}
+	return current, nil
+}
+
+// resolveSupersessions follows the chains of the parts that have been
+// superseded, looking up the replacements a step at a time for all of the
+// chains at once.
+func resolveSupersessions(parts []Part, lookup func(ids []int) ([]Part, error)) (map[int]*Supersession, error) {
+	known := make(map[int]Part, len(parts))
+	for _, p := range parts {
+		known[p.ID] = p
+	}
+	next := parts
+	for depth := 0; depth < maxSupersessions && len(next) > 0; depth++ {
+		var ids []int
+		for _, p := range next {
+			if _, ok := known[p.ReplacedBy]; !ok && p.ReplacedBy != 0 {
+				ids = append(ids, p.ReplacedBy)
+			}
+		}
+		if len(ids) == 0 {
+			break
+		}
+		found, err := lookup(ids)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range found {
+			known[p.ID] = p
+		}
+		next = found
+	}
+
+	ss := make(map[int]*Supersession)
+	for _, p := range parts {
+		if p.ReplacedBy == 0 || p.ReplacedBy == p.ID {
+			continue
+		}
+		s := &Supersession{Requested: p.PartNumber}
+		seen := map[int]bool{}
+		for cur := p; ; {
+			seen[cur.ID] = true
+			s.Chain = append(s.Chain, linkOf(cur))
+			if cur.ReplacedBy == 0 || cur.ReplacedBy == cur.ID {
+				break
+			}
+			if seen[cur.ReplacedBy] {
+				s.Cycle = true
+				break
+			}
+			next, ok := known[cur.ReplacedBy]
+			if !ok || len(s.Chain) > maxSupersessions {
+				s.Unresolved = true
+				break
+			}
+			cur = next
+		}
+		last := len(s.Chain) - 1
+		if s.Chain[last].Status == discontinuedStatus {
+			s.Discontinued = true
+			for last > 0 && s.Chain[last].Status == discontinuedStatus {
+				last--
+			}
+		}
+		current := s.Chain[last]
+		s.Current = &current
+		ss[p.ID] = s
+	}
+	return ss, nil
+}
+
+// replacementsIn looks up replacements among the brands' parts in c.
+func replacementsIn(c *mgo.Collection, brands []int) func(ids []int) ([]Part, error) {
+	return func(ids []int) ([]Part, error) {
+		var found []Part
+		query := bson.M{"id": bson.M{"$in": ids}, "brand.id": bson.M{"$in": brands}}
+		err := c.Find(query).Select(supersessionFields).All(&found)
+		return found, err
+	}
+}
+
+func linkOf(p Part) SupersessionLink {
+	return SupersessionLink{ID: p.ID, PartNumber: p.PartNumber, Status: p.Status}
+}
@@ -0,0 +1,81 @@
+package products
+
+import (
+	"testing"
+
+	. "github.com/smartystreets/goconvey/convey"
+)
+
+func TestSupersession(t *testing.T) {
+	Convey("Testing supersession chains", t, func() {
+		catalog := map[int]Part{
+			1:  {ID: 1, PartNumber: "A", Status: 999, ReplacedBy: 2},
+			2:  {ID: 2, PartNumber: "B", Status: 999, ReplacedBy: 3},
+			3:  {ID: 3, PartNumber: "C", Status: 800},
+			4:  {ID: 4, PartNumber: "D", ReplacedBy: 5},
+			5:  {ID: 5, PartNumber: "E", ReplacedBy: 4},
+			6:  {ID: 6, PartNumber: "F", ReplacedBy: 99},
+			7:  {ID: 7, PartNumber: "G", ReplacedBy: 7},
+			8:  {ID: 8, PartNumber: "H", Status: 999, ReplacedBy: 9},
+			9:  {ID: 9, PartNumber: "I", Status: 800, ReplacedBy: 10},
+			10: {ID: 10, PartNumber: "J", Status: 999, ReplacedBy: 11},
+			11: {ID: 11, PartNumber: "K", Status: 999},
+			12: {ID: 12, PartNumber: "L", Status: 999, ReplacedBy: 11},
+		}
+		lookups := 0
+		lookup := func(ids []int) ([]Part, error) {
+			lookups++
+			var found []Part
+			for _, id := range ids {
+				if p, ok := catalog[id]; ok {
+					found = append(found, p)
+				}
+			}
+			return found, nil
+		}
+		numbers := func(s *Supersession) []string {
+			var pns []string
+			for _, l := range s.Chain {
+				pns = append(pns, l.PartNumber)
+			}
+			return pns
+		}
+
+		Convey("chains are followed to the current part", func() {
+			ss, err := resolveSupersessions([]Part{catalog[1], catalog[3]}, lookup)
+			So(err, ShouldBeNil)
+			So(numbers(ss[1]), ShouldResemble, []string{"A", "B", "C"})
+			So(ss[1].Current.ID, ShouldEqual, 3)
+			So(ss[1].Discontinued, ShouldBeFalse)
+			So(ss[3], ShouldBeNil)
+			// C was already known, so only B is looked up
+			So(lookups, ShouldEqual, 1)
+		})
+
+		Convey("chains that end on a discontinued part stop at the last active one", func() {
+			ss, err := resolveSupersessions([]Part{catalog[8], catalog[12]}, lookup)
+			So(err, ShouldBeNil)
+			So(ss[8].Discontinued, ShouldBeTrue)
+			So(numbers(ss[8]), ShouldResemble, []string{"H", "I", "J", "K"})
+			So(ss[8].Current.PartNumber, ShouldEqual, "I")
+			// nothing in the chain is active, so the part isn't replaced
+			So(ss[12].Discontinued, ShouldBeTrue)
+			So(ss[12].Current.PartNumber, ShouldEqual, "L")
+		})
+
+		Convey("cycles are detected", func() {
+			ss, err := resolveSupersessions([]Part{catalog[4]}, lookup)
+			So(err, ShouldBeNil)
+			So(ss[4].Cycle, ShouldBeTrue)
+			So(numbers(ss[4]), ShouldResemble, []string{"D", "E"})
+		})
+
+		Convey("missing replacements leave the chain unresolved", func() {
+			ss, err := resolveSupersessions([]Part{catalog[6], catalog[7]}, lookup)
+			So(err, ShouldBeNil)
+			So(ss[6].Unresolved, ShouldBeTrue)
+			So(ss[6].Current.PartNumber, ShouldEqual, "F")
+			So(ss[7], ShouldBeNil)
+		})
+	})
+}
@@ -1,6 +1,7 @@
 package products
 
 import (
+	"context"
 	"database/sql"
 	"encoding/json"
 	"fmt"
@@ -83,15 +84,17 @@ func (l *Lookup) LoadParts(ch chan []Part, page int, count int, dtx *apicontext.
 		count = 50
 	}
 
+	ctx := dtx.Context()
+
 	err := database.Init()
 	if err != nil {
-		ch <- nil
+		sendParts(ctx, ch, nil)
 		return
 	}
 
-	stmt, err := database.DB.Prepare(partMatcherStmt)
+	stmt, err := database.DB.PrepareContext(ctx, partMatcherStmt)
 	if err != nil {
-		ch <- nil
+		sendParts(ctx, ch, nil)
 		return
 	}
 	defer stmt.Close()
@@ -101,9 +104,9 @@ func (l *Lookup) LoadParts(ch chan []Part, page int, count int, dtx *apicontext.
 		brands = append(brands, strconv.Itoa(b))
 	}
 
-	rows, err := stmt.Query(l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model, l.Vehicle.Submodel, strings.Join(brands, ","))
+	rows, err := stmt.QueryContext(ctx, l.Vehicle.Base.Year, l.Vehicle.Base.Make, l.Vehicle.Base.Model, l.Vehicle.Submodel, strings.Join(brands, ","))
 	if err != nil || rows == nil {
-		ch <- nil
+		sendParts(ctx, ch, nil)
 		return
 	}
 	defer rows.Close()
@@ -191,18 +194,29 @@ func (l *Lookup) LoadParts(ch chan []Part, page int, count int, dtx *apicontext.
 	}
 
 	l.Parts = make([]Part, 0)
-	perChan := make(chan int)
+	perChan := make(chan *Part, len(pagedParts))
 	for i, p := range pagedParts {
 		go func(j int, prt Part) {
 			if err := prt.Get(dtx); err == nil && prt.ShortDesc != "" {
-				l.Parts = append(l.Parts, prt)
+				perChan <- &prt
+				return
 			}
-			perChan <- 1
+			perChan <- nil
 		}(i, Part{ID: p})
 	}
 
 	for _, _ = range pagedParts {
-		<-perChan
+		select {
+		case prt := <-perChan:
+			if prt != nil {
+				l.Parts = append(l.Parts, *prt)
+			}
+		case <-ctx.Done():
+			// perChan is buffered, so the part getters still running
+			// will finish against the cancelled context and exit.
+			sendParts(ctx, ch, nil)
+			return
+		}
 	}
 
 	sortutil.AscByField(l.Parts, "ID")
@@ -224,11 +238,20 @@ func (l *Lookup) LoadParts(ch chan []Part, page int, count int, dtx *apicontext.
 		TotalPages:    totalPages,
 	}
 
-	ch <- nil
+	sendParts(ctx, ch, nil)
 	return
 }
 
-func (v *Vehicle) GetVcdbID() (int, error) {
+// sendParts hands the result to whoever is waiting on ch, giving up if the
+// request has already been abandoned so the loader doesn't leak.
+func sendParts(ctx context.Context, ch chan []Part, parts []Part) {
+	select {
+	case ch <- parts:
+	case <-ctx.Done():
+	}
+}
+
+func (v *Vehicle) GetVcdbID(ctx context.Context) (int, error) {
 	err := database.Init()
 	if err != nil {
 		return 0, err
@@ -236,24 +259,24 @@ func (v *Vehicle) GetVcdbID() (int, error) {
 
 	var row *sql.Row
 	if v.Submodel != "" {
-		stmt, err := database.VcdbDB.Prepare(getVcdbVehicleIDWithSubmodel)
+		stmt, err := database.VcdbDB.PrepareContext(ctx, getVcdbVehicleIDWithSubmodel)
 		if err != nil {
 			return 0, err
 		}
 		defer stmt.Close()
 
-		row = stmt.QueryRow(v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel)
+		row = stmt.QueryRowContext(ctx, v.Base.Year, v.Base.Make, v.Base.Model, v.Submodel)
 		if row == nil {
 			return 0, err
 		}
 	} else {
-		stmt, err := database.VcdbDB.Prepare(getVcdbVehicleID)
+		stmt, err := database.VcdbDB.PrepareContext(ctx, getVcdbVehicleID)
 		if err != nil {
 			return 0, err
 		}
 		defer stmt.Close()
 
-		row = stmt.QueryRow(v.Base.Year, v.Base.Make, v.Base.Model)
+		row = stmt.QueryRowContext(ctx, v.Base.Year, v.Base.Make, v.Base.Model)
 		if row == nil {
 			return 0, err
 		}
@@ -295,7 +318,7 @@ var (
 	insertStmt = `insert into VehicleInquiry(name, category, phone, email, vehicle, message, date_added) values(?,?,?,?,?,?, now())`
 )
 
-func (i *VehicleInquiry) Push() error {
+func (i *VehicleInquiry) Push(ctx context.Context) error {
 
 	if i.Name == "" {
 		return fmt.Errorf("%s", "name is required")
@@ -315,13 +338,13 @@ func (i *VehicleInquiry) Push() error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(insertStmt)
+	stmt, err := database.DB.PrepareContext(ctx, insertStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	_, err = stmt.Exec(i.Name, i.Category, i.Phone, i.Email, i.Vehicle, i.Message)
+	_, err = stmt.ExecContext(ctx, i.Name, i.Category, i.Phone, i.Email, i.Vehicle, i.Message)
 	return err
 }
 
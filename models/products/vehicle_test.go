@@ -1,6 +1,8 @@
 package products
 
 import (
+	"context"
+
 	"github.com/curt-labs/API/helpers/api"
 	"github.com/curt-labs/API/helpers/apicontextmock"
 	. "github.com/smartystreets/goconvey/convey"
@@ -97,7 +99,7 @@ func TestLoadParts(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
@@ -128,14 +130,14 @@ func TestLoadParts(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			if (len(l.Submodels)) == 0 {
@@ -165,7 +167,7 @@ func TestGetVcdbID(t *testing.T) {
 	Convey("Testing GetVcdbID()", t, func() {
 
 		Convey("without year/make/model", func() {
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			So(err, ShouldNotEqual, nil)
 			So(id, ShouldEqual, 0)
 		})
@@ -178,7 +180,7 @@ func TestGetVcdbID(t *testing.T) {
 			}
 			l.Vehicle.Base.Year = l.Years[api_helpers.RandGenerator(len(l.Years)-1)]
 
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			So(err, ShouldNotEqual, nil)
 			So(id, ShouldEqual, 0)
 		})
@@ -197,7 +199,7 @@ func TestGetVcdbID(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			So(err, ShouldNotEqual, nil)
 			So(id, ShouldEqual, 0)
 		})
@@ -218,14 +220,14 @@ func TestGetVcdbID(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			if err != nil {
 				So(id, ShouldEqual, 0)
 			} else {
@@ -249,14 +251,14 @@ func TestGetVcdbID(t *testing.T) {
 			}
 			l.Vehicle.Base.Make = l.Makes[api_helpers.RandGenerator(len(l.Makes)-1)]
 
-			err = l.GetModels()
+			err = l.GetModels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			if len(l.Models) == 0 {
 				return
 			}
 			l.Vehicle.Base.Model = l.Models[api_helpers.RandGenerator(len(l.Models)-1)]
 
-			err = l.GetSubmodels()
+			err = l.GetSubmodels(MockedDTX)
 			So(err, ShouldEqual, nil)
 			So(l.Submodels, ShouldNotEqual, nil)
 			if (len(l.Submodels)) == 0 {
@@ -264,7 +266,7 @@ func TestGetVcdbID(t *testing.T) {
 			}
 			l.Vehicle.Submodel = l.Submodels[api_helpers.RandGenerator(len(l.Submodels)-1)]
 
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			So(err, ShouldEqual, nil)
 			So(id, ShouldNotEqual, 0)
 		})
@@ -274,7 +276,7 @@ func TestGetVcdbID(t *testing.T) {
 			l.Vehicle.Base.Make = "KD"
 			l.Vehicle.Base.Model = "123"
 			l.Vehicle.Submodel = "LKJ"
-			id, err := l.Vehicle.GetVcdbID()
+			id, err := l.Vehicle.GetVcdbID(MockedDTX.Context())
 			So(err, ShouldNotEqual, nil)
 			So(id, ShouldEqual, 0)
 		})
@@ -287,25 +289,25 @@ func TestInquiry(t *testing.T) {
 		var i VehicleInquiry
 
 		Convey("with no data", func() {
-			err := i.Push()
+			err := i.Push(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("with only name", func() {
 			i.Name = "Test User"
-			err := i.Push()
+			err := i.Push(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("with name and category", func() {
 			i.Name = "Test User"
 			i.Category = 1
-			err := i.Push()
+			err := i.Push(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("with name,category and phone", func() {
 			i.Name = "Test User"
 			i.Category = 1
 			i.Phone = "555-555-5555"
-			err := i.Push()
+			err := i.Push(context.Background())
 			So(err, ShouldNotBeNil)
 		})
 		Convey("with name,category,phone and vehicle", func() {
@@ -313,7 +315,7 @@ func TestInquiry(t *testing.T) {
 			i.Category = 1
 			i.Phone = "555-555-5555"
 			i.Vehicle = "{'base':{'year':2010}}"
-			err := i.Push()
+			err := i.Push(context.Background())
 			So(err, ShouldBeNil)
 		})
 	})
@@ -50,13 +50,13 @@ func (p *Part) GetVideos(dtx *apicontext.DataContext) error {
 		return err
 	}
 
-	qry, err := database.DB.Prepare(partVideoStmt)
+	qry, err := database.DB.PrepareContext(dtx.Context(), partVideoStmt)
 	if err != nil {
 		return err
 	}
 	defer qry.Close()
 
-	rows, err := qry.Query(p.ID)
+	rows, err := qry.QueryContext(dtx.Context(), p.ID)
 	if err != nil {
 		return err
 	}
@@ -79,24 +79,24 @@ func (p *Part) GetVideos(dtx *apicontext.DataContext) error {
 	}
 	defer rows.Close()
 
-	go redis.Setex(redis_key, p.Videos, redis.CacheTimeout)
+	redis.SetexAsync(redis_key, p.Videos, redis.CacheTimeout)
 
 	return nil
 }
 
 func (p *PartVideo) CreatePartVideo(dtx *apicontext.DataContext) (err error) {
-	go redis.Delete(fmt.Sprintf("part:%d:videos:%s", p.PartID, dtx.BrandString))
+	redis.DeleteAsync(fmt.Sprintf("part:%d:videos:%s", p.PartID, dtx.BrandString))
 	err = database.Init()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(createPartVideo)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), createPartVideo)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	res, err := stmt.Exec(p.PartID, p.YouTubeVideoId, p.VideoType.ID, p.IsPrimary)
+	res, err := stmt.ExecContext(dtx.Context(), p.PartID, p.YouTubeVideoId, p.VideoType.ID, p.IsPrimary)
 	if err != nil {
 		return err
 	}
@@ -110,18 +110,18 @@ func (p *PartVideo) CreatePartVideo(dtx *apicontext.DataContext) (err error) {
 }
 
 func (p *PartVideo) DeleteByPart(dtx *apicontext.DataContext) (err error) {
-	go redis.Delete(fmt.Sprintf("part:%d:videos:%s", p.PartID, dtx.BrandString))
+	redis.DeleteAsync(fmt.Sprintf("part:%d:videos:%s", p.PartID, dtx.BrandString))
 	err = database.Init()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(deletePartVideos)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), deletePartVideos)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
-	_, err = stmt.Exec(p.PartID)
+	_, err = stmt.ExecContext(dtx.Context(), p.PartID)
 	if err != nil {
 		return err
 	}
@@ -44,13 +44,13 @@ func (l *Lookup) GetYears(dtx *apicontext.DataContext) error {
 		return err
 	}
 
-	stmt, err := database.DB.Prepare(wholeStmt)
+	stmt, err := database.DB.PrepareContext(dtx.Context(), wholeStmt)
 	if err != nil {
 		return err
 	}
 	defer stmt.Close()
 
-	res, err := stmt.Query()
+	res, err := stmt.QueryContext(dtx.Context())
 	if err != nil {
 		return err
 	}
@@ -73,7 +73,7 @@ func (l *Lookup) GetYears(dtx *apicontext.DataContext) error {
 		TotalPages:    1,
 	}
 	if dtx.BrandString != "" {
-		go redis.Setex(redis_key, l.Years, 604800)
+		redis.SetexAsync(redis_key, l.Years, 604800)
 	}
 	return nil
 }
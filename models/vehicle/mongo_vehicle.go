@@ -2,6 +2,7 @@ package vehicle
 
 import (
 	"github.com/curt-labs/API/helpers/database"
+	"github.com/curt-labs/API/models/products"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
@@ -30,6 +31,9 @@ func ReverseMongoLookup(partId int) (vehicles []MgoVehicle, err error) {
 		return
 	}
 	for _, collection := range collections {
+		if !products.IsApplicationCollection(collection) {
+			continue
+		}
 		var temps []MgoVehicle
 		query := bson.M{
 			"parts": partId,
@@ -354,7 +354,7 @@ func ReverseLookup(partId int) (vehicles []Vehicle, err error) {
 	for _, v := range vehicleArray {
 		vehicles = append(vehicles, v)
 	}
-	go redis.Setex(redis_key, vehicles, redis.CacheTimeout)
+	redis.SetexAsync(redis_key, vehicles, redis.CacheTimeout)
 
 	return
 }
@@ -168,7 +168,7 @@ func (v *Video) Get() error {
 	go populateVideo(row, ch)
 	*v = <-ch
 	if v != nil {
-		go redis.Setex(redis_key, *v, redis.CacheTimeout)
+		redis.SetexAsync(redis_key, *v, redis.CacheTimeout)
 	}
 	return err
 }
@@ -251,7 +251,7 @@ func (v *Video) GetVideoDetails() error {
 	close(partChan)
 
 	if v != nil {
-		go redis.Setex(redis_key, v, redis.CacheTimeout)
+		redis.SetexAsync(redis_key, v, redis.CacheTimeout)
 	}
 	return nil
 }
@@ -289,7 +289,7 @@ func GetAllVideos(dtx *apicontext.DataContext) (vs Videos, err error) {
 		return
 	}
 
-	go redis.Setex(AllVideosRedisKey+":"+dtx.BrandString, vs, 86400)
+	redis.SetexAsync(AllVideosRedisKey+":"+dtx.BrandString, vs, 86400)
 
 	return
 }
@@ -349,7 +349,7 @@ func (v *Video) GetChannels() (chs Channels, err error) {
 	chs = <-ch
 
 	if chs != nil {
-		go redis.Setex(redis_key, chs, redis.CacheTimeout)
+		redis.SetexAsync(redis_key, chs, redis.CacheTimeout)
 	}
 
 	return
@@ -390,7 +390,7 @@ func (v *Video) GetParts() (err error) {
 		}
 	}
 	if len(v.PartIds) > 0 {
-		go redis.Setex(redis_key, v.PartIds, redis.CacheTimeout)
+		redis.SetexAsync(redis_key, v.PartIds, redis.CacheTimeout)
 	}
 	return
 }
@@ -423,7 +423,7 @@ func (v *Video) GetCdnFiles() (cdns CdnFiles, err error) {
 	cdns = <-ch
 
 	if cdns != nil {
-		go redis.Setex(redis_key, cdns, redis.CacheTimeout)
+		redis.SetexAsync(redis_key, cdns, redis.CacheTimeout)
 	}
 
 	return
@@ -499,7 +499,7 @@ func GetAllChannels() (cs Channels, err error) {
 		err = sql.ErrNoRows
 		return
 	}
-	go redis.Setex(AllChannelsRedisKey, cs, 86400)
+	redis.SetexAsync(AllChannelsRedisKey, cs, 86400)
 	return
 }
 
@@ -587,7 +587,7 @@ func GetAllCdnFiles() (cs CdnFiles, err error) {
 		err = sql.ErrNoRows
 		return
 	}
-	go redis.Setex(AllCdnFilesRedisKey, cs, 86400)
+	redis.SetexAsync(AllCdnFilesRedisKey, cs, 86400)
 	return
 }
 
@@ -661,7 +661,7 @@ func GetAllCdnFileTypes() (cts []CdnFileType, err error) {
 	}
 	defer res.Close()
 
-	go redis.Setex(AllCdnFileTypeRedisKey, cts, 86400)
+	redis.SetexAsync(AllCdnFileTypeRedisKey, cts, 86400)
 	return
 }
 
@@ -726,7 +726,7 @@ func GetAllVideoTypes() (vts []VideoType, err error) {
 		vts = append(vts, vt)
 	}
 	defer rows.Close()
-	go redis.Setex(AllVideoTypesRedisKey, vts, 86400)
+	redis.SetexAsync(AllVideoTypesRedisKey, vts, 86400)
 	return
 }
 
@@ -788,7 +788,7 @@ func GetAllChannelTypes() (cts []ChannelType, err error) {
 		cts = append(cts, c)
 	}
 	defer res.Close()
-	go redis.Setex(AllChannelTypesRedisKey, cts, 86400)
+	redis.SetexAsync(AllChannelTypesRedisKey, cts, 86400)
 	return cts, err
 }
 
@@ -213,7 +213,7 @@ func (w *WebProperty) Get(dtx *apicontext.DataContext) error {
 	<-notesChan
 	<-requirementsChan
 
-	go redis.Setex(redis_key, w, 86400)
+	redis.SetexAsync(redis_key, w, 86400)
 	return err
 }
 
@@ -313,7 +313,7 @@ func GetByCustomer(CustID int, dtx *apicontext.DataContext) (ws WebProperties, e
 		ws = append(ws, w)
 	}
 	defer res.Close()
-	go redis.Setex(redis_key, ws, 86400)
+	redis.SetexAsync(redis_key, ws, 86400)
 	return
 }
 
@@ -419,13 +419,13 @@ func GetAll(dtx *apicontext.DataContext) (WebProperties, error) {
 
 		ws = append(ws, w)
 	}
-	go redis.Setex(redis_key, ws, 86400)
+	redis.SetexAsync(redis_key, ws, 86400)
 	return ws, err
 }
 
 // Creates a Web Property
 func (w *WebProperty) Create(dtx *apicontext.DataContext) (err error) {
-	go redis.Delete("webproperties:" + dtx.BrandString)
+	redis.DeleteAsync("webproperties:" + dtx.BrandString)
 	err = database.Init()
 	if err != nil {
 		return err
@@ -492,7 +492,7 @@ func (w *WebProperty) Create(dtx *apicontext.DataContext) (err error) {
 
 // Updates a Web Property
 func (w *WebProperty) Update(dtx *apicontext.DataContext) (err error) {
-	go redis.Delete("webproperties:" + dtx.BrandString)
+	redis.DeleteAsync("webproperties:" + dtx.BrandString)
 
 	err = database.Init()
 	if err != nil {
@@ -548,7 +548,7 @@ func (w *WebProperty) Update(dtx *apicontext.DataContext) (err error) {
 
 // Deletes a Web Property and any associations.
 func (w *WebProperty) Delete(dtx *apicontext.DataContext) error {
-	go redis.Delete("webproperties:" + dtx.BrandString)
+	redis.DeleteAsync("webproperties:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -618,7 +618,7 @@ func GetAllWebPropertyTypes(dtx *apicontext.DataContext) (WebPropertyTypes, erro
 		res.Scan(&w.ID, &w.TypeID, &w.Type)
 		ws = append(ws, w)
 	}
-	go redis.Setex(redis_key, ws, 86400)
+	redis.SetexAsync(redis_key, ws, 86400)
 	return ws, err
 }
 
@@ -652,7 +652,7 @@ func GetAllWebPropertyNotes(dtx *apicontext.DataContext) (WebPropertyNotes, erro
 		res.Scan(&w.ID, &w.WebPropID, &w.Text, &w.DateAdded)
 		ws = append(ws, w)
 	}
-	go redis.Setex(redis_key, ws, 86400)
+	redis.SetexAsync(redis_key, ws, 86400)
 	return ws, err
 }
 
@@ -711,7 +711,7 @@ func GetAllWebPropertyRequirements(dtx *apicontext.DataContext) (WebPropertyRequ
 		}
 		ws = append(ws, w)
 	}
-	go redis.Setex(redis_key, ws, 86400)
+	redis.SetexAsync(redis_key, ws, 86400)
 	return ws, err
 }
 
@@ -743,7 +743,7 @@ func (n *WebPropertyNote) Get() error {
 
 // Creates a new note for a web property.
 func (n *WebPropertyNote) Create(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertynotes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertynotes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -778,7 +778,7 @@ func (n *WebPropertyNote) Create(dtx *apicontext.DataContext) error {
 
 // Updates a note on a web property
 func (n *WebPropertyNote) Update(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertynotes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertynotes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -807,7 +807,7 @@ func (n *WebPropertyNote) Update(dtx *apicontext.DataContext) error {
 
 // Deletes a Web Property Note
 func (n *WebPropertyNote) Delete(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertynotes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertynotes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -834,7 +834,7 @@ func (n *WebPropertyNote) Delete(dtx *apicontext.DataContext) error {
 
 // Deletes all of the notes of a specific Web Property
 func (n *WebProperty) DeleteNotesByPropId(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertynotes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertynotes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -1003,7 +1003,7 @@ func (r *WebPropertyRequirement) Get() error {
 
 // Creates a WebPropertyRequirement
 func (r *WebPropertyRequirement) Create(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertyrequirements:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertyrequirements:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -1037,7 +1037,7 @@ func (r *WebPropertyRequirement) Create(dtx *apicontext.DataContext) error {
 
 // Updates a WebPropertyRequirement
 func (r *WebPropertyRequirement) Update(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertyrequirements:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertyrequirements:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -1064,7 +1064,7 @@ func (r *WebPropertyRequirement) Update(dtx *apicontext.DataContext) error {
 
 // Deletes a Web Property Requirement
 func (r *WebPropertyRequirement) Delete(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertyrequirements:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertyrequirements:" + dtx.BrandString)
 	var err error
 	err = r.Get()
 	if err != nil {
@@ -1126,7 +1126,7 @@ func (t *WebPropertyType) Get() error {
 
 // Updates a WebPropertyType
 func (t *WebPropertyType) Update(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertytypes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertytypes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -1153,7 +1153,7 @@ func (t *WebPropertyType) Update(dtx *apicontext.DataContext) error {
 
 // creates a WebPropertyType
 func (t *WebPropertyType) Create(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertytypes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertytypes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -1184,7 +1184,7 @@ func (t *WebPropertyType) Create(dtx *apicontext.DataContext) error {
 
 // Deletes a WebPropertyType
 func (t *WebPropertyType) Delete(dtx *apicontext.DataContext) error {
-	go redis.Delete("webpropertytypes:" + dtx.BrandString)
+	redis.DeleteAsync("webpropertytypes:" + dtx.BrandString)
 	err := database.Init()
 	if err != nil {
 		return err
@@ -0,0 +1,20 @@
+-- Pricing rules price a customer's parts from a manufacturer price (Map,
+-- List or Jobber). A customer's rules are evaluated in priority order, and
+-- the first whose filters match a part prices it.
+CREATE TABLE IF NOT EXISTS CustomerPricingRule (
+	ruleID int(11) NOT NULL AUTO_INCREMENT,
+	cust_id int(11) NOT NULL,
+	priority int(11) NOT NULL DEFAULT 0,
+	name varchar(255) NOT NULL DEFAULT '',
+	brandID int(11) NOT NULL DEFAULT 0,
+	catID int(11) NOT NULL DEFAULT 0,
+	class varchar(255) NOT NULL DEFAULT '',
+	priceCode varchar(50) NOT NULL DEFAULT '',
+	basePrice varchar(20) NOT NULL,
+	percent decimal(9,4) NOT NULL DEFAULT 0,
+	amount decimal(10,2) NOT NULL DEFAULT 0,
+	rounding varchar(20) NOT NULL DEFAULT '',
+	floorMap tinyint(1) NOT NULL DEFAULT 0,
+	PRIMARY KEY (ruleID),
+	KEY IX_CustomerPricingRule_cust_priority (cust_id, priority, ruleID)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
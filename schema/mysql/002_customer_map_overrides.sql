@@ -0,0 +1,15 @@
+-- MAP overrides let a customer price a part below its enforced MAP, down to
+-- price, until they expire. A null expires never does.
+CREATE TABLE IF NOT EXISTS CustomerMapOverride (
+	overrideID int(11) NOT NULL AUTO_INCREMENT,
+	cust_id int(11) NOT NULL,
+	partID int(11) NOT NULL,
+	price decimal(10,2) NOT NULL,
+	reason varchar(255) NOT NULL,
+	approvedBy varchar(255) NOT NULL DEFAULT '',
+	expires datetime NULL DEFAULT NULL,
+	dateAdded datetime NOT NULL,
+	PRIMARY KEY (overrideID),
+	KEY IX_CustomerMapOverride_cust_part (cust_id, partID, price),
+	KEY IX_CustomerMapOverride_part (partID)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
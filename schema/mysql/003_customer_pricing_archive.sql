@@ -0,0 +1,21 @@
+-- Expired sales are moved here from CustomerPricing, keyed by their
+-- cust_price_id so that archiving them again is a no-op.
+CREATE TABLE IF NOT EXISTS CustomerPricingArchive (
+	cust_price_id int(11) NOT NULL,
+	cust_id int(11) NOT NULL,
+	partID int(11) NOT NULL,
+	price decimal(10,2) NULL DEFAULT NULL,
+	isSale int(11) NULL DEFAULT NULL,
+	sale_start datetime NULL DEFAULT NULL,
+	sale_end datetime NULL DEFAULT NULL,
+	archived datetime NOT NULL,
+	PRIMARY KEY (cust_price_id),
+	KEY IX_CustomerPricingArchive_cust_part (cust_id, partID)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- A customer has a regular price per part and any number of sales, told
+-- apart by the day they start. Sales are read by customer and by when they
+-- end.
+ALTER TABLE CustomerPricing
+	ADD KEY IX_CustomerPricing_cust_part_sale (cust_id, partID, isSale, sale_start),
+	ADD KEY IX_CustomerPricing_sale_end (isSale, sale_end);
@@ -0,0 +1,27 @@
+-- A row per write to CustomerPricing, with the price before (old_) and
+-- after (new_) it. Creates have no old_ values and deletes no new_ ones.
+-- Every write of one request shares a batchID so that it can be reverted
+-- as a whole.
+CREATE TABLE IF NOT EXISTS CustomerPriceHistory (
+	historyID int(11) NOT NULL AUTO_INCREMENT,
+	batchID char(32) NOT NULL,
+	cust_id int(11) NOT NULL,
+	partID int(11) NOT NULL,
+	cust_price_id int(11) NULL DEFAULT NULL,
+	action varchar(16) NOT NULL,
+	source varchar(16) NOT NULL,
+	old_price decimal(10,2) NULL DEFAULT NULL,
+	old_isSale int(11) NULL DEFAULT NULL,
+	old_sale_start datetime NULL DEFAULT NULL,
+	old_sale_end datetime NULL DEFAULT NULL,
+	new_price decimal(10,2) NULL DEFAULT NULL,
+	new_isSale int(11) NULL DEFAULT NULL,
+	new_sale_start datetime NULL DEFAULT NULL,
+	new_sale_end datetime NULL DEFAULT NULL,
+	apiKey varchar(64) NOT NULL DEFAULT '',
+	userID varchar(64) NOT NULL DEFAULT '',
+	dateAdded datetime NOT NULL,
+	PRIMARY KEY (historyID),
+	KEY IX_CustomerPriceHistory_cust_batch (cust_id, batchID),
+	KEY IX_CustomerPriceHistory_cust_date (cust_id, dateAdded)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,47 @@
+-- Webhooks are sent the changes to a customer's prices and to the MAP and
+-- List prices of a brand's parts. lastHistoryID, lastPriceModified and
+-- lastPriceID are how far through CustomerPriceHistory and Price its
+-- deliveries have got.
+CREATE TABLE IF NOT EXISTS CustomerWebhook (
+	webhookID int(11) NOT NULL AUTO_INCREMENT,
+	cust_id int(11) NOT NULL,
+	brandID int(11) NOT NULL,
+	url varchar(2048) NOT NULL,
+	secret varchar(255) NOT NULL,
+	active tinyint(1) NOT NULL DEFAULT 1,
+	lastHistoryID int(11) NOT NULL DEFAULT 0,
+	lastPriceModified datetime NOT NULL,
+	lastPriceID int(11) NOT NULL DEFAULT 0,
+	dateAdded datetime NOT NULL,
+	PRIMARY KEY (webhookID),
+	KEY IX_CustomerWebhook_cust_brand (cust_id, brandID),
+	KEY IX_CustomerWebhook_active (active)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- A batch of changes sent to a webhook, with the JSON payload that's sent.
+-- sequence orders a webhook's deliveries, which are sent one at a time.
+CREATE TABLE IF NOT EXISTS CustomerWebhookDelivery (
+	deliveryID char(32) NOT NULL,
+	sequence bigint(20) NOT NULL AUTO_INCREMENT,
+	webhookID int(11) NOT NULL,
+	status varchar(16) NOT NULL,
+	attempts int(11) NOT NULL DEFAULT 0,
+	events int(11) NOT NULL DEFAULT 0,
+	payload longtext NOT NULL,
+	statusCode int(11) NULL DEFAULT NULL,
+	lastError text NULL,
+	nextAttempt datetime NULL DEFAULT NULL,
+	dateAdded datetime NOT NULL,
+	dateDelivered datetime NULL DEFAULT NULL,
+	PRIMARY KEY (deliveryID),
+	UNIQUE KEY UX_CustomerWebhookDelivery_sequence (sequence),
+	KEY IX_CustomerWebhookDelivery_webhook_status (webhookID, status, sequence),
+	KEY IX_CustomerWebhookDelivery_status (status, sequence)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- Deliveries read a customer's history from where they left off, and the
+-- brand's Map and List prices by when they changed.
+ALTER TABLE CustomerPriceHistory
+	ADD KEY IX_CustomerPriceHistory_cust_history (cust_id, historyID);
+ALTER TABLE Price
+	ADD KEY IX_Price_modified (dateModified, priceID);
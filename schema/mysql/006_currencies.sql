@@ -0,0 +1,20 @@
+-- Exchange rates from US dollars. A currency's rate is the one with the
+-- latest effective date that has passed, and the latest added of those.
+CREATE TABLE IF NOT EXISTS ExchangeRate (
+	rateID int(11) NOT NULL AUTO_INCREMENT,
+	currency char(3) NOT NULL,
+	rate decimal(18,8) NOT NULL,
+	rounding varchar(16) NOT NULL,
+	increment decimal(10,4) NOT NULL,
+	effective datetime NOT NULL,
+	dateAdded datetime NOT NULL,
+	PRIMARY KEY (rateID),
+	KEY IX_ExchangeRate_currency_effective (currency, effective, rateID)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
+
+-- The currency of each customer that doesn't use USD.
+CREATE TABLE IF NOT EXISTS CustomerCurrency (
+	cust_id int(11) NOT NULL,
+	currency char(3) NOT NULL,
+	PRIMARY KEY (cust_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8;
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"github.com/curt-labs/API/helpers/encoding"
+	"github.com/curt-labs/API/helpers/openapi"
+	"github.com/curt-labs/API/models/customer"
+	"github.com/curt-labs/API/models/products"
+)
+
+// v4 returns the response transforms for version 4 of the API and updates
+// its OpenAPI document to match. Models keep encoding the v3 shape; fixes
+// that would break existing clients belong here.
+func v4(spec *openapi.Registry) *encoding.Version {
+	v := encoding.NewVersion("4")
+
+	// Part.VehicleAttributes has been misspelled since the first release.
+	v.Rename(products.Part{}, "vehicle_atttributes", "vehicle_attributes")
+	spec.PatchSchema(products.Part{}, func(props map[string]interface{}) {
+		props["vehicle_attributes"] = props["vehicle_atttributes"]
+		delete(props, "vehicle_atttributes")
+	})
+
+	// Customer.Latitude and Longitude are both tagged "coords", which
+	// encoding/json treats as a conflict and drops, so v3 never returns
+	// them. Nest them the way CustomerLocation does.
+	v.Transform(customer.Customer{}, func(value interface{}, obj map[string]interface{}) {
+		c := value.(customer.Customer)
+		if c.Latitude == 0 && c.Longitude == 0 {
+			return
+		}
+		obj["coords"] = map[string]interface{}{
+			"latitude":  c.Latitude,
+			"longitude": c.Longitude,
+		}
+	})
+	spec.PatchSchema(customer.Customer{}, func(props map[string]interface{}) {
+		props["coords"] = map[string]interface{}{
+			"type": "object",
+			"properties": map[string]interface{}{
+				"latitude":  map[string]interface{}{"type": "number"},
+				"longitude": map[string]interface{}{"type": "number"},
+			},
+		}
+	})
+
+	return v
+}